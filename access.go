@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// accessKeyPrefix prefixes the sidecar keys holding the access metadata of entries.
+const accessKeyPrefix = "access:"
+
+// EntryAccess is the access metadata of an entry, tracked when TrackAccess is set.
+type EntryAccess struct {
+	Key        string    `json:"key"`
+	Hits       int64     `json:"hits"`        // times the entry was served from the cache
+	LastAccess time.Time `json:"last_access"` // last time the entry was served from the cache
+}
+
+// readAccess returns the access metadata of the entry stored under key, or nil if there is none.
+func (r Cache) readAccess(ctx context.Context, key string) (*EntryAccess, error) {
+	accessKey := r.sidecarKey(accessKeyPrefix, key)
+	value, err := r.currentProvider().Get(ctx, accessKey)
+	if err != nil {
+		r.recordProviderError(ctx, "get", err)
+		return nil, &ProviderError{Op: "get", Key: accessKey, Err: err}
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	var access EntryAccess
+	if err := json.Unmarshal(value, &access); err != nil {
+		return nil, nil
+	}
+	access.Key = key
+	return &access, nil
+}
+
+// recordAccess counts a hit on entry, stored under key. Concurrent hits from several processes may be lost, so counts
+// are approximate. The sidecar expires along with the entry, and is written in the background with AsyncWrites.
+func (r Cache) recordAccess(ctx context.Context, key string, entry *cacheEntry) {
+	if !r.TrackAccess {
+		return
+	}
+
+	access, err := r.readAccess(ctx, key)
+	if err != nil {
+		r.logError(ctx, "error reading entry access", "key", key, "provider", r.providerName(), "error", err)
+		return
+	}
+	if access == nil {
+		access = &EntryAccess{Key: key}
+	}
+	access.Hits++
+	access.LastAccess = r.now()
+
+	data, err := json.Marshal(access)
+	if err != nil {
+		return
+	}
+	accessKey, expiry := r.sidecarKey(accessKeyPrefix, key), r.accessExpiry(entry)
+	if r.AsyncWrites != nil && r.writer != nil {
+		pinned, done := r.pinProvider()
+		if r.writer.enqueue(writeJob{ctx: detach(ctx), cache: pinned, key: accessKey, value: data, expiry: expiry, done: done}) {
+			return
+		}
+		done()
+	}
+	if err := r.providerSet(ctx, accessKey, data, expiry); err != nil {
+		r.logError(ctx, "error writing entry access", "key", key, "provider", r.providerName(), "error", err)
+	}
+}
+
+// accessExpiry returns the provider expiry of the access sidecar of entry: the remaining lifetime of the entry, or 0
+// if unknown.
+func (r Cache) accessExpiry(entry *cacheEntry) time.Duration {
+	if entry.Sliding > 0 {
+		return entry.Sliding
+	}
+	expires, ok := entry.expiresAt()
+	if !ok {
+		return 0
+	}
+	if ttl := expires.Sub(r.now()); ttl > 0 {
+		return ttl
+	}
+	return 0
+}
+
+// orphanedAccess reports whether key is the access sidecar of an entry no longer stored, e.g. evicted by the provider.
+func (r Cache) orphanedAccess(ctx context.Context, key string) bool {
+	name := strings.TrimPrefix(key, r.Namespace)
+	if !strings.HasPrefix(name, accessKeyPrefix) {
+		return false
+	}
+	value, err := r.currentProvider().Get(ctx, r.namespaced(strings.TrimPrefix(name, accessKeyPrefix)))
+	return err == nil && len(value) == 0
+}
+
+// HotKeys returns the access metadata of the n most served entries, most served first, or of every entry if n is
+// zero or negative. Requires TrackAccess and a provider implementing Scanner.
+func (r Cache) HotKeys(ctx context.Context, n int) ([]EntryAccess, error) {
+	var hot []EntryAccess
+	for _, c := range r.perProvider() {
+		scanner, ok := c.currentProvider().(Scanner)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s does not implement Scanner", ErrNotSupported, c.providerName())
+		}
+		prefix := c.namespaced(accessKeyPrefix)
+		err := scanner.Scan(ctx, prefix, func(key string) error {
+			access, err := c.readAccess(ctx, c.namespaced(strings.TrimPrefix(key, prefix)))
+			if err != nil || access == nil {
+				return err
+			}
+			hot = append(hot, *access)
+			return nil
+		})
+		if err != nil {
+			return nil, providerError("scan", prefix, err)
+		}
+	}
+
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].Hits != hot[j].Hits {
+			return hot[i].Hits > hot[j].Hits
+		}
+		return hot[i].LastAccess.After(hot[j].LastAccess)
+	})
+	if n > 0 && len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot, nil
+}
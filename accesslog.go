@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the line format of an AccessLog.
+type AccessLogFormat int
+
+const (
+	// AccessLogCommon writes lines in the Common Log Format, followed by the cache key, cache status and latency in
+	// seconds.
+	AccessLogCommon AccessLogFormat = iota
+	// AccessLogJSON writes one JSON object per line.
+	AccessLogJSON
+)
+
+const commonLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog writes one line per call to Do, independently of the structured Logger. Install its hooks on the cache
+// to enable it.
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+}
+
+// NewAccessLog returns an access log writing to w in the given format.
+func NewAccessLog(w io.Writer, format AccessLogFormat) *AccessLog {
+	return &AccessLog{w: w, format: format}
+}
+
+// Hooks returns the cache hooks feeding the access log.
+func (l *AccessLog) Hooks() Hooks {
+	return Hooks{
+		OnDo: func(_ context.Context, event Event) {
+			l.write(event)
+		},
+	}
+}
+
+type accessLogLine struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Key       string    `json:"key,omitempty"`
+	Cache     string    `json:"cache,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (l *AccessLog) write(event Event) {
+	var line []byte
+	switch l.format {
+	case AccessLogJSON:
+		entry := accessLogLine{
+			Time:      event.Start,
+			Method:    event.Method,
+			URL:       event.URL,
+			Key:       event.Key,
+			Cache:     string(event.Status),
+			Status:    event.StatusCode,
+			Bytes:     event.Size,
+			LatencyMs: float64(event.Duration.Microseconds()) / 1000,
+		}
+		if event.Err != nil {
+			entry.Error = event.Err.Error()
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		line = append(data, '\n')
+	default:
+		status, size, cacheStatus := "-", "-", "-"
+		if event.StatusCode != 0 {
+			status = strconv.Itoa(event.StatusCode)
+		}
+		if event.Size >= 0 && event.Err == nil {
+			size = strconv.FormatInt(event.Size, 10)
+		}
+		if event.Status != "" {
+			cacheStatus = string(event.Status)
+		}
+		line = []byte(fmt.Sprintf("- - - [%s] %q %s %s %q %s %.6f\n",
+			event.Start.Format(commonLogTimeFormat),
+			event.Method+" "+event.URL,
+			status,
+			size,
+			event.Key,
+			cacheStatus,
+			event.Duration.Seconds(),
+		))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// access logs are best effort, there is nothing to do about a failed write
+	_, _ = l.w.Write(line)
+}
+
+// ReplayOptions configures WarmAccessLog.
+type ReplayOptions struct {
+	WarmOptions
+	Format  AccessLogFormat // format of the log, AccessLogCommon also reads Common and Combined Log Format files of web servers
+	BaseURL string          // resolves relative request targets such as "/index.html", lines with one are skipped if empty
+	Top     int             // number of most frequent URLs replayed, or 0 for all of them
+}
+
+// WarmAccessLog primes the cache with the most frequently requested GET URLs of an access log, most frequent first,
+// so a fresh instance quickly reaches a useful hit ratio. Set Interval to replay them at a controlled rate. See
+// TopAccessLogURLs and Warm.
+func (r Cache) WarmAccessLog(ctx context.Context, log io.Reader, opts ReplayOptions) (WarmResult, error) {
+	urls, err := TopAccessLogURLs(log, opts)
+	if err != nil {
+		return WarmResult{}, err
+	}
+	return r.Warm(ctx, urls, opts.WarmOptions)
+}
+
+// TopAccessLogURLs returns the opts.Top most frequently requested GET URLs of an access log allowed by the include
+// and exclude patterns, most frequent first. Lines that can't be parsed are ignored.
+func TopAccessLogURLs(log io.Reader, opts ReplayOptions) ([]string, error) {
+	var base *url.URL
+	if opts.BaseURL != "" {
+		var err error
+		if base, err = url.Parse(opts.BaseURL); err != nil {
+			return nil, fmt.Errorf("url.Parse(): %w", err)
+		}
+	}
+
+	counts := make(map[string]int)
+	var urls []string // in order of first appearance, to break ties
+	scanner := bufio.NewScanner(log)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		method, target, ok := parseAccessLogLine(scanner.Bytes(), opts.Format)
+		if !ok || method != http.MethodGet {
+			continue
+		}
+		u, err := url.Parse(target)
+		if err != nil {
+			continue
+		}
+		if !u.IsAbs() {
+			if base == nil {
+				continue
+			}
+			u = base.ResolveReference(u)
+		}
+		target = u.String()
+		if !opts.allows(target) {
+			continue
+		}
+		if counts[target] == 0 {
+			urls = append(urls, target)
+		}
+		counts[target]++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Scan(): %w", err)
+	}
+
+	sort.SliceStable(urls, func(i, j int) bool {
+		return counts[urls[i]] > counts[urls[j]]
+	})
+	if opts.Top > 0 && len(urls) > opts.Top {
+		urls = urls[:opts.Top]
+	}
+	return urls, nil
+}
+
+// parseAccessLogLine returns the method and request target of an access log line.
+func parseAccessLogLine(line []byte, format AccessLogFormat) (method, target string, ok bool) {
+	if format == AccessLogJSON {
+		var entry accessLogLine
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return "", "", false
+		}
+		return entry.Method, entry.URL, entry.Method != "" && entry.URL != ""
+	}
+
+	// the request line is the first quoted field: "GET /index.html HTTP/1.1"
+	s := string(line)
+	start := strings.IndexByte(s, '"')
+	if start < 0 {
+		return "", "", false
+	}
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", "", false
+	}
+	fields := strings.Fields(s[start+1 : start+1+end])
+	if len(fields) < 2 {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
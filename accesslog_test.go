@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	event := Event{
+		Start:      time.Date(2023, time.March, 4, 13, 55, 36, 0, time.UTC),
+		Method:     "GET",
+		URL:        "http://example.com/",
+		Key:        "http://example.com/",
+		Status:     CacheStatusHit,
+		StatusCode: 200,
+		Size:       11,
+		Duration:   1500 * time.Microsecond,
+	}
+
+	t.Run("common", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewAccessLog(&buf, AccessLogCommon).Hooks().OnDo(context.Background(), event)
+
+		require.Equal(t, `- - - [04/Mar/2023:13:55:36 +0000] "GET http://example.com/" 200 11 "http://example.com/" hit 0.001500`+"\n", buf.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewAccessLog(&buf, AccessLogJSON).Hooks().OnDo(context.Background(), event)
+
+		require.JSONEq(t, `{"time":"2023-03-04T13:55:36Z","method":"GET","url":"http://example.com/","key":"http://example.com/","cache":"hit","status":200,"bytes":11,"latency_ms":1.5}`, buf.String())
+		require.Equal(t, byte('\n'), buf.Bytes()[buf.Len()-1])
+	})
+}
+
+func TestTopAccessLogURLs(t *testing.T) {
+	t.Run("common", func(t *testing.T) {
+		log := strings.Join([]string{
+			`127.0.0.1 - - [04/Mar/2023:13:55:36 +0000] "GET /a HTTP/1.1" 200 11 "-" "curl/8.0"`,
+			`127.0.0.1 - - [04/Mar/2023:13:55:37 +0000] "GET /b HTTP/1.1" 200 11`,
+			`127.0.0.1 - - [04/Mar/2023:13:55:38 +0000] "POST /b HTTP/1.1" 201 0`,
+			`- - - [04/Mar/2023:13:55:39 +0000] "GET http://example.com/b" 200 11 "http://example.com/b" hit 0.001500`,
+			`garbage`,
+			`127.0.0.1 - - [04/Mar/2023:13:55:40 +0000] "GET /c?print=1 HTTP/1.1" 200 11`,
+			`127.0.0.1 - - [04/Mar/2023:13:55:41 +0000] "GET /c HTTP/1.1" 200 11`,
+			`127.0.0.1 - - [04/Mar/2023:13:55:42 +0000] "GET /c HTTP/1.1" 200 11`,
+		}, "\n")
+
+		urls, err := TopAccessLogURLs(strings.NewReader(log), ReplayOptions{
+			BaseURL:     "http://example.com",
+			Top:         2,
+			WarmOptions: WarmOptions{Exclude: []*regexp.Regexp{regexp.MustCompile(`print=`)}},
+		})
+		require.NoError(t, err, "TopAccessLogURLs")
+		require.Equal(t, []string{"http://example.com/b", "http://example.com/c"}, urls)
+
+		urls, err = TopAccessLogURLs(strings.NewReader(log), ReplayOptions{})
+		require.NoError(t, err, "TopAccessLogURLs")
+		require.Equal(t, []string{"http://example.com/b"}, urls, "Expected relative targets to be skipped without a base URL")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		log := `{"method":"GET","url":"http://example.com/a"}
+{"method":"GET","url":"http://example.com/b"}
+{"method":"HEAD","url":"http://example.com/a"}
+{"method":"GET","url":"http://example.com/b"}
+`
+		urls, err := TopAccessLogURLs(strings.NewReader(log), ReplayOptions{Format: AccessLogJSON})
+		require.NoError(t, err, "TopAccessLogURLs")
+		require.Equal(t, []string{"http://example.com/b", "http://example.com/a"}, urls)
+	})
+}
+
+func TestCache_WarmAccessLog(t *testing.T) {
+	entry := func() *cacheEntry {
+		return &cacheEntry{
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+		}
+	}
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			"http://example.com/a": entry(),
+			"http://example.com/b": entry(),
+			"http://example.com/c": entry(),
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	ctx := context.Background()
+
+	log := "\"GET /a\"\n\"GET /b\"\n\"GET /b\"\n\"GET /c\"\n\"GET /c\"\n\"GET /c\"\n"
+	start := time.Now()
+	result, err := cache.WarmAccessLog(ctx, strings.NewReader(log), ReplayOptions{
+		BaseURL:     "http://example.com",
+		Top:         2,
+		WarmOptions: WarmOptions{Interval: 20 * time.Millisecond},
+	})
+	require.NoError(t, err, "cache.WarmAccessLog")
+	require.Equal(t, WarmResult{Requested: 2}, result)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "Expected requests to be paced")
+
+	for _, u := range []string{"http://example.com/b", "http://example.com/c"} {
+		_, err := cache.PeekKey(ctx, u)
+		require.NoError(t, err, "Expected %s to be warmed", u)
+	}
+	_, err = cache.PeekKey(ctx, "http://example.com/a")
+	require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected the least requested URL not to be warmed, got %v", err)
+}
@@ -0,0 +1,237 @@
+// Package adminhandler provides an http.Handler to inspect and control a cache at runtime.
+//
+// The handler serves the following endpoints, relative to where it is mounted:
+//
+//	GET    /stats                  cache statistics
+//	GET    /entry?url=...|key=...  information about an entry
+//	DELETE /entry?url=...|key=...  removes an entry, every entry of url with cache.Cache.ReverseIndex
+//	POST   /purge?prefix=...       removes every entry whose key starts with prefix
+//	POST   /purge?host=...         removes every entry fetched from host
+//	POST   /flush                  removes every entry, requires a cache Namespace
+//	GET    /hot?n=...              most served entries, requires cache.Cache.TrackAccess
+//	GET    /inflight               origin fetches currently in progress
+package adminhandler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lsmoura/cache"
+)
+
+// Options configures the handler.
+type Options struct {
+	// Authorize decides whether a request may use the handler. It is required: when nil, every request is rejected.
+	Authorize func(r *http.Request) bool
+}
+
+type handler struct {
+	cache *cache.Cache
+	opts  Options
+}
+
+// New returns a handler operating on the given cache. Use http.StripPrefix when mounting it under a path.
+func New(c *cache.Cache, opts Options) http.Handler {
+	return &handler{cache: c, opts: opts}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Authorize == nil || !h.opts.Authorize(r) {
+		writeError(w, http.StatusForbidden, errors.New("forbidden"))
+		return
+	}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/stats", "stats":
+		h.stats(w, r)
+	case "/entry", "entry":
+		h.entry(w, r)
+	case "/purge", "purge":
+		h.purge(w, r)
+	case "/flush", "flush":
+		h.flush(w, r)
+	case "/hot", "hot":
+		h.hot(w, r)
+	case "/inflight", "inflight":
+		h.inflight(w, r)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (h *handler) stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	stats := h.cache.Stats()
+	writeJSON(w, http.StatusOK, struct {
+		cache.Stats
+		HitRatio float64 `json:"hit_ratio"`
+	}{Stats: stats, HitRatio: stats.HitRatio()})
+}
+
+// entryTarget returns the cache key designated by the key query parameter, or else the GET request to the url query
+// parameter, whose key depends on the variants of the cached resource.
+func (h *handler) entryTarget(r *http.Request) (string, *http.Request, error) {
+	query := r.URL.Query()
+	if key := query.Get("key"); key != "" {
+		return key, nil, nil
+	}
+	rawURL := query.Get("url")
+	if rawURL == "" {
+		return "", nil, errors.New("missing url or key parameter")
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return "", req, nil
+}
+
+func (h *handler) entry(w http.ResponseWriter, r *http.Request) {
+	key, req, err := h.entryTarget(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var info *cache.EntryInfo
+		if req != nil {
+			info, err = h.cache.Peek(r.Context(), req)
+		} else {
+			info, err = h.cache.PeekKey(r.Context(), key)
+		}
+		if errors.Is(err, cache.ErrCacheMiss) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	case http.MethodDelete:
+		deleted := 1
+		if req != nil {
+			deleted, err = h.cache.InvalidateURL(r.Context(), req.URL.String())
+		} else {
+			err = h.cache.Invalidate(r.Context(), key)
+		}
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (h *handler) purge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	query := r.URL.Query()
+	prefix, host := query.Get("prefix"), query.Get("host")
+	if prefix == "" && host == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing prefix or host parameter, use flush to remove every entry"))
+		return
+	}
+
+	var deleted int
+	var err error
+	if host != "" {
+		deleted, err = h.cache.InvalidateHost(r.Context(), host)
+	} else {
+		deleted, err = h.cache.Purge(r.Context(), prefix)
+	}
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+func (h *handler) flush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	deleted, err := h.cache.Flush(r.Context())
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+func (h *handler) hot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	n := 10
+	if raw := r.URL.Query().Get("n"); raw != "" {
+		var err error
+		if n, err = strconv.Atoi(raw); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid n parameter"))
+			return
+		}
+	}
+
+	hot, err := h.cache.HotKeys(r.Context(), n)
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	if hot == nil {
+		hot = []cache.EntryAccess{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": hot})
+}
+
+func (h *handler) inflight(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	requests := h.cache.InFlight()
+	if requests == nil {
+		requests = []cache.InFlight{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"requests": requests})
+}
+
+func statusFor(err error) int {
+	if errors.Is(err, cache.ErrNotSupported) {
+		return http.StatusNotImplemented
+	}
+	if errors.Is(err, cache.ErrNoNamespace) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
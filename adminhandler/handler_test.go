@@ -0,0 +1,186 @@
+package adminhandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache"
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+)
+
+type staticRequester struct{}
+
+func (staticRequester) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Expires": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+		Body:       io.NopCloser(bytes.NewReader([]byte("Hello World"))),
+		Request:    req,
+	}, nil
+}
+
+func TestHandler(t *testing.T) {
+	c := cache.New(memoryprovider.New())
+	c.HttpClient = staticRequester{}
+	c.TrackAccess = true
+
+	for _, u := range []string{"http://example.com/a", "http://example.com/b", "http://example.org/"} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+	}
+
+	const token = "secret"
+	handler := New(c, Options{Authorize: func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer "+token
+	}})
+
+	do := func(method string, target string, authorized bool) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(method, target, nil)
+		if authorized {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec, body
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		rec, _ := do(http.MethodGet, "/stats", false)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		rec, body := do(http.MethodGet, "/stats", true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, float64(3), body["misses"])
+	})
+
+	t.Run("entry", func(t *testing.T) {
+		rec, body := do(http.MethodGet, "/entry?url="+url.QueryEscape("http://example.com/a"), true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "http://example.com/a", body["key"])
+		require.Equal(t, float64(11), body["size"])
+		require.Equal(t, true, body["fresh"])
+
+		rec, _ = do(http.MethodDelete, "/entry?key="+url.QueryEscape("http://example.com/a"), true)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec, _ = do(http.MethodGet, "/entry?url="+url.QueryEscape("http://example.com/a"), true)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("hot", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "http://example.org/", nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+
+		rec, body := do(http.MethodGet, "/hot?n=5", true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		entries := body["entries"].([]any)
+		require.Len(t, entries, 1)
+		require.Equal(t, "http://example.org/", entries[0].(map[string]any)["key"])
+	})
+
+	t.Run("inflight", func(t *testing.T) {
+		rec, body := do(http.MethodGet, "/inflight", true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Empty(t, body["requests"])
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		rec, body := do(http.MethodPost, "/purge?prefix="+url.QueryEscape("http://example.com/"), true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, float64(1), body["deleted"])
+
+		req, err := http.NewRequest(http.MethodGet, "http://example.net/", nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+
+		rec, body = do(http.MethodPost, "/purge?host=example.net", true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, float64(1), body["deleted"])
+
+		rec, _ = do(http.MethodPost, "/purge", true)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		rec, _ := do(http.MethodGet, "/flush", true)
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+		rec, _ = do(http.MethodPost, "/flush", true)
+		require.Equal(t, http.StatusConflict, rec.Code, "Expected caches without namespace not to be flushed")
+
+		c.Namespace = "cache:"
+		req, err := http.NewRequest(http.MethodGet, "http://example.net/", nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+
+		rec, body := do(http.MethodPost, "/flush", true)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, float64(1), body["deleted"])
+	})
+}
+
+type varyRequester struct{}
+
+func (varyRequester) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Expires": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+			"Vary":    []string{"Accept-Language"},
+		},
+		Body:    io.NopCloser(bytes.NewReader([]byte("Hello World"))),
+		Request: req,
+	}, nil
+}
+
+func TestHandler_Vary(t *testing.T) {
+	c := cache.New(memoryprovider.New())
+	c.HttpClient = varyRequester{}
+	for _, language := range []string{"", "fr"} {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Language", language)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+	}
+
+	handler := New(c, Options{Authorize: func(*http.Request) bool { return true }})
+	do := func(method string) (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/entry?url="+url.QueryEscape("http://example.com/"), nil))
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec, body
+	}
+
+	rec, body := do(http.MethodGet)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, float64(http.StatusOK), body["status_code"], "Expected the variant to be returned, not its index")
+	require.Equal(t, true, body["fresh"])
+
+	rec, body = do(http.MethodDelete)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, float64(2), body["deleted"], "Expected every variant to be deleted")
+
+	rec, _ = do(http.MethodGet)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
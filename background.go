@@ -0,0 +1,14 @@
+package cache
+
+import (
+	"context"
+)
+
+// detach returns the context of background work spawned by a call made with ctx, such as a refresh or an
+// asynchronous write. The work outlives the call, so the context is neither canceled with it nor bound by its
+// deadline, but it keeps every value of ctx: its logger and log fields, the values read by the LoggerExtractor of the
+// caller, its tenant, partition and principal, and its trace span, parent of the spans of the work. The work is bounded
+// by its own timeout instead.
+func detach(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
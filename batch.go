@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultBatchConcurrency = 8
+
+// BatchOptions configures DoBatch.
+type BatchOptions struct {
+	Concurrency int           // maximum number of requests handled at once, defaults to 8
+	Interval    time.Duration // minimum interval between the start of two requests, or 0 for no rate limit
+}
+
+// BatchResult is the outcome of one of the requests given to DoBatch.
+type BatchResult struct {
+	Response *http.Response
+	Err      error
+}
+
+// DoBatch handles every request as Do would, returning the results in the same order as reqs. The entries of all
+// requests are first read at once from providers implementing MultiGetter, then misses are fetched from the origin
+// concurrently, at most opts.Concurrency at a time. Requests not started when ctx is done fail with its error.
+func (r Cache) DoBatch(ctx context.Context, reqs []*http.Request, opts BatchOptions) []BatchResult {
+	results := make([]BatchResult, len(reqs))
+	reqs = r.prefetch(ctx, reqs)
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var limiter <-chan time.Time
+	if opts.Interval > 0 {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		if limiter != nil && i > 0 {
+			select {
+			case <-limiter:
+			case <-ctx.Done():
+				results[i].Err = ctx.Err()
+				continue
+			}
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, req *http.Request) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			resp, err := r.Do(req)
+			results[i] = BatchResult{Response: resp, Err: err}
+		}(i, req)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// prefetchGroup gathers the keys of a batch stored in the same provider.
+type prefetchGroup struct {
+	cache   Cache
+	getter  MultiGetter
+	keys    []string
+	reqs    []*http.Request // request of each key, carrying its policy rule
+	indexes []int           // position of each key in the batch
+}
+
+// prefetch reads the entries of reqs from the providers implementing MultiGetter, returning copies of the requests
+// carrying the values read. Requests that don't read the cache are left untouched.
+func (r Cache) prefetch(ctx context.Context, reqs []*http.Request) []*http.Request {
+	var groups []*prefetchGroup
+	for i, req := range reqs {
+		if r.VCR != nil || req.Method != http.MethodGet || IgnoreCache(req.Context()) {
+			continue
+		}
+		rule := r.policyRule(req)
+		if rule.bypasses(req) {
+			continue
+		}
+		routed := r.route(req)
+		getter, ok := routed.currentProvider().(MultiGetter)
+		if !ok {
+			continue
+		}
+
+		var group *prefetchGroup
+		for _, g := range groups {
+			if g.getter == getter {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &prefetchGroup{cache: routed, getter: getter}
+			groups = append(groups, group)
+		}
+		req = req.WithContext(r.withPolicyRule(req.Context(), req, rule))
+		group.keys = append(group.keys, r.key(req))
+		group.reqs = append(group.reqs, req)
+		group.indexes = append(group.indexes, i)
+	}
+	if len(groups) == 0 {
+		return reqs
+	}
+
+	prefetched := make([]*http.Request, len(reqs))
+	copy(prefetched, reqs)
+	for _, g := range groups {
+		values, err := g.getter.GetMulti(ctx, g.keys)
+		if err != nil {
+			// the requests will read their entries one by one
+			g.cache.recordProviderError(ctx, "get", err)
+			g.cache.logError(ctx, "error reading entries", "keys", len(g.keys), "provider", g.cache.providerName(), "error", err)
+			continue
+		}
+		for j, i := range g.indexes {
+			req := g.reqs[j]
+			prefetched[i] = req.WithContext(withPrefetched(req.Context(), g.keys[j], values[j]))
+		}
+	}
+	return prefetched
+}
+
+type prefetchedValue struct {
+	key   string
+	value []byte
+}
+
+func withPrefetched(ctx context.Context, key string, value []byte) context.Context {
+	return context.WithValue(ctx, contextKeyPrefetched, prefetchedValue{key: key, value: value})
+}
+
+// lookup is read, using the value prefetched by DoBatch for key if there is one.
+func (r Cache) lookup(ctx context.Context, key string) (*cacheEntry, error) {
+	if v, ok := ctx.Value(contextKeyPrefetched).(prefetchedValue); ok && v.key == key {
+		return r.decode(ctx, key, v.value)
+	}
+	return r.read(ctx, key)
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// WriteBatching configures write coalescing: entries are buffered and written to their provider in batches, in a
+// single round trip for providers implementing MultiSetter, reducing backend round trips during warmups and bursts.
+//
+// Buffered writes are not durable: they are lost if the process dies before they are flushed, and stay invisible to
+// reads, of this process as of others, until then, so concurrent misses for a buffered entry still go to the origin.
+// Pending writes are flushed on Close.
+type WriteBatching struct {
+	MaxSize  int           // number of buffered writes triggering a flush, defaults to 64
+	Interval time.Duration // maximum time a write stays buffered, defaults to 10 milliseconds
+}
+
+const (
+	defaultWriteBatchSize     = 64
+	defaultWriteBatchInterval = 10 * time.Millisecond
+)
+
+type bufferedWrite struct {
+	key   string
+	value []byte
+	done  func() // called once the write is flushed
+}
+
+// writeBatch holds the writes buffered for a provider.
+type writeBatch struct {
+	cache  Cache // pinned to the provider
+	writes []bufferedWrite
+	timer  *time.Timer
+}
+
+type batchWriter struct {
+	mu      sync.Mutex
+	pending map[providerID]*writeBatch
+	closed  bool
+	flushes sync.WaitGroup
+
+	depth   atomic.Int64
+	flushed atomic.Int64
+	failed  atomic.Int64
+}
+
+func newBatchWriter() *batchWriter {
+	return &batchWriter{pending: make(map[providerID]*writeBatch)}
+}
+
+// add buffers a write for the provider of pinned. Returns false if the writer is closed and the write should be done
+// synchronously.
+func (w *batchWriter) add(pinned Cache, key string, value []byte, done func()) bool {
+	cfg := *pinned.WriteBatching
+	size := cfg.MaxSize
+	if size <= 0 {
+		size = defaultWriteBatchSize
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultWriteBatchInterval
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return false
+	}
+	id := pinned.bound
+	b, ok := w.pending[id]
+	if !ok {
+		b = &writeBatch{cache: pinned}
+		w.pending[id] = b
+		b.timer = time.AfterFunc(interval, func() { w.flushBatch(id, b) })
+	}
+	b.writes = append(b.writes, bufferedWrite{key: key, value: value, done: done})
+	w.depth.Add(1)
+	if len(b.writes) >= size {
+		b.timer.Stop()
+		delete(w.pending, id)
+		w.flushes.Add(1)
+		go func() {
+			defer w.flushes.Done()
+			w.flush(b)
+		}()
+	}
+	return true
+}
+
+// flushBatch flushes b, if it is still pending for id.
+func (w *batchWriter) flushBatch(id providerID, b *writeBatch) {
+	w.mu.Lock()
+	if w.pending[id] != b {
+		// flushed in the meantime
+		w.mu.Unlock()
+		return
+	}
+	delete(w.pending, id)
+	w.flushes.Add(1)
+	w.mu.Unlock()
+
+	defer w.flushes.Done()
+	w.flush(b)
+}
+
+func (w *batchWriter) flush(b *writeBatch) {
+	w.depth.Add(-int64(len(b.writes)))
+	w.flushed.Add(1)
+
+	ctx := context.Background()
+	r := b.cache
+	if err := r.providerSetMulti(ctx, b.writes); err != nil {
+		r.logError(ctx, "error writing entries", "keys", len(b.writes), "provider", r.providerName(), "error", err)
+		for _, write := range b.writes {
+			if !r.retryWrite(write.key, write.value, 0, write.done) {
+				w.failed.Add(1)
+				r.releaseUsage(write.key)
+				write.done()
+			}
+		}
+		return
+	}
+	for _, write := range b.writes {
+		write.done()
+	}
+}
+
+// close stops buffering writes and flushes the pending ones.
+func (w *batchWriter) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	var pending []*writeBatch
+	for id, b := range w.pending {
+		b.timer.Stop()
+		delete(w.pending, id)
+		pending = append(pending, b)
+	}
+	w.mu.Unlock()
+
+	for _, b := range pending {
+		w.flush(b)
+	}
+	w.flushes.Wait()
+}
+
+// providerSetMulti writes buffered writes to the provider in a single round trip when it implements MultiSetter, one
+// by one otherwise, tracing the operation.
+func (r Cache) providerSetMulti(ctx context.Context, writes []bufferedWrite) error {
+	setter, ok := r.currentProvider().(MultiSetter)
+	if ok {
+		keys := make([]string, len(writes))
+		values := make([][]byte, len(writes))
+		for i, write := range writes {
+			keys[i], values[i] = write.key, write.value
+		}
+
+		spanCtx, span := r.startSpan(ctx, "cache.provider.SetMulti", attribute.Int("cache.keys", len(keys)))
+		err := setter.SetMulti(spanCtx, keys, values, 0)
+		endSpan(span, err)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			if err != nil {
+				r.recordProviderError(ctx, "set", err)
+				return err
+			}
+			for _, write := range writes {
+				if r.budget != nil {
+					r.budget.written.Add(int64(len(write.value)))
+				}
+				r.recordStore(ctx, write.key, len(write.value))
+			}
+			return nil
+		}
+	}
+
+	var errs []error
+	for _, write := range writes {
+		if err := r.providerSet(ctx, write.key, write.value, 0); err != nil {
+			errs = append(errs, &ProviderError{Op: "set", Key: write.key, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
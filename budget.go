@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sizer is an optional interface implemented by providers able to report the size of their values cheaply. When
+// every provider of the cache implements it, their sizes are used to enforce MemoryBudget instead of the estimate
+// of the current process.
+type Sizer interface {
+	// Size returns the number of bytes of the stored values.
+	Size(ctx context.Context) (int64, error)
+}
+
+// budgetTracker accounts for the bytes written by the cache.
+type budgetTracker struct {
+	written  atomic.Int64
+	rejected atomic.Int64
+
+	mu     sync.Mutex
+	sizes  map[string]trackedSize // size of the stored entries, by key. Only tracked with a MemoryBudget
+	stored int64
+}
+
+func newBudgetTracker() *budgetTracker {
+	return &budgetTracker{sizes: make(map[string]trackedSize)}
+}
+
+// fits reports whether an entry of size bytes can be written under key without growing the stored bytes over
+// budget. stored is the current number of stored bytes, or a negative value to use the tracked estimate, from which
+// the entries lapsed at the given moment are dropped.
+func (b *budgetTracker) fits(key string, size int, budget int64, stored int64, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	estimated := stored < 0
+	if estimated {
+		stored = b.stored
+	}
+	growth := int64(size - b.sizes[key].size)
+	if growth > 0 && stored+growth > budget && estimated {
+		b.prune(now)
+		stored, growth = b.stored, int64(size-b.sizes[key].size)
+	}
+	if growth > 0 && stored+growth > budget {
+		b.rejected.Add(1)
+		return false
+	}
+	return true
+}
+
+// prune stops accounting for the entries lapsed at the given moment.
+func (b *budgetTracker) prune(now time.Time) {
+	for key, s := range b.sizes {
+		if s.lapsed(now) {
+			delete(b.sizes, key)
+			b.stored -= int64(s.size)
+		}
+	}
+}
+
+// charge accounts for an entry of size bytes written under key, until it lapses.
+func (b *budgetTracker) charge(key string, size int, lapses time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stored += int64(size - b.sizes[key].size)
+	b.sizes[key] = trackedSize{size: size, lapses: lapses}
+}
+
+// release accounts for the removal of the entry stored under key.
+func (b *budgetTracker) release(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s, ok := b.sizes[key]; ok {
+		delete(b.sizes, key)
+		b.stored -= int64(s.size)
+	}
+}
+
+// storedBytes returns the number of bytes stored in the providers when they all implement Sizer, or -1.
+func (r Cache) storedBytes(ctx context.Context) int64 {
+	var total int64
+	for _, c := range r.perProvider() {
+		sizer, ok := c.currentProvider().(Sizer)
+		if !ok {
+			return -1
+		}
+		size, err := sizer.Size(ctx)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return -1
+		}
+		if err != nil {
+			r.logError(ctx, "error reading provider size", "provider", c.providerName(), "error", err)
+			return -1
+		}
+		total += size
+	}
+	return total
+}
+
+// checkBudget checks the MemoryBudget before an entry of size bytes is written under key.
+func (r Cache) checkBudget(ctx context.Context, key string, size int) bool {
+	if r.MemoryBudget <= 0 || r.budget == nil {
+		return true
+	}
+	if !r.budget.fits(key, size, r.MemoryBudget, r.storedBytes(ctx), r.now()) {
+		r.logInfo(ctx, "memory budget exceeded, not storing entry", "key", key, "size", size, "budget", r.MemoryBudget)
+		return false
+	}
+	return true
+}
+
+// chargeBudget accounts for an entry of size bytes written under key, until it lapses.
+func (r Cache) chargeBudget(key string, size int, lapses time.Time) {
+	if r.MemoryBudget > 0 && r.budget != nil {
+		r.budget.charge(key, size, lapses)
+	}
+}
+
+func (r Cache) releaseBudget(key string) {
+	if r.MemoryBudget > 0 && r.budget != nil {
+		r.budget.release(key)
+	}
+}
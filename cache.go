@@ -7,8 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type HttpRequester interface {
@@ -20,29 +27,335 @@ type KeyGenerator func(req *http.Request) string
 type Cache struct {
 	HttpClient   HttpRequester // custom http client provider, or nil for http.DefaultClient
 	KeyGenerator KeyGenerator  // custom key generator, or nil for default
-	provider     Provider
+	KeyHash      KeyHash       // hash generated keys before handing them to the provider, defaults to KeyHashNone
+	provider     Provider      // set on copies of the cache bound to a provider, see currentProvider
+	bound        providerID    // identifies the provider of bound copies
+	slot         *atomic.Pointer[providerSlot]
+
+	// Namespace prefixes every key the cache stores, entries and sidecar keys alike, e.g. "cache:", so that the cache
+	// can share a provider such as redis with other data: Flush, Purge, Sweep, Export, HotKeys and InvalidateHost only
+	// scan the keys under it. Flush refuses to run without it.
+	Namespace string
+
+	// SensitiveParams lists query parameters, matched case-insensitively, that are removed from the URLs recorded in
+	// logs, spans, events, errors and entries, and whose values are replaced by their HMAC-SHA256 digest, keyed by
+	// KeySecret, in the URLs keys are generated from, so secrets don't end up in the provider keyspace or in log
+	// aggregators while requests made with different credentials keep their own entries. Requires KeySecret. See
+	// DefaultSensitiveParams.
+	SensitiveParams []string
+
+	// ScopeByAuthorization gives authenticated requests their own entries, by adding a digest of their Authorization
+	// header, or of the principal set with WithPrincipal, to their keys. By default, responses to authenticated
+	// requests are shared by every caller. Requires KeySecret.
+	ScopeByAuthorization bool
+
+	// KeySecret keys the HMAC-SHA256 digests of credentials put in keys by ScopeByAuthorization and SensitiveParams, so
+	// that tokens can't be recovered from the provider keyspace by brute force. It is required with either, and must
+	// be the same on every instance sharing a provider: when unset, a random secret is drawn per process, and the
+	// entries keyed by credentials are neither shared across instances nor kept across restarts.
+	KeySecret []byte
+
+	// DisableCoalescing makes every concurrent miss for the same key reach the origin. By default, only one request
+	// per key is in-flight at a time and concurrent callers share its result.
+	DisableCoalescing bool
+	flights           *flightGroup
+
+	// DedupWindow extends coalescing past the end of a fetch: identical calls arriving within DedupWindow after a
+	// successful fetch completed share its result instead of reaching the origin, even when it wasn't stored, e.g.
+	// with WithIgnoreCache. This smooths bursts of duplicate requests such as retry storms. Zero disables it.
+	DedupWindow time.Duration
+
+	// StampedeLockTTL enables cross-process stampede protection when the provider implements Locker: only the
+	// instance holding the refresh lock for a key goes to the origin, while others serve the stale entry. The lock
+	// is held for at most this long.
+	StampedeLockTTL time.Duration
+	// StampedeWait is how long instances not holding the refresh lock wait for a fresh entry when there is no stale
+	// entry to serve, before going to the origin themselves.
+	StampedeWait time.Duration
+
+	// EarlyExpirationBeta enables probabilistic early expiration (XFetch): as an entry approaches its expiry, it is
+	// randomly treated as expired and refreshed, with higher values refreshing earlier. 1 is a sensible default,
+	// 0 disables early expiration.
+	EarlyExpirationBeta float64
+
+	// RefreshAhead enables background revalidation of hot entries shortly before they expire. Call Close to stop
+	// the background workers.
+	RefreshAhead *RefreshAhead
+	refresher    *refresher
+
+	// Sweeper enables a background sweeper removing expired entries, for providers without native expiry. It is
+	// started by the first call to Do; call Close to stop it.
+	Sweeper *Sweeper
+	sweeper *sweeper
+
+	// MinRefreshInterval is the minimum interval between two origin requests for the same cached key, or 0 for no
+	// limit. Within it, calls that would go to the origin, be it because the entry expired, WithIgnoreCache, a
+	// Pragma: no-cache header or a refresh ahead, are served the cached entry instead, with the rate_limited status.
+	// This protects the origin from abusive or buggy callers.
+	MinRefreshInterval time.Duration
+	refreshLimits      *refreshLimiter
+
+	// ReverseIndex maintains sidecar keys listing the keys stored for each URL and host, so that InvalidateURL and
+	// InvalidateHost remove every entry of a resource, including variants and entries stored under the keys of a
+	// custom KeyGenerator, tenants or partitions. This costs two provider reads and writes per stored entry.
+	ReverseIndex bool
+
+	// TrackAccess records the hit count and last access time of entries in sidecar keys, see EntryInfo and HotKeys.
+	// This costs a provider read and write per hit.
+	TrackAccess bool
+
+	// SlidingExpiration pushes the expiry of entries forward on every hit, so they stay fresh for at least
+	// SlidingExpiration after their last use. Policy rules can set their own duration. Zero disables it.
+	//
+	// With providers implementing Toucher, hits only extend the expiry of the key in the provider, which drops the
+	// entry once unused for SlidingExpiration. Otherwise, entries are rewritten once less than half of it is left.
+	SlidingExpiration time.Duration
+
+	// StatusTTLs sets freshness lifetimes by status code for responses whose headers don't set one, e.g. 404 → 1m,
+	// or prevents them from being stored. Policy rules take precedence.
+	StatusTTLs StatusTTLs
+
+	// TTLOverride computes the freshness lifetime of responses fetched from the origin, for instance from an
+	// expires_at field of their JSON body, when their headers don't set one or set a wrong one. It is called before
+	// the response is stored, with its body buffered, and returns false to keep the lifetime set by the headers.
+	// Policy rules take precedence; a zero or negative lifetime stores the entry already stale.
+	TTLOverride func(req *http.Request, resp *http.Response) (time.Duration, bool)
+
+	// TTLJitter shortens the freshness lifetime of stored entries by a random duration of up to TTLJitter, so that
+	// entries written at the same moment don't all expire at the same time.
+	TTLJitter time.Duration
+
+	// StaleIfError serves the most recent entry, even if expired, when the origin fails or answers with a 5xx
+	// status code, as long as it has been stale for less than StaleIfError. Served responses carry a Warning header.
+	StaleIfError time.Duration
+
+	// RetryAfter honors the Retry-After header of 429 and 503 origin responses, for up to RetryAfter: until the
+	// indicated moment, the entry, even expired, is served without reaching the origin, or the error response itself
+	// when there is none and it may be stored. Expired entries are still served as stale. Zero disables it.
+	RetryAfter time.Duration
+
+	// Offline answers every request from the cache, regardless of freshness, without ever reaching the origin.
+	// Misses return ErrCacheMiss. See also WithOffline.
+	Offline bool
+
+	// HostLimit caps the number of concurrent origin requests per host, or nil for no limit.
+	HostLimit *HostLimit
+	hosts     *hostLimiter
+
+	// Retry enables retries with exponential backoff of idempotent origin requests that fail or answer with a
+	// retryable status code. When retries are exhausted, StaleIfError still applies.
+	Retry *Retry
+
+	// TracerProvider enables OpenTelemetry tracing of Do, provider operations and origin requests, or nil to
+	// disable tracing. Spans are children of the span carried by the request context.
+	TracerProvider trace.TracerProvider
+
+	// Hooks are called on cache activity, see MergeHooks to combine several observers.
+	Hooks    Hooks
+	counters *counters
+
+	// RevalidationBudget is the minimum time left before the request context deadline for an expired entry to be
+	// revalidated against the origin. With less time left, the stale entry is served instead of risking a
+	// deadline error. Zero always revalidates.
+	RevalidationBudget time.Duration
+
+	// KeyCollisionCheck compares the URL recorded in entries with the URL of the requests they are served to, and logs
+	// an error with both when they differ: a custom KeyGenerator maps different resources to the same key.
+	KeyCollisionCheck bool
+
+	// DebugHeaders annotates responses with X-Cache-Key, X-Cache-Status and X-Cache-Age headers, telling what the
+	// cache did. Meant for development, as keys may reveal more than the URL.
+	DebugHeaders bool
+
+	// DecompressCached transparently decodes gzip, deflate, br and zstd encoded responses served from the cache when the
+	// request didn't ask for that coding in its Accept-Encoding header, as net/http does for gzip: Content-Encoding and
+	// Content-Length are removed and Uncompressed is set. Partial responses are served as stored.
+	DecompressCached bool
+
+	// ReadFailurePolicy defines what happens when the provider fails to return an entry. By default, the failure is
+	// logged and the request is treated as a miss.
+	ReadFailurePolicy FailurePolicy
+
+	// WriteFailurePolicy defines what happens when the provider fails to store an entry, fetched, revalidated or held
+	// off by RetryAfter. By default, the failure is logged and the response is returned, uncached.
+	WriteFailurePolicy FailurePolicy
+
+	// AsyncWrites enables write-behind storage, where entries are written to the provider in the background.
+	// Pending writes are flushed on Close.
+	AsyncWrites *AsyncWrites
+	writer      *asyncWriter
+
+	// WriteBatching buffers entries and writes them to their provider in batches, see WriteBatching for its
+	// durability caveats. It takes precedence over AsyncWrites.
+	WriteBatching *WriteBatching
+	batcher       *batchWriter
+
+	// WriteRetry retries failed provider writes in the background, with exponential backoff. Writes still failing
+	// after the last attempt are counted in Stats. Retries are canceled by a newer write or a removal of their key.
+	// Shutdown waits for the pending retries, Close abandons them.
+	WriteRetry *WriteRetry
+	retrier    *writeRetrier
+
+	// TenantQuotas returns the quota of a tenant, see WithTenant, or nil for no quotas. Entries of tenants over quota
+	// are not stored.
+	TenantQuotas func(tenant string) TenantQuota
+	tenants      *tenantTracker
+
+	// MemoryBudget caps the number of bytes the cache stores in its providers, or 0 for no limit. Once reached, new
+	// entries are not stored, which is logged and counted in Stats. Usage is estimated from the writes of the
+	// current process, until the written entries expire, unless every provider implements Sizer.
+	MemoryBudget int64
+	budget       *budgetTracker
+
+	// EntryCompression compresses the bodies of the entries written to the provider, or nil to store them as they are.
+	EntryCompression *EntryCompression
+	compressor       *entryCompressor
+
+	// Policy overrides the header-driven behaviour of the cache for requests matching its rules, or nil.
+	Policy *Policy
+
+	// StreamIdleTimeout is how long the body of unknown length of a response may stay idle before the response is
+	// handed over to the caller as a stream and not stored, as chunked long polls need, e.g. 500 milliseconds. Like
+	// the ones over the MaxBodySize of their policy rule, such bodies are otherwise read until they end. Zero disables
+	// it, and the reading of these bodies in the background it requires.
+	StreamIdleTimeout time.Duration
+
+	// RequestMetadata records, in every stored entry, the method, selected headers and body hash of the request it
+	// was fetched for, or nil not to. See RequestMetadata.
+	RequestMetadata *RequestMetadata
+
+	// BypassHeader lets authorized requests carrying a header skip the cache, see BypassHeader.
+	BypassHeader *BypassHeader
+
+	// Routes send the entries of matching requests to other providers, for instance to keep large media files out of
+	// an in-memory provider. The first matching route wins; requests matching no route use the provider of the cache.
+	Routes []ProviderRoute
+
+	// VCR enables record-and-replay mode, turning the cache into a fixture for tests of HTTP-dependent code, or nil
+	// for regular caching.
+	VCR *VCR
+
+	// Now returns the current time, or nil for time.Now. Entry timestamps and freshness checks use it, so tests can
+	// control the passing of time.
+	Now func() time.Time
+
+	// NotModified defines the response returned when the origin revalidates an entry with a 304 status code. By
+	// default, a 200 response is built from the cached entry.
+	NotModified NotModifiedMode
+
+	// UnusableNotModified defines what happens when the origin answers with a 304 status code but there is no entry
+	// to serve, e.g. because the validators came from the caller or the entry was evicted in the meantime. By
+	// default, the request is transparently reissued once without validators.
+	UnusableNotModified UnusableNotModifiedMode
 
 	LogExtractor LoggerExtractor
+
+	groupPrefix string // key prefix of the group, see Group
+	groups      *groupRegistry
 }
 
-type cacheStat string
+// FailurePolicy defines how the cache reacts to provider failures.
+type FailurePolicy int
+
+const (
+	FailureLenient FailurePolicy = iota // log the failure and carry on as if there was no cache
+	FailureStrict                       // abort the call, returning the failure
+)
+
+// NotModifiedMode defines how revalidated entries are returned.
+type NotModifiedMode int
 
 const (
-	cacheStatExpired       cacheStat = "expired"
-	cacheStatHit           cacheStat = "hit"
-	cacheStatIgnoreCheck   cacheStat = "ignored_check"
-	cacheStatIgnored       cacheStat = "ignored"
-	cacheStatIgnoredExpiry cacheStat = "ignored_expiry"
-	cacheStatMiss          cacheStat = "miss"
+	NotModifiedAsEntry NotModifiedMode = iota // return the cached entry, with its status code, body and refreshed headers
+	NotModifiedEmpty                          // return the 304 response, with the refreshed headers and an empty body
 )
 
+// UnusableNotModifiedMode defines how 304 origin responses without an entry to serve are handled.
+type UnusableNotModifiedMode int
+
+const (
+	UnusableNotModifiedRetry UnusableNotModifiedMode = iota // reissue the request once, without its validators
+	UnusableNotModifiedError                                // fail with an OriginError wrapping ErrValidatorMismatch
+)
+
+// CacheStatus describes how a call to Do was answered.
+type CacheStatus string
+
+const (
+	CacheStatusExpired       CacheStatus = "expired"        // the entry was expired and revalidated with the origin
+	CacheStatusHit           CacheStatus = "hit"            // a fresh entry was served
+	CacheStatusIgnoreCheck   CacheStatus = "ignored_check"  // the origin was not checked, see WithOnlyCached
+	CacheStatusIgnored       CacheStatus = "ignored"        // the cache was not read, see WithIgnoreCache
+	CacheStatusIgnoredExpiry CacheStatus = "ignored_expiry" // an expired entry was served, see WithIgnoreExpired
+	CacheStatusMiss          CacheStatus = "miss"           // there was no entry, the origin was requested
+	CacheStatusStale         CacheStatus = "stale"          // a stale entry was served while another instance refreshes it
+	CacheStatusStaleError    CacheStatus = "stale_if_error" // a stale entry was served because the origin failed
+	CacheStatusStaleDeadline CacheStatus = "stale_deadline" // a stale entry was served because of the request deadline
+	CacheStatusBypass        CacheStatus = "bypass"         // the cache was neither read nor written, see Policy
+	CacheStatusRevalidated   CacheStatus = "revalidated"    // a fresh entry was revalidated, as asked by a Pragma: no-cache header
+	CacheStatusRateLimited   CacheStatus = "rate_limited"   // the entry was served because it was refreshed too recently, see MinRefreshInterval
+)
+
+// FromCache reports whether responses with this status were served from the cache, without an origin response.
+func (s CacheStatus) FromCache() bool {
+	switch s {
+	case CacheStatusHit, CacheStatusIgnoreCheck, CacheStatusIgnoredExpiry, CacheStatusStale, CacheStatusStaleError, CacheStatusStaleDeadline, CacheStatusRateLimited:
+		return true
+	}
+	return false
+}
+
 func New(provider Provider) *Cache {
 	return &Cache{
-		provider: provider,
+		slot:      newProviderSlot(provider),
+		flights:   newFlightGroup(),
+		refresher: newRefresher(),
+		hosts:     newHostLimiter(),
+		writer:    newAsyncWriter(),
+		counters:  &counters{latencies: newLatencies(), endpoints: newEndpointLatencies()},
+		tenants:   newTenantTracker(),
+		budget:    newBudgetTracker(),
+
+		refreshLimits: newRefreshLimiter(),
+		sweeper:       newSweeper(),
+		groups:        newGroupRegistry(),
+		batcher:       newBatchWriter(),
+		retrier:       newWriteRetrier(),
+		compressor:    newEntryCompressor(),
+	}
+}
+
+// Close stops the background workers started by the cache and flushes pending writes.
+func (r Cache) Close() error {
+	if r.refresher != nil {
+		r.refresher.close()
+	}
+	if r.sweeper != nil {
+		r.sweeper.close()
+	}
+	if r.batcher != nil {
+		r.batcher.close()
 	}
+	if r.writer != nil {
+		r.writer.close()
+	}
+	if r.retrier != nil {
+		r.retrier.close()
+	}
+	return nil
 }
 
-func (r Cache) httpClient() HttpRequester {
+func (r Cache) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
+
+func (r Cache) httpClient(ctx context.Context) HttpRequester {
+	if client := HTTPClient(ctx); client != nil {
+		return client
+	}
 	if r.HttpClient == nil {
 		return http.DefaultClient
 	}
@@ -50,45 +363,208 @@ func (r Cache) httpClient() HttpRequester {
 }
 
 func (r Cache) read(ctx context.Context, key string) (*cacheEntry, error) {
-	value, err := r.provider.Get(ctx, key)
+	spanCtx, span := r.startSpan(ctx, "cache.provider.Get", attribute.String("cache.key", key))
+	value, err := r.currentProvider().Get(spanCtx, key)
+	span.SetAttributes(attribute.Bool("cache.found", len(value) > 0))
+	endSpan(span, err)
 	if err != nil {
-		return nil, fmt.Errorf("provider.Get(): %w", err)
+		r.recordProviderError(ctx, "get", err)
+		return nil, &ProviderError{Op: "get", Key: key, Err: err}
 	}
 
+	return r.decode(ctx, key, value)
+}
+
+// decode unmarshals a value read from the provider, telling whether the entry is expired.
+func (r Cache) decode(ctx context.Context, key string, value []byte) (*cacheEntry, error) {
 	if len(value) == 0 {
 		return nil, nil
 	}
 
 	var entry cacheEntry
 	if err := json.Unmarshal(value, &entry); err != nil {
-		r.logger(ctx).Error("error unmarshalling cache entry", "error", err)
+		r.logError(ctx, "error unmarshalling cache entry", "key", key, "provider", r.providerName(), "error", err)
+		return nil, nil
+	}
+	if err := decompressEntry(&entry); err != nil {
+		r.logError(ctx, "error decompressing cache entry", "key", key, "codec", entry.Codec, "provider", r.providerName(), "error", err)
 		return nil, nil
 	}
 
-	if entry.expired() {
-		if IgnoreExpired(ctx) {
-			return &entry, ErrCacheExpiryIgnored
+	if entry.isIndex() {
+		// indexes don't expire, their variants do
+		return &entry, nil
+	}
+	if entry.expired(r.now()) {
+		expires, _ := entry.expiresAt()
+		if IgnoreExpired(ctx) || r.now().Sub(expires) <= MaxStale(ctx) {
+			return &entry, &StaleEntryError{Key: key, Expires: expires, Err: ErrCacheExpiryIgnored}
+		}
+		return &entry, &StaleEntryError{Key: key, Expires: expires, Err: ErrCacheExpired}
+	}
+	if minFresh := MinFresh(ctx); minFresh > 0 && !IgnoreExpired(ctx) {
+		if expires, _ := entry.expiresAt(); expires.Sub(r.now()) < minFresh {
+			r.logDebug(ctx, "entry not fresh enough", "key", key, "min-fresh", minFresh)
+			return &entry, &StaleEntryError{Key: key, Expires: expires, Err: ErrCacheExpired}
 		}
-		return &entry, ErrCacheExpired
+	}
+	if !IgnoreExpired(ctx) && entry.expiresEarly(r.now(), r.EarlyExpirationBeta, rand.Float64()) {
+		r.logDebug(ctx, "early expiration", "key", key)
+		expires, _ := entry.expiresAt()
+		return &entry, &StaleEntryError{Key: key, Expires: expires, Err: ErrCacheExpired}
 	}
 	return &entry, nil
 }
 
 func (r Cache) write(ctx context.Context, key string, entry *cacheEntry) error {
-	dataBytes, err := json.Marshal(entry)
+	return r.writeExpiring(ctx, key, entry, 0)
+}
+
+// writeExpiring writes entry under key, with the given provider expiry, 0 meaning no expiry. Entries expiring in the
+// provider are never batched, batches sharing a single expiry.
+func (r Cache) writeExpiring(ctx context.Context, key string, entry *cacheEntry, expiry time.Duration) error {
+	dataBytes, err := json.Marshal(r.compressEntry(ctx, key, entry))
 	if err != nil {
 		return fmt.Errorf("json.Marshal(): %w", err)
 	}
 
+	if !r.checkBudget(ctx, key, len(dataBytes)) {
+		return nil
+	}
+	if !r.checkTenant(ctx, key, len(dataBytes)) {
+		return nil
+	}
+
+	// a pending retry of an older value must not land after this write
+	r.cancelRetry(key)
+
+	// writes completing in the background are accounted for once accepted, and released if they finally fail
+	lapses := r.usageLapses(entry)
 	// TODO: optionally retrieve the expiration from the headers
 	// TODO: optionally retrieve the expiration from the context
-	if err := r.provider.Set(ctx, key, dataBytes, 0); err != nil {
-		return fmt.Errorf("provider.Set(): %w", err)
+	if r.WriteBatching != nil && r.batcher != nil && expiry == 0 {
+		pinned, done := r.pinProvider()
+		r.chargeUsage(key, len(dataBytes), lapses)
+		if r.batcher.add(pinned, key, dataBytes, done) {
+			return nil
+		}
+		r.releaseUsage(key)
+		done()
+	}
+	if r.AsyncWrites != nil && r.writer != nil {
+		pinned, done := r.pinProvider()
+		r.chargeUsage(key, len(dataBytes), lapses)
+		if r.writer.enqueue(writeJob{ctx: detach(ctx), cache: pinned, key: key, value: dataBytes, expiry: expiry, done: done}) {
+			return nil
+		}
+		r.releaseUsage(key)
+		done()
+	}
+	if err := r.providerSet(ctx, key, dataBytes, expiry); err != nil {
+		if r.WriteRetry != nil {
+			pinned, done := r.pinProvider()
+			r.chargeUsage(key, len(dataBytes), lapses)
+			if pinned.retryWrite(key, dataBytes, expiry, done) {
+				r.logError(ctx, "error writing entry, retrying in the background", "key", key, "provider", r.providerName(), "error", err)
+				return nil
+			}
+			r.releaseUsage(key)
+			done()
+		}
+		return &ProviderError{Op: "set", Key: key, Err: err}
+	}
+	r.chargeUsage(key, len(dataBytes), lapses)
+	return nil
+}
+
+// usageLapses returns the moment an entry stops being accounted for in the usage of the cache: its expiry, or now if
+// it is unknown, as for variant indexes.
+func (r Cache) usageLapses(entry *cacheEntry) time.Time {
+	if entry.Sliding > 0 {
+		// expired by the provider, once unused for the window
+		return r.now().Add(entry.Sliding)
+	}
+	if expires, ok := entry.expiresAt(); ok {
+		return expires
+	}
+	return r.now()
+}
+
+// chargeUsage accounts for an entry of size bytes written under key, until it lapses.
+func (r Cache) chargeUsage(key string, size int, lapses time.Time) {
+	r.chargeTenant(key, size, lapses)
+	r.chargeBudget(key, size, lapses)
+}
+
+// releaseUsage accounts for the removal of the entry stored under key, or for a failed write of it.
+func (r Cache) releaseUsage(key string) {
+	r.releaseTenant(key)
+	r.releaseBudget(key)
+}
+
+// providerSet writes value to the provider, tracing the operation.
+func (r Cache) providerSet(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	ctx, span := r.startSpan(ctx, "cache.provider.Set",
+		attribute.String("cache.key", key),
+		attribute.Int("cache.entry_size", len(value)),
+	)
+	err := r.currentProvider().Set(ctx, key, value, expiry)
+	endSpan(span, err)
+	if err != nil {
+		r.recordProviderError(ctx, "set", err)
+		return err
+	}
+	if r.budget != nil {
+		r.budget.written.Add(int64(len(value)))
+	}
+	r.recordStore(ctx, key, len(value))
+	return nil
+}
+
+// store reads the response body and writes it to the provider, see writeEntry. start is the time the origin request
+// was issued, and rule the policy rule matching the request, or nil. A nil req stores the entry under key regardless
+// of its Vary header. When writing fails, the entry is returned along with the error.
+func (r Cache) store(ctx context.Context, key string, req *http.Request, resp *http.Response, start time.Time, rule *PolicyRule) (*cacheEntry, error) {
+	e, err := r.buffer(ctx, req, resp, start)
+	if err != nil {
+		return nil, err
+	}
+	return r.storeEntry(ctx, key, req, resp, e, rule)
+}
+
+// storeEntry computes the expiry of e, buffered from resp, and writes it to the provider if it may be stored, see
+// store.
+func (r Cache) storeEntry(ctx context.Context, key string, req *http.Request, resp *http.Response, e *cacheEntry, rule *PolicyRule) (*cacheEntry, error) {
+	r.overrideTTL(e, req, resp, e.Ts)
+	r.setExpiry(e, e.Ts, rule)
+
+	if !r.storable(ctx, key, e, rule) {
+		return e, nil
+	}
+	if r.skipWrite(ctx, key) {
+		return e, nil
 	}
+	if err := r.writeEntry(ctx, key, req, e); err != nil {
+		// the body was buffered, the caller can still use the response
+		return e, r.writeFailed(ctx, key, err)
+	}
+
+	return e, nil
+}
+
+// writeFailed applies the WriteFailurePolicy to err, the failure to write the entry of a response stored under key:
+// it is returned with FailureStrict, along with the response, and logged otherwise.
+func (r Cache) writeFailed(ctx context.Context, key string, err error) error {
+	if r.WriteFailurePolicy == FailureStrict {
+		return fmt.Errorf("r.write(): %w", err)
+	}
+	r.logError(ctx, "error writing entry", "key", key, "provider", r.providerName(), "error", err)
 	return nil
 }
 
-func (r Cache) store(ctx context.Context, key string, resp *http.Response) (*cacheEntry, error) {
+// buffer reads and closes the body of resp, returning it as an entry without expiry. start is the time the origin
+// request was issued.
+func (r Cache) buffer(ctx context.Context, req *http.Request, resp *http.Response, start time.Time) (*cacheEntry, error) {
 	defer func(Body io.ReadCloser) {
 		if err := Body.Close(); err != nil {
 			r.logInfo(ctx, "error closing response body", "error", err)
@@ -99,127 +575,615 @@ func (r Cache) store(ctx context.Context, key string, resp *http.Response) (*cac
 		return nil, fmt.Errorf("io.ReadAll(): %w", err)
 	}
 
-	e := cacheEntry{
-		Ts:         time.Now(),
+	e := &cacheEntry{
+		Ts:         r.now(),
 		StatusCode: resp.StatusCode,
 		Data:       data,
 		Headers:    make(map[string]string),
+		Delta:      time.Since(start),
+	}
+	if req != nil {
+		e.URL = r.logURL(req)
+		e.Request = r.requestMetadata(req)
 	}
 	for k, v := range resp.Header {
 		e.Headers[k] = v[0]
 	}
+	normalizeContentEncoding(e.Headers)
+	return e, nil
+}
 
-	if err := r.write(ctx, key, &e); err != nil {
-		return nil, fmt.Errorf("r.write(): %w", err)
+// skipWrite reports whether the response of the call must not be written, because the caller went away or asked for
+// it with WithNoStore.
+func (r Cache) skipWrite(ctx context.Context, key string) bool {
+	if ctx.Err() != nil {
+		r.logDebug(ctx, "request canceled, not storing entry", "key", key)
+		return true
 	}
+	if NoStore(ctx) {
+		r.logDebug(ctx, "no store requested, not storing entry", "key", key)
+		return true
+	}
+	return false
+}
 
-	return &e, nil
+// storable reports whether e may be stored under key, logging why it may not. WithForceStore overrides every check but
+// the size one.
+func (r Cache) storable(ctx context.Context, key string, e *cacheEntry, rule *PolicyRule) bool {
+	if !rule.storable(len(e.Data)) {
+		r.logDebug(ctx, "response too large to be stored", "key", key, "size", len(e.Data))
+		return false
+	}
+	if ForceStore(ctx) {
+		return true
+	}
+	if rule.ttl(e.StatusCode) <= 0 && !r.StatusTTLs.storable(e.StatusCode) {
+		r.logDebug(ctx, "status not stored", "key", key, "status", e.StatusCode)
+		return false
+	}
+	if _, any := parseVary(e.header("Vary")); any {
+		r.logDebug(ctx, "response varying on anything not stored", "key", key)
+		return false
+	}
+	return true
+}
+
+// jitter moves expires back by a random duration of up to maxJitter, but never before now.
+func jitter(expires time.Time, now time.Time, maxJitter time.Duration) time.Time {
+	if !expires.After(now) {
+		return expires
+	}
+	jittered := expires.Add(-time.Duration(rand.Int63n(int64(maxJitter))))
+	if jittered.Before(now) {
+		return now
+	}
+	return jittered
+}
+
+// Key returns the key the entry for req is stored under.
+func (r Cache) Key(req *http.Request) string {
+	return r.key(req)
 }
 
 func (r Cache) key(req *http.Request) string {
+	rule := r.policyRule(req)
+	req = r.keyRequest(req)
+	var key string
 	if r.KeyGenerator == nil {
-		return DefaultKeyGenerator(req)
+		key = DefaultKeyGenerator(req)
+	} else {
+		key = r.KeyGenerator(req)
 	}
-	return r.KeyGenerator(req)
+	key = rule.varyKey(key, req)
+	if r.ScopeByAuthorization {
+		key = r.authorizationScope(key, req)
+	}
+	return r.namespaced(tenantKey(req.Context(), partitionKey(req.Context(), r.groupPrefix+r.KeyHash.apply(key))))
 }
 
+// callInfo collects details about how a call to Do was answered.
+type callInfo struct {
+	key         string
+	stat        CacheStatus
+	stored      time.Time   // when the served entry was stored, zero if the response did not come from an entry
+	expires     time.Time   // when the served entry expires, zero if unknown or if the response did not come from an entry
+	revalidated bool        // the origin answered with a 304 status code
+	rule        *PolicyRule // policy rule matching the request, if any
+	endpoint    string      // Name of rule
+}
+
+// serve returns entry as the response to req, remembering when the entry was stored. Range requests are answered from
+// the entry.
+func (i *callInfo) serve(req *http.Request, entry *cacheEntry) *http.Response {
+	i.stored = entry.Ts
+	i.expires, _ = entry.expiresAt()
+	return withRange(req, entry, entry.asHttpResponse(req))
+}
+
+// Do answers req from the cache or the origin. With WriteFailurePolicy FailureStrict, a response fetched from the
+// origin but that could not be stored is returned along with the error.
 func (r Cache) Do(req *http.Request) (*http.Response, error) {
+	resp, info, err := r.doWithInfo(req)
+	if err == nil && StaleError(req.Context()) {
+		if _, stale := info.staleFor(r.now()); stale {
+			return resp, &StaleEntryError{Key: info.key, Expires: info.expires, Err: ErrStaleServed}
+		}
+	}
+	return resp, err
+}
+
+// DoStatus describes how a call to DoWithStatus was answered.
+type DoStatus struct {
+	Key      string        // cache key, empty if the request bypassed the cache
+	Cache    CacheStatus   // how the call was answered, empty if the request bypassed the cache
+	Stale    bool          // the response was served from an expired entry
+	StaleFor time.Duration // time since the served entry expired, if Stale
+}
+
+// DoWithStatus is Do, also returning how the call was answered, so callers relying on freshness can tell stale
+// responses apart and decide to retry or annotate them.
+func (r Cache) DoWithStatus(req *http.Request) (*http.Response, DoStatus, error) {
+	resp, info, err := r.doWithInfo(req)
+	status := DoStatus{Key: info.key, Cache: info.stat}
+	status.StaleFor, status.Stale = info.staleFor(r.now())
+	return resp, status, err
+}
+
+func (r Cache) doWithInfo(req *http.Request) (*http.Response, callInfo, error) {
+	ctx, span := r.startSpan(req.Context(), "cache.Do",
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", r.logURL(req)),
+	)
+	if span.IsRecording() {
+		req = req.WithContext(ctx)
+	}
+
+	var info callInfo
+	start := time.Now()
+	resp, err := r.route(req).do(req, &info)
+	endDoSpan(span, info, resp, err)
+	if resp != nil && r.VCR == nil {
+		r.markStale(resp, info)
+	}
+	if r.DecompressCached && resp != nil && !info.stored.IsZero() {
+		r.decompress(ctx, req, resp, info.rule)
+	}
+	if r.DebugHeaders && resp != nil {
+		r.annotate(resp, info)
+	}
+
+	event := Event{
+		Start:    start,
+		Method:   req.Method,
+		URL:      r.logURL(req),
+		Key:      info.key,
+		Status:   info.stat,
+		Source:   info.source(),
+		Endpoint: info.endpoint,
+		Size:     -1,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if resp != nil {
+		event.StatusCode = resp.StatusCode
+		event.Size = resp.ContentLength
+	}
+	r.recordDo(ctx, event)
+
+	return resp, info, err
+}
+
+func (r Cache) do(req *http.Request, info *callInfo) (*http.Response, error) {
 	ctx := req.Context()
 
 	event := &internalLogger{logger: r.logger(ctx)}
-	event = event.With("url", req.URL.String())
-	var stat cacheStat
+	event = event.With("url", r.logURL(req))
 	defer func() {
-		if stat != "" {
-			event = event.With("cache", stat)
+		if info.stat != "" {
+			event = event.With("cache", info.stat)
 		}
 		event.Info("cache.Do")
 	}()
-	if req.Method != http.MethodGet {
-		return r.httpClient().Do(req)
+	if r.VCR != nil {
+		return r.doVCR(req, info)
+	}
+	r.startSweeper()
+	if r.BypassHeader != nil && req.Header.Get(r.BypassHeader.name()) != "" {
+		if r.bypassRequested(req) {
+			ctx = WithIgnoreCache(ctx, true)
+			event = event.With("bypass-header", true)
+		}
+		// never forward the header, and its secret, to the origin
+		req = req.Clone(ctx)
+		req.Header.Del(r.BypassHeader.name())
+	}
+	offline := r.Offline || Offline(ctx)
+	if offline {
+		// serve anything we have, but never go to the origin
+		ctx = WithIgnoreCache(WithIgnoreExpired(WithOnlyCached(ctx, true), true), false)
+		event = event.With("offline", true)
+	}
+	rule := r.policyRule(req)
+	ctx = r.withPolicyRule(ctx, req, rule)
+	req = req.WithContext(ctx)
+	info.rule = rule
+	if rule != nil {
+		info.endpoint = rule.Name
+	}
+	if req.Method != http.MethodGet || rule.bypasses(req) {
+		if offline {
+			return nil, ErrCacheMiss
+		}
+		if req.Method == http.MethodGet {
+			info.stat = CacheStatusBypass
+		}
+		return r.originDo(ctx, req)
+	}
+	if rule != nil && rule.OnlyCached {
+		ctx = WithOnlyCached(ctx, true)
 	}
 
 	key := r.key(req)
+	info.key = key
 	event = event.With("cache-key", key)
 
 	var entry *cacheEntry
+	entryKey := key // key of the variant matching req, once known
 
 	if IgnoreCache(ctx) {
-		stat = cacheStatIgnored
+		info.stat = CacheStatusIgnored
 	} else {
 		var err error
-		entry, err = r.read(ctx, key)
+		entry, err = r.lookup(ctx, key)
+		entryKey, entry, err = r.resolveVariant(ctx, key, req, entry, err)
+		r.checkCollision(ctx, entryKey, req, entry)
 		if err != nil {
-			if errors.Is(err, ErrCacheExpired) {
-				stat = cacheStatExpired
+			if errors.Is(err, ErrCacheExpired) && entry != nil && entry.held(r.now()) {
+				// the origin asked to be left alone, see RetryAfter
+				info.stat = CacheStatusStaleError
+				return info.serve(req, entry), nil
+			} else if errors.Is(err, ErrCacheExpired) {
+				info.stat = CacheStatusExpired
 			} else if errors.Is(err, ErrCacheExpiryIgnored) {
-				stat = cacheStatIgnoredExpiry
-				return entry.asHttpResponse(req), nil
+				info.stat = CacheStatusIgnoredExpiry
+				return info.serve(req, entry), nil
 			} else {
 				event.Error("error", "err", err)
-				return nil, err
+				if r.ReadFailurePolicy == FailureStrict {
+					return nil, err
+				}
+				// carry on without the cache
+				entry = nil
+				info.stat = CacheStatusMiss
 			}
+		} else if entry != nil && pragmaNoCache(req) {
+			// legacy clients asking for revalidation
+			info.stat = CacheStatusRevalidated
 		} else if entry != nil {
-			stat = cacheStatHit
-			return entry.asHttpResponse(req), nil
+			info.stat = CacheStatusHit
+			if r.refresher != nil {
+				r.refresher.touch(r, req, key, entry)
+			}
+			r.slide(ctx, entryKey, entry, rule)
+			r.recordAccess(ctx, entryKey, entry)
+			return info.serve(req, entry), nil
 		} else {
-			stat = cacheStatMiss
+			info.stat = CacheStatusMiss
 		}
 	}
 
 	if OnlyCached(ctx) {
-		stat = cacheStatIgnoreCheck
+		info.stat = CacheStatusIgnoreCheck
 		if entry == nil {
 			return nil, ErrCacheMiss
 		}
-		return entry.asHttpResponse(req), nil
+		return info.serve(req, entry), nil
+	}
+
+	if entry != nil && r.RevalidationBudget > 0 {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < r.RevalidationBudget {
+			info.stat = CacheStatusStaleDeadline
+			return info.serve(req, entry), nil
+		}
+	}
+
+	if !r.allowRefresh(key) {
+		if entry == nil && IgnoreCache(ctx) {
+			entry = r.cachedEntry(ctx, key, req)
+		}
+		if entry != nil {
+			info.stat = CacheStatusRateLimited
+			return info.serve(req, entry), nil
+		}
+	}
+
+	if !IgnoreCache(ctx) {
+		release, acquired := r.acquireRefresh(ctx, key)
+		defer release()
+		if !acquired {
+			// some other instance is refreshing this key
+			if entry != nil {
+				info.stat = CacheStatusStale
+				return info.serve(req, entry), nil
+			}
+			if fresh := r.waitFresh(ctx, key, req); fresh != nil {
+				info.stat = CacheStatusHit
+				return info.serve(req, fresh), nil
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		// the caller went away while the entry was read
+		return nil, err
+	}
+
+	var (
+		result *fetchResult
+		shared bool
+		err    error
+	)
+	start := time.Now()
+	if r.DisableCoalescing || r.flights == nil || NoStore(ctx) {
+		// responses not to be stored must not be shared either
+		result, err = r.fetch(ctx, req, key, entry)
+	} else {
+		result, shared, err = r.flights.do(ctx, entryKey, r.logURL(req), r.DedupWindow, func(ctx context.Context) (*fetchResult, error) {
+			return r.fetch(ctx, req.WithContext(ctx), key, entry)
+		})
+	}
+	if err == nil && shared && result.key != "" && result.key != r.storageKey(key, req, result.entry) {
+		// the shared response is another variant of the resource
+		shared = false
+		result, err = r.fetch(ctx, req, key, entry)
+	}
+	if err == nil && result.resp != nil && shared {
+		// the body of unbuffered responses can only be read once
+		info.stat = CacheStatusBypass
+		return r.originDo(ctx, req)
+	}
+	if err != nil {
+		event.Error("error", "err", err)
+		if result != nil && result.entry != nil {
+			// the response is fine, only storing it failed
+			return info.serve(req, result.entry), err
+		}
+		return nil, err
+	}
+	event = event.With("elapsed", time.Since(start))
+	if result.resp != nil {
+		info.stat = result.stat
+		event = event.With("status", result.resp.StatusCode)
+		return result.resp, nil
 	}
+	event = event.With("status", result.entry.StatusCode)
+	if shared {
+		event = event.With("coalesced", true)
+	}
+	if result.stat != "" {
+		info.stat = result.stat
+	}
+	info.revalidated = result.revalidated
+
+	return info.serve(req, result.entry), nil
+}
 
+// pragmaNoCache reports whether req carries a Pragma: no-cache header, which HTTP/1.0 clients send to ask for a
+// revalidation. It is ignored when req has a Cache-Control header, as in RFC 9111.
+func pragmaNoCache(req *http.Request) bool {
+	if _, ok := req.Header["Cache-Control"]; ok {
+		return false
+	}
+	for _, pragma := range req.Header.Values("Pragma") {
+		for _, directive := range strings.Split(pragma, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchResult is the outcome of an origin fetch.
+type fetchResult struct {
+	key         string         // key entry was stored under, see writeEntry
+	entry       *cacheEntry    // response to be handed to the caller
+	resp        *http.Response // unbuffered origin response to be handed to the caller instead of entry, see passthrough
+	stat        CacheStatus    // overrides the cache status of the lookup, if set
+	revalidated bool           // entry was revalidated by a 304 origin response
+}
+
+// fetch requests the resource from the origin, revalidating the given entry when possible, and stores the result.
+func (r Cache) fetch(ctx context.Context, req *http.Request, key string, entry *cacheEntry) (*fetchResult, error) {
+	rule := r.policyRule(req)
+	if req.Header.Get("Range") != "" {
+		// fetch the whole resource, ranges are served from the entry. Never modify the caller's request
+		req = req.Clone(ctx)
+		req.Header.Del("Range")
+		req.Header.Del("If-Range")
+	}
 	if entry != nil {
 		// find ETAG
-		etag, ok := entry.Headers["ETag"]
-		if ok && etag != "" {
+		if etag := entry.header("ETag"); etag != "" {
+			// never modify the caller's request
+			req = req.Clone(ctx)
 			req.Header.Set("If-None-Match", etag)
 		}
 	}
 
 	start := time.Now()
-	resp, err := r.httpClient().Do(req)
+	resp, err := r.originDo(ctx, req)
 	if err != nil {
-		event.Error("error", "err", err)
-		return nil, fmt.Errorf("http.Do(): %w", err)
+		if stale := r.staleOnError(entry); stale != nil {
+			r.logError(ctx, "origin request failed, serving stale entry", "key", key, "error", err)
+			return &fetchResult{entry: stale, stat: CacheStatusStaleError}, nil
+		}
+		return nil, &OriginError{Method: req.Method, URL: r.logURL(req), Key: key, Err: err}
+	}
+	if wait, ok := r.retryAfter(resp); ok {
+		return r.holdOff(ctx, key, req, resp, entry, wait, start, rule)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		if stale := r.staleOnError(entry); stale != nil {
+			if err := resp.Body.Close(); err != nil {
+				r.logInfo(ctx, "error closing response body", "error", err)
+			}
+			r.logError(ctx, "origin returned an error, serving stale entry", "key", key, "status", resp.StatusCode)
+			return &fetchResult{entry: stale, stat: CacheStatusStaleError}, nil
+		}
 	}
-	event = event.With("elapsed", time.Since(start))
-	event = event.With("status", resp.StatusCode)
 
 	if resp.StatusCode == http.StatusNotModified {
-		// update expires and last-modified
+		if err := resp.Body.Close(); err != nil {
+			r.logInfo(ctx, "error closing response body", "error", err)
+		}
+
 		if entry == nil {
 			// we don't have any data to use as "not modified"
-			err := errors.New("no cached entry to return")
-			event.Error("error", "err", err)
+			if r.UnusableNotModified != UnusableNotModifiedError && hasValidators(req) {
+				r.logInfo(ctx, "not modified without an entry, retrying without validators", "key", key)
+				return r.fetch(ctx, withoutValidators(ctx, req), key, nil)
+			}
+			return nil, &OriginError{Method: req.Method, URL: r.logURL(req), Key: key, Err: ErrValidatorMismatch}
+		}
+		refreshed := r.revalidated(entry, resp, start, rule)
+		if !r.skipWrite(ctx, key) {
+			if err := r.writeEntry(ctx, key, req, refreshed); err != nil {
+				if err := r.writeFailed(ctx, key, err); err != nil {
+					return &fetchResult{entry: r.notModified(refreshed), revalidated: true}, err
+				}
+			}
+		}
+
+		return &fetchResult{key: r.storageKey(key, req, refreshed), entry: r.notModified(refreshed), revalidated: true}, nil
+	}
+
+	passthrough, err := r.passthrough(ctx, key, resp, rule)
+	if err != nil {
+		return nil, &OriginError{Method: req.Method, URL: r.logURL(req), Key: key, Err: err}
+	}
+	if passthrough != nil {
+		return &fetchResult{resp: passthrough, stat: CacheStatusBypass}, nil
+	}
+
+	e, err := r.store(ctx, key, req, resp, start, rule)
+	if err != nil {
+		err = fmt.Errorf("r.store(): %w", err)
+		if e == nil {
 			return nil, err
 		}
-		if expires, ok := entry.Headers["Expires"]; ok {
-			resp.Header.Set("Expires", expires)
+		return &fetchResult{entry: e}, err
+	}
+
+	return &fetchResult{key: r.storageKey(key, req, e), entry: e}, nil
+}
+
+// revalidated returns a copy of entry refreshed by a 304 origin response: the headers of the response (Cache-Control,
+// Expires, Date, ...) take precedence over the cached ones, and the freshness lifetime starts over. The cached Expires
+// header is dropped unless the response repeats it, the max-age or s-maxage of Cache-Control counting from the Date
+// of the response instead. start is the time the origin request was issued, and rule the policy rule matching the
+// request, or nil.
+func (r Cache) revalidated(entry *cacheEntry, resp *http.Response, start time.Time, rule *PolicyRule) *cacheEntry {
+	now := r.now()
+	e := &cacheEntry{
+		Ts:         now,
+		StatusCode: entry.StatusCode,
+		Data:       entry.Data,
+		Headers:    make(map[string]string, len(entry.Headers)+len(resp.Header)),
+		Delta:      time.Since(start),
+		Request:    entry.Request,
+	}
+	for k, v := range entry.Headers {
+		e.Headers[http.CanonicalHeaderKey(k)] = v
+	}
+	if resp.Header.Get("Expires") == "" {
+		// expired already, or the entry wouldn't be revalidated
+		delete(e.Headers, "Expires")
+	}
+	for k, v := range resp.Header {
+		if k != "Content-Length" && len(v) > 0 {
+			e.Headers[k] = v[0]
 		}
-		if lastModified, ok := entry.Headers["Last-Modified"]; ok {
-			resp.Header.Set("Last-Modified", lastModified)
+	}
+	if lifetime, ok := maxAge(e.header("Cache-Control")); ok {
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			date = now
 		}
-		if err := r.write(ctx, key, entry); err != nil {
-			event.Error("error", "err", err)
+		e.Expires = date.Add(lifetime)
+	}
+	r.setExpiry(e, now, rule)
+	return e
+}
+
+// maxAge returns the freshness lifetime set by a Cache-Control header value: its s-maxage directive, meant for shared
+// caches, or else its max-age directive. Returns false if there is none.
+func maxAge(cacheControl string) (time.Duration, bool) {
+	var lifetime time.Duration
+	var found bool
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		name = strings.ToLower(name)
+		if name != "s-maxage" && (name != "max-age" || found) {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			continue
 		}
+		lifetime, found = time.Duration(seconds)*time.Second, true
+		if name == "s-maxage" {
+			break
+		}
+	}
+	return lifetime, found
+}
 
-		resp.Body = io.NopCloser(bytes.NewReader(entry.Data))
+// overrideTTL sets the expiry of e, buffered from resp, to the lifetime returned by TTLOverride, if any.
+func (r Cache) overrideTTL(e *cacheEntry, req *http.Request, resp *http.Response, now time.Time) {
+	if r.TTLOverride == nil || req == nil {
+		return
+	}
+	buffered := *resp
+	buffered.Body = io.NopCloser(bytes.NewReader(e.Data))
+	if ttl, ok := r.TTLOverride(req, &buffered); ok {
+		e.Expires = now.Add(max(ttl, 0))
+	}
+}
 
-		return resp, nil
+// setExpiry computes the expiry of an entry stored at now, applying the policy rule, if any, and TTLJitter.
+func (r Cache) setExpiry(e *cacheEntry, now time.Time, rule *PolicyRule) {
+	if ttl := rule.ttl(e.StatusCode); ttl > 0 {
+		e.Expires = now.Add(ttl)
+	} else if _, ok := e.expiresAt(); !ok {
+		if ttl, ok := r.StatusTTLs.lookup(e.StatusCode); ok && ttl > 0 {
+			e.Expires = now.Add(ttl)
+		}
+	}
+	if expires, ok := e.expiresAt(); ok && r.TTLJitter > 0 {
+		e.Expires = jitter(expires, now, r.TTLJitter)
 	}
+}
 
-	e, err := r.store(ctx, key, resp)
-	if err != nil {
-		event.Error("error", "err", err)
-		return nil, fmt.Errorf("r.store(): %w", err)
+// notModified shapes the entry returned for a 304 origin response, according to the NotModified mode.
+func (r Cache) notModified(entry *cacheEntry) *cacheEntry {
+	if r.NotModified != NotModifiedEmpty {
+		return entry
+	}
+
+	e := *entry
+	e.StatusCode = http.StatusNotModified
+	e.Data = nil
+	e.Headers = make(map[string]string, len(entry.Headers))
+	for k, v := range entry.Headers {
+		if k != "Content-Length" {
+			e.Headers[k] = v
+		}
+	}
+	return &e
+}
+
+// staleOnError returns a copy of entry, annotated with a Warning header, if it can be served in place of a failed
+// origin response according to StaleIfError. Returns nil otherwise.
+func (r Cache) staleOnError(entry *cacheEntry) *cacheEntry {
+	if r.StaleIfError <= 0 || entry == nil {
+		return nil
+	}
+
+	expires, ok := entry.expiresAt()
+	if !ok {
+		expires = entry.Ts
+	}
+	if r.now().Sub(expires) > r.StaleIfError {
+		return nil
+	}
+
+	stale := *entry
+	stale.Headers = make(map[string]string, len(entry.Headers)+1)
+	for k, v := range entry.Headers {
+		stale.Headers[k] = v
 	}
+	stale.Headers["Warning"] = warningRevalidationFailed
 
-	return e.asHttpResponse(req), nil
+	return &stale
 }
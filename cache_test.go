@@ -1,14 +1,32 @@
 package cache
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"expvar"
+	"fmt"
 	"github.com/stretchr/testify/require"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"github.com/lsmoura/cache/memoryprovider"
 )
 
@@ -171,6 +189,7 @@ func TestCache_Etag(t *testing.T) {
 	ifNoneMatchHeader, ok := lastReq.Header["If-None-Match"]
 	require.False(t, !ok, "Expected If-None-Match header to be set")
 	require.Equalf(t, ifNoneMatchHeader[0], etag, "Expected If-None-Match header to match etag value")
+	require.Empty(t, req.Header.Get("If-None-Match"), "Expected the caller's request to be left untouched")
 }
 
 func TestCache_Keygen(t *testing.T) {
@@ -263,9 +282,4493 @@ func TestCache_Etag304(t *testing.T) {
 	ifNoneMatchHeader, ok := lastReq.Header["If-None-Match"]
 	require.True(t, ok, "Expected If-None-Match header to be set")
 	require.Equalf(t, ifNoneMatchHeader[0], etag, "Expected If-None-Match header to be %s, got %s", etag, ifNoneMatchHeader[0])
-	require.Equalf(t, resp.StatusCode, http.StatusNotModified, "Expected status code to be %d, got %d", http.StatusNotModified, resp.StatusCode)
+	require.Equalf(t, resp.StatusCode, http.StatusOK, "Expected status code to be %d, got %d", http.StatusOK, resp.StatusCode)
+	require.Equal(t, int64(len("Hello World")), resp.ContentLength)
 
 	body, err := io.ReadAll(resp.Body)
 	require.NoError(t, err, "io.ReadAll")
 	require.Equal(t, "Hello World", string(body))
+
+	cache.NotModified = NotModifiedEmpty
+	req, err = http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+
+	resp, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equalf(t, resp.StatusCode, http.StatusNotModified, "Expected status code to be %d, got %d", http.StatusNotModified, resp.StatusCode)
+	require.Equal(t, int64(0), resp.ContentLength)
+	require.Equal(t, etag, resp.Header.Get("ETag"))
+
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err, "io.ReadAll")
+	require.Empty(t, body)
+}
+
+func TestCache_KeyHash(t *testing.T) {
+	const cacheURL = "http://example.com/?token=secret"
+
+	tests := []struct {
+		name string
+		hash KeyHash
+		key  string
+	}{
+		{name: "none", hash: KeyHashNone, key: cacheURL},
+		{name: "sha256 hex", hash: KeyHashSHA256Hex, key: "ae015e3c2b4435df78ada968f677782f3d911bd2134fd774cdd902fd49d924e2"},
+		{name: "sha256 base64", hash: KeyHashSHA256Base64, key: "rgFePCtENd94ralo9nd4Lz2RG9ITT9d0zdkC_UnZJOI"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requester := fakeRequester{
+				data: map[string]*cacheEntry{
+					cacheURL: {
+						Ts:         time.Now(),
+						StatusCode: 200,
+						Data:       []byte("Hello World"),
+						Headers: map[string]string{
+							"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+						},
+					},
+				},
+			}
+
+			provider := memoryprovider.New()
+			cache := New(provider)
+			cache.HttpClient = &requester
+			cache.KeyHash = tt.hash
+
+			req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+			require.NoError(t, err, "http.NewRequest")
+			_, err = cache.Do(req)
+			require.NoError(t, err, "cache.Do")
+
+			key := tt.hash.apply(cacheURL)
+			require.Equal(t, tt.key, key)
+			if tt.hash != KeyHashNone {
+				require.NotContains(t, key, "secret")
+			}
+
+			value, err := provider.Get(context.Background(), key)
+			require.NoError(t, err, "provider.Get")
+			require.NotEmpty(t, value, "Expected entry to be stored under the hashed key")
+		})
+	}
+}
+
+// gatedRequester blocks every request until release is closed. Safe for concurrent use.
+type gatedRequester struct {
+	requestCount atomic.Int32
+	release      chan struct{}
+	entry        *cacheEntry
+}
+
+func (g *gatedRequester) Do(req *http.Request) (*http.Response, error) {
+	g.requestCount.Add(1)
+	<-g.release
+	return g.entry.asHttpResponse(req), nil
+}
+
+func TestCache_Coalescing(t *testing.T) {
+	const cacheURL = "http://example.com/"
+	const parallel = 100
+
+	newRequester := func() *gatedRequester {
+		return &gatedRequester{
+			release: make(chan struct{}),
+			entry: &cacheEntry{
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		}
+	}
+
+	run := func(t *testing.T, cache *Cache) {
+		var wg sync.WaitGroup
+		errs := make(chan error, parallel)
+		for i := 0; i < parallel; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				resp, err := cache.Do(req)
+				if err != nil {
+					errs <- err
+					return
+				}
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if string(body) != "Hello World" {
+					errs <- fmt.Errorf("unexpected body %q", body)
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("concurrent misses share one origin request", func(t *testing.T) {
+		requester := newRequester()
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+
+		go func() {
+			// wait for every caller but the in-flight one to join before letting the origin answer
+			for cache.flights.waiters(cache.key(httptest.NewRequest(http.MethodGet, cacheURL, nil))) < parallel-1 {
+				time.Sleep(time.Millisecond)
+			}
+			close(requester.release)
+		}()
+
+		run(t, cache)
+		require.Equal(t, int32(1), requester.requestCount.Load())
+	})
+
+	t.Run("disabled coalescing", func(t *testing.T) {
+		requester := newRequester()
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+		cache.DisableCoalescing = true
+
+		go func() {
+			for requester.requestCount.Load() < parallel {
+				time.Sleep(time.Millisecond)
+			}
+			close(requester.release)
+		}()
+
+		run(t, cache)
+		require.Equal(t, int32(parallel), requester.requestCount.Load())
+	})
+
+	t.Run("canceled caller", func(t *testing.T) {
+		requester := newRequester()
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+		key := cache.key(httptest.NewRequest(http.MethodGet, cacheURL, nil))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		first := make(chan error, 1)
+		go func() {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+			if err == nil {
+				_, err = cache.Do(req)
+			}
+			first <- err
+		}()
+		require.Eventually(t, func() bool { return requester.requestCount.Load() == 1 }, time.Second, time.Millisecond)
+
+		second := make(chan error, 1)
+		go func() {
+			req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+			if err == nil {
+				var resp *http.Response
+				if resp, err = cache.Do(req); err == nil {
+					_, err = io.ReadAll(resp.Body)
+				}
+			}
+			second <- err
+		}()
+		require.Eventually(t, func() bool { return cache.flights.waiters(key) == 1 }, time.Second, time.Millisecond)
+
+		cancel()
+		close(requester.release)
+		require.NoError(t, <-second, "Expected the fetch to outlive the caller that started it")
+		<-first
+		require.Equal(t, int32(1), requester.requestCount.Load())
+		_, err := cache.PeekKey(context.Background(), key)
+		require.NoError(t, err, "Expected the entry to be stored for the remaining caller")
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		cache := New(memoryprovider.New())
+		joined := make(chan struct{})
+		compute := func(context.Context) ([]byte, error) {
+			<-joined
+			panic("boom")
+		}
+
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				_, err := cache.GetOrSet(context.Background(), "value", time.Minute, compute)
+				errs <- err
+			}()
+		}
+		require.Eventually(t, func() bool { return cache.flights.waiters("value") == 1 }, time.Second, time.Millisecond)
+		close(joined)
+		for i := 0; i < 2; i++ {
+			require.ErrorContains(t, <-errs, "boom", "Expected the panic to be returned to every caller")
+		}
+	})
+}
+
+func TestCache_StampedeLock(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	ctx := context.Background()
+
+	newRequester := func(expires time.Time) *fakeRequester {
+		return &fakeRequester{
+			data: map[string]*cacheEntry{
+				cacheURL: {
+					Ts:         time.Now(),
+					StatusCode: 200,
+					Data:       []byte("Hello World"),
+					Headers: map[string]string{
+						"Expires": expires.Format(time.RFC1123),
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("lock is released after refresh", func(t *testing.T) {
+		provider := memoryprovider.New()
+		cache := New(provider)
+		cache.HttpClient = newRequester(time.Now().Add(time.Hour))
+		cache.StampedeLockTTL = time.Minute
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		lock, err := provider.Get(ctx, stampedeLockPrefix+cache.key(req))
+		require.NoError(t, err, "provider.Get")
+		require.Nil(t, lock, "Expected refresh lock to be released")
+	})
+
+	t.Run("stale entry is served while another instance refreshes", func(t *testing.T) {
+		requester := newRequester(time.Now().Add(-time.Hour))
+		provider := memoryprovider.New()
+		cache := New(provider)
+		cache.HttpClient = requester
+		cache.StampedeLockTTL = time.Minute
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.Equal(t, 1, requester.requestCount)
+
+		// another instance takes the lock
+		ok, err := provider.SetNX(ctx, stampedeLockPrefix+cache.key(req), []byte("other"), time.Minute)
+		require.NoError(t, err, "provider.SetNX")
+		require.True(t, ok)
+
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.Equal(t, 1, requester.requestCount, "Expected stale entry to be served without reaching the origin")
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+	})
+
+	t.Run("miss waits before going to the origin", func(t *testing.T) {
+		requester := newRequester(time.Now().Add(time.Hour))
+		provider := memoryprovider.New()
+		cache := New(provider)
+		cache.HttpClient = requester
+		cache.StampedeLockTTL = time.Minute
+		cache.StampedeWait = 20 * time.Millisecond
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+
+		ok, err := provider.SetNX(ctx, stampedeLockPrefix+cache.key(req), []byte("other"), time.Minute)
+		require.NoError(t, err, "provider.SetNX")
+		require.True(t, ok)
+
+		start := time.Now()
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.GreaterOrEqual(t, int64(time.Since(start)), int64(cache.StampedeWait))
+		require.Equal(t, 1, requester.requestCount, "Expected origin to be reached after waiting")
+	})
+
+	t.Run("lapsed lock taken by another instance is left alone", func(t *testing.T) {
+		memory := memoryprovider.New()
+		for name, provider := range map[string]Provider{
+			"unlocker": memory,
+			"deleter": struct {
+				Provider
+				Locker
+				Deleter
+			}{memory, memory, memory},
+		} {
+			cache := New(provider)
+			cache.StampedeLockTTL = time.Minute
+			lockKey := stampedeLockPrefix + cacheURL
+
+			release, acquired := cache.acquireRefresh(ctx, cacheURL)
+			require.True(t, acquired, name)
+			require.NoError(t, memory.Set(ctx, lockKey, []byte("other"), time.Minute), "memory.Set")
+			release()
+			lock, err := memory.Get(ctx, lockKey)
+			require.NoError(t, err, "memory.Get")
+			require.Equal(t, "other", string(lock), "Expected the lock of another instance not to be released (%s)", name)
+
+			require.NoError(t, memory.Delete(ctx, lockKey), "memory.Delete")
+			release, acquired = cache.acquireRefresh(ctx, cacheURL)
+			require.True(t, acquired, name)
+			release()
+			lock, err = memory.Get(ctx, lockKey)
+			require.NoError(t, err, "memory.Get")
+			require.Nil(t, lock, "Expected the lock to be released (%s)", name)
+		}
+	})
+
+	t.Run("lock is released when the call is canceled", func(t *testing.T) {
+		memory := memoryprovider.New()
+		cache := New(contextUnlocker{memory})
+		cache.StampedeLockTTL = time.Minute
+
+		callCtx, cancel := context.WithCancel(ctx)
+		release, acquired := cache.acquireRefresh(callCtx, cacheURL)
+		require.True(t, acquired)
+		cancel()
+		release()
+		lock, err := memory.Get(ctx, stampedeLockPrefix+cacheURL)
+		require.NoError(t, err, "memory.Get")
+		require.Nil(t, lock, "Expected the lock to be released")
+	})
+}
+
+// contextUnlocker is a memory provider whose DeleteIfEqual fails once its context is done, as remote providers do.
+type contextUnlocker struct {
+	*memoryprovider.MemoryProvider
+}
+
+func (p contextUnlocker) DeleteIfEqual(ctx context.Context, key string, value []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return p.MemoryProvider.DeleteIfEqual(ctx, key, value)
+}
+
+func TestCacheEntry_ExpiresEarly(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	entry := cacheEntry{
+		Ts:    now,
+		Delta: time.Second,
+		Headers: map[string]string{
+			"Expires": now.Add(time.Minute).Format(time.RFC1123),
+		},
+	}
+
+	require.False(t, entry.expiresEarly(now, 0, 0.5), "Expected early expiration to be disabled")
+	require.False(t, entry.expiresEarly(now, 1, 0.5), "Expected entry far from expiry to be fresh")
+	require.True(t, entry.expiresEarly(now, 1, 1e-30), "Expected unlucky draw to expire the entry early")
+	require.True(t, entry.expiresEarly(now.Add(time.Minute-time.Millisecond), 1, 0.5), "Expected entry close to expiry to expire early")
+	require.False(t, entry.expiresEarly(now.Add(time.Minute-time.Millisecond), 1e-6, 0.5), "Expected small beta to refresh later")
+}
+
+func TestCache_RefreshAhead(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	release := make(chan struct{})
+	close(release)
+	requester := &gatedRequester{
+		release: release,
+		entry: &cacheEntry{
+			Ts:         time.Now(),
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers: map[string]string{
+				"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+			},
+		},
+	}
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = requester
+	cache.RefreshAhead = &RefreshAhead{Window: 2 * time.Hour, MinHits: 2}
+	defer func() {
+		require.NoError(t, cache.Close())
+	}()
+
+	do := func() {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	do() // miss
+	do() // first hit
+	require.Equal(t, int32(1), requester.requestCount.Load(), "Expected entry not to be refreshed before MinHits")
+
+	do() // second hit, entry is hot and expires within the window
+	require.Eventually(t, func() bool {
+		return cache.Stats().RefreshCompleted == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(2), requester.requestCount.Load(), "Expected entry to be refreshed in the background")
+	require.Equal(t, int64(1), cache.Stats().RefreshScheduled)
+}
+
+func TestCache_WithRefreshAhead(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	release := make(chan struct{})
+	close(release)
+	requester := &gatedRequester{
+		release: release,
+		entry: &cacheEntry{
+			Ts:         time.Now(),
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers: map[string]string{
+				"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+			},
+		},
+	}
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = requester
+	defer func() {
+		require.NoError(t, cache.Close())
+	}()
+
+	do := func(ctx context.Context) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	do(context.Background()) // miss
+	do(WithRefreshAhead(context.Background(), time.Minute))
+	require.Equal(t, int32(1), requester.requestCount.Load(), "Expected entry not to be refreshed outside of the window")
+
+	do(WithRefreshAhead(context.Background(), 2*time.Hour))
+	require.Eventually(t, func() bool {
+		return cache.Stats().RefreshCompleted == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(2), requester.requestCount.Load(), "Expected entry to be refreshed in the background")
+}
+
+func TestCache_TTLJitter(t *testing.T) {
+	expires := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+
+	requester := fakeRequester{data: make(map[string]*cacheEntry)}
+	provider := memoryprovider.New()
+	cache := New(provider)
+	cache.HttpClient = &requester
+	cache.TTLJitter = time.Hour
+
+	seen := make(map[time.Time]struct{})
+	for i := 0; i < 10; i++ {
+		cacheURL := fmt.Sprintf("http://example.com/%d", i)
+		requester.data[cacheURL] = &cacheEntry{
+			Ts:         time.Now(),
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers: map[string]string{
+				"Expires": expires.Format(time.RFC1123),
+			},
+		}
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		entry, err := cache.read(context.Background(), cache.key(req))
+		require.NoError(t, err, "cache.read")
+		got, ok := entry.expiresAt()
+		require.True(t, ok)
+		require.False(t, got.After(expires), "Expected jitter to never extend freshness")
+		require.True(t, got.After(expires.Add(-time.Hour-time.Second)), "Expected jitter to be bounded")
+		seen[got] = struct{}{}
+	}
+
+	require.Greater(t, len(seen), 1, "Expected entries written together to expire at different times")
+}
+
+func TestCache_StaleIfError(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	newCache := func(staleIfError time.Duration) (*Cache, *fakeRequester) {
+		requester := &fakeRequester{
+			data: map[string]*cacheEntry{
+				cacheURL: {
+					Ts:         time.Now(),
+					StatusCode: 200,
+					Data:       []byte("Hello World"),
+					Headers: map[string]string{
+						"Expires": time.Now().Add(-time.Minute).Format(time.RFC1123),
+					},
+				},
+			},
+		}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+		cache.StaleIfError = staleIfError
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		return cache, requester
+	}
+
+	requireStale := func(t *testing.T, cache *Cache) {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Contains(t, resp.Header.Get("Warning"), "111")
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+	}
+
+	t.Run("origin 5xx", func(t *testing.T) {
+		cache, requester := newCache(time.Hour)
+		requester.data[cacheURL].StatusCode = http.StatusBadGateway
+		requireStale(t, cache)
+		// the error response must not replace the stored entry
+		requireStale(t, cache)
+	})
+
+	t.Run("transport failure", func(t *testing.T) {
+		cache, requester := newCache(time.Hour)
+		requester.data = nil
+		requireStale(t, cache)
+	})
+
+	t.Run("too stale", func(t *testing.T) {
+		cache, requester := newCache(time.Second)
+		requester.data = nil
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.Error(t, err, "Expected entries older than StaleIfError not to be served")
+	})
+}
+
+func TestCache_Offline(t *testing.T) {
+	const expiredURL = "http://example.com/alwaysExpired"
+	const nonExistingURL = "http://example.com/nonExisting"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			expiredURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(-time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	req, err := http.NewRequest(http.MethodGet, expiredURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 1, requester.requestCount)
+
+	t.Run("context flag", func(t *testing.T) {
+		ctx := WithOffline(context.Background(), true)
+
+		req, err := http.NewRequestWithContext(WithIgnoreCache(ctx, true), http.MethodGet, expiredURL, nil)
+		require.NoError(t, err, "http.NewRequestWithContext")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, nonExistingURL, nil)
+		require.NoError(t, err, "http.NewRequestWithContext")
+		_, err = cache.Do(req)
+		require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected error to be ErrCacheMiss, got %v", err)
+
+		require.Equal(t, 1, requester.requestCount, "Expected offline requests not to reach the origin")
+	})
+
+	t.Run("cache option", func(t *testing.T) {
+		offline := *cache
+		offline.Offline = true
+
+		req, err := http.NewRequest(http.MethodPost, expiredURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = offline.Do(req)
+		require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected error to be ErrCacheMiss, got %v", err)
+
+		req, err = http.NewRequest(http.MethodGet, expiredURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = offline.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		require.Equal(t, 1, requester.requestCount, "Expected offline requests not to reach the origin")
+	})
+}
+
+// concurrencyRequester records the maximum number of concurrent requests it served. Safe for concurrent use.
+type concurrencyRequester struct {
+	inflight    atomic.Int32
+	maxInflight atomic.Int32
+	delay       time.Duration
+}
+
+func (c *concurrencyRequester) Do(req *http.Request) (*http.Response, error) {
+	n := c.inflight.Add(1)
+	defer c.inflight.Add(-1)
+	for {
+		max := c.maxInflight.Load()
+		if n <= max || c.maxInflight.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	time.Sleep(c.delay)
+
+	entry := cacheEntry{StatusCode: http.StatusOK, Data: []byte("Hello World")}
+	return entry.asHttpResponse(req), nil
+}
+
+func TestCache_HostLimit(t *testing.T) {
+	t.Run("excess requests are queued", func(t *testing.T) {
+		requester := &concurrencyRequester{delay: 5 * time.Millisecond}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+		cache.HostLimit = &HostLimit{MaxConcurrent: 2}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+				require.NoError(t, err, "http.NewRequest")
+				_, err = cache.Do(req)
+				require.NoError(t, err, "cache.Do")
+			}(i)
+		}
+		wg.Wait()
+
+		require.Equal(t, int32(2), requester.maxInflight.Load())
+	})
+
+	t.Run("excess requests are shed", func(t *testing.T) {
+		release := make(chan struct{})
+		requester := &gatedRequester{release: release, entry: &cacheEntry{StatusCode: http.StatusOK}}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+		cache.HostLimit = &HostLimit{MaxConcurrent: 1, MaxWait: -1}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com/1", nil)
+			_, _ = cache.Do(req)
+		}()
+		require.Eventually(t, func() bool { return requester.requestCount.Load() == 1 }, time.Second, time.Millisecond)
+
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/2", nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.Truef(t, errors.Is(err, ErrHostLimit), "Expected error to be ErrHostLimit, got %v", err)
+
+		// other hosts are not affected
+		req, err = http.NewRequest(http.MethodGet, "http://example.org/", nil)
+		require.NoError(t, err, "http.NewRequest")
+		close(release)
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		<-done
+	})
+}
+
+// flakyRequester fails the first failures requests, either with the given status code or with a transport error.
+type flakyRequester struct {
+	fakeRequester
+	failures   int
+	statusCode int
+}
+
+func (f *flakyRequester) Do(req *http.Request) (*http.Response, error) {
+	if f.failures > 0 {
+		f.failures--
+		f.requestCount++
+		if f.statusCode == 0 {
+			return nil, errors.New("connection reset")
+		}
+		return &http.Response{StatusCode: f.statusCode, Body: http.NoBody, Header: http.Header{}}, nil
+	}
+	return f.fakeRequester.Do(req)
+}
+
+func TestCache_Retry(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	newRequester := func(failures int, statusCode int) *flakyRequester {
+		return &flakyRequester{
+			fakeRequester: fakeRequester{
+				data: map[string]*cacheEntry{
+					cacheURL: {
+						Ts:         time.Now(),
+						StatusCode: 200,
+						Data:       []byte("Hello World"),
+						Headers: map[string]string{
+							"Expires": time.Now().Add(-time.Minute).Format(time.RFC1123),
+						},
+					},
+				},
+			},
+			failures:   failures,
+			statusCode: statusCode,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		failures   int
+		statusCode int
+		requests   int
+		status     int
+	}{
+		{name: "transport error", failures: 2, requests: 3, status: http.StatusOK},
+		{name: "retryable status", failures: 2, statusCode: http.StatusServiceUnavailable, requests: 3, status: http.StatusOK},
+		{name: "non retryable status", failures: 2, statusCode: http.StatusNotFound, requests: 1, status: http.StatusNotFound},
+		{name: "exhausted", failures: 5, statusCode: http.StatusServiceUnavailable, requests: 3, status: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requester := newRequester(tt.failures, tt.statusCode)
+			cache := New(memoryprovider.New())
+			cache.HttpClient = requester
+			cache.Retry = &Retry{Attempts: 3, BaseDelay: time.Millisecond}
+
+			req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+			require.NoError(t, err, "http.NewRequest")
+			resp, err := cache.Do(req)
+			require.NoError(t, err, "cache.Do")
+			require.Equal(t, tt.status, resp.StatusCode)
+			require.Equal(t, tt.requests, requester.requestCount)
+		})
+	}
+
+	t.Run("stale fallback when exhausted", func(t *testing.T) {
+		requester := newRequester(0, 0)
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+		cache.Retry = &Retry{Attempts: 3, BaseDelay: time.Millisecond}
+		cache.StaleIfError = time.Hour
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		requester.failures = 5
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, 4, requester.requestCount)
+	})
+}
+
+func TestRetry_Delay(t *testing.T) {
+	retry := Retry{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	require.Equal(t, 10*time.Millisecond, retry.delay(1))
+	require.Equal(t, 20*time.Millisecond, retry.delay(2))
+	require.Equal(t, 40*time.Millisecond, retry.delay(3))
+	require.Equal(t, 50*time.Millisecond, retry.delay(4))
+	require.Equal(t, 50*time.Millisecond, retry.delay(100))
+}
+
+func TestCache_RevalidationBudget(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(-time.Minute).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.RevalidationBudget = time.Second
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 1, requester.requestCount)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	resp, err := cache.Do(req.WithContext(ctx))
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 1, requester.requestCount, "Expected stale entry to be served without revalidation")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err, "io.ReadAll")
+	require.Equal(t, "Hello World", string(body))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = cache.Do(req.WithContext(ctx))
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 2, requester.requestCount, "Expected entry to be revalidated with enough budget")
+}
+
+// failingProvider fails every operation whose error is set, delegating the others to a memory provider.
+type failingProvider struct {
+	*memoryprovider.MemoryProvider
+	getErr error
+	setErr error
+}
+
+func (p *failingProvider) Get(ctx context.Context, key string) ([]byte, error) {
+	if p.getErr != nil {
+		return nil, p.getErr
+	}
+	return p.MemoryProvider.Get(ctx, key)
+}
+
+func (p *failingProvider) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	if p.setErr != nil {
+		return p.setErr
+	}
+	return p.MemoryProvider.Set(ctx, key, value, expiry)
+}
+
+func TestCache_ReadFailurePolicy(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	provider := &failingProvider{MemoryProvider: memoryprovider.New(), getErr: errors.New("connection refused")}
+	cache := New(provider)
+	cache.HttpClient = &requester
+
+	t.Run("lenient", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		strict := *cache
+		strict.ReadFailurePolicy = FailureStrict
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = strict.Do(req)
+		require.Truef(t, errors.Is(err, provider.getErr), "Expected provider error, got %v", err)
+	})
+}
+
+func TestCache_WriteFailurePolicy(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	provider := &failingProvider{MemoryProvider: memoryprovider.New(), setErr: errors.New("out of memory")}
+	cache := New(provider)
+	cache.HttpClient = &requester
+
+	t.Run("lenient", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		strict := *cache
+		strict.WriteFailurePolicy = FailureStrict
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := strict.Do(req)
+		require.Truef(t, errors.Is(err, provider.setErr), "Expected provider error, got %v", err)
+
+		require.NotNil(t, resp, "Expected the origin response to be returned along with the error")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+	})
+
+	t.Run("strict revalidation", func(t *testing.T) {
+		now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+		requester := fakeRequester{
+			data: map[string]*cacheEntry{
+				cacheURL: {
+					StatusCode: 200,
+					Data:       []byte("Hello World"),
+					Headers:    map[string]string{"Expires": now.Add(time.Minute).Format(time.RFC1123), "ETag": `"v1"`},
+				},
+			},
+		}
+		provider := &failingProvider{MemoryProvider: memoryprovider.New()}
+		strict := New(provider)
+		strict.HttpClient = &requester
+		strict.Now = func() time.Time { return now }
+		strict.RetryAfter = time.Hour
+		strict.WriteFailurePolicy = FailureStrict
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = strict.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		now = now.Add(2 * time.Minute)
+		provider.setErr = errors.New("out of memory")
+		for _, answer := range []*cacheEntry{
+			{StatusCode: http.StatusNotModified, Headers: map[string]string{"Cache-Control": "max-age=60"}},
+			{StatusCode: http.StatusTooManyRequests, Headers: map[string]string{"Retry-After": "60"}},
+		} {
+			requester.data[cacheURL] = answer
+			resp, err := strict.Do(req)
+			require.Truef(t, errors.Is(err, provider.setErr), "Expected provider error after a %d, got %v", answer.StatusCode, err)
+			require.NotNil(t, resp, "Expected the entry to be returned along with the error")
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err, "io.ReadAll")
+			require.Equal(t, "Hello World", string(body))
+		}
+	})
+}
+
+// slowProvider blocks every Set until release is closed. Safe for concurrent use.
+type slowProvider struct {
+	*memoryprovider.MemoryProvider
+	release chan struct{}
+	sets    atomic.Int32
+}
+
+func (p *slowProvider) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	<-p.release
+	p.sets.Add(1)
+	return p.MemoryProvider.Set(ctx, key, value, expiry)
+}
+
+func TestCache_AsyncWrites(t *testing.T) {
+	requester := fakeRequester{data: make(map[string]*cacheEntry)}
+	for i := 0; i < 3; i++ {
+		requester.data[fmt.Sprintf("http://example.com/%d", i)] = &cacheEntry{
+			Ts:         time.Now(),
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers: map[string]string{
+				"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+			},
+		}
+	}
+
+	provider := &slowProvider{MemoryProvider: memoryprovider.New(), release: make(chan struct{})}
+	cache := New(provider)
+	cache.HttpClient = &requester
+	cache.AsyncWrites = &AsyncWrites{Workers: 1, QueueSize: 1}
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do should not wait for the provider")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+
+		if i == 0 {
+			// wait for the worker to pick the first write
+			require.Eventually(t, func() bool { return cache.Stats().WriteQueueDepth == 0 }, time.Second, time.Millisecond)
+		}
+	}
+
+	// one write is held by the worker, one is queued and the last one is dropped
+	require.Eventually(t, func() bool {
+		stats := cache.Stats()
+		return stats.WriteQueueDepth == 1 && stats.WritesDropped == 1
+	}, time.Second, time.Millisecond)
+
+	close(provider.release)
+	require.NoError(t, cache.Close())
+	require.Equal(t, int32(2), provider.sets.Load(), "Expected pending writes to be flushed on Close")
+	require.Equal(t, int64(0), cache.Stats().WriteQueueDepth)
+}
+
+var expvarRuns atomic.Int32
+
+func TestCache_PublishExpvar(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {Ts: time.Now(), StatusCode: 200, Data: []byte("Hello World")},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	// expvar names can't be unpublished, hence one per run
+	name := fmt.Sprintf("%s-%d", t.Name(), expvarRuns.Add(1))
+	var published atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cache.PublishExpvar(name) == nil {
+				published.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), published.Load(), "Expected duplicate names to be rejected")
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	var stats map[string]any
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &stats))
+	require.Equal(t, float64(1), stats["requests"])
+	require.Equal(t, float64(1), stats["misses"])
+	require.Equal(t, float64(0), stats["hit_ratio"])
+}
+
+func TestCache_ExportImport(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	ctx := context.Background()
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	source := New(memoryprovider.New())
+	source.HttpClient = &requester
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = source.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	var archive bytes.Buffer
+	exported, err := source.Export(ctx, &archive)
+	require.NoError(t, err, "cache.Export")
+	require.Equal(t, 1, exported)
+
+	target := New(memoryprovider.New())
+	target.HttpClient = &requester
+	imported, err := target.Import(ctx, &archive)
+	require.NoError(t, err, "cache.Import")
+	require.Equal(t, 1, imported)
+
+	resp, err := target.Do(req.WithContext(WithOnlyCached(ctx, true)))
+	require.NoError(t, err, "cache.Do")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Hello World", string(body))
+	require.Equal(t, 1, requester.requestCount)
+
+	_, err = target.Import(ctx, strings.NewReader(`{"key":"x"}`))
+	require.Error(t, err, "Expected records without an entry to be rejected")
+}
+
+func TestCache_VCR(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {Ts: time.Now(), StatusCode: 200, Data: []byte("Hello World")},
+		},
+	}
+	provider := memoryprovider.New()
+	cache := New(provider)
+	cache.HttpClient = &requester
+	cache.VCR = &VCR{Mode: VCRRecord, Cassette: "TestCache_VCR"}
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	for i := 0; i < 2; i++ {
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+	require.Equal(t, 2, requester.requestCount, "Expected every request to reach the origin while recording")
+
+	cache.VCR = &VCR{Mode: VCRReplay, Cassette: "TestCache_VCR"}
+	resp, err := cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Hello World", string(body))
+	require.Equal(t, 2, requester.requestCount, "Expected replay to never reach the origin")
+
+	req, err = http.NewRequest(http.MethodGet, "http://example.com/other", nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.Truef(t, errors.Is(err, ErrNotRecorded), "Expected ErrNotRecorded, got %v", err)
+	require.Contains(t, err.Error(), "http://example.com/other")
+
+	cache.VCR = &VCR{Mode: VCRReplay, Cassette: "other"}
+	req, err = http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.Truef(t, errors.Is(err, ErrNotRecorded), "Expected cassettes to be separate, got %v", err)
+}
+
+func TestCache_GetOrSet(t *testing.T) {
+	ctx := context.Background()
+	cache := New(memoryprovider.New())
+
+	var calls atomic.Int32
+	compute := func(ctx context.Context) ([]byte, error) {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return []byte("value"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrSet(ctx, "key", time.Hour, compute)
+			require.NoError(t, err, "cache.GetOrSet")
+			require.Equal(t, "value", string(value))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), calls.Load(), "Expected concurrent calls to share the computation")
+
+	value, err := cache.GetOrSet(ctx, "key", time.Hour, compute)
+	require.NoError(t, err, "cache.GetOrSet")
+	require.Equal(t, "value", string(value))
+	require.Equal(t, int32(1), calls.Load(), "Expected the stored value to be returned")
+
+	_, err = cache.GetOrSet(ctx, "short", time.Millisecond, compute)
+	require.NoError(t, err, "cache.GetOrSet")
+	time.Sleep(5 * time.Millisecond)
+	_, err = cache.GetOrSet(ctx, "short", time.Millisecond, compute)
+	require.NoError(t, err, "cache.GetOrSet")
+	require.Equal(t, int32(3), calls.Load(), "Expected expired values to be computed again")
+
+	failure := errors.New("failure")
+	_, err = cache.GetOrSet(ctx, "failing", time.Hour, func(ctx context.Context) ([]byte, error) {
+		return nil, failure
+	})
+	require.Truef(t, errors.Is(err, failure), "Expected the error of fn, got %v", err)
+	_, err = cache.PeekKey(ctx, "failing")
+	require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected errors not to be cached, got %v", err)
+}
+
+func TestTyped(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	ctx := context.Background()
+	users := NewTyped[user](New(memoryprovider.New()))
+
+	_, err := users.Get(ctx, "user:1")
+	require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected ErrCacheMiss, got %v", err)
+
+	require.NoError(t, users.Set(ctx, "user:1", user{Name: "Ada", Age: 36}, time.Hour))
+	u, err := users.Get(ctx, "user:1")
+	require.NoError(t, err, "typed.Get")
+	require.Equal(t, user{Name: "Ada", Age: 36}, u)
+
+	users.Cache.Now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = users.Get(ctx, "user:1")
+	require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected ErrCacheMiss for an expired value, got %v", err)
+	u, err = users.Get(WithIgnoreExpired(ctx, true), "user:1")
+	require.NoError(t, err, "Expected expired values to be returned under WithIgnoreExpired")
+	require.Equal(t, user{Name: "Ada", Age: 36}, u)
+	users.Cache.Now = nil
+
+	var calls int
+	for i := 0; i < 2; i++ {
+		u, err = users.GetOrCompute(ctx, "user:2", time.Hour, func(ctx context.Context) (user, error) {
+			calls++
+			return user{Name: "Grace", Age: 45}, nil
+		})
+		require.NoError(t, err, "typed.GetOrCompute")
+		require.Equal(t, user{Name: "Grace", Age: 45}, u)
+	}
+	require.Equal(t, 1, calls)
+
+	names := NewTyped[string](users.Cache)
+	_, err = names.Get(ctx, "user:1")
+	require.Error(t, err, "Expected values of another type to fail to decode")
+}
+
+func TestCache_Now(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         now,
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": now.Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	info, err := cache.Peek(context.Background(), req)
+	require.NoError(t, err, "cache.Peek")
+	require.Equal(t, now, info.StoredAt)
+
+	now = now.Add(59 * time.Minute)
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 1, requester.requestCount, "Expected the entry to still be fresh")
+
+	now = now.Add(2 * time.Minute)
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 2, requester.requestCount, "Expected the entry to be expired")
+}
+
+func TestCache_ErrorTypes(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	ctx := context.Background()
+
+	t.Run("provider", func(t *testing.T) {
+		provider := &failingProvider{MemoryProvider: memoryprovider.New(), getErr: errors.New("connection refused")}
+		cache := New(provider)
+		cache.ReadFailurePolicy = FailureStrict
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+
+		var pe *ProviderError
+		require.Truef(t, errors.As(err, &pe), "Expected a ProviderError, got %v", err)
+		require.Equal(t, "get", pe.Op)
+		require.Equal(t, cacheURL, pe.Key)
+		require.Truef(t, errors.Is(err, provider.getErr), "Expected the provider error to be wrapped, got %v", err)
+	})
+
+	t.Run("origin", func(t *testing.T) {
+		cache := New(memoryprovider.New())
+		cache.HttpClient = &flakyRequester{failures: 1}
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+
+		var oe *OriginError
+		require.Truef(t, errors.As(err, &oe), "Expected an OriginError, got %v", err)
+		require.Equal(t, cacheURL, oe.URL)
+		require.Equal(t, cacheURL, oe.Key)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		cache := New(memoryprovider.New())
+		expires := time.Now().Add(-time.Hour).Truncate(time.Second)
+		require.NoError(t, cache.write(ctx, "key", &cacheEntry{Ts: time.Now(), StatusCode: 200, Expires: expires}))
+
+		entry, err := cache.read(ctx, "key")
+		require.NotNil(t, entry)
+		var se *StaleEntryError
+		require.Truef(t, errors.As(err, &se), "Expected a StaleEntryError, got %v", err)
+		require.True(t, se.Expires.Equal(expires))
+		require.Truef(t, errors.Is(err, ErrCacheExpired), "Expected ErrCacheExpired, got %v", err)
+	})
+}
+
+func TestCache_RevalidationRefreshesEntry(t *testing.T) {
+	const cacheURL = "http://example.com/"
+	const etag = "\"123456789\""
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": now.Add(time.Minute).Format(time.RFC1123),
+					"ETag":    etag,
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	now = now.Add(2 * time.Minute)
+	requester.data[cacheURL] = &cacheEntry{
+		StatusCode: http.StatusNotModified,
+		Headers: map[string]string{
+			"Expires": now.Add(time.Hour).Format(time.RFC1123),
+			"Date":    now.Format(time.RFC1123),
+		},
+	}
+	resp, err := cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, requester.requestCount)
+
+	info, err := cache.Peek(context.Background(), req)
+	require.NoError(t, err, "cache.Peek")
+	require.Equal(t, now, info.StoredAt, "Expected the freshness clock to start over")
+	require.True(t, info.Fresh, "Expected the new expiry to be stored")
+	require.Equal(t, now.Format(time.RFC1123), info.Headers["Date"])
+	require.Equal(t, etag, info.Headers["Etag"], "Expected the cached headers to be kept")
+
+	now = now.Add(30 * time.Minute)
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 2, requester.requestCount, "Expected the revalidated entry to be served")
+}
+
+func TestCache_RevalidationCacheControl(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": now.Add(time.Minute).Format(time.RFC1123),
+					"ETag":    "\"123456789\"",
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	now = now.Add(2 * time.Minute)
+	requester.data[cacheURL] = &cacheEntry{
+		StatusCode: http.StatusNotModified,
+		Headers:    map[string]string{"Cache-Control": "public, max-age=3600"},
+	}
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 2, requester.requestCount)
+
+	info, err := cache.Peek(context.Background(), req)
+	require.NoError(t, err, "cache.Peek")
+	require.True(t, info.Fresh, "Expected the expiry to be derived from Cache-Control")
+	require.Equal(t, now.Add(time.Hour), info.Expires)
+	require.Empty(t, info.Headers["Expires"], "Expected the stale Expires header to be dropped")
+
+	now = now.Add(30 * time.Minute)
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 2, requester.requestCount, "Expected the revalidated entry to be served")
+}
+
+func TestMaxAge(t *testing.T) {
+	for cacheControl, want := range map[string]time.Duration{
+		"max-age=60":               time.Minute,
+		"public, MAX-AGE=\"60\"":   time.Minute,
+		"max-age=60, s-maxage=120": 2 * time.Minute,
+		"s-maxage=120, max-age=60": 2 * time.Minute,
+		"no-cache, max-age=-1":     -1,
+		"no-store":                 -1,
+	} {
+		lifetime, ok := maxAge(cacheControl)
+		if want < 0 {
+			require.Falsef(t, ok, "Expected no lifetime in %q", cacheControl)
+			continue
+		}
+		require.Truef(t, ok, "Expected a lifetime in %q", cacheControl)
+		require.Equalf(t, want, lifetime, "maxAge(%q)", cacheControl)
+	}
+}
+
+func TestCache_WithHTTPClient(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	entries := map[string]*cacheEntry{
+		cacheURL: {Ts: time.Now(), StatusCode: 200, Data: []byte("Hello World")},
+	}
+	requester := fakeRequester{data: entries}
+	override := fakeRequester{data: entries}
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	req, err := http.NewRequestWithContext(WithHTTPClient(context.Background(), &override), http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 0, requester.requestCount)
+	require.Equal(t, 1, override.requestCount)
+
+	req, err = http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 1, requester.requestCount)
+}
+
+func TestCache_Routes(t *testing.T) {
+	const apiURL = "http://api.example.com/users"
+	const mediaURL = "http://media.example.com/video.mp4"
+
+	ctx := context.Background()
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			apiURL:   {Ts: time.Now(), StatusCode: 200, Data: []byte("[]")},
+			mediaURL: {Ts: time.Now(), StatusCode: 200, Data: []byte("video")},
+		},
+	}
+	defaultProvider := memoryprovider.New()
+	mediaProvider := memoryprovider.New()
+
+	cache := New(defaultProvider)
+	cache.HttpClient = &requester
+	cache.Namespace = "cache:"
+	cache.Routes = []ProviderRoute{
+		{Host: "media.*", Provider: mediaProvider},
+	}
+
+	for _, u := range []string{apiURL, mediaURL} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	value, err := mediaProvider.Get(ctx, "cache:"+mediaURL)
+	require.NoError(t, err)
+	require.NotNil(t, value, "Expected the media entry in the routed provider")
+	value, err = defaultProvider.Get(ctx, "cache:"+mediaURL)
+	require.NoError(t, err)
+	require.Nil(t, value, "Expected the media entry not to be in the default provider")
+	value, err = defaultProvider.Get(ctx, "cache:"+apiURL)
+	require.NoError(t, err)
+	require.NotNil(t, value, "Expected the api entry in the default provider")
+
+	req, err := http.NewRequest(http.MethodGet, mediaURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Peek(ctx, req)
+	require.NoError(t, err, "cache.Peek")
+
+	require.NoError(t, defaultProvider.Set(ctx, "other:data", []byte("{}"), 0))
+	deleted, err := cache.Flush(ctx)
+	require.NoError(t, err, "cache.Flush")
+	require.Equal(t, 2, deleted, "Expected every provider to be flushed")
+	value, err = defaultProvider.Get(ctx, "other:data")
+	require.NoError(t, err)
+	require.NotNil(t, value, "Expected keys outside of the namespace to be kept")
+
+	cache.Namespace = ""
+	_, err = cache.Flush(ctx)
+	require.ErrorIs(t, err, ErrNoNamespace)
+
+	tagged := New(taggedProvider{MemoryProvider: memoryprovider.New()})
+	tagged.Namespace = "cache:"
+	tagged.Routes = []ProviderRoute{{Host: "media.*", Provider: taggedProvider{MemoryProvider: memoryprovider.New()}}}
+	_, err = tagged.Flush(ctx)
+	require.NoError(t, err, "Expected providers that can't be compared to be flushed")
+}
+
+func TestCache_Policy(t *testing.T) {
+	const apiURL = "http://example.com/api/users"
+	const liveURL = "http://example.com/live"
+	const missingURL = "http://example.com/api/missing"
+	const largeURL = "http://example.com/large"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			apiURL:     {StatusCode: 200, Data: []byte("[]")},
+			liveURL:    {StatusCode: 200, Data: []byte("live")},
+			missingURL: {StatusCode: 404, Data: []byte("not found")},
+			largeURL:   {StatusCode: 200, Data: []byte("0123456789")},
+		},
+	}
+	policy, err := NewPolicy(
+		PolicyRule{Pattern: `/live$`, Bypass: true},
+		PolicyRule{Pattern: `/large$`, MaxBodySize: 5},
+		PolicyRule{Host: "example.com", Pattern: `/api/`, TTL: time.Hour, NegativeTTL: time.Minute},
+	)
+	require.NoError(t, err, "NewPolicy")
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Policy = policy
+	cache.Now = func() time.Time { return now }
+
+	for _, u := range []string{apiURL, liveURL, missingURL, largeURL} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	info, err := cache.PeekKey(ctx, apiURL)
+	require.NoError(t, err, "cache.PeekKey")
+	require.Equal(t, now.Add(time.Hour), info.Expires)
+	info, err = cache.PeekKey(ctx, missingURL)
+	require.NoError(t, err, "cache.PeekKey")
+	require.Equal(t, now.Add(time.Minute), info.Expires)
+	_, err = cache.PeekKey(ctx, liveURL)
+	require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected bypassed responses not to be stored, got %v", err)
+	_, err = cache.PeekKey(ctx, largeURL)
+	require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected large responses not to be stored, got %v", err)
+
+	_, err = NewPolicy(PolicyRule{Pattern: `(`})
+	require.Error(t, err, "Expected invalid patterns to be rejected")
+}
+
+func TestCache_PolicyUpdated(t *testing.T) {
+	const cacheURL = "http://example.com/api/users"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {StatusCode: 200, Data: []byte("[]")},
+		},
+	}
+	policy, err := NewPolicy(PolicyRule{Pattern: `/api/`, TTL: time.Hour})
+	require.NoError(t, err, "NewPolicy")
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Policy = policy
+	cache.Now = func() time.Time { return now }
+	cache.KeyGenerator = func(req *http.Request) string {
+		// the rules are replaced while the call is under way
+		require.NoError(t, policy.Update(PolicyRule{Pattern: `/api/`, TTL: time.Minute}), "policy.Update")
+		return DefaultKeyGenerator(req)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	info, err := cache.PeekKey(context.Background(), cacheURL)
+	require.NoError(t, err, "cache.PeekKey")
+	require.Equal(t, now.Add(time.Hour), info.Expires, "Expected the rule matched when the call started to apply throughout")
+}
+
+func TestCache_PolicyCookies(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {StatusCode: 200, Data: []byte("Hello World")},
+		},
+	}
+	policy, err := NewPolicy(PolicyRule{TTL: time.Hour, VaryCookies: []string{"lang"}, BypassCookies: []string{"session"}})
+	require.NoError(t, err, "NewPolicy")
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Policy = policy
+
+	do := func(cookies ...*http.Cookie) string {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		return cache.Key(req)
+	}
+
+	fr := do(&http.Cookie{Name: "lang", Value: "fr"}, &http.Cookie{Name: "other", Value: "1"})
+	require.Equal(t, cacheURL+"|cookies:lang=fr", fr)
+	do(&http.Cookie{Name: "lang", Value: "fr"})
+	require.Equal(t, 1, requester.requestCount, "Expected other cookies not to be part of the key")
+
+	en := do(&http.Cookie{Name: "lang", Value: "en"})
+	require.NotEqual(t, fr, en)
+	require.Equal(t, 2, requester.requestCount, "Expected each language to have its own entry")
+
+	do(&http.Cookie{Name: "lang", Value: "fr"}, &http.Cookie{Name: "session", Value: "secret"})
+	require.Equal(t, 3, requester.requestCount, "Expected requests with a session cookie to bypass the cache")
+}
+
+func TestCache_SetProvider(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	ctx := context.Background()
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	previous := &slowProvider{MemoryProvider: memoryprovider.New(), release: make(chan struct{})}
+	next := memoryprovider.New()
+
+	cache := New(previous)
+	cache.HttpClient = &requester
+	cache.AsyncWrites = &AsyncWrites{Workers: 1}
+	defer cache.Close()
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	swapped := make(chan struct{})
+	go func() {
+		cache.SetProvider(next)
+		close(swapped)
+	}()
+
+	select {
+	case <-swapped:
+		t.Fatal("Expected SetProvider to wait for pending writes")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(previous.release)
+	<-swapped
+	require.Equal(t, int32(1), previous.sets.Load(), "Expected the pending write to reach the previous provider")
+
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 2, requester.requestCount, "Expected the new provider to start empty")
+	require.Eventually(t, func() bool {
+		value, err := next.Get(ctx, cacheURL)
+		return err == nil && value != nil
+	}, time.Second, time.Millisecond, "Expected new writes to reach the new provider")
+}
+
+func TestCache_Tenants(t *testing.T) {
+	const cacheURL1 = "http://example.com/1"
+	const cacheURL2 = "http://example.com/2"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL1: {Ts: time.Now(), StatusCode: 200, Data: []byte("one"), Headers: map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)}},
+			cacheURL2: {Ts: time.Now(), StatusCode: 200, Data: []byte("two"), Headers: map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)}},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.TenantQuotas = func(tenant string) TenantQuota {
+		if tenant == "small" {
+			return TenantQuota{MaxEntries: 1}
+		}
+		return TenantQuota{}
+	}
+
+	do := func(tenant string, u string) {
+		req, err := http.NewRequestWithContext(WithTenant(context.Background(), tenant), http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	do("a", cacheURL1)
+	do("b", cacheURL1)
+	require.Equal(t, 2, requester.requestCount, "Expected tenants not to share entries")
+	do("a", cacheURL1)
+	require.Equal(t, 2, requester.requestCount)
+
+	stats := cache.TenantStats("a")
+	require.Equal(t, int64(2), stats.Requests)
+	require.Equal(t, int64(1), stats.Hits)
+	require.Equal(t, 1, stats.Entries)
+	require.Equal(t, int64(0), cache.TenantStats("b").Hits)
+
+	do("small", cacheURL1)
+	do("small", cacheURL2)
+	require.Equal(t, 1, cache.TenantStats("small").Entries)
+	require.Equal(t, int64(1), cache.TenantStats("small").Rejected)
+
+	req, err := http.NewRequestWithContext(WithTenant(context.Background(), "small"), http.MethodGet, cacheURL1, nil)
+	require.NoError(t, err, "http.NewRequest")
+	require.NoError(t, cache.Invalidate(context.Background(), cache.Key(req)))
+	require.Equal(t, 0, cache.TenantStats("small").Entries)
+}
+
+func TestCache_TenantQuotaUsage(t *testing.T) {
+	const cacheURL1 = "http://example.com/1"
+	const cacheURL2 = "http://example.com/2"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL1: {StatusCode: 200, Data: []byte("one"), Headers: map[string]string{"Expires": now.Add(time.Minute).Format(time.RFC1123)}},
+			cacheURL2: {StatusCode: 200, Data: []byte("two"), Headers: map[string]string{"Expires": now.Add(time.Hour).Format(time.RFC1123)}},
+		},
+	}
+	provider := &failingProvider{MemoryProvider: memoryprovider.New()}
+	cache := New(provider)
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+	cache.TenantQuotas = func(string) TenantQuota { return TenantQuota{MaxEntries: 1} }
+
+	do := func(u string) error {
+		req, err := http.NewRequestWithContext(WithTenant(context.Background(), "small"), http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		return err
+	}
+
+	provider.setErr = errors.New("connection reset")
+	require.NoError(t, do(cacheURL1), "cache.Do")
+	require.Equal(t, 0, cache.TenantStats("small").Entries, "Expected failed writes not to be accounted for")
+
+	provider.setErr = nil
+	require.NoError(t, do(cacheURL1), "cache.Do")
+	require.NoError(t, do(cacheURL2), "cache.Do")
+	require.Equal(t, int64(1), cache.TenantStats("small").Rejected)
+
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, 0, cache.TenantStats("small").Entries, "Expected expired entries to lapse")
+	require.NoError(t, do(cacheURL2), "cache.Do")
+	stats := cache.TenantStats("small")
+	require.Equal(t, 1, stats.Entries)
+	require.Equal(t, int64(1), stats.Rejected, "Expected the tenant to store entries again once the previous ones expired")
+}
+
+func TestCache_SlidingExpiration(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": now.Add(time.Minute).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	// hide Toucher, for entries to be rewritten
+	cache := New(plainProvider{memoryprovider.New()})
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+	cache.SlidingExpiration = 5 * time.Minute
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	for i := 0; i < 3; i++ {
+		if i == 0 {
+			now = now.Add(30 * time.Second)
+		} else {
+			now = now.Add(4 * time.Minute)
+		}
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+	require.Equal(t, 1, requester.requestCount, "Expected every hit to extend the expiry")
+
+	info, err := cache.Peek(context.Background(), req)
+	require.NoError(t, err, "cache.Peek")
+	require.Equal(t, now.Add(5*time.Minute), info.Expires)
+
+	// more than half of the window left, not rewritten
+	written := cache.Stats().BytesWritten
+	now = now.Add(time.Minute)
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, written, cache.Stats().BytesWritten, "Expected the entry not to be rewritten")
+
+	now = now.Add(5 * time.Minute)
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 2, requester.requestCount, "Expected idle entries to expire")
+}
+
+func TestCache_SlidingExpirationToucher(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(2 * time.Second).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.SlidingExpiration = time.Second
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	// well past the original expiry. The entry is rewritten once, within a window of its expiry
+	var written int64
+	for i := 0; i < 7; i++ {
+		if i == 3 {
+			written = cache.Stats().BytesWritten
+		}
+		time.Sleep(500 * time.Millisecond)
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+	require.Equal(t, 1, requester.requestCount, "Expected every hit to extend the expiry")
+	require.Equal(t, written, cache.Stats().BytesWritten, "Expected hits not to rewrite the entry")
+
+	info, err := cache.Peek(context.Background(), req)
+	require.NoError(t, err, "cache.Peek")
+	require.Zero(t, info.Expires, "Expected the provider to expire the entry")
+
+	time.Sleep(1500 * time.Millisecond)
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 2, requester.requestCount, "Expected idle entries to expire")
+}
+
+type expiryProvider struct {
+	*memoryprovider.MemoryProvider
+	expiries map[string]time.Duration
+}
+
+func (p *expiryProvider) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	p.expiries[key] = expiry
+	return p.MemoryProvider.Set(ctx, key, value, expiry)
+}
+
+func TestCache_TrackAccess(t *testing.T) {
+	const cacheURL1 = "http://example.com/1"
+	const cacheURL2 = "http://example.com/2"
+
+	ctx := context.Background()
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL1: {StatusCode: 200, Data: []byte("one"), Headers: map[string]string{"Expires": now.Add(time.Hour).Format(time.RFC1123)}},
+			cacheURL2: {StatusCode: 200, Data: []byte("two"), Headers: map[string]string{"Expires": now.Add(time.Hour).Format(time.RFC1123)}},
+		},
+	}
+	provider := &expiryProvider{MemoryProvider: memoryprovider.New(), expiries: make(map[string]time.Duration)}
+	cache := New(provider)
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+	cache.TrackAccess = true
+	cache.Namespace = "cache:"
+
+	for _, u := range []string{cacheURL1, cacheURL2, cacheURL1, cacheURL2, cacheURL2} {
+		now = now.Add(time.Second)
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	info, err := cache.PeekKey(ctx, "cache:"+cacheURL2)
+	require.NoError(t, err, "cache.PeekKey")
+	require.Equal(t, int64(2), info.Hits)
+	require.Equal(t, now, info.LastAccess)
+
+	hot, err := cache.HotKeys(ctx, 1)
+	require.NoError(t, err, "cache.HotKeys")
+	require.Equal(t, []EntryAccess{{Key: "cache:" + cacheURL2, Hits: 2, LastAccess: now}}, hot)
+	require.Equal(t, time.Hour-5*time.Second, provider.expiries["cache:access:"+cacheURL2], "Expected access metadata to expire with the entry")
+
+	// evicted behind the back of the cache
+	require.NoError(t, provider.Delete(ctx, "cache:"+cacheURL1), "provider.Delete")
+	deleted, err := cache.Sweep(ctx, 0)
+	require.NoError(t, err, "cache.Sweep")
+	require.Zero(t, deleted)
+	hot, err = cache.HotKeys(ctx, 0)
+	require.NoError(t, err, "cache.HotKeys")
+	require.Equal(t, []EntryAccess{{Key: "cache:" + cacheURL2, Hits: 2, LastAccess: now}}, hot, "Expected orphaned access metadata to be swept")
+
+	deleted, err = cache.Flush(ctx)
+	require.NoError(t, err, "cache.Flush")
+	require.Equal(t, 1, deleted, "Expected access metadata not to be counted as entries")
+	hot, err = cache.HotKeys(ctx, 0)
+	require.NoError(t, err, "cache.HotKeys")
+	require.Empty(t, hot)
+}
+
+func TestCache_InFlight(t *testing.T) {
+	const cacheURL = "http://example.com/slow"
+
+	requester := &gatedRequester{
+		release: make(chan struct{}),
+		entry: &cacheEntry{
+			Ts:         time.Now(),
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers:    map[string]string{"Cache-Control": "max-age=60"},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = requester
+	require.Empty(t, cache.InFlight())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, cacheURL, nil)
+			_, _ = cache.Do(req)
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		calls := cache.InFlight()
+		return len(calls) == 1 && calls[0].Waiters == 2
+	}, time.Second, time.Millisecond)
+
+	calls := cache.InFlight()
+	require.Equal(t, cacheURL, calls[0].Key)
+	require.Equal(t, cacheURL, calls[0].URL)
+	require.False(t, calls[0].Start.IsZero())
+
+	close(requester.release)
+	wg.Wait()
+	require.Empty(t, cache.InFlight())
+	require.Equal(t, int32(1), requester.requestCount.Load())
+}
+
+// multiGetProvider counts the reads made to a memory provider. Safe for concurrent use.
+type multiGetProvider struct {
+	*memoryprovider.MemoryProvider
+	gets      atomic.Int32
+	multiGets atomic.Int32
+}
+
+func (p *multiGetProvider) Get(ctx context.Context, key string) ([]byte, error) {
+	p.gets.Add(1)
+	return p.MemoryProvider.Get(ctx, key)
+}
+
+func (p *multiGetProvider) GetMulti(ctx context.Context, keys []string) ([][]byte, error) {
+	p.multiGets.Add(1)
+	return p.MemoryProvider.GetMulti(ctx, keys)
+}
+
+func TestCache_DoBatch(t *testing.T) {
+	provider := &multiGetProvider{MemoryProvider: memoryprovider.New()}
+	requester := &concurrencyRequester{delay: 5 * time.Millisecond}
+	cache := New(provider)
+	cache.HttpClient = requester
+
+	newRequest := func(i int) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+		require.NoError(t, err, "http.NewRequest")
+		return req
+	}
+	// concurrencyRequester responses carry no expiry, warm the cache with explicit entries
+	for _, i := range []int{1, 3} {
+		entry := cacheEntry{
+			Ts:         time.Now(),
+			StatusCode: http.StatusOK,
+			Data:       []byte("cached"),
+			Expires:    time.Now().Add(time.Minute),
+		}
+		require.NoError(t, cache.write(context.Background(), cache.Key(newRequest(i)), &entry))
+	}
+
+	var reqs []*http.Request
+	for i := 0; i < 6; i++ {
+		reqs = append(reqs, newRequest(i))
+	}
+	results := cache.DoBatch(context.Background(), reqs, BatchOptions{Concurrency: 2})
+	require.Len(t, results, len(reqs))
+
+	for i, result := range results {
+		require.NoError(t, result.Err)
+		body, err := io.ReadAll(result.Response.Body)
+		require.NoError(t, err)
+		if i == 1 || i == 3 {
+			require.Equal(t, "cached", string(body), "request %d", i)
+		} else {
+			require.Equal(t, "Hello World", string(body), "request %d", i)
+		}
+	}
+	require.Equal(t, int32(1), provider.multiGets.Load())
+	require.Zero(t, provider.gets.Load(), "entries are read at once")
+	require.LessOrEqual(t, requester.maxInflight.Load(), int32(2))
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		results := cache.DoBatch(ctx, []*http.Request{newRequest(7)}, BatchOptions{})
+		require.Truef(t, errors.Is(results[0].Err, context.Canceled), "expected context.Canceled, got %v", results[0].Err)
+	})
+}
+
+func TestCache_IsFresh(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         now,
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": now.Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+
+	fresh, ttl, err := cache.IsFresh(ctx, req)
+	require.NoError(t, err, "cache.IsFresh")
+	require.False(t, fresh, "Expected no entry")
+	require.Zero(t, ttl)
+
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	now = now.Add(15 * time.Minute)
+	fresh, ttl, err = cache.IsFresh(ctx, req)
+	require.NoError(t, err, "cache.IsFresh")
+	require.True(t, fresh)
+	require.Equal(t, 45*time.Minute, ttl)
+
+	now = now.Add(time.Hour)
+	fresh, _, err = cache.IsFresh(ctx, req)
+	require.NoError(t, err, "cache.IsFresh")
+	require.False(t, fresh, "Expected the entry to be expired")
+	require.Equal(t, 1, requester.requestCount, "IsFresh must not go to the origin")
+}
+
+func TestCache_DebugHeaders(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": now.Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+	cache.DebugHeaders = true
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+
+	resp, err := cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, cacheURL, resp.Header.Get(HeaderCacheKey))
+	require.Equal(t, string(CacheStatusMiss), resp.Header.Get(HeaderCacheStatus))
+	require.Equal(t, "0", resp.Header.Get(HeaderCacheAge))
+
+	now = now.Add(90 * time.Second)
+	resp, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, string(CacheStatusHit), resp.Header.Get(HeaderCacheStatus))
+	require.Equal(t, "90", resp.Header.Get(HeaderCacheAge))
+
+	post, err := http.NewRequest(http.MethodPost, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	resp, err = cache.Do(post)
+	require.NoError(t, err, "cache.Do")
+	require.Empty(t, resp.Header.Get(HeaderCacheAge))
+}
+
+func TestCache_ScopeByAuthorization(t *testing.T) {
+	const cacheURL = "http://example.com/me"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.ScopeByAuthorization = true
+	cache.KeySecret = []byte("key secret")
+
+	do := func(ctx context.Context, authorization string) string {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		return cache.Key(req)
+	}
+	ctx := context.Background()
+
+	require.Equal(t, cacheURL, do(ctx, ""), "Expected anonymous requests to keep their key")
+	alice := do(ctx, "Bearer alice")
+	require.NotContains(t, alice, "alice", "Expected credentials not to show up in keys")
+	mac := hmac.New(sha256.New, []byte("key secret"))
+	mac.Write([]byte("Bearer alice"))
+	require.Equal(t, cacheURL+"|auth:"+hex.EncodeToString(mac.Sum(nil)), alice, "Expected a digest keyed by KeySecret")
+	do(ctx, "Bearer alice")
+	require.Equal(t, 2, requester.requestCount)
+
+	do(ctx, "Bearer bob")
+	require.Equal(t, 3, requester.requestCount, "Expected each user to have its own entry")
+
+	user := do(WithPrincipal(ctx, "user-42"), "Bearer token-1")
+	require.Equal(t, user, do(WithPrincipal(ctx, "user-42"), "Bearer token-2"), "Expected the principal to take precedence")
+	require.Equal(t, 4, requester.requestCount)
+
+	cache.KeySecret = nil
+	require.NotEqual(t, alice, do(ctx, "Bearer alice"), "Expected a secret drawn for the process without KeySecret")
+}
+
+func TestCache_SensitiveParams(t *testing.T) {
+	requester := fakeRequester{data: map[string]*cacheEntry{
+		"http://example.com/search?q=go&API_KEY=secret&page=2&sig=abc": {StatusCode: 200, Data: []byte("Hello World")},
+	}}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.SensitiveParams = DefaultSensitiveParams
+	cache.KeySecret = []byte("key secret")
+
+	var events []Event
+	cache.Hooks.OnDo = func(_ context.Context, event Event) {
+		events = append(events, event)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/search?q=go&API_KEY=secret&page=2&sig=abc", nil)
+	require.NoError(t, err, "http.NewRequest")
+	digest := func(value string) string {
+		mac := hmac.New(sha256.New, []byte("key secret"))
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	require.Equal(t, "http://example.com/search?q=go&API_KEY="+digest("secret")+"&page=2&sig="+digest("abc"), cache.Key(req))
+	require.Equal(t, "http://example.com/search?q=go&API_KEY=secret&page=2&sig=abc", req.URL.String(), "Expected the request to be left untouched")
+
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Len(t, events, 1)
+	require.Equal(t, "http://example.com/search?q=go&page=2", events[0].URL)
+	require.Equal(t, "http://example.com/search?q=go&API_KEY=secret&page=2&sig=abc", requester.requestLog[0].URL.String(), "Expected the origin to get every parameter")
+
+	plain, err := http.NewRequest(http.MethodGet, "http://example.com/search?q=go&page=2", nil)
+	require.NoError(t, err, "http.NewRequest")
+	require.NotEqual(t, cache.Key(req), cache.Key(plain), "Expected requests without credentials not to share the entry")
+	other, err := http.NewRequest(http.MethodGet, "http://example.com/search?q=go&API_KEY=other&page=2&sig=abc", nil)
+	require.NoError(t, err, "http.NewRequest")
+	require.NotEqual(t, cache.Key(req), cache.Key(other), "Expected requests with other credentials not to share the entry")
+}
+
+func TestCache_HooksEventSize(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	var events []Event
+	cache.Hooks.OnDo = func(_ context.Context, event Event) {
+		events = append(events, event)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.Error(t, err, "Expected the origin to fail")
+
+	requester.data = map[string]*cacheEntry{
+		cacheURL: {StatusCode: 200, Data: []byte("Hello World"), Headers: map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)}},
+	}
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	require.Len(t, events, 2)
+	require.Equal(t, int64(-1), events[0].Size, "Expected the size to be unknown on error")
+	require.Equal(t, int64(11), events[1].Size)
+}
+
+// streamRequester answers with a body that only ends when the test closes it.
+type streamRequester struct {
+	contentType string
+	body        *io.PipeReader
+}
+
+func (s *streamRequester) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{s.contentType}},
+		Body:          s.body,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+func TestCache_Streaming(t *testing.T) {
+	const cacheURL = "http://example.com/events"
+
+	type result struct {
+		body string
+		err  error
+	}
+
+	do := func(t *testing.T, cache *Cache, requester *streamRequester, w *io.PipeWriter) {
+		cache.HttpClient = requester
+		go func() {
+			_, _ = w.Write([]byte("0123456789"))
+		}()
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		results := make(chan result, 1)
+		go func() {
+			resp, err := cache.Do(req)
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+			buf := make([]byte, 10)
+			_, err = io.ReadFull(resp.Body, buf)
+			results <- result{body: string(buf), err: err}
+		}()
+		select {
+		case res := <-results:
+			require.NoError(t, res.err)
+			require.Equal(t, "0123456789", res.body)
+		case <-time.After(time.Second):
+			t.Fatal("Expected the stream not to be buffered")
+		}
+
+		_, err = cache.PeekKey(context.Background(), cacheURL)
+		require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected streams not to be stored, got %v", err)
+	}
+
+	t.Run("event stream", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer w.Close()
+		do(t, New(memoryprovider.New()), &streamRequester{contentType: "text/event-stream; charset=utf-8", body: r}, w)
+	})
+
+	t.Run("unbounded body over MaxBodySize", func(t *testing.T) {
+		policy, err := NewPolicy(PolicyRule{MaxBodySize: 4})
+		require.NoError(t, err, "NewPolicy")
+		cache := New(memoryprovider.New())
+		cache.Policy = policy
+
+		r, w := io.Pipe()
+		defer w.Close()
+		do(t, cache, &streamRequester{contentType: "text/plain", body: r}, w)
+	})
+
+	t.Run("idle unbounded body", func(t *testing.T) {
+		// a chunked long poll
+		cache := New(memoryprovider.New())
+		cache.StreamIdleTimeout = 50 * time.Millisecond
+
+		r, w := io.Pipe()
+		defer w.Close()
+		start := time.Now()
+		do(t, cache, &streamRequester{contentType: "text/plain", body: r}, w)
+		require.GreaterOrEqual(t, time.Since(start), cache.StreamIdleTimeout, "Expected the body to be waited for")
+	})
+
+	t.Run("idle handoff disabled", func(t *testing.T) {
+		cache := New(memoryprovider.New())
+		r, w := io.Pipe()
+		cache.HttpClient = &streamRequester{contentType: "text/plain", body: r}
+		go func() {
+			_, _ = w.Write([]byte("0123456789"))
+			time.Sleep(100 * time.Millisecond)
+			_ = w.Close()
+		}()
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "0123456789", string(body))
+
+		_, err = cache.PeekKey(context.Background(), cacheURL)
+		require.NoError(t, err, "Expected bodies of unknown length to be read until they end")
+	})
+}
+
+func TestCache_Range(t *testing.T) {
+	const cacheURL = "http://example.com/file"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Etag":    `"v1"`,
+					"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	do := func(headers map[string]string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		return resp, string(body)
+	}
+
+	resp, body := do(map[string]string{"Range": "bytes=0-4"})
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "Hello", body)
+	require.Equal(t, "bytes 0-4/11", resp.Header.Get("Content-Range"))
+	require.Empty(t, requester.requestLog[0].Header.Get("Range"), "Expected the whole resource to be fetched")
+
+	resp, body = do(nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "Hello World", body, "Expected the whole resource to be cached")
+	require.Equal(t, 1, requester.requestCount)
+
+	resp, body = do(map[string]string{"Range": "bytes=-5"})
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "World", body)
+
+	resp, _ = do(map[string]string{"Range": "bytes=20-"})
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	require.Equal(t, "bytes */11", resp.Header.Get("Content-Range"))
+
+	resp, body = do(map[string]string{"Range": "bytes=0-4", "If-Range": `"v0"`})
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Expected mismatching If-Range to get the whole resource")
+	require.Equal(t, "Hello World", body)
+	require.Equal(t, 1, requester.requestCount)
+
+	t.Run("partial content is not stored", func(t *testing.T) {
+		requester := fakeRequester{
+			data: map[string]*cacheEntry{
+				cacheURL: {StatusCode: http.StatusPartialContent, Data: []byte("Hello")},
+			},
+		}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = &requester
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+		_, err = cache.PeekKey(context.Background(), cacheURL)
+		require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected partial content not to be stored, got %v", err)
+	})
+}
+
+func TestCache_StatusTTLs(t *testing.T) {
+	const okURL = "http://example.com/"
+	const headerURL = "http://example.com/header"
+	const missingURL = "http://example.com/missing"
+	const failingURL = "http://example.com/failing"
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			okURL: {StatusCode: 200, Data: []byte("Hello World")},
+			headerURL: {StatusCode: 200, Data: []byte("Hello World"), Headers: map[string]string{
+				"Expires": now.Add(time.Minute).Format(time.RFC1123),
+			}},
+			missingURL: {StatusCode: 404, Data: []byte("not found")},
+			failingURL: {StatusCode: 503, Data: []byte("unavailable")},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+	cache.StatusTTLs = StatusTTLs{"2xx": time.Hour, "404": 10 * time.Second, "5xx": -1}
+	require.NoError(t, cache.StatusTTLs.Validate())
+
+	for _, u := range []string{okURL, headerURL, missingURL, failingURL} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	info, err := cache.PeekKey(ctx, okURL)
+	require.NoError(t, err, "cache.PeekKey")
+	require.Equal(t, now.Add(time.Hour), info.Expires)
+	info, err = cache.PeekKey(ctx, headerURL)
+	require.NoError(t, err, "cache.PeekKey")
+	require.Equal(t, now.Add(time.Minute), info.Expires, "Expected headers to take precedence")
+	info, err = cache.PeekKey(ctx, missingURL)
+	require.NoError(t, err, "cache.PeekKey")
+	require.Equal(t, now.Add(10*time.Second), info.Expires)
+	_, err = cache.PeekKey(ctx, failingURL)
+	require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected 5xx responses not to be stored, got %v", err)
+
+	require.Error(t, StatusTTLs{"2x": time.Hour}.Validate())
+}
+
+func TestCache_PragmaNoCache(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.DebugHeaders = true
+
+	do := func(headers map[string]string) CacheStatus {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		return CacheStatus(resp.Header.Get(HeaderCacheStatus))
+	}
+
+	require.Equal(t, CacheStatusMiss, do(nil))
+	require.Equal(t, CacheStatusHit, do(nil))
+	require.Equal(t, CacheStatusRevalidated, do(map[string]string{"Pragma": "no-cache"}))
+	require.Equal(t, 2, requester.requestCount, "Expected Pragma: no-cache to reach the origin")
+	require.Equal(t, CacheStatusHit, do(map[string]string{"Pragma": "no-cache", "Cache-Control": "max-age=60"}), "Expected Pragma to be ignored along with Cache-Control")
+	require.Equal(t, 2, requester.requestCount)
+}
+
+func TestCache_Partition(t *testing.T) {
+	const cacheURL = "http://cdn.example.com/lib.js"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	do := func(ctx context.Context) string {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		return cache.Key(req)
+	}
+	ctx := context.Background()
+
+	require.Equal(t, "partition:a.example:"+cacheURL, do(WithPartition(ctx, "a.example")))
+	do(WithPartition(ctx, "a.example"))
+	require.Equal(t, 1, requester.requestCount)
+
+	do(WithPartition(ctx, "b.example"))
+	require.Equal(t, 2, requester.requestCount, "Expected partitions not to share entries")
+	do(ctx)
+	require.Equal(t, 3, requester.requestCount, "Expected partitioned entries not to be shared with unpartitioned calls")
+
+	require.Equal(t, "tenant:acme:partition:a.example:"+cacheURL, do(WithTenant(WithPartition(ctx, "a.example"), "acme")))
+
+	deleted, err := cache.Purge(ctx, "partition:b.example:")
+	require.NoError(t, err, "cache.Purge")
+	require.Equal(t, 1, deleted)
+}
+
+// plainProvider hides the optional interfaces of the provider it wraps.
+type plainProvider struct {
+	Provider
+}
+
+func TestCache_MemoryBudget(t *testing.T) {
+	run := func(t *testing.T, provider Provider) {
+		requester := fakeRequester{data: map[string]*cacheEntry{}}
+		for i := 0; i < 3; i++ {
+			requester.data[fmt.Sprintf("http://example.com/%d", i)] = &cacheEntry{
+				StatusCode: 200,
+				Data:       bytes.Repeat([]byte("a"), 100),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			}
+		}
+		cache := New(provider)
+		cache.HttpClient = &requester
+
+		do := func(i int) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+			require.NoError(t, err, "http.NewRequest")
+			_, err = cache.Do(req)
+			require.NoError(t, err, "cache.Do")
+		}
+
+		cache.MemoryBudget = 1 << 20
+		do(0)
+		stats := cache.Stats()
+		require.Positive(t, stats.BytesWritten)
+		require.Equal(t, stats.BytesWritten, stats.BytesStored)
+		// room for a second entry, not a third
+		cache.MemoryBudget = stats.BytesStored*2 + stats.BytesStored/2
+
+		do(1)
+		do(2)
+		stats = cache.Stats()
+		require.Equal(t, int64(1), stats.BudgetRejected)
+		_, err := cache.PeekKey(context.Background(), "http://example.com/2")
+		require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected the entry over budget not to be stored, got %v", err)
+
+		do(0)
+		require.Equal(t, 3, requester.requestCount, "Expected entries within budget to be served")
+	}
+
+	t.Run("sizer", func(t *testing.T) {
+		run(t, memoryprovider.New())
+	})
+	t.Run("estimate", func(t *testing.T) {
+		run(t, plainProvider{memoryprovider.New()})
+	})
+}
+
+func TestCache_MemoryBudgetUsage(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	requester := fakeRequester{data: map[string]*cacheEntry{}}
+	for i := 0; i < 2; i++ {
+		requester.data[fmt.Sprintf("http://example.com/%d", i)] = &cacheEntry{
+			StatusCode: 200,
+			Data:       bytes.Repeat([]byte("a"), 100),
+			Headers:    map[string]string{"Expires": now.Add(time.Duration(i+1) * time.Hour).Format(time.RFC1123)},
+		}
+	}
+	provider := &failingProvider{MemoryProvider: memoryprovider.New()}
+	cache := New(plainProvider{provider})
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+	cache.MemoryBudget = 1 << 20
+
+	do := func(i int) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	provider.setErr = errors.New("connection reset")
+	do(0)
+	require.Zero(t, cache.Stats().BytesStored, "Expected failed writes not to be accounted for")
+
+	provider.setErr = nil
+	do(0)
+	stored := cache.Stats().BytesStored
+	require.Positive(t, stored)
+	// no room for a second entry until the first one expires
+	cache.MemoryBudget = stored + stored/2
+	do(1)
+	require.Equal(t, int64(1), cache.Stats().BudgetRejected)
+
+	now = now.Add(90 * time.Minute)
+	require.Zero(t, cache.Stats().BytesStored, "Expected expired entries to lapse")
+	do(1)
+	stats := cache.Stats()
+	// entries only differ by their fetch duration
+	require.InDelta(t, stored, stats.BytesStored, 8)
+	require.Equal(t, int64(1), stats.BudgetRejected)
+}
+
+// revalidatingRequester answers conditional requests with a 304 status code, and others as fakeRequester does.
+type revalidatingRequester struct {
+	fakeRequester
+}
+
+func (r *revalidatingRequester) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("If-None-Match") != "" {
+		return &http.Response{StatusCode: http.StatusNotModified, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	}
+	return r.fakeRequester.Do(req)
+}
+
+func TestCache_LatencyStats(t *testing.T) {
+	const freshURL = "http://example.com/"
+	const etagURL = "http://example.com/api/etag"
+	const expiredURL = "http://example.com/api/expired"
+
+	expired := map[string]string{"Expires": time.Now().Add(-time.Hour).Format(time.RFC1123)}
+	requester := revalidatingRequester{fakeRequester{
+		data: map[string]*cacheEntry{
+			freshURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+			etagURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": expired["Expires"], "Etag": `"v1"`},
+			},
+			expiredURL: {StatusCode: 200, Data: []byte("Hello World"), Headers: expired},
+		},
+	}}
+	policy, err := NewPolicy(PolicyRule{Name: "api", Pattern: "/api/"})
+	require.NoError(t, err, "NewPolicy")
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Policy = policy
+
+	var sources []LatencySource
+	cache.Hooks.OnDo = func(_ context.Context, event Event) {
+		sources = append(sources, event.Source)
+	}
+	for _, u := range []string{freshURL, freshURL, freshURL, etagURL, etagURL, expiredURL, expiredURL} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+	require.Equal(t, []LatencySource{
+		SourceOrigin, SourceCache, SourceCache,
+		SourceOrigin, SourceRevalidation,
+		SourceOrigin, SourceOrigin,
+	}, sources, "Expected expired entries fully fetched again to count as origin fetches")
+
+	stats := cache.Stats()
+	require.Equal(t, int64(2), stats.CacheLatency.Count)
+	require.Equal(t, int64(1), stats.RevalidationLatency.Count)
+	require.Equal(t, int64(4), stats.OriginLatency.Count)
+
+	h := stats.CacheLatency
+	require.Len(t, h.Buckets, len(LatencyBuckets))
+	require.GreaterOrEqual(t, h.Count, h.Buckets[len(h.Buckets)-1], "Expected cumulative buckets")
+	require.LessOrEqual(t, h.Mean(), h.Sum)
+
+	require.Len(t, stats.EndpointLatency, 1, "Expected calls matching no named rule not to be labelled")
+	api := stats.EndpointLatency["api"]
+	require.Equal(t, int64(0), api.Cache.Count)
+	require.Equal(t, int64(1), api.Revalidation.Count)
+	require.Equal(t, int64(3), api.Origin.Count)
+}
+
+func TestCache_MinRefreshInterval(t *testing.T) {
+	const targetURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			targetURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	now := time.Now()
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.MinRefreshInterval = time.Minute
+	cache.Now = func() time.Time { return now }
+
+	do := func(ctx context.Context) CacheStatus {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+		require.NoError(t, err)
+		var status CacheStatus
+		cache.Hooks = Hooks{OnDo: func(_ context.Context, event Event) { status = event.Status }}
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(body))
+		return status
+	}
+
+	ctx := context.Background()
+	require.Equal(t, CacheStatusMiss, do(ctx))
+	require.Equal(t, CacheStatusHit, do(ctx))
+	require.Equal(t, CacheStatusRateLimited, do(WithIgnoreCache(ctx, true)))
+	require.Equal(t, CacheStatusRateLimited, do(WithIgnoreCache(ctx, true)))
+	require.Equal(t, 1, requester.requestCount, "Expected a single origin request within the interval")
+
+	now = now.Add(time.Minute)
+	require.Equal(t, CacheStatusIgnored, do(WithIgnoreCache(ctx, true)))
+	require.Equal(t, 2, requester.requestCount)
+}
+
+func TestCache_DedupWindow(t *testing.T) {
+	const targetURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			targetURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.DedupWindow = 50 * time.Millisecond
+
+	ctx := WithIgnoreCache(context.Background(), true)
+	do := func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+		require.NoError(t, err)
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(body))
+	}
+
+	do()
+	do()
+	do()
+	require.Equal(t, 1, requester.requestCount, "Expected calls within the window to share the fetch")
+
+	time.Sleep(60 * time.Millisecond)
+	do()
+	require.Equal(t, 2, requester.requestCount, "Expected a new fetch after the window")
+
+	cache.DedupWindow = 0
+	do()
+	require.Equal(t, 3, requester.requestCount)
+}
+
+func TestCache_StaleHeaders(t *testing.T) {
+	const targetURL = "http://example.com/"
+
+	now := time.Now().Truncate(time.Second)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			targetURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": now.Add(time.Minute).Format(time.RFC1123)},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+
+	do := func(ctx context.Context) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+		require.NoError(t, err)
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		return resp
+	}
+
+	ctx := context.Background()
+	do(ctx)
+	resp := do(ctx)
+	require.Empty(t, resp.Header.Get("Warning"), "Expected no warning on fresh responses")
+	require.Empty(t, resp.Header.Get(HeaderCacheStaleFor))
+
+	now = now.Add(3 * time.Minute)
+	resp = do(WithIgnoreExpired(ctx, true))
+	require.Equal(t, `110 - "Response is Stale"`, resp.Header.Get("Warning"))
+	require.Equal(t, "120", resp.Header.Get(HeaderCacheStaleFor))
+
+	resp = do(WithOffline(ctx, true))
+	require.Equal(t, `110 - "Response is Stale"`, resp.Header.Get("Warning"))
+
+	requester.data[targetURL] = &cacheEntry{StatusCode: http.StatusBadGateway}
+	cache.StaleIfError = time.Hour
+	resp = do(ctx)
+	require.Equal(t, `111 - "Revalidation Failed"`, resp.Header.Get("Warning"))
+	require.Equal(t, "120", resp.Header.Get(HeaderCacheStaleFor))
+}
+
+func TestCache_DoWithStatus(t *testing.T) {
+	const targetURL = "http://example.com/"
+
+	now := time.Now().Truncate(time.Second)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			targetURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": now.Add(time.Minute).Format(time.RFC1123)},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+
+	req, err := http.NewRequest(http.MethodGet, targetURL, nil)
+	require.NoError(t, err)
+	_, status, err := cache.DoWithStatus(req)
+	require.NoError(t, err, "cache.DoWithStatus")
+	require.Equal(t, DoStatus{Key: targetURL, Cache: CacheStatusMiss}, status)
+
+	now = now.Add(2 * time.Minute)
+	ctx := WithIgnoreExpired(context.Background(), true)
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	require.NoError(t, err)
+	_, status, err = cache.DoWithStatus(req)
+	require.NoError(t, err, "cache.DoWithStatus")
+	require.Equal(t, DoStatus{Key: targetURL, Cache: CacheStatusIgnoredExpiry, Stale: true, StaleFor: time.Minute}, status)
+
+	resp, err := cache.Do(req)
+	require.NoError(t, err, "Expected no error without WithStaleError")
+	require.NotNil(t, resp)
+
+	req, err = http.NewRequestWithContext(WithStaleError(ctx, true), http.MethodGet, targetURL, nil)
+	require.NoError(t, err)
+	resp, err = cache.Do(req)
+	require.Truef(t, errors.Is(err, ErrStaleServed), "Expected ErrStaleServed, got %v", err)
+	var staleErr *StaleEntryError
+	require.True(t, errors.As(err, &staleErr))
+	require.Equal(t, targetURL, staleErr.Key)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Hello World", string(body), "Expected the stale response along with the error")
+}
+
+// conditionalRequester answers every conditional request with a 304 status code, or every request if always is set.
+type conditionalRequester struct {
+	always   bool
+	requests []*http.Request
+}
+
+func (c *conditionalRequester) Do(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req)
+	if c.always || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return &http.Response{StatusCode: http.StatusNotModified, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Expires": []string{time.Now().Add(time.Hour).Format(time.RFC1123)}},
+		Body:       io.NopCloser(strings.NewReader("Hello World")),
+		Request:    req,
+	}, nil
+}
+
+func TestCache_UnusableNotModified(t *testing.T) {
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		req.Header.Set("If-None-Match", `"v1"`)
+		return req
+	}
+
+	t.Run("error", func(t *testing.T) {
+		requester := &conditionalRequester{}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+		cache.UnusableNotModified = UnusableNotModifiedError
+
+		_, err := cache.Do(newRequest())
+		require.Truef(t, errors.Is(err, ErrValidatorMismatch), "Expected ErrValidatorMismatch, got %v", err)
+		var originErr *OriginError
+		require.True(t, errors.As(err, &originErr))
+		require.Len(t, requester.requests, 1)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		requester := &conditionalRequester{}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+
+		req := newRequest()
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(body))
+
+		require.Len(t, requester.requests, 2)
+		require.Empty(t, requester.requests[1].Header.Get("If-None-Match"), "Expected the retry without validators")
+		require.Equal(t, `"v1"`, req.Header.Get("If-None-Match"), "Expected the caller's request to be left untouched")
+	})
+
+	t.Run("retry once", func(t *testing.T) {
+		requester := &conditionalRequester{always: true}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+
+		_, err := cache.Do(newRequest())
+		require.Truef(t, errors.Is(err, ErrValidatorMismatch), "Expected ErrValidatorMismatch, got %v", err)
+		require.Len(t, requester.requests, 2, "Expected a single retry")
+	})
+}
+
+// languageRequester answers in the language of the Accept-Language header, varying on it.
+type languageRequester struct {
+	vary     string
+	requests int
+}
+
+func (l *languageRequester) Do(req *http.Request) (*http.Response, error) {
+	l.requests++
+	body := "hello"
+	if req.Header.Get("Accept-Language") == "fr" {
+		body = "bonjour"
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Expires": []string{time.Now().Add(time.Hour).Format(time.RFC1123)},
+			"Vary":    []string{l.vary},
+		},
+		Body:    io.NopCloser(strings.NewReader(body)),
+		Request: req,
+	}, nil
+}
+
+func TestCache_Vary(t *testing.T) {
+	const targetURL = "http://example.com/"
+	ctx := context.Background()
+
+	newRequest := func(lang string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, targetURL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Language", lang)
+		return req
+	}
+	get := func(t *testing.T, cache *Cache, lang string) string {
+		resp, err := cache.Do(newRequest(lang))
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("variants", func(t *testing.T) {
+		requester := &languageRequester{vary: "accept-language, Accept-Language"}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+
+		require.Equal(t, "hello", get(t, cache, "en"))
+		require.Equal(t, "bonjour", get(t, cache, "fr"))
+		require.Equal(t, "hello", get(t, cache, "en"))
+		require.Equal(t, "bonjour", get(t, cache, "fr"))
+		require.Equal(t, 2, requester.requests, "Expected each variant to be cached")
+
+		info, err := cache.Peek(ctx, newRequest("fr"))
+		require.NoError(t, err, "cache.Peek")
+		require.Equal(t, "bonjour", string(info.Body))
+		fresh, _, err := cache.IsFresh(ctx, newRequest("en"))
+		require.NoError(t, err, "cache.IsFresh")
+		require.True(t, fresh)
+
+		deleted, err := cache.InvalidateURL(ctx, targetURL)
+		require.NoError(t, err, "cache.InvalidateURL")
+		require.Equal(t, 2, deleted)
+		require.Equal(t, "hello", get(t, cache, "en"))
+		require.Equal(t, "bonjour", get(t, cache, "fr"))
+		require.Equal(t, 4, requester.requests, "Expected every variant to be invalidated")
+	})
+
+	t.Run("any", func(t *testing.T) {
+		requester := &languageRequester{vary: "*"}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+
+		require.Equal(t, "hello", get(t, cache, "en"))
+		require.Equal(t, "hello", get(t, cache, "en"))
+		require.Equal(t, 2, requester.requests, "Expected responses varying on anything not to be stored")
+	})
+}
+
+func TestCache_ReverseIndex(t *testing.T) {
+	const targetURL = "http://example.com/page"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			targetURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	do := func(t *testing.T, cache *Cache, ctx context.Context, device string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Device", device)
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	for _, indexed := range []bool{false, true} {
+		requester.requestCount = 0
+		cache := New(memoryprovider.New())
+		cache.HttpClient = &requester
+		cache.ReverseIndex = indexed
+		cache.KeyGenerator = func(req *http.Request) string {
+			return req.URL.String() + "#" + req.Header.Get("X-Device")
+		}
+
+		do(t, cache, ctx, "mobile")
+		do(t, cache, ctx, "desktop")
+		do(t, cache, WithTenant(ctx, "acme"), "mobile")
+		require.Equal(t, 3, requester.requestCount)
+
+		deleted, err := cache.InvalidateURL(ctx, "http://EXAMPLE.com:80/page")
+		require.NoError(t, err, "cache.InvalidateURL")
+		do(t, cache, ctx, "mobile")
+		do(t, cache, ctx, "desktop")
+		do(t, cache, WithTenant(ctx, "acme"), "mobile")
+		if indexed {
+			require.Equal(t, 3, deleted)
+			require.Equal(t, 6, requester.requestCount, "Expected every entry of the URL to be invalidated")
+		} else {
+			require.Zero(t, deleted)
+			require.Equal(t, 3, requester.requestCount, "Expected custom keys to be out of reach without an index")
+		}
+
+		var buf bytes.Buffer
+		n, err := cache.Export(ctx, &buf)
+		require.NoError(t, err, "cache.Export")
+		require.Equal(t, 3, n, "Expected index keys not to be exported")
+	}
+}
+
+func TestCache_InvalidateHost(t *testing.T) {
+	entry := func() *cacheEntry {
+		return &cacheEntry{
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+		}
+	}
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			"http://bad.example.com/a":    entry(),
+			"http://bad.example.com:80/b": entry(),
+			"http://good.example.com/a":   entry(),
+		},
+	}
+	ctx := context.Background()
+
+	for _, indexed := range []bool{false, true} {
+		// keys of other applications sharing the provider
+		provider := wrongTypeProvider{MemoryProvider: memoryprovider.New()}
+		require.NoError(t, provider.Set(ctx, "list:jobs", nil, 0))
+		require.NoError(t, provider.Set(ctx, "other", []byte(`{"url": "http://bad.example.com/"}`), 0))
+
+		cache := New(provider)
+		cache.HttpClient = &requester
+		cache.ReverseIndex = indexed
+		cache.KeyHash = KeyHashSHA256Hex
+
+		for u := range requester.data {
+			req, err := http.NewRequest(http.MethodGet, u, nil)
+			require.NoError(t, err)
+			_, err = cache.Do(req)
+			require.NoError(t, err, "cache.Do")
+		}
+
+		deleted, err := cache.InvalidateHost(ctx, "BAD.example.com")
+		require.NoError(t, err, "cache.InvalidateHost")
+		require.Equal(t, 2, deleted)
+		value, err := provider.Get(ctx, "other")
+		require.NoError(t, err)
+		require.NotNil(t, value, "Expected values that aren't entries to be kept")
+
+		for u := range requester.data {
+			req, err := http.NewRequest(http.MethodGet, u, nil)
+			require.NoError(t, err)
+			_, err = cache.Peek(ctx, req)
+			if strings.Contains(u, "bad") {
+				require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected %s to be invalidated, got %v", u, err)
+			} else {
+				require.NoError(t, err, "Expected %s to be kept", u)
+			}
+		}
+	}
+}
+
+func TestCache_Sweep(t *testing.T) {
+	entry := func(ttl time.Duration) *cacheEntry {
+		return &cacheEntry{
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers:    map[string]string{"Expires": time.Now().Add(ttl).Format(time.RFC1123)},
+		}
+	}
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			"http://example.com/short": entry(time.Hour),
+			"http://example.com/long":  entry(3 * time.Hour),
+		},
+	}
+	ctx := context.Background()
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.TrackAccess = true
+	for u := range requester.data {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	deleted, err := cache.Sweep(ctx, 0)
+	require.NoError(t, err, "cache.Sweep")
+	require.Equal(t, 0, deleted)
+
+	cache.Now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	deleted, err = cache.Sweep(ctx, 2*time.Hour)
+	require.NoError(t, err, "cache.Sweep")
+	require.Equal(t, 0, deleted, "Expected expired entries to be kept during the grace period")
+
+	deleted, err = cache.Sweep(ctx, 30*time.Minute)
+	require.NoError(t, err, "cache.Sweep")
+	require.Equal(t, 1, deleted)
+	require.Equal(t, int64(1), cache.Stats().SweptEntries)
+
+	_, err = cache.PeekKey(ctx, "http://example.com/short")
+	require.ErrorIs(t, err, ErrCacheMiss)
+	_, err = cache.PeekKey(ctx, "http://example.com/long")
+	require.NoError(t, err)
+
+	t.Run("background", func(t *testing.T) {
+		cache.Now = func() time.Time { return time.Now().Add(4 * time.Hour) }
+		cache.Sweeper = &Sweeper{Interval: 10 * time.Millisecond}
+		defer cache.Close()
+
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/long", nil)
+		require.NoError(t, err)
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		require.Eventually(t, func() bool {
+			_, err := cache.PeekKey(ctx, "http://example.com/long")
+			return errors.Is(err, ErrCacheMiss)
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestCache_MaxEntriesPerHost(t *testing.T) {
+	entry := func() *cacheEntry {
+		return &cacheEntry{
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+		}
+	}
+	urls := []string{
+		"http://api.example.com/1",
+		"http://api.example.com/2",
+		"http://api.example.com/3",
+		"http://www.example.com/1",
+		"http://www.example.com/2",
+	}
+	requester := fakeRequester{data: map[string]*cacheEntry{}}
+	for _, u := range urls {
+		requester.data[u] = entry()
+	}
+	ctx := context.Background()
+
+	for _, indexed := range []bool{false, true} {
+		cache := New(memoryprovider.New())
+		cache.HttpClient = &requester
+		cache.ReverseIndex = indexed
+		policy, err := NewPolicy(PolicyRule{Host: "api.example.com", MaxEntriesPerHost: 2})
+		require.NoError(t, err)
+		cache.Policy = policy
+
+		now := time.Now()
+		for i, u := range urls {
+			ts := now.Add(time.Duration(i) * time.Second)
+			cache.Now = func() time.Time { return ts }
+			req, err := http.NewRequest(http.MethodGet, u, nil)
+			require.NoError(t, err)
+			_, err = cache.Do(req)
+			require.NoError(t, err, "cache.Do")
+		}
+		if !indexed {
+			_, err := cache.PeekKey(ctx, "http://api.example.com/1")
+			require.NoError(t, err, "Expected the limit to be enforced by Sweep without ReverseIndex")
+
+			deleted, err := cache.Sweep(ctx, 0)
+			require.NoError(t, err, "cache.Sweep")
+			require.Equal(t, 1, deleted)
+		}
+
+		for i, u := range urls {
+			_, err := cache.PeekKey(ctx, u)
+			if i == 0 {
+				require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected %s to be removed, got %v", u, err)
+			} else {
+				require.NoError(t, err, "Expected %s to be kept", u)
+			}
+		}
+	}
+}
+
+func TestCache_Group(t *testing.T) {
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			"http://example.com/": {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+			"http://example.com/missing": {StatusCode: 404},
+		},
+	}
+	ctx := context.Background()
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	geo := cache.Group("geo", GroupOptions{StatusTTLs: StatusTTLs{"404": time.Minute}})
+	require.Same(t, geo, cache.Group("geo", GroupOptions{}), "Expected the same group to be returned")
+	pricing := cache.Group("pricing", GroupOptions{})
+
+	do := func(c *Cache, u string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		require.NoError(t, err, "cache.Do")
+		return resp
+	}
+
+	do(geo, "http://example.com/")
+	do(geo, "http://example.com/")
+	do(pricing, "http://example.com/")
+
+	_, err := cache.PeekKey(ctx, "group:geo:http://example.com/")
+	require.NoError(t, err, "Expected the entry to be stored in the namespace of the group")
+	_, err = cache.PeekKey(ctx, "http://example.com/")
+	require.ErrorIs(t, err, ErrCacheMiss)
+
+	require.Equal(t, int64(2), geo.Stats().Requests)
+	require.Equal(t, int64(1), geo.Stats().Hits)
+	require.Equal(t, int64(1), pricing.Stats().Misses)
+	require.Equal(t, int64(3), cache.Stats().Requests, "Expected group calls to count for the cache")
+
+	t.Run("options", func(t *testing.T) {
+		do(geo, "http://example.com/missing")
+		info, err := cache.PeekKey(ctx, "group:geo:http://example.com/missing")
+		require.NoError(t, err, "cache.PeekKey")
+		require.True(t, info.Fresh, "Expected the 404 to be stored with the TTL of the group")
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(WithTenant(ctx, "acme"), http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		_, err = geo.Do(req)
+		require.NoError(t, err, "geo.Do")
+
+		deleted, err := cache.PurgeGroup(ctx, "geo")
+		require.NoError(t, err)
+		require.Equal(t, 3, deleted, "Expected the entries of every tenant to be removed")
+		_, err = cache.PeekKey(ctx, "group:pricing:http://example.com/")
+		require.NoError(t, err, "Expected other groups to be kept")
+	})
+}
+
+// multiSetProvider counts the writes made to a memory provider. Safe for concurrent use.
+type multiSetProvider struct {
+	*memoryprovider.MemoryProvider
+	sets      atomic.Int32
+	multiSets atomic.Int32
+}
+
+func (p *multiSetProvider) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	p.sets.Add(1)
+	return p.MemoryProvider.Set(ctx, key, value, expiry)
+}
+
+func (p *multiSetProvider) SetMulti(ctx context.Context, keys []string, values [][]byte, expiry time.Duration) error {
+	p.multiSets.Add(1)
+	return p.MemoryProvider.SetMulti(ctx, keys, values, expiry)
+}
+
+func TestCache_WriteBatching(t *testing.T) {
+	requester := fakeRequester{data: make(map[string]*cacheEntry)}
+	for i := 0; i < 5; i++ {
+		requester.data[fmt.Sprintf("http://example.com/%d", i)] = &cacheEntry{
+			StatusCode: 200,
+			Data:       []byte("Hello World"),
+			Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+		}
+	}
+	do := func(cache *Cache, i int) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+
+	t.Run("size", func(t *testing.T) {
+		provider := &multiSetProvider{MemoryProvider: memoryprovider.New()}
+		cache := New(provider)
+		cache.HttpClient = &requester
+		cache.WriteBatching = &WriteBatching{MaxSize: 2, Interval: time.Hour}
+
+		for i := 0; i < 5; i++ {
+			do(cache, i)
+		}
+		require.Eventually(t, func() bool { return provider.multiSets.Load() == 2 }, time.Second, time.Millisecond)
+		require.Equal(t, int64(1), cache.Stats().WriteQueueDepth)
+
+		require.NoError(t, cache.Close())
+		require.Equal(t, int32(3), provider.multiSets.Load(), "Expected pending writes to be flushed on Close")
+		require.Equal(t, int32(0), provider.sets.Load())
+		require.Equal(t, int64(3), cache.Stats().WriteBatches)
+		require.Equal(t, int64(0), cache.Stats().WriteQueueDepth)
+
+		_, err := cache.PeekKey(context.Background(), "http://example.com/4")
+		require.NoError(t, err)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		provider := &multiSetProvider{MemoryProvider: memoryprovider.New()}
+		cache := New(provider)
+		cache.HttpClient = &requester
+		cache.WriteBatching = &WriteBatching{Interval: 50 * time.Millisecond}
+		defer cache.Close()
+
+		for i := 0; i < 3; i++ {
+			do(cache, i)
+		}
+		require.Eventually(t, func() bool { return provider.multiSets.Load() == 1 }, time.Second, time.Millisecond)
+		require.Equal(t, int64(0), cache.Stats().WriteQueueDepth)
+	})
+
+	t.Run("routes", func(t *testing.T) {
+		const mediaURL = "http://media.example.com/video.mp4"
+		requester.data[mediaURL] = requester.data["http://example.com/0"]
+		defaultProvider := memoryprovider.New()
+		mediaProvider := taggedProvider{MemoryProvider: memoryprovider.New(), tags: []string{"media"}}
+
+		cache := New(defaultProvider)
+		cache.HttpClient = &requester
+		cache.WriteBatching = &WriteBatching{Interval: time.Hour}
+		cache.Routes = []ProviderRoute{{Host: "media.*", Provider: mediaProvider}}
+
+		do(cache, 0)
+		req, err := http.NewRequest(http.MethodGet, mediaURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.NoError(t, cache.Close())
+
+		ctx := context.Background()
+		value, err := defaultProvider.Get(ctx, "http://example.com/0")
+		require.NoError(t, err)
+		require.NotNil(t, value, "Expected the entry in the default provider")
+		value, err = mediaProvider.Get(ctx, mediaURL)
+		require.NoError(t, err)
+		require.NotNil(t, value, "Expected the media entry in the routed provider")
+	})
+}
+
+// taggedProvider is a provider value that can't be compared, like any struct holding a slice.
+type taggedProvider struct {
+	*memoryprovider.MemoryProvider
+	tags []string
+}
+
+// flakyProvider fails the given number of writes before delegating them to a memory provider. Safe for concurrent use.
+type flakyProvider struct {
+	*memoryprovider.MemoryProvider
+	failures atomic.Int32
+}
+
+func (p *flakyProvider) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	if p.failures.Add(-1) >= 0 {
+		return errors.New("connection reset")
+	}
+	return p.MemoryProvider.Set(ctx, key, value, expiry)
+}
+
+func TestCache_WriteRetry(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	do := func(t *testing.T, failures int32, delay time.Duration) (*Cache, *flakyProvider) {
+		provider := &flakyProvider{MemoryProvider: memoryprovider.New()}
+		provider.failures.Store(failures)
+		cache := New(provider)
+		cache.HttpClient = &requester
+		cache.WriteRetry = &WriteRetry{Attempts: 3, BaseDelay: delay}
+
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do should not fail while the write is retried")
+		return cache, provider
+	}
+
+	t.Run("recovered", func(t *testing.T) {
+		cache, _ := do(t, 2, time.Millisecond)
+		require.Eventually(t, func() bool {
+			_, err := cache.PeekKey(context.Background(), cacheURL)
+			return err == nil
+		}, time.Second, time.Millisecond)
+		require.NoError(t, cache.Close())
+
+		stats := cache.Stats()
+		require.Equal(t, int64(2), stats.WriteRetries)
+		require.Equal(t, int64(0), stats.WritesAbandoned)
+	})
+
+	t.Run("abandoned", func(t *testing.T) {
+		cache, _ := do(t, 5, time.Millisecond)
+		require.Eventually(t, func() bool { return cache.Stats().WritesAbandoned == 1 }, time.Second, time.Millisecond)
+		require.NoError(t, cache.Close())
+		require.Equal(t, int64(2), cache.Stats().WriteRetries)
+
+		_, err := cache.PeekKey(context.Background(), cacheURL)
+		require.ErrorIs(t, err, ErrCacheMiss)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		cache, _ := do(t, 1, 20*time.Millisecond)
+		require.NoError(t, cache.Invalidate(context.Background(), cacheURL))
+		time.Sleep(50 * time.Millisecond)
+		require.NoError(t, cache.Close())
+
+		stats := cache.Stats()
+		require.Equal(t, int64(0), stats.WriteRetries, "Expected the retry to be canceled by the removal of the key")
+		require.Equal(t, int64(0), stats.WritesAbandoned)
+		_, err := cache.PeekKey(context.Background(), cacheURL)
+		require.ErrorIs(t, err, ErrCacheMiss, "Expected the retry not to bring the entry back")
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		cache, _ := do(t, 2, 10*time.Millisecond)
+		require.NoError(t, cache.Shutdown(context.Background()))
+
+		stats := cache.Stats()
+		require.Equal(t, int64(2), stats.WriteRetries)
+		require.Equal(t, int64(0), stats.WritesAbandoned, "Expected Shutdown to wait for the pending retries")
+		_, err := cache.PeekKey(context.Background(), cacheURL)
+		require.NoError(t, err)
+	})
+}
+
+// cancelingRequester cancels the context of the caller before answering, once the cancellation reached the origin
+// request. Not safe for concurrent use.
+type cancelingRequester struct {
+	cancel context.CancelFunc
+	calls  int
+}
+
+func (c *cancelingRequester) Do(req *http.Request) (*http.Response, error) {
+	c.calls++
+	c.cancel()
+	select {
+	case <-req.Context().Done():
+	case <-time.After(time.Second):
+		return nil, errors.New("origin request not canceled with its only caller")
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Expires": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+		Body:       io.NopCloser(strings.NewReader("Hello World")),
+		Request:    req,
+	}, nil
+}
+
+func TestCache_Canceled(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	t.Run("before fetch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		requester := &cancelingRequester{cancel: cancel}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+
+		cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 0, requester.calls, "Expected the origin not to be requested")
+	})
+
+	t.Run("before store", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		requester := &cancelingRequester{cancel: cancel}
+		cache := New(memoryprovider.New())
+		cache.HttpClient = requester
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+
+		_, err = cache.PeekKey(context.Background(), cacheURL)
+		require.ErrorIs(t, err, ErrCacheMiss, "Expected the entry not to be stored for a canceled request")
+	})
+}
+
+func TestCache_BypassHeader(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.BypassHeader = &BypassHeader{Secret: "s3cret"}
+
+	do := func(bypass string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		if bypass != "" {
+			req.Header.Set(HeaderCacheBypass, bypass)
+		}
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		return resp
+	}
+
+	do("")
+	require.Equal(t, 1, requester.requestCount)
+
+	do("wrong")
+	require.Equal(t, 1, requester.requestCount, "Expected a wrong secret to be ignored")
+
+	do("s3cret")
+	require.Equal(t, 2, requester.requestCount, "Expected the cache to be bypassed")
+	require.Empty(t, requester.requestLog[1].Header.Get(HeaderCacheBypass), "Expected the header not to be forwarded")
+
+	t.Run("allow", func(t *testing.T) {
+		cache.BypassHeader = &BypassHeader{Name: "X-Smoke-Test", Allow: func(req *http.Request) bool {
+			return req.Header.Get("Authorization") == "Bearer ops"
+		}}
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		req.Header.Set("X-Smoke-Test", "1")
+		req.Header.Set("Authorization", "Bearer ops")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		require.Equal(t, 3, requester.requestCount)
+	})
+}
+
+func TestCache_WithForceStore(t *testing.T) {
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			"http://example.com/error": {
+				StatusCode: 503,
+				Data:       []byte("Service Unavailable"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+			"http://example.com/events": {
+				StatusCode: 200,
+				Data:       []byte("data: hello\n\n"),
+				Headers: map[string]string{
+					"Content-Type": "text/event-stream",
+					"Expires":      time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+			"http://example.com/large": {
+				StatusCode: 200,
+				Data:       bytes.Repeat([]byte("a"), 100),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.StatusTTLs = StatusTTLs{"5xx": -1}
+	policy, err := NewPolicy(PolicyRule{Pattern: "/large", MaxBodySize: 10})
+	require.NoError(t, err, "NewPolicy")
+	cache.Policy = policy
+
+	for u := range requester.data {
+		for _, force := range []bool{false, true} {
+			req, err := http.NewRequestWithContext(WithForceStore(ctx, force), http.MethodGet, u, nil)
+			require.NoError(t, err, "http.NewRequest")
+			resp, err := cache.Do(req)
+			require.NoError(t, err, "cache.Do")
+			require.NoError(t, resp.Body.Close())
+
+			_, err = cache.PeekKey(ctx, u)
+			if force && u == "http://example.com/error" {
+				require.NoError(t, err, "Expected %s to be stored with WithForceStore", u)
+			} else {
+				require.ErrorIs(t, err, ErrCacheMiss, "Expected %s not to be stored", u)
+			}
+		}
+	}
+}
+
+func TestCache_WithNoStore(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	do := func(ctx context.Context) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+	}
+
+	do(WithForceStore(WithNoStore(ctx, true), true))
+	_, err := cache.PeekKey(ctx, cacheURL)
+	require.ErrorIs(t, err, ErrCacheMiss, "Expected the response not to be stored")
+
+	do(ctx)
+	do(WithNoStore(ctx, true))
+	require.Equal(t, 2, requester.requestCount, "Expected WithNoStore calls to read the cache")
+}
+
+func TestCache_DecompressCached(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write([]byte("Hello World"))
+	require.NoError(t, err, "gzip.Write")
+	require.NoError(t, w.Close(), "gzip.Close")
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       compressed.Bytes(),
+				Headers: map[string]string{
+					"Expires":          time.Now().Add(time.Hour).Format(time.RFC1123),
+					"Content-Encoding": "gzip",
+					"Content-Length":   strconv.Itoa(compressed.Len()),
+				},
+			},
+		},
+	}
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.DecompressCached = true
+
+	do := func(acceptEncoding string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		return resp
+	}
+
+	resp := do("")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err, "io.ReadAll")
+	require.Equal(t, "Hello World", string(body))
+	require.True(t, resp.Uncompressed, "Expected the response to be decompressed")
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+	require.Empty(t, resp.Header.Get("Content-Length"))
+
+	resp = do("gzip, br")
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err, "io.ReadAll")
+	require.Equal(t, compressed.Bytes(), body, "Expected the encoding asked for to be kept")
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	resp = do("gzip;q=0")
+	require.True(t, resp.Uncompressed, "Expected a refused encoding to be decoded")
+	require.Equal(t, 1, requester.requestCount)
+
+	policy, err := NewPolicy(PolicyRule{Host: "example.com", MaxBodySize: 10})
+	require.NoError(t, err, "NewPolicy")
+	cache.Policy = policy
+	resp = do("")
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err, "io.ReadAll")
+	require.Equal(t, compressed.Bytes(), body, "Expected a body decoding past MaxBodySize to be served encoded")
+	require.False(t, resp.Uncompressed)
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	require.Equal(t, 1, requester.requestCount)
+}
+
+func TestDecodeContent(t *testing.T) {
+	var zlibbed, deflated, brotlied bytes.Buffer
+	zw := zlib.NewWriter(&zlibbed)
+	_, _ = zw.Write([]byte("Hello World"))
+	require.NoError(t, zw.Close())
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, _ = fw.Write([]byte("Hello World"))
+	require.NoError(t, fw.Close())
+	bw := brotli.NewWriter(&brotlied)
+	_, _ = bw.Write([]byte("Hello World"))
+	require.NoError(t, bw.Close())
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	zstded := enc.EncodeAll([]byte("Hello World"), nil)
+
+	for _, tc := range []struct {
+		coding string
+		data   []byte
+	}{
+		{"deflate", zlibbed.Bytes()},
+		{"deflate", deflated.Bytes()},
+		{"br", brotlied.Bytes()},
+		{"zstd", zstded},
+	} {
+		decoded, err := decodeContent(tc.coding, tc.data, defaultMaxDecodedSize)
+		require.NoError(t, err, tc.coding)
+		require.Equal(t, "Hello World", string(decoded), tc.coding)
+
+		_, err = decodeContent(tc.coding, tc.data, 5)
+		require.Truef(t, errors.Is(err, errDecodedTooLarge), "Expected errDecodedTooLarge for %s, got %v", tc.coding, err)
+	}
+
+	_, err = decodeContent("compress", nil, defaultMaxDecodedSize)
+	require.Error(t, err)
+}
+
+func TestNormalizeContentEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"gzip":            "gzip",
+		" X-GZIP ":        "gzip",
+		"BR":              "br",
+		"identity":        "",
+		"zstd , identity": "zstd",
+		"deflate,br":      "deflate, br",
+	} {
+		headers := map[string]string{"Content-Encoding": header}
+		normalizeContentEncoding(headers)
+		require.Equal(t, expected, headers["Content-Encoding"], header)
+	}
+}
+
+func TestCache_EntryCompression(t *testing.T) {
+	const largeURL = "http://example.com/large"
+	const smallURL = "http://example.com/small"
+
+	large := strings.Repeat("Hello World ", 200)
+	expires := time.Now().Add(time.Hour).Format(time.RFC1123)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			largeURL: {StatusCode: 200, Data: []byte(large), Headers: map[string]string{"Expires": expires}},
+			smallURL: {StatusCode: 200, Data: []byte("Hello World"), Headers: map[string]string{"Expires": expires}},
+		},
+	}
+	ctx := context.Background()
+
+	provider := memoryprovider.New()
+	cache := New(provider)
+	cache.HttpClient = &requester
+	cache.EntryCompression = &EntryCompression{Threshold: 100}
+
+	for _, codec := range []string{"", "gzip"} {
+		cache.EntryCompression.Codec = codec
+		for _, u := range []string{largeURL, smallURL} {
+			require.NoError(t, cache.Invalidate(ctx, u), "cache.Invalidate")
+			for i := 0; i < 2; i++ {
+				req, err := http.NewRequest(http.MethodGet, u, nil)
+				require.NoError(t, err, "http.NewRequest")
+				resp, err := cache.Do(req)
+				require.NoError(t, err, "cache.Do")
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err, "io.ReadAll")
+				require.Equal(t, string(requester.data[u].Data), string(body))
+			}
+		}
+
+		value, err := provider.Get(ctx, largeURL)
+		require.NoError(t, err, "provider.Get")
+		var stored cacheEntry
+		require.NoError(t, json.Unmarshal(value, &stored))
+		expected := codec
+		if expected == "" {
+			expected = "zstd"
+		}
+		require.Equal(t, expected, stored.Codec)
+		require.Less(t, len(stored.Data), len(large))
+
+		value, err = provider.Get(ctx, smallURL)
+		require.NoError(t, err, "provider.Get")
+		stored = cacheEntry{}
+		require.NoError(t, json.Unmarshal(value, &stored))
+		require.Empty(t, stored.Codec, "Expected bodies under the threshold to be stored as they are")
+	}
+
+	stats := cache.Stats()
+	require.Equal(t, int64(2), stats.EntriesCompressed)
+	require.Greater(t, stats.BytesSaved, int64(len(large)))
+}
+
+func TestCache_RequestMetadata(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.RequestMetadata = &RequestMetadata{Headers: []string{"accept", "X-Request-Id"}}
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, strings.NewReader("query"))
+	require.NoError(t, err, "http.NewRequest")
+	req.Header.Set("Accept", "text/plain")
+	req.Header.Set("Authorization", "Bearer secret")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	info, err := cache.PeekKey(ctx, cacheURL)
+	require.NoError(t, err, "cache.PeekKey")
+	require.NotNil(t, info.Request)
+	require.Equal(t, http.MethodGet, info.Request.Method)
+	require.Equal(t, map[string]string{"Accept": "text/plain"}, info.Request.Headers)
+	sum := sha256.Sum256([]byte("query"))
+	require.Equal(t, hex.EncodeToString(sum[:]), info.Request.BodyHash)
+}
+
+func TestCache_TTLOverride(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte(`{"ttl": 60}`),
+				Headers:    map[string]string{"Expires": time.Now().Add(-time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.TTLOverride = func(req *http.Request, resp *http.Response) (time.Duration, bool) {
+		var body struct {
+			TTL int `json:"ttl"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.TTL == 0 {
+			return 0, false
+		}
+		return time.Duration(body.TTL) * time.Second, true
+	}
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	resp, err := cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err, "io.ReadAll")
+	require.Equal(t, `{"ttl": 60}`, string(body), "Expected the body to be served whole")
+
+	info, err := cache.PeekKey(ctx, cacheURL)
+	require.NoError(t, err, "cache.PeekKey")
+	require.True(t, info.Fresh, "Expected the overridden lifetime to win over the Expires header")
+	require.WithinDuration(t, time.Now().Add(time.Minute), info.Expires, time.Second)
+
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	require.Equal(t, 1, requester.requestCount)
+}
+
+func TestCache_RetryAfter(t *testing.T) {
+	const cacheURL = "http://example.com/"
+	const limitedURL = "http://example.com/limited"
+	const unstoredURL = "http://example.com/unstored"
+
+	now := time.Now()
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": now.Add(time.Minute).Format(time.RFC1123)},
+			},
+			limitedURL: {
+				StatusCode: http.StatusTooManyRequests,
+				Data:       []byte("Slow down"),
+				Headers:    map[string]string{"Retry-After": "120"},
+			},
+		},
+	}
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.RetryAfter = time.Hour
+	cache.Now = func() time.Time { return now }
+
+	do := func(u string) (*http.Response, DoStatus) {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, status, err := cache.DoWithStatus(req)
+		require.NoError(t, err, "cache.Do")
+		return resp, status
+	}
+
+	// without an entry, the error response is served until the origin can be retried
+	for i := 0; i < 2; i++ {
+		resp, _ := do(limitedURL)
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+	require.Equal(t, 1, requester.requestCount)
+
+	// with an expired entry, the entry is served instead
+	do(cacheURL)
+	now = now.Add(2 * time.Minute)
+	requester.data[cacheURL] = requester.data[limitedURL]
+	resp, status := do(cacheURL)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, CacheStatusStaleError, status.Cache)
+	resp, status = do(cacheURL)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, CacheStatusStaleError, status.Cache, "Expected the held entry to be served as stale")
+	require.True(t, status.Stale)
+	require.Equal(t, warningRevalidationFailed, resp.Header.Get("Warning"))
+	require.Equal(t, 3, requester.requestCount)
+
+	req, err := http.NewRequestWithContext(WithStaleError(context.Background(), true), http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.Truef(t, errors.Is(err, ErrStaleServed), "Expected ErrStaleServed, got %v", err)
+	require.Equal(t, 3, requester.requestCount)
+
+	now = now.Add(3 * time.Minute)
+	do(limitedURL)
+	require.Equal(t, 4, requester.requestCount, "Expected the origin to be retried after Retry-After")
+
+	// error responses kept out of the cache are not held
+	cache.StatusTTLs = StatusTTLs{"429": -1}
+	requester.data[unstoredURL] = requester.data[limitedURL]
+	for i := 0; i < 2; i++ {
+		resp, _ := do(unstoredURL)
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+	require.Equal(t, 6, requester.requestCount)
+}
+
+func TestCache_WithMaxStale(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	now := time.Now()
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": now.Add(time.Minute).Format(time.RFC1123)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+
+	do := func(ctx context.Context) (*http.Response, DoStatus) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, status, err := cache.DoWithStatus(req)
+		require.NoError(t, err, "cache.Do")
+		return resp, status
+	}
+
+	do(ctx)
+	now = now.Add(3 * time.Minute)
+
+	resp, status := do(WithMaxStale(ctx, 5*time.Minute))
+	require.Equal(t, CacheStatusIgnoredExpiry, status.Cache)
+	require.True(t, status.Stale)
+	require.Equal(t, warningStale, resp.Header.Get("Warning"))
+	require.Equal(t, 1, requester.requestCount)
+
+	_, status = do(WithMaxStale(ctx, time.Minute))
+	require.Equal(t, CacheStatusExpired, status.Cache, "Expected entries expired for longer to be revalidated")
+	require.Equal(t, 2, requester.requestCount)
+}
+
+func TestCache_WithMinFresh(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	now := time.Now()
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": now.Add(10 * time.Minute).Format(time.RFC1123)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Now = func() time.Time { return now }
+
+	do := func(ctx context.Context) DoStatus {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, status, err := cache.DoWithStatus(req)
+		require.NoError(t, err, "cache.Do")
+		return status
+	}
+
+	do(ctx)
+	now = now.Add(8 * time.Minute)
+
+	require.Equal(t, CacheStatusHit, do(WithMinFresh(ctx, time.Minute)).Cache)
+	require.Equal(t, 1, requester.requestCount)
+
+	require.Equal(t, CacheStatusExpired, do(WithMinFresh(ctx, 5*time.Minute)).Cache)
+	require.Equal(t, 2, requester.requestCount, "Expected entries expiring too soon to be revalidated")
+}
+
+// contextRequester records the error of the request context when the origin is reached.
+type contextRequester struct {
+	fakeRequester
+	errs []error
+}
+
+func (c *contextRequester) Do(req *http.Request) (*http.Response, error) {
+	c.errs = append(c.errs, req.Context().Err())
+	return c.fakeRequester.Do(req)
+}
+
+func TestCache_RefreshDetached(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := contextRequester{fakeRequester: fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}}
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	defer func() {
+		require.NoError(t, cache.Close())
+	}()
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	type callerKey struct{}
+	ctx := context.WithValue(context.Background(), callerKey{}, "caller")
+	ctx, cancel := context.WithCancel(WithLogFields(WithRefreshAhead(ctx, 2*time.Hour), "request_id", "abc"))
+	_, err = cache.Do(req.WithContext(ctx))
+	require.NoError(t, err, "cache.Do")
+	cancel()
+
+	require.Eventually(t, func() bool {
+		return cache.Stats().RefreshCompleted == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, requester.errs[1], "Expected the refresh not to be canceled with its call")
+	refreshCtx := requester.requestLog[1].Context()
+	require.Equal(t, []any{"request_id", "abc"}, LogFields(refreshCtx))
+	require.Equal(t, "caller", refreshCtx.Value(callerKey{}), "Expected the values of the caller to be kept")
+	_, ok := refreshCtx.Deadline()
+	require.True(t, ok, "Expected the refresh to be bounded by its own timeout")
+}
+
+func TestCache_RefreshDropOldest(t *testing.T) {
+	urls := []string{"http://example.com/1", "http://example.com/2", "http://example.com/3"}
+
+	entry := &cacheEntry{
+		StatusCode: 200,
+		Data:       []byte("Hello World"),
+		Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+	}
+	provider := memoryprovider.New()
+	primer := New(provider)
+	primer.HttpClient = &fakeRequester{data: map[string]*cacheEntry{urls[0]: entry, urls[1]: entry, urls[2]: entry}}
+
+	requester := &gatedRequester{release: make(chan struct{}), entry: entry}
+	cache := New(provider)
+	cache.HttpClient = requester
+	cache.RefreshAhead = &RefreshAhead{Window: 2 * time.Hour, Workers: 1, QueueSize: 1, DropPolicy: RefreshDropOldest}
+	defer func() {
+		require.NoError(t, cache.Close())
+	}()
+
+	do := func(c *Cache, u string) {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = c.Do(req)
+		require.NoError(t, err, "cache.Do")
+	}
+	for _, u := range urls {
+		do(primer, u)
+	}
+
+	do(cache, urls[0])
+	require.Eventually(t, func() bool {
+		return cache.Stats().RefreshInFlight == 1
+	}, time.Second, time.Millisecond)
+	do(cache, urls[0])
+	require.Equal(t, int64(1), cache.Stats().RefreshDeduped)
+
+	do(cache, urls[1])
+	do(cache, urls[2])
+	stats := cache.Stats()
+	require.Equal(t, int64(1), stats.RefreshDropped, "Expected the oldest pending refresh to be dropped")
+	require.Equal(t, int64(1), stats.RefreshQueued)
+	require.Equal(t, int64(3), stats.RefreshScheduled)
+
+	close(requester.release)
+	require.Eventually(t, func() bool {
+		return cache.Stats().RefreshCompleted == 2
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int32(2), requester.requestCount.Load())
+}
+
+func TestCache_RefreshClose(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	entry := &cacheEntry{
+		StatusCode: 200,
+		Data:       []byte("Hello World"),
+		Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+	}
+	for i := 0; i < 20; i++ {
+		cache := New(memoryprovider.New())
+		cache.HttpClient = &fakeRequester{data: map[string]*cacheEntry{cacheURL: entry}}
+		cache.RefreshAhead = &RefreshAhead{Window: 2 * time.Hour}
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		_, err = cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+
+		// hits scheduling refreshes while the cache is closed
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 10; k++ {
+					_, _ = cache.Do(req.Clone(context.Background()))
+				}
+			}()
+		}
+		require.NoError(t, cache.Close())
+		wg.Wait()
+	}
+}
+
+// closingProvider records whether it was closed.
+type closingProvider struct {
+	*memoryprovider.MemoryProvider
+	closed bool
+}
+
+func (p *closingProvider) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestCache_Shutdown(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	provider := &closingProvider{MemoryProvider: memoryprovider.New()}
+	provider.SnapshotPath = filepath.Join(t.TempDir(), "cache.snapshot")
+	cache := New(provider)
+	cache.HttpClient = &requester
+	cache.AsyncWrites = &AsyncWrites{}
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequest")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+
+	require.NoError(t, cache.Shutdown(ctx), "cache.Shutdown")
+	require.True(t, provider.closed, "Expected the provider to be closed")
+
+	restored := memoryprovider.New()
+	restored.SnapshotPath = provider.SnapshotPath
+	require.NoError(t, restored.Load(), "restored.Load")
+	_, err = New(restored).PeekKey(ctx, cacheURL)
+	require.NoError(t, err, "Expected the pending write to be flushed before the snapshot")
+
+	// a refresh stuck on the origin outlives the shutdown deadline
+	gated := &gatedRequester{release: make(chan struct{}), entry: requester.data[cacheURL]}
+	defer close(gated.release)
+	stuck := New(restored)
+	stuck.HttpClient = gated
+	_, err = stuck.Do(req.WithContext(WithRefreshAhead(ctx, 2*time.Hour)))
+	require.NoError(t, err, "cache.Do")
+	require.Eventually(t, func() bool {
+		return stuck.Stats().RefreshInFlight == 1
+	}, time.Second, time.Millisecond)
+
+	deadline, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, stuck.Shutdown(deadline), context.DeadlineExceeded)
+}
+
+func TestCache_PolicyAlias(t *testing.T) {
+	const rootURL = "http://example.com/docs/"
+	const indexURL = "http://example.com/docs/index.html"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			indexURL: {StatusCode: 200, Data: []byte("Hello World")},
+		},
+	}
+	policy, err := NewPolicy(PolicyRule{Pattern: `/index\.html$`, Alias: "/", TTL: time.Hour})
+	require.NoError(t, err, "NewPolicy")
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.Policy = policy
+
+	for _, u := range []string{indexURL, rootURL} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := cache.Do(req)
+		require.NoError(t, err, "cache.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "io.ReadAll")
+		require.Equal(t, "Hello World", string(body))
+		require.Equal(t, rootURL, cache.Key(req))
+	}
+	require.Equal(t, 1, requester.requestCount, "Expected aliases to share their entry")
+	require.Equal(t, indexURL, requester.requestLog[0].URL.String(), "Expected the origin to be requested with the original URL")
+
+	_, err = NewPolicy(PolicyRule{Host: "example.com", Alias: "/"})
+	require.Error(t, err, "Expected aliases without pattern to be rejected")
+}
+
+func TestNormalizeAcceptEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                        "",
+		"gzip, br":                "br,gzip",
+		"br,gzip":                 "br,gzip",
+		"BR;q=0.8, x-gzip;q=1.0":  "br,gzip",
+		"gzip, deflate;q=0, zstd": "gzip,zstd",
+		"identity, gzip, gzip":    "gzip",
+		"*;q=0.1, x-compress":     "*,compress",
+	} {
+		require.Equal(t, expected, normalizeAcceptEncoding([]string{header}), header)
+	}
+
+	a, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	require.NoError(t, err, "http.NewRequest")
+	a.Header.Set("Accept-Encoding", "gzip, br")
+	b := a.Clone(context.Background())
+	b.Header.Set("Accept-Encoding", "br;q=0.9,gzip")
+	vary := []string{"Accept-Encoding"}
+	require.Equal(t, variantKey("key", vary, a), variantKey("key", vary, b), "Expected equivalent headers to select the same variant")
+}
+
+// wrongTypeProvider fails to read the keys starting with "list:", as redis does for keys that aren't strings.
+type wrongTypeProvider struct {
+	*memoryprovider.MemoryProvider
+}
+
+func (p wrongTypeProvider) Get(ctx context.Context, key string) ([]byte, error) {
+	if strings.HasPrefix(key, "list:") {
+		return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return p.MemoryProvider.Get(ctx, key)
+}
+
+func TestCache_SweepForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	provider := wrongTypeProvider{MemoryProvider: memoryprovider.New()}
+	for key, value := range map[string]string{
+		"session:1": `{"user": "alice"}`,
+		"counter":   "42",
+		"list:jobs": "",
+	} {
+		require.NoError(t, provider.Set(ctx, key, []byte(value), 0))
+	}
+
+	cache := New(provider)
+	logger := fakeLogger{buf: &bytes.Buffer{}}
+	cache.LogExtractor = func(context.Context) Logger { return &logger }
+
+	deleted, err := cache.Sweep(ctx, 0)
+	require.NoError(t, err, "Expected unreadable keys to be skipped")
+	require.Equal(t, 0, deleted, "Expected values that aren't entries to be kept")
+	require.NotContains(t, logger.String(), "ERROR")
+	for _, key := range []string{"session:1", "counter"} {
+		value, err := provider.MemoryProvider.Get(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, value, key)
+	}
 }
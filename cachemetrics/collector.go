@@ -0,0 +1,179 @@
+package cachemetrics
+
+import (
+	"context"
+
+	"github.com/lsmoura/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options configures a Collector.
+type Options struct {
+	Namespace       string
+	Subsystem       string             // defaults to "cache"
+	DurationBuckets []float64          // request duration buckets in seconds, defaults to prometheus.DefBuckets
+	SizeBuckets     []float64          // entry size buckets in bytes, defaults to 256B up to 16MB
+	Stats           func() cache.Stats // source of hit ratio and background activity metrics, usually Cache.Stats
+
+	// Endpoint returns the endpoint label of the latency histogram for each call, e.g. its host or route, to compare
+	// cached and origin latencies per endpoint. It must return a small set of values. Defaults to Event.Endpoint, the
+	// Name of the policy rule matching the call.
+	Endpoint func(event cache.Event) string
+}
+
+// Collector is a prometheus.Collector fed by the cache hooks and statistics.
+type Collector struct {
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	latency        *prometheus.HistogramVec
+	endpoint       func(event cache.Event) string
+	entrySize      prometheus.Histogram
+	providerErrors *prometheus.CounterVec
+
+	stats           func() cache.Stats
+	hitRatio        *prometheus.Desc
+	refresh         *prometheus.Desc
+	refreshQueued   *prometheus.Desc
+	refreshInFlight *prometheus.Desc
+	writeQueueDepth *prometheus.Desc
+	writesDropped   *prometheus.Desc
+	writesFailed    *prometheus.Desc
+	bytesWritten    *prometheus.Desc
+	bytesStored     *prometheus.Desc
+	budgetRejected  *prometheus.Desc
+}
+
+// New returns a collector. Its hooks must be installed on the cache for request metrics to be recorded:
+//
+//	m := cachemetrics.New(cachemetrics.Options{Stats: c.Stats})
+//	c.Hooks = cache.MergeHooks(c.Hooks, m.Hooks())
+//	prometheus.MustRegister(m)
+func New(opts Options) *Collector {
+	if opts.Subsystem == "" {
+		opts.Subsystem = "cache"
+	}
+	if opts.DurationBuckets == nil {
+		opts.DurationBuckets = prometheus.DefBuckets
+	}
+	if opts.SizeBuckets == nil {
+		opts.SizeBuckets = prometheus.ExponentialBuckets(256, 4, 10)
+	}
+	name := func(name string) string {
+		return prometheus.BuildFQName(opts.Namespace, opts.Subsystem, name)
+	}
+	if opts.Endpoint == nil {
+		opts.Endpoint = func(event cache.Event) string { return event.Endpoint }
+	}
+
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: name("requests_total"),
+			Help: "Calls to Do, by cache status.",
+		}, []string{"status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    name("request_duration_seconds"),
+			Help:    "Duration of calls to Do, by cache status.",
+			Buckets: opts.DurationBuckets,
+		}, []string{"status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    name("latency_seconds"),
+			Help:    "Duration of successful calls to Do, by source of the response (cache, revalidation or origin) and endpoint.",
+			Buckets: opts.DurationBuckets,
+		}, []string{"source", "endpoint"}),
+		endpoint: opts.Endpoint,
+		entrySize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    name("entry_size_bytes"),
+			Help:    "Size of the entries written to the provider.",
+			Buckets: opts.SizeBuckets,
+		}),
+		providerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: name("provider_errors_total"),
+			Help: "Failed provider operations, by operation.",
+		}, []string{"operation"}),
+
+		stats:           opts.Stats,
+		hitRatio:        prometheus.NewDesc(name("hit_ratio"), "Ratio of cacheable calls answered from the cache.", nil, nil),
+		refresh:         prometheus.NewDesc(name("refresh_total"), "Refresh-ahead jobs, by result.", []string{"result"}, nil),
+		refreshQueued:   prometheus.NewDesc(name("refresh_queue_depth"), "Refresh-ahead jobs waiting for a worker.", nil, nil),
+		refreshInFlight: prometheus.NewDesc(name("refresh_in_flight"), "Refresh-ahead jobs running.", nil, nil),
+		writeQueueDepth: prometheus.NewDesc(name("write_queue_depth"), "Asynchronous writes waiting to be written.", nil, nil),
+		writesDropped:   prometheus.NewDesc(name("writes_dropped_total"), "Asynchronous writes dropped because the queue was full.", nil, nil),
+		writesFailed:    prometheus.NewDesc(name("writes_failed_total"), "Asynchronous writes the provider failed to store.", nil, nil),
+		bytesWritten:    prometheus.NewDesc(name("written_bytes_total"), "Bytes written to the providers.", nil, nil),
+		bytesStored:     prometheus.NewDesc(name("stored_bytes"), "Bytes stored in the providers.", nil, nil),
+		budgetRejected:  prometheus.NewDesc(name("budget_rejected_total"), "Writes skipped because the memory budget was exceeded.", nil, nil),
+	}
+}
+
+// Hooks returns the cache hooks feeding the collector.
+func (c *Collector) Hooks() cache.Hooks {
+	return cache.Hooks{
+		OnDo: func(_ context.Context, event cache.Event) {
+			status := string(event.Status)
+			switch {
+			case event.Err != nil:
+				status = "error"
+			case status == "":
+				status = "bypass"
+			}
+			c.requests.WithLabelValues(status).Inc()
+			c.duration.WithLabelValues(status).Observe(event.Duration.Seconds())
+			if event.Err == nil {
+				c.latency.WithLabelValues(string(event.Source), c.endpoint(event)).Observe(event.Duration.Seconds())
+			}
+		},
+		OnStore: func(_ context.Context, _ string, size int) {
+			c.entrySize.Observe(float64(size))
+		},
+		OnProviderError: func(_ context.Context, op string, _ error) {
+			c.providerErrors.WithLabelValues(op).Inc()
+		},
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.duration.Describe(ch)
+	c.latency.Describe(ch)
+	c.entrySize.Describe(ch)
+	c.providerErrors.Describe(ch)
+	if c.stats != nil {
+		ch <- c.hitRatio
+		ch <- c.refresh
+		ch <- c.refreshQueued
+		ch <- c.refreshInFlight
+		ch <- c.writeQueueDepth
+		ch <- c.writesDropped
+		ch <- c.writesFailed
+		ch <- c.bytesWritten
+		ch <- c.bytesStored
+		ch <- c.budgetRejected
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.duration.Collect(ch)
+	c.latency.Collect(ch)
+	c.entrySize.Collect(ch)
+	c.providerErrors.Collect(ch)
+	if c.stats == nil {
+		return
+	}
+
+	stats := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.hitRatio, prometheus.GaugeValue, stats.HitRatio())
+	ch <- prometheus.MustNewConstMetric(c.refresh, prometheus.CounterValue, float64(stats.RefreshScheduled), "scheduled")
+	ch <- prometheus.MustNewConstMetric(c.refresh, prometheus.CounterValue, float64(stats.RefreshCompleted), "completed")
+	ch <- prometheus.MustNewConstMetric(c.refresh, prometheus.CounterValue, float64(stats.RefreshFailed), "failed")
+	ch <- prometheus.MustNewConstMetric(c.refresh, prometheus.CounterValue, float64(stats.RefreshDropped), "dropped")
+	ch <- prometheus.MustNewConstMetric(c.refresh, prometheus.CounterValue, float64(stats.RefreshDeduped), "deduped")
+	ch <- prometheus.MustNewConstMetric(c.refreshQueued, prometheus.GaugeValue, float64(stats.RefreshQueued))
+	ch <- prometheus.MustNewConstMetric(c.refreshInFlight, prometheus.GaugeValue, float64(stats.RefreshInFlight))
+	ch <- prometheus.MustNewConstMetric(c.writeQueueDepth, prometheus.GaugeValue, float64(stats.WriteQueueDepth))
+	ch <- prometheus.MustNewConstMetric(c.writesDropped, prometheus.CounterValue, float64(stats.WritesDropped))
+	ch <- prometheus.MustNewConstMetric(c.writesFailed, prometheus.CounterValue, float64(stats.WritesFailed))
+	ch <- prometheus.MustNewConstMetric(c.bytesWritten, prometheus.CounterValue, float64(stats.BytesWritten))
+	ch <- prometheus.MustNewConstMetric(c.bytesStored, prometheus.GaugeValue, float64(stats.BytesStored))
+	ch <- prometheus.MustNewConstMetric(c.budgetRejected, prometheus.CounterValue, float64(stats.BudgetRejected))
+}
@@ -0,0 +1,118 @@
+package cachemetrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache"
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type staticRequester struct{}
+
+func (staticRequester) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Expires": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+		Body:       io.NopCloser(bytes.NewReader([]byte("Hello World"))),
+		Request:    req,
+	}, nil
+}
+
+func TestCollector(t *testing.T) {
+	c := cache.New(memoryprovider.New())
+	c.HttpClient = staticRequester{}
+
+	collector := New(Options{Namespace: "test", Stats: c.Stats})
+	c.Hooks = cache.MergeHooks(c.Hooks, collector.Hooks())
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(collector))
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+	}
+
+	expected := `
+# HELP test_cache_requests_total Calls to Do, by cache status.
+# TYPE test_cache_requests_total counter
+test_cache_requests_total{status="hit"} 2
+test_cache_requests_total{status="miss"} 1
+# HELP test_cache_hit_ratio Ratio of cacheable calls answered from the cache.
+# TYPE test_cache_hit_ratio gauge
+test_cache_hit_ratio 0.6666666666666666
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "test_cache_requests_total", "test_cache_hit_ratio"))
+	require.Equal(t, 1, testutil.CollectAndCount(collector, "test_cache_entry_size_bytes"))
+	require.Equal(t, 1, testutil.CollectAndCount(collector, "test_cache_stored_bytes"))
+	require.Equal(t, 2, testutil.CollectAndCount(collector, "test_cache_request_duration_seconds"))
+	require.Equal(t, 2, testutil.CollectAndCount(collector, "test_cache_latency_seconds"))
+}
+
+func TestCollector_Endpoint(t *testing.T) {
+	c := cache.New(memoryprovider.New())
+	c.HttpClient = staticRequester{}
+
+	collector := New(Options{Endpoint: func(event cache.Event) string {
+		u, _ := url.Parse(event.URL)
+		return u.Path
+	}})
+	c.Hooks = collector.Hooks()
+
+	for _, path := range []string{"/a", "/a", "/b"} {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 3, testutil.CollectAndCount(collector, "cache_latency_seconds"), "Expected a series per source and endpoint")
+}
+
+func TestCollector_PolicyEndpoint(t *testing.T) {
+	policy, err := cache.NewPolicy(cache.PolicyRule{Name: "a", Pattern: "/a$"})
+	require.NoError(t, err)
+	c := cache.New(memoryprovider.New())
+	c.HttpClient = staticRequester{}
+	c.Policy = policy
+
+	collector := New(Options{})
+	c.Hooks = collector.Hooks()
+
+	for _, path := range []string{"/a", "/a", "/b"} {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(collector))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	series := make(map[string]uint64)
+	for _, family := range families {
+		if family.GetName() != "cache_latency_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			series[labels["source"]+"/"+labels["endpoint"]] = metric.GetHistogram().GetSampleCount()
+		}
+	}
+	require.Equal(t, map[string]uint64{"origin/a": 1, "cache/a": 1, "origin/": 1}, series, "Expected the calls to be labelled with the name of their policy rule")
+}
@@ -0,0 +1,124 @@
+package cachestatsd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lsmoura/cache"
+)
+
+// Options configures an Emitter.
+type Options struct {
+	Prefix string // prefix of every metric name, defaults to "cache."
+
+	// DogStatsD attaches labels such as the cache status as DogStatsD tags. Otherwise, labels are appended to the
+	// metric name, as plain StatsD has no notion of tags.
+	DogStatsD bool
+	Tags      []string // DogStatsD tags attached to every metric, such as "env:prod"
+}
+
+// Emitter sends the cache metrics to a StatsD or DogStatsD endpoint, one metric per packet.
+type Emitter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	opts Options
+}
+
+// Dial returns an emitter sending metrics over UDP to the given address.
+func Dial(addr string, opts Options) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("net.Dial(): %w", err)
+	}
+	return New(conn, opts), nil
+}
+
+// New returns an emitter writing metrics to w.
+func New(w io.Writer, opts Options) *Emitter {
+	if opts.Prefix == "" {
+		opts.Prefix = "cache."
+	}
+	return &Emitter{w: w, opts: opts}
+}
+
+// Close closes the underlying writer, if it is an io.Closer.
+func (e *Emitter) Close() error {
+	if closer, ok := e.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Hooks returns the cache hooks feeding the emitter.
+func (e *Emitter) Hooks() cache.Hooks {
+	return cache.Hooks{
+		OnDo: func(_ context.Context, event cache.Event) {
+			status := string(event.Status)
+			switch {
+			case event.Err != nil:
+				status = "error"
+			case status == "":
+				status = "bypass"
+			}
+			e.send("requests", "1", "c", "status", status)
+			e.send("request_duration", strconv.FormatFloat(float64(event.Duration.Microseconds())/1000, 'f', -1, 64), "ms", "status", status)
+		},
+		OnStore: func(_ context.Context, _ string, size int) {
+			e.send("entry_size", strconv.Itoa(size), "h", "", "")
+		},
+		OnProviderError: func(_ context.Context, op string, _ error) {
+			e.send("provider_errors", "1", "c", "operation", op)
+		},
+	}
+}
+
+// ReportStats sends the cache statistics as gauges. Call it periodically, usually with the result of Cache.Stats.
+func (e *Emitter) ReportStats(stats cache.Stats) {
+	e.send("hit_ratio", strconv.FormatFloat(stats.HitRatio(), 'f', -1, 64), "g", "", "")
+	e.send("refresh_scheduled", strconv.FormatInt(stats.RefreshScheduled, 10), "g", "", "")
+	e.send("refresh_completed", strconv.FormatInt(stats.RefreshCompleted, 10), "g", "", "")
+	e.send("refresh_failed", strconv.FormatInt(stats.RefreshFailed, 10), "g", "", "")
+	e.send("refresh_dropped", strconv.FormatInt(stats.RefreshDropped, 10), "g", "", "")
+	e.send("refresh_deduped", strconv.FormatInt(stats.RefreshDeduped, 10), "g", "", "")
+	e.send("refresh_queued", strconv.FormatInt(stats.RefreshQueued, 10), "g", "", "")
+	e.send("refresh_in_flight", strconv.FormatInt(stats.RefreshInFlight, 10), "g", "", "")
+	e.send("write_queue_depth", strconv.FormatInt(stats.WriteQueueDepth, 10), "g", "", "")
+	e.send("writes_dropped", strconv.FormatInt(stats.WritesDropped, 10), "g", "", "")
+	e.send("writes_failed", strconv.FormatInt(stats.WritesFailed, 10), "g", "", "")
+}
+
+// send writes a single metric. label and value describe an optional dimension of the metric.
+func (e *Emitter) send(name string, value string, kind string, label string, labelValue string) {
+	var b strings.Builder
+	b.WriteString(e.opts.Prefix)
+	b.WriteString(name)
+	if label != "" && !e.opts.DogStatsD {
+		b.WriteString(".")
+		b.WriteString(labelValue)
+	}
+	b.WriteString(":")
+	b.WriteString(value)
+	b.WriteString("|")
+	b.WriteString(kind)
+
+	if e.opts.DogStatsD {
+		tags := e.opts.Tags
+		if label != "" {
+			tags = append(tags[:len(tags):len(tags)], label+":"+labelValue)
+		}
+		if len(tags) > 0 {
+			b.WriteString("|#")
+			b.WriteString(strings.Join(tags, ","))
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// metrics are best effort, there is nothing to do about a failed write
+	_, _ = io.WriteString(e.w, b.String())
+}
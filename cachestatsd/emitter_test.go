@@ -0,0 +1,57 @@
+package cachestatsd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache"
+	"github.com/stretchr/testify/require"
+)
+
+// packetRecorder records every write as a separate packet.
+type packetRecorder struct {
+	packets []string
+}
+
+func (p *packetRecorder) Write(b []byte) (int, error) {
+	p.packets = append(p.packets, string(b))
+	return len(b), nil
+}
+
+func TestEmitter(t *testing.T) {
+	ctx := context.Background()
+	event := cache.Event{Status: cache.CacheStatusHit, Duration: 1500 * time.Microsecond}
+
+	t.Run("statsd", func(t *testing.T) {
+		var rec packetRecorder
+		hooks := New(&rec, Options{}).Hooks()
+
+		hooks.OnDo(ctx, event)
+		hooks.OnStore(ctx, "key", 42)
+		hooks.OnProviderError(ctx, "get", errors.New("failure"))
+
+		require.Equal(t, []string{
+			"cache.requests.hit:1|c",
+			"cache.request_duration.hit:1.5|ms",
+			"cache.entry_size:42|h",
+			"cache.provider_errors.get:1|c",
+		}, rec.packets)
+	})
+
+	t.Run("dogstatsd", func(t *testing.T) {
+		var rec packetRecorder
+		emitter := New(&rec, Options{Prefix: "app.", DogStatsD: true, Tags: []string{"env:test"}})
+		hooks := emitter.Hooks()
+
+		hooks.OnDo(ctx, event)
+		hooks.OnStore(ctx, "key", 42)
+		emitter.ReportStats(cache.Stats{Hits: 1, Misses: 1})
+
+		require.Equal(t, "app.requests:1|c|#env:test,status:hit", rec.packets[0])
+		require.Equal(t, "app.request_duration:1.5|ms|#env:test,status:hit", rec.packets[1])
+		require.Equal(t, "app.entry_size:42|h|#env:test", rec.packets[2])
+		require.Equal(t, "app.hit_ratio:0.5|g|#env:test", rec.packets[3])
+	})
+}
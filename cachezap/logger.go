@@ -0,0 +1,38 @@
+package cachezap
+
+import (
+	"github.com/lsmoura/cache"
+	"go.uber.org/zap"
+)
+
+// Logger adapts a zap logger to the cache.Logger interface. Params are handled as loosely-typed key-value pairs.
+type Logger struct {
+	logger *zap.SugaredLogger
+}
+
+// New returns a cache.Logger writing to the given zap logger.
+func New(logger *zap.Logger) *Logger {
+	return &Logger{logger: logger.Sugar()}
+}
+
+// NewSugared returns a cache.Logger writing to the given sugared zap logger.
+func NewSugared(logger *zap.SugaredLogger) *Logger {
+	return &Logger{logger: logger}
+}
+
+func (l *Logger) Debug(msg string, params ...any) {
+	l.logger.Debugw(msg, params...)
+}
+
+func (l *Logger) Info(msg string, params ...any) {
+	l.logger.Infow(msg, params...)
+}
+
+func (l *Logger) Error(msg string, params ...any) {
+	l.logger.Errorw(msg, params...)
+}
+
+// With returns a logger including the given key-value pairs on every message.
+func (l *Logger) With(params ...any) cache.Logger {
+	return &Logger{logger: l.logger.With(params...)}
+}
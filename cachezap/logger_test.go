@@ -0,0 +1,28 @@
+package cachezap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := New(zap.New(core))
+
+	logger.With("key", "value").Info("hello world", "key2", 2)
+	logger.Error("failure")
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+
+	require.Equal(t, "hello world", entries[0].Message)
+	require.Equal(t, zapcore.InfoLevel, entries[0].Level)
+	require.Equal(t, map[string]any{"key": "value", "key2": int64(2)}, entries[0].ContextMap())
+
+	require.Equal(t, zapcore.ErrorLevel, entries[1].Level)
+	require.Empty(t, entries[1].ContextMap(), "Expected With not to modify the original logger")
+}
@@ -0,0 +1,33 @@
+package cachezerolog
+
+import (
+	"github.com/lsmoura/cache"
+	"github.com/rs/zerolog"
+)
+
+// Logger adapts a zerolog logger to the cache.Logger interface. Params are handled as key-value pairs.
+type Logger struct {
+	logger zerolog.Logger
+}
+
+// New returns a cache.Logger writing to the given zerolog logger.
+func New(logger zerolog.Logger) *Logger {
+	return &Logger{logger: logger}
+}
+
+func (l *Logger) Debug(msg string, params ...any) {
+	l.logger.Debug().Fields(params).Msg(msg)
+}
+
+func (l *Logger) Info(msg string, params ...any) {
+	l.logger.Info().Fields(params).Msg(msg)
+}
+
+func (l *Logger) Error(msg string, params ...any) {
+	l.logger.Error().Fields(params).Msg(msg)
+}
+
+// With returns a logger including the given key-value pairs on every message.
+func (l *Logger) With(params ...any) cache.Logger {
+	return &Logger{logger: l.logger.With().Fields(params).Logger()}
+}
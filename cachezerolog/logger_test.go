@@ -0,0 +1,21 @@
+package cachezerolog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(zerolog.New(&buf))
+
+	logger.With("key", "value").Info("hello world", "key2", 2)
+	require.JSONEq(t, `{"level":"info","key":"value","key2":2,"message":"hello world"}`, buf.String())
+
+	buf.Reset()
+	logger.Error("failure")
+	require.JSONEq(t, `{"level":"error","message":"failure"}`, buf.String(), "Expected With not to modify the original logger")
+}
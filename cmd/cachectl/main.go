@@ -0,0 +1,223 @@
+// Command cachectl inspects and manipulates the entries stored by a cache provider.
+//
+// Usage:
+//
+//	cachectl [flags] keys [prefix]         lists keys
+//	cachectl [flags] show <key>            shows a decoded entry
+//	cachectl [flags] delete <key>...       deletes keys
+//	cachectl [flags] purge <prefix>        deletes every key starting with prefix
+//	cachectl [flags] export [file]         writes every entry to file, or stdout
+//	cachectl [flags] import [file]         reads entries from file, or stdin
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis"
+	"github.com/lsmoura/cache"
+	"github.com/lsmoura/cache/redisprovider"
+)
+
+type provider interface {
+	cache.Provider
+	cache.Scanner
+	cache.Deleter
+}
+
+func main() {
+	flags := flag.NewFlagSet("cachectl", flag.ExitOnError)
+	addr := flags.String("redis-addr", envOr("CACHECTL_REDIS_ADDR", "localhost:6379"), "redis address")
+	password := flags.String("redis-password", os.Getenv("CACHECTL_REDIS_PASSWORD"), "redis password")
+	db := flags.Int("redis-db", 0, "redis database")
+	preview := flags.Int("preview", 512, "number of body bytes shown by show")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cachectl [flags] keys|show|delete|purge|export|import [args]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+
+	p, err := redisprovider.New(&redis.Options{Addr: *addr, Password: *password, DB: *db})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cachectl:", err)
+		os.Exit(1)
+	}
+
+	cmd := command{provider: p, stdin: os.Stdin, stdout: os.Stdout, preview: *preview}
+	if err := cmd.run(context.Background(), flags.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "cachectl:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(name string, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+type command struct {
+	provider provider
+	stdin    io.Reader
+	stdout   io.Writer
+	preview  int
+}
+
+func (c command) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing command")
+	}
+
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "keys":
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+		return c.keys(ctx, prefix)
+	case "show":
+		if len(args) != 1 {
+			return errors.New("usage: show <key>")
+		}
+		return c.show(ctx, args[0])
+	case "delete":
+		if len(args) == 0 {
+			return errors.New("usage: delete <key>...")
+		}
+		return c.delete(ctx, args)
+	case "purge":
+		if len(args) != 1 || args[0] == "" {
+			return errors.New("usage: purge <prefix>")
+		}
+		return c.purge(ctx, args[0])
+	case "export":
+		return c.export(ctx, args)
+	case "import":
+		return c.importDump(ctx, args)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func (c command) keys(ctx context.Context, prefix string) error {
+	var keys []string
+	if err := c.provider.Scan(ctx, prefix, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintln(c.stdout, key)
+	}
+	return nil
+}
+
+func (c command) show(ctx context.Context, key string) error {
+	info, err := cache.New(c.provider).PeekKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(c.stdout)
+	fmt.Fprintf(w, "Key:       %s\n", info.Key)
+	fmt.Fprintf(w, "Status:    %d\n", info.StatusCode)
+	fmt.Fprintf(w, "Stored at: %s (%s ago)\n", info.StoredAt.Format(time.RFC3339), time.Since(info.StoredAt).Round(time.Second))
+	if info.Expires.IsZero() {
+		fmt.Fprintln(w, "Expires:   unknown")
+	} else if info.Fresh {
+		fmt.Fprintf(w, "Expires:   %s (fresh for %s)\n", info.Expires.Format(time.RFC3339), time.Until(info.Expires).Round(time.Second))
+	} else {
+		fmt.Fprintf(w, "Expires:   %s (stale for %s)\n", info.Expires.Format(time.RFC3339), time.Since(info.Expires).Round(time.Second))
+	}
+	fmt.Fprintf(w, "Size:      %d bytes\n", info.Size)
+
+	fmt.Fprintln(w, "\nHeaders:")
+	names := make([]string, 0, len(info.Headers))
+	for name := range info.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s: %s\n", name, info.Headers[name])
+	}
+
+	fmt.Fprintln(w, "\nBody:")
+	body := info.Body
+	truncated := c.preview >= 0 && len(body) > c.preview
+	if truncated {
+		body = body[:c.preview]
+	}
+	if utf8.Valid(body) {
+		fmt.Fprintln(w, string(body))
+	} else {
+		fmt.Fprintf(w, "%q\n", body)
+	}
+	if truncated {
+		fmt.Fprintf(w, "... (%d more bytes)\n", len(info.Body)-len(body))
+	}
+
+	return w.Flush()
+}
+
+func (c command) delete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := c.provider.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c command) purge(ctx context.Context, prefix string) error {
+	deleted, err := cache.New(c.provider).Purge(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "deleted %d keys\n", deleted)
+	return nil
+}
+
+func (c command) export(ctx context.Context, args []string) error {
+	w := c.stdout
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, err := cache.New(c.provider).Export(ctx, w)
+	return err
+}
+
+func (c command) importDump(ctx context.Context, args []string) error {
+	r := c.stdin
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	imported, err := cache.New(c.provider).Import(ctx, r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "imported %d keys\n", imported)
+	return nil
+}
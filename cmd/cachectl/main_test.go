@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache"
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+)
+
+type staticRequester struct{}
+
+func (staticRequester) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Expires":      []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+			"Content-Type": []string{"text/plain"},
+		},
+		Body:    io.NopCloser(strings.NewReader("Hello World")),
+		Request: req,
+	}, nil
+}
+
+func TestCommand(t *testing.T) {
+	ctx := context.Background()
+	p := memoryprovider.New()
+
+	c := cache.New(p)
+	c.HttpClient = staticRequester{}
+	for _, u := range []string{"http://example.com/a", "http://example.com/b", "http://example.org/"} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := command{provider: p, stdout: &stdout, preview: 5}
+
+	require.NoError(t, cmd.run(ctx, []string{"keys", "http://example.com/"}))
+	require.Equal(t, "http://example.com/a\nhttp://example.com/b\n", stdout.String())
+
+	stdout.Reset()
+	require.NoError(t, cmd.run(ctx, []string{"show", "http://example.com/a"}))
+	require.Contains(t, stdout.String(), "Status:    200")
+	require.Contains(t, stdout.String(), "Content-Type: text/plain")
+	require.Contains(t, stdout.String(), "Hello\n... (6 more bytes)")
+
+	stdout.Reset()
+	require.NoError(t, cmd.run(ctx, []string{"export"}))
+	dump := stdout.String()
+	require.Equal(t, 3, strings.Count(dump, "\n"))
+
+	require.NoError(t, cmd.run(ctx, []string{"delete", "http://example.org/"}))
+	stdout.Reset()
+	require.NoError(t, cmd.run(ctx, []string{"purge", "http://example.com/"}))
+	require.Equal(t, "deleted 2 keys\n", stdout.String())
+
+	stdout.Reset()
+	require.NoError(t, cmd.run(ctx, []string{"keys"}))
+	require.Empty(t, stdout.String())
+
+	stdout.Reset()
+	cmd.stdin = strings.NewReader(dump)
+	require.NoError(t, cmd.run(ctx, []string{"import"}))
+	require.Equal(t, "imported 3 keys\n", stdout.String())
+
+	stdout.Reset()
+	require.NoError(t, cmd.run(ctx, []string{"keys"}))
+	require.Equal(t, 3, strings.Count(stdout.String(), "\n"))
+}
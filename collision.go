@@ -0,0 +1,25 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// checkCollision logs an error when entry, read under key for req, was stored for another URL: the key generator maps
+// both URLs to the same key, and each is served the response of the other. Entries stored without their URL are not
+// checked.
+func (r Cache) checkCollision(ctx context.Context, key string, req *http.Request, entry *cacheEntry) {
+	if !r.KeyCollisionCheck || entry == nil || entry.URL == "" {
+		return
+	}
+	stored, err := url.Parse(entry.URL)
+	if err != nil {
+		return
+	}
+	stored = r.Policy.match(stored).alias(stored)
+	if CanonicalURL(stored, CanonicalOptions{}) == CanonicalURL(r.resourceURL(req), CanonicalOptions{}) {
+		return
+	}
+	r.logError(ctx, "cache key collision", "key", key, "stored-url", entry.URL, "requested-url", r.logURL(req))
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// EntryCompression configures the compression of entry bodies before they are written to the provider, trading CPU
+// for provider memory and bandwidth. The codec is recorded in every entry, so entries are read back whatever the
+// configuration of the reading process. Bodies already carrying a Content-Encoding, or larger than 64 MiB, are stored
+// as they are.
+type EntryCompression struct {
+	Threshold int    // minimum size of the bodies compressed, in bytes, defaults to 1 KiB
+	Codec     string // "zstd" (default) or "gzip", gzip is used when the codec is unknown or fails
+}
+
+const defaultCompressionThreshold = 1024
+
+// entryCodecs compress entry bodies, by codec name. Compressed bodies are decoded by decodeContent.
+var entryCodecs = map[string]func(data []byte) ([]byte, error){
+	"zstd": compressZstd,
+	"gzip": compressGzip,
+}
+
+// zstdEncoder is shared by every cache, EncodeAll being safe for concurrent use.
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+})
+
+func compressZstd(data []byte) ([]byte, error) {
+	enc, err := zstdEncoder()
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(data, nil), nil
+}
+
+func compressGzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type entryCompressor struct {
+	compressed atomic.Int64
+	saved      atomic.Int64
+}
+
+func newEntryCompressor() *entryCompressor {
+	return &entryCompressor{}
+}
+
+// compressEntry returns a copy of entry with its body compressed when EntryCompression is set and the body is large
+// enough, or entry itself. Bodies that don't shrink are stored as they are.
+func (r Cache) compressEntry(ctx context.Context, key string, entry *cacheEntry) *cacheEntry {
+	if r.EntryCompression == nil || entry.isIndex() || entry.header("Content-Encoding") != "" {
+		return entry
+	}
+	cfg := *r.EntryCompression
+	threshold := cfg.Threshold
+	if threshold <= 0 {
+		threshold = defaultCompressionThreshold
+	}
+	if len(entry.Data) < threshold || len(entry.Data) > defaultMaxDecodedSize {
+		return entry
+	}
+
+	codec := cfg.Codec
+	if _, ok := entryCodecs[codec]; !ok {
+		codec = "zstd"
+	}
+	data, err := entryCodecs[codec](entry.Data)
+	if err != nil && codec != "gzip" {
+		r.logError(ctx, "error compressing entry, falling back to gzip", "key", key, "codec", codec, "error", err)
+		codec = "gzip"
+		data, err = compressGzip(entry.Data)
+	}
+	if err != nil {
+		r.logError(ctx, "error compressing entry", "key", key, "codec", codec, "error", err)
+		return entry
+	}
+	if len(data) >= len(entry.Data) {
+		return entry
+	}
+
+	if r.compressor != nil {
+		r.compressor.compressed.Add(1)
+		r.compressor.saved.Add(int64(len(entry.Data) - len(data)))
+	}
+	compressed := *entry
+	compressed.Data = data
+	compressed.Codec = codec
+	return &compressed
+}
+
+// decompressEntry decodes the body of an entry read from the provider, if it was compressed. Bodies decoding to more
+// than defaultMaxDecodedSize, which compressEntry never compresses, are rejected.
+func decompressEntry(entry *cacheEntry) error {
+	if entry.Codec == "" {
+		return nil
+	}
+	data, err := decodeContent(entry.Codec, entry.Data, defaultMaxDecodedSize)
+	if err != nil {
+		return err
+	}
+	entry.Data = data
+	entry.Codec = ""
+	return nil
+}
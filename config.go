@@ -0,0 +1,640 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the cache options that can be loaded from a file or the environment, see LoadConfig and
+// Config.LoadEnv. Zero values keep the defaults of the cache. Options holding code, such as the HTTP client, hooks or
+// routes, are only set on the Cache.
+type Config struct {
+	DisableCoalescing   bool                `json:"disable_coalescing" yaml:"disable_coalescing"`
+	DedupWindow         Duration            `json:"dedup_window" yaml:"dedup_window"`
+	StampedeLockTTL     Duration            `json:"stampede_lock_ttl" yaml:"stampede_lock_ttl"`
+	StampedeWait        Duration            `json:"stampede_wait" yaml:"stampede_wait"`
+	EarlyExpirationBeta float64             `json:"early_expiration_beta" yaml:"early_expiration_beta"`
+	SlidingExpiration   Duration            `json:"sliding_expiration" yaml:"sliding_expiration"`
+	TTLJitter           Duration            `json:"ttl_jitter" yaml:"ttl_jitter"`
+	StaleIfError        Duration            `json:"stale_if_error" yaml:"stale_if_error"`
+	RetryAfter          Duration            `json:"retry_after" yaml:"retry_after"` // maximum Retry-After honored
+	Offline             bool                `json:"offline" yaml:"offline"`
+	RevalidationBudget  Duration            `json:"revalidation_budget" yaml:"revalidation_budget"`
+	MinRefreshInterval  Duration            `json:"min_refresh_interval" yaml:"min_refresh_interval"`
+	RefreshWindow       Duration            `json:"refresh_window" yaml:"refresh_window"`             // enables RefreshAhead
+	RefreshWorkers      int                 `json:"refresh_workers" yaml:"refresh_workers"`           // maximum concurrent refreshes
+	RefreshQueueSize    int                 `json:"refresh_queue_size" yaml:"refresh_queue_size"`     // maximum pending refreshes
+	RefreshDropPolicy   string              `json:"refresh_drop_policy" yaml:"refresh_drop_policy"`   // "newest" (default) or "oldest"
+	ReadFailurePolicy   string              `json:"read_failure_policy" yaml:"read_failure_policy"`   // "lenient" (default) or "strict"
+	WriteFailurePolicy  string              `json:"write_failure_policy" yaml:"write_failure_policy"` // "lenient" (default) or "strict"
+	BypassHeader        string              `json:"bypass_header" yaml:"bypass_header"`               // defaults to X-Cache-Bypass
+	BypassSecret        string              `json:"bypass_secret" yaml:"bypass_secret"`               // enables BypassHeader
+	KeyHash             string              `json:"key_hash" yaml:"key_hash"`                         // "none" (default), "sha256-hex" or "sha256-base64"
+	Namespace           string              `json:"namespace" yaml:"namespace"`                       // prefix of every key, e.g. "cache:"
+	StatusTTLs          map[string]Duration `json:"status_ttls" yaml:"status_ttls"`                   // e.g. {"404": "1m", "5xx": "-1s"}, see StatusTTLs
+	Rules               []RuleConfig        `json:"rules" yaml:"rules"`                               // replace the rules of the policy, if any
+
+	RefreshMinHits  int      `json:"refresh_min_hits" yaml:"refresh_min_hits"`
+	RefreshInterval Duration `json:"refresh_interval" yaml:"refresh_interval"`
+	RefreshTimeout  Duration `json:"refresh_timeout" yaml:"refresh_timeout"`
+
+	SensitiveParams      []string `json:"sensitive_params" yaml:"sensitive_params"`
+	ScopeByAuthorization bool     `json:"scope_by_authorization" yaml:"scope_by_authorization"`
+	KeySecret            string   `json:"key_secret" yaml:"key_secret"`
+
+	ReverseIndex      bool     `json:"reverse_index" yaml:"reverse_index"`
+	TrackAccess       bool     `json:"track_access" yaml:"track_access"`
+	MemoryBudget      int64    `json:"memory_budget" yaml:"memory_budget"` // in bytes
+	StreamIdleTimeout Duration `json:"stream_idle_timeout" yaml:"stream_idle_timeout"`
+
+	HostMaxConcurrent int      `json:"host_max_concurrent" yaml:"host_max_concurrent"` // enables HostLimit
+	HostMaxWait       Duration `json:"host_max_wait" yaml:"host_max_wait"`             // negative to shed excess requests
+
+	RetryAttempts    int      `json:"retry_attempts" yaml:"retry_attempts"` // enables Retry
+	RetryBaseDelay   Duration `json:"retry_base_delay" yaml:"retry_base_delay"`
+	RetryMaxDelay    Duration `json:"retry_max_delay" yaml:"retry_max_delay"`
+	RetryStatusCodes []int    `json:"retry_status_codes" yaml:"retry_status_codes"`
+
+	AsyncWrites         bool `json:"async_writes" yaml:"async_writes"`
+	AsyncWriteWorkers   int  `json:"async_write_workers" yaml:"async_write_workers"`
+	AsyncWriteQueueSize int  `json:"async_write_queue_size" yaml:"async_write_queue_size"`
+
+	WriteBatching      bool     `json:"write_batching" yaml:"write_batching"`
+	WriteBatchSize     int      `json:"write_batch_size" yaml:"write_batch_size"`
+	WriteBatchInterval Duration `json:"write_batch_interval" yaml:"write_batch_interval"`
+
+	WriteRetryAttempts  int      `json:"write_retry_attempts" yaml:"write_retry_attempts"` // enables WriteRetry
+	WriteRetryBaseDelay Duration `json:"write_retry_base_delay" yaml:"write_retry_base_delay"`
+	WriteRetryMaxDelay  Duration `json:"write_retry_max_delay" yaml:"write_retry_max_delay"`
+
+	EntryCompression     bool   `json:"entry_compression" yaml:"entry_compression"`
+	CompressionThreshold int    `json:"compression_threshold" yaml:"compression_threshold"` // in bytes
+	CompressionCodec     string `json:"compression_codec" yaml:"compression_codec"`         // "zstd" (default) or "gzip"
+
+	Sweeper       bool     `json:"sweeper" yaml:"sweeper"`
+	SweepInterval Duration `json:"sweep_interval" yaml:"sweep_interval"`
+	SweepGrace    Duration `json:"sweep_grace" yaml:"sweep_grace"`
+
+	KeyCollisionCheck      bool     `json:"key_collision_check" yaml:"key_collision_check"`
+	DebugHeaders           bool     `json:"debug_headers" yaml:"debug_headers"`
+	DecompressCached       bool     `json:"decompress_cached" yaml:"decompress_cached"`
+	NotModified            string   `json:"not_modified" yaml:"not_modified"`                         // "entry" (default) or "empty"
+	UnusableNotModified    string   `json:"unusable_not_modified" yaml:"unusable_not_modified"`       // "retry" (default) or "error"
+	RequestMetadataHeaders []string `json:"request_metadata_headers" yaml:"request_metadata_headers"` // enables RequestMetadata
+}
+
+// RuleConfig is the configuration of a PolicyRule.
+type RuleConfig struct {
+	Name        string   `json:"name" yaml:"name"`
+	Host        string   `json:"host" yaml:"host"`
+	Pattern     string   `json:"pattern" yaml:"pattern"`
+	Bypass      bool     `json:"bypass" yaml:"bypass"`
+	OnlyCached  bool     `json:"only_cached" yaml:"only_cached"`
+	TTL         Duration `json:"ttl" yaml:"ttl"`
+	NegativeTTL Duration `json:"negative_ttl" yaml:"negative_ttl"`
+	MaxBodySize int64    `json:"max_body_size" yaml:"max_body_size"`
+	Sliding     Duration `json:"sliding" yaml:"sliding"`
+
+	MaxEntriesPerHost int `json:"max_entries_per_host" yaml:"max_entries_per_host"`
+
+	VaryCookies   []string `json:"vary_cookies" yaml:"vary_cookies"`
+	BypassCookies []string `json:"bypass_cookies" yaml:"bypass_cookies"`
+
+	Alias string `json:"alias" yaml:"alias"`
+}
+
+// Duration is a time.Duration read from strings such as "1m30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// LoadConfig reads a configuration file, in YAML or JSON depending on its extension.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	default:
+		return nil, fmt.Errorf("unsupported configuration format %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// LoadEnv overrides the configuration with the environment variables named after the options, in upper case, with the
+// given prefix, e.g. CACHE_TTL_JITTER=5s for the "CACHE_" prefix. Lists are comma separated, status TTLs and rules
+// are read as JSON, e.g. <prefix>RULES as a JSON array.
+func (c *Config) LoadEnv(prefix string) error {
+	for _, name := range []string{
+		"DISABLE_COALESCING", "DEDUP_WINDOW", "STAMPEDE_LOCK_TTL", "STAMPEDE_WAIT", "EARLY_EXPIRATION_BETA", "SLIDING_EXPIRATION",
+		"TTL_JITTER", "STALE_IF_ERROR", "RETRY_AFTER", "OFFLINE", "REVALIDATION_BUDGET", "MIN_REFRESH_INTERVAL",
+		"REFRESH_WINDOW", "REFRESH_WORKERS", "REFRESH_QUEUE_SIZE", "REFRESH_DROP_POLICY",
+		"READ_FAILURE_POLICY", "WRITE_FAILURE_POLICY", "BYPASS_HEADER", "BYPASS_SECRET", "KEY_HASH", "NAMESPACE", "STATUS_TTLS",
+		"RULES", "REFRESH_MIN_HITS", "REFRESH_INTERVAL", "REFRESH_TIMEOUT",
+		"SENSITIVE_PARAMS", "SCOPE_BY_AUTHORIZATION", "KEY_SECRET",
+		"REVERSE_INDEX", "TRACK_ACCESS", "MEMORY_BUDGET", "STREAM_IDLE_TIMEOUT", "HOST_MAX_CONCURRENT", "HOST_MAX_WAIT",
+		"RETRY_ATTEMPTS", "RETRY_BASE_DELAY", "RETRY_MAX_DELAY", "RETRY_STATUS_CODES",
+		"ASYNC_WRITES", "ASYNC_WRITE_WORKERS", "ASYNC_WRITE_QUEUE_SIZE", "WRITE_BATCHING", "WRITE_BATCH_SIZE",
+		"WRITE_BATCH_INTERVAL", "WRITE_RETRY_ATTEMPTS", "WRITE_RETRY_BASE_DELAY", "WRITE_RETRY_MAX_DELAY",
+		"ENTRY_COMPRESSION", "COMPRESSION_THRESHOLD", "COMPRESSION_CODEC", "SWEEPER", "SWEEP_INTERVAL", "SWEEP_GRACE",
+		"KEY_COLLISION_CHECK", "DEBUG_HEADERS", "DECOMPRESS_CACHED", "NOT_MODIFIED", "UNUSABLE_NOT_MODIFIED",
+		"REQUEST_METADATA_HEADERS",
+	} {
+		value, ok := os.LookupEnv(prefix + name)
+		if !ok {
+			continue
+		}
+		if err := c.set(name, value); err != nil {
+			return fmt.Errorf("%s%s: %w", prefix, name, err)
+		}
+	}
+	return c.Validate()
+}
+
+func (c *Config) set(name string, value string) error {
+	var err error
+	switch name {
+	case "DISABLE_COALESCING":
+		c.DisableCoalescing, err = strconv.ParseBool(value)
+	case "DEDUP_WINDOW":
+		err = c.DedupWindow.UnmarshalText([]byte(value))
+	case "STAMPEDE_LOCK_TTL":
+		err = c.StampedeLockTTL.UnmarshalText([]byte(value))
+	case "STAMPEDE_WAIT":
+		err = c.StampedeWait.UnmarshalText([]byte(value))
+	case "EARLY_EXPIRATION_BETA":
+		c.EarlyExpirationBeta, err = strconv.ParseFloat(value, 64)
+	case "SLIDING_EXPIRATION":
+		err = c.SlidingExpiration.UnmarshalText([]byte(value))
+	case "TTL_JITTER":
+		err = c.TTLJitter.UnmarshalText([]byte(value))
+	case "STALE_IF_ERROR":
+		err = c.StaleIfError.UnmarshalText([]byte(value))
+	case "RETRY_AFTER":
+		err = c.RetryAfter.UnmarshalText([]byte(value))
+	case "OFFLINE":
+		c.Offline, err = strconv.ParseBool(value)
+	case "REVALIDATION_BUDGET":
+		err = c.RevalidationBudget.UnmarshalText([]byte(value))
+	case "MIN_REFRESH_INTERVAL":
+		err = c.MinRefreshInterval.UnmarshalText([]byte(value))
+	case "REFRESH_WINDOW":
+		err = c.RefreshWindow.UnmarshalText([]byte(value))
+	case "REFRESH_WORKERS":
+		c.RefreshWorkers, err = strconv.Atoi(value)
+	case "REFRESH_QUEUE_SIZE":
+		c.RefreshQueueSize, err = strconv.Atoi(value)
+	case "REFRESH_DROP_POLICY":
+		c.RefreshDropPolicy = value
+	case "READ_FAILURE_POLICY":
+		c.ReadFailurePolicy = value
+	case "WRITE_FAILURE_POLICY":
+		c.WriteFailurePolicy = value
+	case "BYPASS_HEADER":
+		c.BypassHeader = value
+	case "BYPASS_SECRET":
+		c.BypassSecret = value
+	case "KEY_HASH":
+		c.KeyHash = value
+	case "NAMESPACE":
+		c.Namespace = value
+	case "STATUS_TTLS":
+		c.StatusTTLs = nil
+		err = json.Unmarshal([]byte(value), &c.StatusTTLs)
+	case "RULES":
+		c.Rules = nil
+		err = json.Unmarshal([]byte(value), &c.Rules)
+	case "REFRESH_MIN_HITS":
+		c.RefreshMinHits, err = strconv.Atoi(value)
+	case "REFRESH_INTERVAL":
+		err = c.RefreshInterval.UnmarshalText([]byte(value))
+	case "REFRESH_TIMEOUT":
+		err = c.RefreshTimeout.UnmarshalText([]byte(value))
+	case "SENSITIVE_PARAMS":
+		c.SensitiveParams = splitList(value)
+	case "SCOPE_BY_AUTHORIZATION":
+		c.ScopeByAuthorization, err = strconv.ParseBool(value)
+	case "KEY_SECRET":
+		c.KeySecret = value
+	case "REVERSE_INDEX":
+		c.ReverseIndex, err = strconv.ParseBool(value)
+	case "TRACK_ACCESS":
+		c.TrackAccess, err = strconv.ParseBool(value)
+	case "MEMORY_BUDGET":
+		c.MemoryBudget, err = strconv.ParseInt(value, 10, 64)
+	case "STREAM_IDLE_TIMEOUT":
+		err = c.StreamIdleTimeout.UnmarshalText([]byte(value))
+	case "HOST_MAX_CONCURRENT":
+		c.HostMaxConcurrent, err = strconv.Atoi(value)
+	case "HOST_MAX_WAIT":
+		err = c.HostMaxWait.UnmarshalText([]byte(value))
+	case "RETRY_ATTEMPTS":
+		c.RetryAttempts, err = strconv.Atoi(value)
+	case "RETRY_BASE_DELAY":
+		err = c.RetryBaseDelay.UnmarshalText([]byte(value))
+	case "RETRY_MAX_DELAY":
+		err = c.RetryMaxDelay.UnmarshalText([]byte(value))
+	case "RETRY_STATUS_CODES":
+		c.RetryStatusCodes = nil
+		for _, code := range splitList(value) {
+			status, err := strconv.Atoi(code)
+			if err != nil {
+				return err
+			}
+			c.RetryStatusCodes = append(c.RetryStatusCodes, status)
+		}
+	case "ASYNC_WRITES":
+		c.AsyncWrites, err = strconv.ParseBool(value)
+	case "ASYNC_WRITE_WORKERS":
+		c.AsyncWriteWorkers, err = strconv.Atoi(value)
+	case "ASYNC_WRITE_QUEUE_SIZE":
+		c.AsyncWriteQueueSize, err = strconv.Atoi(value)
+	case "WRITE_BATCHING":
+		c.WriteBatching, err = strconv.ParseBool(value)
+	case "WRITE_BATCH_SIZE":
+		c.WriteBatchSize, err = strconv.Atoi(value)
+	case "WRITE_BATCH_INTERVAL":
+		err = c.WriteBatchInterval.UnmarshalText([]byte(value))
+	case "WRITE_RETRY_ATTEMPTS":
+		c.WriteRetryAttempts, err = strconv.Atoi(value)
+	case "WRITE_RETRY_BASE_DELAY":
+		err = c.WriteRetryBaseDelay.UnmarshalText([]byte(value))
+	case "WRITE_RETRY_MAX_DELAY":
+		err = c.WriteRetryMaxDelay.UnmarshalText([]byte(value))
+	case "ENTRY_COMPRESSION":
+		c.EntryCompression, err = strconv.ParseBool(value)
+	case "COMPRESSION_THRESHOLD":
+		c.CompressionThreshold, err = strconv.Atoi(value)
+	case "COMPRESSION_CODEC":
+		c.CompressionCodec = value
+	case "SWEEPER":
+		c.Sweeper, err = strconv.ParseBool(value)
+	case "SWEEP_INTERVAL":
+		err = c.SweepInterval.UnmarshalText([]byte(value))
+	case "SWEEP_GRACE":
+		err = c.SweepGrace.UnmarshalText([]byte(value))
+	case "KEY_COLLISION_CHECK":
+		c.KeyCollisionCheck, err = strconv.ParseBool(value)
+	case "DEBUG_HEADERS":
+		c.DebugHeaders, err = strconv.ParseBool(value)
+	case "DECOMPRESS_CACHED":
+		c.DecompressCached, err = strconv.ParseBool(value)
+	case "NOT_MODIFIED":
+		c.NotModified = value
+	case "UNUSABLE_NOT_MODIFIED":
+		c.UnusableNotModified = value
+	case "REQUEST_METADATA_HEADERS":
+		c.RequestMetadataHeaders = splitList(value)
+	}
+	return err
+}
+
+// splitList splits a comma separated list, ignoring empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate checks the configuration for invalid values.
+func (c *Config) Validate() error {
+	for name, d := range map[string]Duration{
+		"dedup_window":         c.DedupWindow,
+		"stampede_lock_ttl":    c.StampedeLockTTL,
+		"stampede_wait":        c.StampedeWait,
+		"sliding_expiration":   c.SlidingExpiration,
+		"ttl_jitter":           c.TTLJitter,
+		"stale_if_error":       c.StaleIfError,
+		"retry_after":          c.RetryAfter,
+		"revalidation_budget":  c.RevalidationBudget,
+		"min_refresh_interval": c.MinRefreshInterval,
+		"refresh_window":       c.RefreshWindow,
+		"refresh_interval":     c.RefreshInterval,
+		"refresh_timeout":      c.RefreshTimeout,
+		"stream_idle_timeout":  c.StreamIdleTimeout,
+		"retry_base_delay":     c.RetryBaseDelay,
+		"retry_max_delay":      c.RetryMaxDelay,
+		"write_batch_interval": c.WriteBatchInterval,
+
+		"write_retry_base_delay": c.WriteRetryBaseDelay,
+		"write_retry_max_delay":  c.WriteRetryMaxDelay,
+		"sweep_interval":         c.SweepInterval,
+		"sweep_grace":            c.SweepGrace,
+	} {
+		if d < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if c.EarlyExpirationBeta < 0 {
+		return fmt.Errorf("early_expiration_beta must not be negative")
+	}
+	if c.RefreshWorkers < 0 || c.RefreshQueueSize < 0 {
+		return fmt.Errorf("refresh_workers and refresh_queue_size must not be negative")
+	}
+	for name, n := range map[string]int64{
+		"refresh_min_hits":       int64(c.RefreshMinHits),
+		"memory_budget":          c.MemoryBudget,
+		"host_max_concurrent":    int64(c.HostMaxConcurrent),
+		"retry_attempts":         int64(c.RetryAttempts),
+		"async_write_workers":    int64(c.AsyncWriteWorkers),
+		"async_write_queue_size": int64(c.AsyncWriteQueueSize),
+		"write_batch_size":       int64(c.WriteBatchSize),
+		"write_retry_attempts":   int64(c.WriteRetryAttempts),
+		"compression_threshold":  int64(c.CompressionThreshold),
+	} {
+		if n < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	for _, status := range c.RetryStatusCodes {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid retry_status_codes status %d", status)
+		}
+	}
+	if _, ok := entryCodecs[c.CompressionCodec]; c.CompressionCodec != "" && !ok {
+		return fmt.Errorf("unknown compression_codec %q", c.CompressionCodec)
+	}
+	if _, err := c.refreshDropPolicy(); err != nil {
+		return err
+	}
+	if _, err := failurePolicy("read_failure_policy", c.ReadFailurePolicy); err != nil {
+		return err
+	}
+	if _, err := failurePolicy("write_failure_policy", c.WriteFailurePolicy); err != nil {
+		return err
+	}
+	if _, err := c.keyHash(); err != nil {
+		return err
+	}
+	if _, err := c.notModified(); err != nil {
+		return err
+	}
+	if _, err := c.unusableNotModified(); err != nil {
+		return err
+	}
+	if err := c.statusTTLs().Validate(); err != nil {
+		return fmt.Errorf("status_ttls: %w", err)
+	}
+	if _, err := c.policy(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Config) statusTTLs() StatusTTLs {
+	if len(c.StatusTTLs) == 0 {
+		return nil
+	}
+	ttls := make(StatusTTLs, len(c.StatusTTLs))
+	for status, ttl := range c.StatusTTLs {
+		ttls[status] = time.Duration(ttl)
+	}
+	return ttls
+}
+
+// failurePolicy parses the value of the failure policy option called name.
+func failurePolicy(name string, value string) (FailurePolicy, error) {
+	switch value {
+	case "", "lenient":
+		return FailureLenient, nil
+	case "strict":
+		return FailureStrict, nil
+	}
+	return 0, fmt.Errorf("unknown %s %q", name, value)
+}
+
+func (c *Config) refreshDropPolicy() (RefreshDropPolicy, error) {
+	switch c.RefreshDropPolicy {
+	case "", "newest":
+		return RefreshDropNewest, nil
+	case "oldest":
+		return RefreshDropOldest, nil
+	}
+	return 0, fmt.Errorf("unknown refresh_drop_policy %q", c.RefreshDropPolicy)
+}
+
+func (c *Config) keyHash() (KeyHash, error) {
+	switch c.KeyHash {
+	case "", "none":
+		return KeyHashNone, nil
+	case "sha256-hex":
+		return KeyHashSHA256Hex, nil
+	case "sha256-base64":
+		return KeyHashSHA256Base64, nil
+	}
+	return 0, fmt.Errorf("unknown key_hash %q", c.KeyHash)
+}
+
+func (c *Config) notModified() (NotModifiedMode, error) {
+	switch c.NotModified {
+	case "", "entry":
+		return NotModifiedAsEntry, nil
+	case "empty":
+		return NotModifiedEmpty, nil
+	}
+	return 0, fmt.Errorf("unknown not_modified %q", c.NotModified)
+}
+
+func (c *Config) unusableNotModified() (UnusableNotModifiedMode, error) {
+	switch c.UnusableNotModified {
+	case "", "retry":
+		return UnusableNotModifiedRetry, nil
+	case "error":
+		return UnusableNotModifiedError, nil
+	}
+	return 0, fmt.Errorf("unknown unusable_not_modified %q", c.UnusableNotModified)
+}
+
+// policy returns the policy built from the rules, or nil if there are none.
+func (c *Config) policy() (*Policy, error) {
+	if len(c.Rules) == 0 {
+		return nil, nil
+	}
+	return NewPolicy(c.policyRules()...)
+}
+
+func (c *Config) policyRules() []PolicyRule {
+	rules := make([]PolicyRule, len(c.Rules))
+	for i, rule := range c.Rules {
+		rules[i] = PolicyRule{
+			Name:        rule.Name,
+			Host:        rule.Host,
+			Pattern:     rule.Pattern,
+			Bypass:      rule.Bypass,
+			OnlyCached:  rule.OnlyCached,
+			TTL:         time.Duration(rule.TTL),
+			NegativeTTL: time.Duration(rule.NegativeTTL),
+			MaxBodySize: rule.MaxBodySize,
+			Sliding:     time.Duration(rule.Sliding),
+
+			MaxEntriesPerHost: rule.MaxEntriesPerHost,
+
+			VaryCookies:   rule.VaryCookies,
+			BypassCookies: rule.BypassCookies,
+
+			Alias: rule.Alias,
+		}
+	}
+	return rules
+}
+
+// Apply sets the options of the cache from the configuration. It must be called before the cache is used. If the
+// cache already has a policy, its rules are replaced in place; without rules, the policy is left as it is.
+func (c *Config) Apply(r *Cache) error {
+	readFailurePolicy, err := failurePolicy("read_failure_policy", c.ReadFailurePolicy)
+	if err != nil {
+		return err
+	}
+	writeFailurePolicy, err := failurePolicy("write_failure_policy", c.WriteFailurePolicy)
+	if err != nil {
+		return err
+	}
+	refreshDropPolicy, err := c.refreshDropPolicy()
+	if err != nil {
+		return err
+	}
+	keyHash, err := c.keyHash()
+	if err != nil {
+		return err
+	}
+	notModified, err := c.notModified()
+	if err != nil {
+		return err
+	}
+	unusableNotModified, err := c.unusableNotModified()
+	if err != nil {
+		return err
+	}
+	policy, err := c.policy()
+	if err != nil {
+		return err
+	}
+	statusTTLs := c.statusTTLs()
+	if err := statusTTLs.Validate(); err != nil {
+		return fmt.Errorf("status_ttls: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	r.DisableCoalescing = c.DisableCoalescing
+	r.DedupWindow = time.Duration(c.DedupWindow)
+	r.StampedeLockTTL = time.Duration(c.StampedeLockTTL)
+	r.StampedeWait = time.Duration(c.StampedeWait)
+	r.EarlyExpirationBeta = c.EarlyExpirationBeta
+	r.SlidingExpiration = time.Duration(c.SlidingExpiration)
+	r.TTLJitter = time.Duration(c.TTLJitter)
+	r.StaleIfError = time.Duration(c.StaleIfError)
+	r.RetryAfter = time.Duration(c.RetryAfter)
+	r.Offline = c.Offline
+	r.RevalidationBudget = time.Duration(c.RevalidationBudget)
+	r.MinRefreshInterval = time.Duration(c.MinRefreshInterval)
+	if c.RefreshWindow > 0 {
+		r.RefreshAhead = &RefreshAhead{
+			Window:     time.Duration(c.RefreshWindow),
+			MinHits:    c.RefreshMinHits,
+			Workers:    c.RefreshWorkers,
+			QueueSize:  c.RefreshQueueSize,
+			Interval:   time.Duration(c.RefreshInterval),
+			Timeout:    time.Duration(c.RefreshTimeout),
+			DropPolicy: refreshDropPolicy,
+		}
+	}
+	r.ReadFailurePolicy = readFailurePolicy
+	r.WriteFailurePolicy = writeFailurePolicy
+	if c.BypassSecret != "" {
+		r.BypassHeader = &BypassHeader{Name: c.BypassHeader, Secret: c.BypassSecret}
+	}
+	r.KeyHash = keyHash
+	r.Namespace = c.Namespace
+	r.StatusTTLs = statusTTLs
+	r.SensitiveParams = c.SensitiveParams
+	r.ScopeByAuthorization = c.ScopeByAuthorization
+	if c.KeySecret != "" {
+		r.KeySecret = []byte(c.KeySecret)
+	}
+	r.ReverseIndex = c.ReverseIndex
+	r.TrackAccess = c.TrackAccess
+	r.MemoryBudget = c.MemoryBudget
+	r.StreamIdleTimeout = time.Duration(c.StreamIdleTimeout)
+	if c.HostMaxConcurrent > 0 {
+		r.HostLimit = &HostLimit{MaxConcurrent: c.HostMaxConcurrent, MaxWait: time.Duration(c.HostMaxWait)}
+	}
+	if c.RetryAttempts > 0 {
+		r.Retry = &Retry{
+			Attempts:    c.RetryAttempts,
+			BaseDelay:   time.Duration(c.RetryBaseDelay),
+			MaxDelay:    time.Duration(c.RetryMaxDelay),
+			StatusCodes: c.RetryStatusCodes,
+		}
+	}
+	if c.AsyncWrites {
+		r.AsyncWrites = &AsyncWrites{Workers: c.AsyncWriteWorkers, QueueSize: c.AsyncWriteQueueSize}
+	}
+	if c.WriteBatching {
+		r.WriteBatching = &WriteBatching{MaxSize: c.WriteBatchSize, Interval: time.Duration(c.WriteBatchInterval)}
+	}
+	if c.WriteRetryAttempts > 0 {
+		r.WriteRetry = &WriteRetry{
+			Attempts:  c.WriteRetryAttempts,
+			BaseDelay: time.Duration(c.WriteRetryBaseDelay),
+			MaxDelay:  time.Duration(c.WriteRetryMaxDelay),
+		}
+	}
+	if c.EntryCompression {
+		r.EntryCompression = &EntryCompression{Threshold: c.CompressionThreshold, Codec: c.CompressionCodec}
+	}
+	if c.Sweeper {
+		r.Sweeper = &Sweeper{Interval: time.Duration(c.SweepInterval), Grace: time.Duration(c.SweepGrace)}
+	}
+	r.KeyCollisionCheck = c.KeyCollisionCheck
+	r.DebugHeaders = c.DebugHeaders
+	r.DecompressCached = c.DecompressCached
+	r.NotModified = notModified
+	r.UnusableNotModified = unusableNotModified
+	if len(c.RequestMetadataHeaders) > 0 {
+		r.RequestMetadata = &RequestMetadata{Headers: c.RequestMetadataHeaders}
+	}
+	switch {
+	case len(c.Rules) == 0:
+	case r.Policy != nil:
+		return r.Policy.Update(c.policyRules()...)
+	default:
+		r.Policy = policy
+	}
+	return nil
+}
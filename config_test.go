@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "cache.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+ttl_jitter: 30s
+stale_if_error: 5m
+refresh_window: 1m
+refresh_drop_policy: oldest
+read_failure_policy: strict
+write_failure_policy: strict
+key_hash: sha256-hex
+namespace: "cache:"
+status_ttls:
+  "404": 1m
+  5xx: -1s
+rules:
+  - pattern: /live/
+    bypass: true
+  - host: api.example.com
+    ttl: 1m
+    negative_ttl: 10s
+refresh_min_hits: 2
+refresh_timeout: 10s
+sensitive_params: [token]
+scope_by_authorization: true
+key_secret: secret
+memory_budget: 1048576
+stream_idle_timeout: 500ms
+host_max_concurrent: 8
+host_max_wait: -1s
+retry_attempts: 3
+retry_base_delay: 100ms
+retry_status_codes: [503]
+write_batching: true
+write_batch_size: 16
+write_retry_attempts: 2
+entry_compression: true
+compression_codec: gzip
+sweeper: true
+sweep_grace: 1h
+not_modified: empty
+request_metadata_headers: [Accept]
+`), 0o600))
+
+	config, err := LoadConfig(yamlPath)
+	require.NoError(t, err, "LoadConfig")
+	require.Equal(t, Duration(30*time.Second), config.TTLJitter)
+	require.Len(t, config.Rules, 2)
+	require.Equal(t, Duration(10*time.Second), config.Rules[1].NegativeTTL)
+
+	jsonPath := filepath.Join(dir, "cache.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"ttl_jitter": "30s", "rules": [{"pattern": "/live/", "bypass": true}]}`), 0o600))
+	jsonConfig, err := LoadConfig(jsonPath)
+	require.NoError(t, err, "LoadConfig")
+	require.Equal(t, config.TTLJitter, jsonConfig.TTLJitter)
+
+	t.Setenv("TEST_CACHE_TTL_JITTER", "1m")
+	t.Setenv("TEST_CACHE_OFFLINE", "true")
+	t.Setenv("TEST_CACHE_RETRY_AFTER", "10m")
+	t.Setenv("TEST_CACHE_SENSITIVE_PARAMS", "token, api_key")
+	t.Setenv("TEST_CACHE_RETRY_STATUS_CODES", "502,503")
+	require.NoError(t, config.LoadEnv("TEST_CACHE_"), "config.LoadEnv")
+	require.Equal(t, Duration(time.Minute), config.TTLJitter)
+	require.True(t, config.Offline)
+
+	c := New(memoryprovider.New())
+	require.NoError(t, config.Apply(c), "config.Apply")
+	require.Equal(t, time.Minute, c.TTLJitter)
+	require.Equal(t, 5*time.Minute, c.StaleIfError)
+	require.Equal(t, 10*time.Minute, c.RetryAfter)
+	require.Equal(t, &RefreshAhead{Window: time.Minute, MinHits: 2, Timeout: 10 * time.Second, DropPolicy: RefreshDropOldest}, c.RefreshAhead)
+	require.Equal(t, FailureStrict, c.ReadFailurePolicy)
+	require.Equal(t, FailureStrict, c.WriteFailurePolicy)
+	require.Equal(t, KeyHashSHA256Hex, c.KeyHash)
+	require.Equal(t, "cache:", c.Namespace)
+	require.Equal(t, StatusTTLs{"404": time.Minute, "5xx": -time.Second}, c.StatusTTLs)
+	require.NotNil(t, c.Policy)
+	require.Equal(t, []string{"token", "api_key"}, c.SensitiveParams)
+	require.True(t, c.ScopeByAuthorization)
+	require.Equal(t, []byte("secret"), c.KeySecret)
+	require.Equal(t, int64(1<<20), c.MemoryBudget)
+	require.Equal(t, 500*time.Millisecond, c.StreamIdleTimeout)
+	require.Equal(t, &HostLimit{MaxConcurrent: 8, MaxWait: -time.Second}, c.HostLimit)
+	require.Equal(t, &Retry{Attempts: 3, BaseDelay: 100 * time.Millisecond, StatusCodes: []int{502, 503}}, c.Retry)
+	require.Nil(t, c.AsyncWrites)
+	require.Equal(t, &WriteBatching{MaxSize: 16}, c.WriteBatching)
+	require.Equal(t, &WriteRetry{Attempts: 2}, c.WriteRetry)
+	require.Equal(t, &EntryCompression{Codec: "gzip"}, c.EntryCompression)
+	require.Equal(t, &Sweeper{Grace: time.Hour}, c.Sweeper)
+	require.Equal(t, NotModifiedEmpty, c.NotModified)
+	require.Equal(t, &RequestMetadata{Headers: []string{"Accept"}}, c.RequestMetadata)
+
+	policy := c.Policy
+	require.NoError(t, (&Config{}).Apply(c), "config.Apply")
+	require.Same(t, policy, c.Policy)
+	u, err := url.Parse("http://example.com/live/feed")
+	require.NoError(t, err)
+	require.True(t, c.Policy.match(u).Bypass, "Expected a configuration without rules to keep the policy")
+
+	invalidPath := filepath.Join(dir, "invalid.yaml")
+	for _, invalid := range []string{
+		"ttl_jitter: -1s",
+		"key_hash: md5",
+		"refresh_drop_policy: random",
+		"status_ttls: {ok: 1m}",
+		"rules: [{pattern: '('}]",
+		"ttl_jitter: soon",
+		"memory_budget: -1",
+		"retry_status_codes: [42]",
+		"compression_codec: brotli",
+		"not_modified: never",
+	} {
+		require.NoError(t, os.WriteFile(invalidPath, []byte(invalid), 0o600))
+		_, err := LoadConfig(invalidPath)
+		require.Errorf(t, err, "Expected %q to be rejected", invalid)
+	}
+}
+
+func TestPolicy_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("rules: [{pattern: /live/, bypass: true}]"), 0o600))
+
+	rules, err := FilePolicySource(path)(context.Background())
+	require.NoError(t, err, "FilePolicySource")
+	policy, err := NewPolicy(rules...)
+	require.NoError(t, err, "NewPolicy")
+
+	u, err := url.Parse("http://example.com/live/feed")
+	require.NoError(t, err)
+	require.True(t, policy.match(u).Bypass)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 10)
+	go func() {
+		_ = policy.Watch(ctx, time.Millisecond, FilePolicySource(path), func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		})
+	}()
+
+	require.NoError(t, os.WriteFile(path, []byte("rules: [{pattern: /live/, ttl: 1m}]"), 0o600))
+	require.Eventually(t, func() bool {
+		rule := policy.match(u)
+		return rule != nil && !rule.Bypass && rule.TTL == time.Minute
+	}, time.Second, time.Millisecond, "Expected the new rules to be loaded")
+
+	require.NoError(t, os.WriteFile(path, []byte("rules: [{pattern: '('}]"), 0o600))
+	var syntaxErr *syntax.Error
+	require.Eventually(t, func() bool {
+		// errors of reads racing the writes may come first
+		return errors.As(<-errs, &syntaxErr)
+	}, time.Second, time.Millisecond, "Expected invalid rules to be reported")
+	require.Equal(t, time.Minute, policy.match(u).TTL, "Expected invalid rules to keep the current ones")
+
+	require.Error(t, policy.Watch(ctx, 0, FilePolicySource(path), nil), "Expected a zero interval to be rejected")
+}
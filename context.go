@@ -1,6 +1,9 @@
 package cache
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type contextKey string
 
@@ -8,6 +11,21 @@ const (
 	contextKeyIgnoreExpired contextKey = "contextKeyIgnoreExpired"
 	contextKeyIgnoreCache   contextKey = "contextKeyIgnoreCache"
 	contextKeyOnlyCached    contextKey = "contextKeyOnlyCached"
+	contextKeyOffline       contextKey = "contextKeyOffline"
+	contextKeySlogLogger    contextKey = "contextKeySlogLogger"
+	contextKeyHTTPClient    contextKey = "contextKeyHTTPClient"
+	contextKeyTenant        contextKey = "contextKeyTenant"
+	contextKeyPrefetched    contextKey = "contextKeyPrefetched"
+	contextKeyRefreshAhead  contextKey = "contextKeyRefreshAhead"
+	contextKeyPrincipal     contextKey = "contextKeyPrincipal"
+	contextKeyPartition     contextKey = "contextKeyPartition"
+	contextKeyStaleError    contextKey = "contextKeyStaleError"
+	contextKeyForceStore    contextKey = "contextKeyForceStore"
+	contextKeyNoStore       contextKey = "contextKeyNoStore"
+	contextKeyMaxStale      contextKey = "contextKeyMaxStale"
+	contextKeyMinFresh      contextKey = "contextKeyMinFresh"
+	contextKeyLogFields     contextKey = "contextKeyLogFields"
+	contextKeyPolicyRule    contextKey = "contextKeyPolicyRule"
 )
 
 // WithIgnoreExpired returns a copy of parent context with ignoreExpired flag set to the given parameter.
@@ -58,3 +76,197 @@ func OnlyCached(ctx context.Context) bool {
 	}
 	return v.(bool)
 }
+
+// WithOffline answers every request from the cache, regardless of freshness, without ever reaching the origin.
+// Returns an ErrCacheMiss error if the value is not cached. Takes precedence over IgnoreCache.
+func WithOffline(ctx context.Context, offline bool) context.Context {
+	return context.WithValue(ctx, contextKeyOffline, offline)
+}
+
+func Offline(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v := ctx.Value(contextKeyOffline)
+	if v == nil {
+		return false
+	}
+	return v.(bool)
+}
+
+// WithHTTPClient sends the origin requests of calls using the returned context through client instead of the
+// HttpClient of the cache, for instance to go through a proxy. Background refreshes keep using the HttpClient of the
+// cache.
+func WithHTTPClient(ctx context.Context, client HttpRequester) context.Context {
+	return context.WithValue(ctx, contextKeyHTTPClient, client)
+}
+
+// HTTPClient returns the client set with WithHTTPClient, or nil.
+func HTTPClient(ctx context.Context) HttpRequester {
+	if ctx == nil {
+		return nil
+	}
+	v, _ := ctx.Value(contextKeyHTTPClient).(HttpRequester)
+	return v
+}
+
+// WithTenant isolates the entries of calls using the returned context under the given tenant: their keys are prefixed
+// with the tenant, its quota applies (see TenantQuotas) and its statistics are tracked (see TenantStats). This also
+// applies to GetOrSet and Typed.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKeyTenant, tenant)
+}
+
+// Tenant returns the tenant set with WithTenant, or an empty string.
+func Tenant(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	v, _ := ctx.Value(contextKeyTenant).(string)
+	return v
+}
+
+// WithRefreshAhead serves cached entries as usual, but revalidates them in the background when they expire within
+// window, regardless of how often they are accessed. This is a per-call version of the RefreshAhead option of the
+// cache, sharing its workers, for latency-critical paths.
+func WithRefreshAhead(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, contextKeyRefreshAhead, window)
+}
+
+// RefreshAheadWindow returns the window set with WithRefreshAhead, or 0.
+func RefreshAheadWindow(ctx context.Context) time.Duration {
+	if ctx == nil {
+		return 0
+	}
+	v, _ := ctx.Value(contextKeyRefreshAhead).(time.Duration)
+	return v
+}
+
+// WithPrincipal scopes the entries of calls using the returned context to principal, e.g. a user id, when the cache
+// has ScopeByAuthorization set. It takes precedence over the Authorization header of the request.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, contextKeyPrincipal, principal)
+}
+
+// Principal returns the principal set with WithPrincipal, or an empty string.
+func Principal(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	v, _ := ctx.Value(contextKeyPrincipal).(string)
+	return v
+}
+
+// WithPartition double-keys the entries of calls using the returned context: their keys are prefixed with partition,
+// e.g. the top-level site a resource is loaded for, as browsers do, so that entries are never shared across
+// partitions. This prevents cross-site timing and poisoning attacks through a shared provider. Unlike WithTenant,
+// partitions have no quotas nor statistics, and don't apply to GetOrSet.
+func WithPartition(ctx context.Context, partition string) context.Context {
+	return context.WithValue(ctx, contextKeyPartition, partition)
+}
+
+// Partition returns the partition set with WithPartition, or an empty string.
+func Partition(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	v, _ := ctx.Value(contextKeyPartition).(string)
+	return v
+}
+
+// WithStaleError makes Do return a *StaleEntryError wrapping ErrStaleServed along with responses served from an
+// expired entry. The response is valid and its body must be closed: the error is informational, for callers relying
+// on freshness. See also DoWithStatus.
+func WithStaleError(ctx context.Context, staleError bool) context.Context {
+	return context.WithValue(ctx, contextKeyStaleError, staleError)
+}
+
+// StaleError returns the flag set with WithStaleError.
+func StaleError(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(contextKeyStaleError).(bool)
+	return v
+}
+
+// WithForceStore stores the responses of calls using the returned context even when they would normally not be, because
+// of their status code or Vary header, for debugging or pre-seeding the cache. Partial content, streams, responses
+// larger than the MaxBodySize of their policy rule and requests bypassing the cache are never stored.
+func WithForceStore(ctx context.Context, force bool) context.Context {
+	return context.WithValue(ctx, contextKeyForceStore, force)
+}
+
+// ForceStore returns the flag set with WithForceStore.
+func ForceStore(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(contextKeyForceStore).(bool)
+	return v
+}
+
+// WithNoStore makes calls using the returned context read the cache as usual, but never store the response they get
+// from the origin, e.g. because it carries a one-time token. Such responses are not shared with concurrent calls
+// either. Takes precedence over WithForceStore.
+func WithNoStore(ctx context.Context, noStore bool) context.Context {
+	return context.WithValue(ctx, contextKeyNoStore, noStore)
+}
+
+// NoStore returns the flag set with WithNoStore.
+func NoStore(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(contextKeyNoStore).(bool)
+	return v
+}
+
+// WithMaxStale makes calls using the returned context accept entries expired for up to maxStale, as the max-stale
+// request directive does: they are served without revalidation, marked stale. Entries expired for longer are
+// revalidated as usual. WithIgnoreExpired accepts any expired entry.
+func WithMaxStale(ctx context.Context, maxStale time.Duration) context.Context {
+	return context.WithValue(ctx, contextKeyMaxStale, maxStale)
+}
+
+// MaxStale returns the tolerance set with WithMaxStale, or 0.
+func MaxStale(ctx context.Context) time.Duration {
+	if ctx == nil {
+		return 0
+	}
+	v, _ := ctx.Value(contextKeyMaxStale).(time.Duration)
+	return v
+}
+
+// WithMinFresh makes calls using the returned context revalidate entries that expire within minFresh, as the
+// min-fresh request directive does, so that the response stays fresh for at least that long. Ignored with
+// WithIgnoreExpired.
+func WithMinFresh(ctx context.Context, minFresh time.Duration) context.Context {
+	return context.WithValue(ctx, contextKeyMinFresh, minFresh)
+}
+
+// MinFresh returns the requirement set with WithMinFresh, or 0.
+func MinFresh(ctx context.Context) time.Duration {
+	if ctx == nil {
+		return 0
+	}
+	v, _ := ctx.Value(contextKeyMinFresh).(time.Duration)
+	return v
+}
+
+// WithLogFields attaches key-value pairs, such as a request or trace ID, to every message the cache logs for calls
+// using the returned context, so that cache logs can be joined with the application ones. Fields add up to those
+// already attached to ctx.
+func WithLogFields(ctx context.Context, keyvals ...any) context.Context {
+	fields := LogFields(ctx)
+	return context.WithValue(ctx, contextKeyLogFields, append(fields[:len(fields):len(fields)], keyvals...))
+}
+
+// LogFields returns the key-value pairs attached with WithLogFields, or nil.
+func LogFields(ctx context.Context) []any {
+	if ctx == nil {
+		return nil
+	}
+	v, _ := ctx.Value(contextKeyLogFields).([]any)
+	return v
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Headers set on responses with DebugHeaders.
+const (
+	HeaderCacheKey    = "X-Cache-Key"
+	HeaderCacheStatus = "X-Cache-Status"
+	HeaderCacheAge    = "X-Cache-Age" // seconds since the entry was stored, only set for responses served from an entry
+)
+
+// annotate sets the debug headers on resp.
+func (r Cache) annotate(resp *http.Response, info callInfo) {
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	if info.key != "" {
+		resp.Header.Set(HeaderCacheKey, info.key)
+	}
+	if info.stat != "" {
+		resp.Header.Set(HeaderCacheStatus, string(info.stat))
+	}
+	if !info.stored.IsZero() {
+		age := r.now().Sub(info.stored)
+		if age < 0 {
+			age = 0
+		}
+		resp.Header.Set(HeaderCacheAge, strconv.FormatInt(int64(age.Seconds()), 10))
+	}
+}
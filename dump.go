@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// dumpRecord is a single record of the archive written by Export, one JSON document per line.
+type dumpRecord struct {
+	Key   string      `json:"key"`
+	Entry *cacheEntry `json:"entry"`
+}
+
+// Export writes every entry stored by the provider to w, one record per line, returning the number of exported
+// entries. The archive can be loaded into any provider with Import. Requires a provider implementing Scanner.
+func (r Cache) Export(ctx context.Context, w io.Writer) (int, error) {
+	scanner, ok := r.currentProvider().(Scanner)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Scanner", ErrNotSupported, r.providerName())
+	}
+
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+
+	var exported int
+	err := scanner.Scan(ctx, r.Namespace, func(key string) error {
+		if r.internalKey(key) {
+			return nil
+		}
+		entry, err := r.read(ctx, key)
+		if err != nil && !errors.Is(err, ErrCacheExpired) && !errors.Is(err, ErrCacheExpiryIgnored) {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+		if err := enc.Encode(dumpRecord{Key: key, Entry: entry}); err != nil {
+			return err
+		}
+		exported++
+		return nil
+	})
+	if err != nil {
+		return exported, providerError("scan", r.Namespace, err)
+	}
+	return exported, buf.Flush()
+}
+
+// Import stores every entry of an archive written by Export, returning the number of imported entries. Existing
+// entries with the same keys are overwritten.
+func (r Cache) Import(ctx context.Context, rd io.Reader) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(rd))
+
+	var imported int
+	for {
+		var record dumpRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("record %d: %w", imported+1, err)
+		}
+		if record.Key == "" || record.Entry == nil {
+			return imported, fmt.Errorf("record %d: missing key or entry", imported+1)
+		}
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+
+		data, err := json.Marshal(record.Entry)
+		if err != nil {
+			return imported, fmt.Errorf("json.Marshal(): %w", err)
+		}
+		if err := r.providerSet(ctx, record.Key, data, 0); err != nil {
+			return imported, &ProviderError{Op: "set", Key: record.Key, Err: err}
+		}
+		imported++
+	}
+}
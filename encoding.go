@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultMaxDecodedSize caps the size of decoded bodies when no MaxBodySize applies, so that a small encoded body
+// can't inflate without bounds. It also bounds the memory of zstd decoders.
+const defaultMaxDecodedSize = 64 << 20
+
+// errDecodedTooLarge is returned by decodeContent when the decoded body exceeds its limit.
+var errDecodedTooLarge = errors.New("decoded content too large")
+
+// contentDecoders decode the content codings known to the cache, by normalized name, see normalizeCoding.
+var contentDecoders = map[string]func(r io.Reader) (io.ReadCloser, error){
+	"gzip":    func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"deflate": newDeflateReader,
+	"br":      func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil },
+	"zstd":    newZstdReader,
+}
+
+// newDeflateReader decodes the deflate content coding, which is zlib wrapped, though some servers send raw deflate.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(2); err == nil && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 && head[0]&0x0f == 8 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(defaultMaxDecodedSize))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// normalizeCoding returns the canonical name of a content coding: lower case, with the x-gzip and x-compress aliases
+// resolved.
+func normalizeCoding(coding string) string {
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	switch coding {
+	case "x-gzip":
+		return "gzip"
+	case "x-compress":
+		return "compress"
+	}
+	return coding
+}
+
+// normalizeAcceptEncoding returns the sorted, normalized codings accepted by the given Accept-Encoding header values,
+// without q-values, refused codings and identity, so that equivalent headers select the same variant.
+func normalizeAcceptEncoding(values []string) string {
+	var codings []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = normalizeCoding(name)
+			if name == "" || name == "identity" || slices.Contains(codings, name) {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					continue
+				}
+			}
+			codings = append(codings, name)
+		}
+	}
+	slices.Sort(codings)
+	return strings.Join(codings, ",")
+}
+
+// contentCodings returns the normalized codings listed by a Content-Encoding header, in the order they were applied,
+// without identity.
+func contentCodings(header string) []string {
+	var codings []string
+	for _, coding := range strings.Split(header, ",") {
+		if coding = normalizeCoding(coding); coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// normalizeContentEncoding rewrites the Content-Encoding header of an entry about to be stored with normalized
+// codings, so that entries of origins spelling them differently are served alike. An identity only header is removed.
+func normalizeContentEncoding(headers map[string]string) {
+	header, ok := headers["Content-Encoding"]
+	if !ok {
+		return
+	}
+	if codings := contentCodings(header); len(codings) > 0 {
+		headers["Content-Encoding"] = strings.Join(codings, ", ")
+	} else {
+		delete(headers, "Content-Encoding")
+	}
+}
+
+// decodeContent decodes data, encoded with coding, returning errDecodedTooLarge if it decodes to more than limit
+// bytes.
+func decodeContent(coding string, data []byte, limit int64) ([]byte, error) {
+	decoder, ok := contentDecoders[normalizeCoding(coding)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content coding %q", coding)
+	}
+	rc, err := decoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	decoded, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > limit {
+		return nil, errDecodedTooLarge
+	}
+	return decoded, nil
+}
+
+// acceptsEncoding reports whether req accepts responses encoded with coding, according to its Accept-Encoding header.
+func acceptsEncoding(req *http.Request, coding string) bool {
+	for _, value := range req.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			if name = normalizeCoding(name); name != coding && name != "*" {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// decompress decodes the body of resp, served from an entry, when it is encoded with codings req didn't ask for, as
+// net/http does for gzip. Content-Encoding and Content-Length are removed, and resp.Uncompressed is set. Bodies
+// encoded with an unknown coding, or decoding to more than the MaxBodySize of rule, the policy rule matching req, or
+// defaultMaxDecodedSize, are left as they are.
+func (r Cache) decompress(ctx context.Context, req *http.Request, resp *http.Response, rule *PolicyRule) {
+	codings := contentCodings(resp.Header.Get("Content-Encoding"))
+	if len(codings) == 0 || resp.StatusCode == http.StatusPartialContent {
+		return
+	}
+	accepted := true
+	for _, coding := range codings {
+		if _, ok := contentDecoders[coding]; !ok {
+			return
+		}
+		accepted = accepted && acceptsEncoding(req, coding)
+	}
+	if accepted {
+		return
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		r.logError(ctx, "error reading cached body", "error", err)
+		return
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	limit := int64(defaultMaxDecodedSize)
+	if rule != nil && rule.MaxBodySize > 0 {
+		limit = rule.MaxBodySize
+	}
+	decoded := data
+	for i := len(codings) - 1; i >= 0; i-- {
+		// codings are listed in the order they were applied
+		decoded, err = decodeContent(codings[i], decoded, limit)
+		if errors.Is(err, errDecodedTooLarge) {
+			r.logInfo(ctx, "cached body too large to be decoded, serving it encoded", "coding", codings[i], "limit", limit)
+			return
+		}
+		if err != nil {
+			r.logError(ctx, "error decoding cached body", "coding", codings[i], "error", err)
+			return
+		}
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(decoded))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EntryInfo describes a cached entry.
+type EntryInfo struct {
+	Key        string            `json:"key"`
+	StatusCode int               `json:"status_code"`
+	Headers    map[string]string `json:"headers"`
+	Size       int               `json:"size"`              // length of the response body
+	StoredAt   time.Time         `json:"stored_at"`         // moment the entry was fetched from the origin
+	Expires    time.Time         `json:"expires,omitempty"` // zero if the entry has no known expiry
+	Fresh      bool              `json:"fresh"`
+	Body       []byte            `json:"-"`
+	Request    *EntryRequest     `json:"request,omitempty"` // only recorded with RequestMetadata
+
+	// access metadata, only tracked with TrackAccess
+	Hits       int64     `json:"hits,omitempty"`
+	LastAccess time.Time `json:"last_access,omitempty"`
+}
+
+func newEntryInfo(key string, entry *cacheEntry, now time.Time) *EntryInfo {
+	info := &EntryInfo{
+		Key:        key,
+		StatusCode: entry.StatusCode,
+		Headers:    entry.Headers,
+		Size:       len(entry.Data),
+		StoredAt:   entry.Ts,
+		Fresh:      !entry.expired(now),
+		Body:       entry.Data,
+		Request:    entry.Request,
+	}
+	if expires, ok := entry.expiresAt(); ok && entry.Sliding == 0 {
+		// sliding entries are expired by the provider
+		info.Expires = expires
+	}
+	return info
+}
+
+// PeekKey returns information about the entry stored under key, without going to the origin.
+// Returns an ErrCacheMiss error if there is no such entry.
+func (r Cache) PeekKey(ctx context.Context, key string) (*EntryInfo, error) {
+	entry, err := r.read(ctx, key)
+	if err != nil && !errors.Is(err, ErrCacheExpired) && !errors.Is(err, ErrCacheExpiryIgnored) {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrCacheMiss
+	}
+	info := newEntryInfo(key, entry, r.now())
+	if r.TrackAccess {
+		if access, err := r.readAccess(ctx, key); err == nil && access != nil {
+			info.Hits, info.LastAccess = access.Hits, access.LastAccess
+		}
+	}
+	return info, nil
+}
+
+// Peek returns information about the entry matching req, without going to the origin.
+// Returns an ErrCacheMiss error if there is no such entry.
+func (r Cache) Peek(ctx context.Context, req *http.Request) (*EntryInfo, error) {
+	routed := r.route(req)
+	key := r.key(req)
+	if index, err := routed.read(ctx, key); err == nil && index != nil && index.isIndex() {
+		key = variantKey(key, index.Vary, req)
+	}
+	return routed.PeekKey(ctx, key)
+}
+
+// IsFresh reports whether a fresh entry matching req is cached and how long it stays fresh, without going to the
+// origin. Early expiration is not taken into account.
+func (r Cache) IsFresh(ctx context.Context, req *http.Request) (bool, time.Duration, error) {
+	routed := r.route(req)
+	key := r.key(req)
+	ctx = WithIgnoreExpired(ctx, true)
+	entry, err := routed.read(ctx, key)
+	_, entry, err = routed.resolveVariant(ctx, key, req, entry, err)
+	if err != nil && !errors.Is(err, ErrCacheExpiryIgnored) {
+		return false, 0, err
+	}
+	if entry == nil {
+		return false, 0, nil
+	}
+
+	now := r.now()
+	if entry.expired(now) {
+		return false, 0, nil
+	}
+	expires, _ := entry.expiresAt()
+	return true, expires.Sub(now), nil
+}
+
+// Invalidate removes the entry stored under key, from every provider the cache routes requests to. Requires
+// providers implementing Deleter.
+func (r Cache) Invalidate(ctx context.Context, key string) error {
+	for _, c := range r.perProvider() {
+		if _, err := c.invalidateVariants(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r Cache) invalidate(ctx context.Context, key string) error {
+	deleter, ok := r.currentProvider().(Deleter)
+	if !ok {
+		return fmt.Errorf("%w: %s does not implement Deleter", ErrNotSupported, r.providerName())
+	}
+	r.cancelRetry(key)
+	if err := deleter.Delete(ctx, key); err != nil {
+		r.recordProviderError(ctx, "delete", err)
+		return providerError("delete", key, err)
+	}
+	r.releaseUsage(key)
+	if r.TrackAccess && !r.internalKey(key) {
+		if err := deleter.Delete(ctx, r.sidecarKey(accessKeyPrefix, key)); err != nil {
+			r.logError(ctx, "error deleting entry access", "key", key, "provider", r.providerName(), "error", err)
+		}
+	}
+	return nil
+}
+
+// InvalidateURL removes the entry a GET request to rawURL would be answered with, along with its variants, returning
+// the number of removed entries. With ReverseIndex, every entry stored for rawURL is removed, whatever its key.
+// Requires a provider implementing Deleter.
+func (r Cache) InvalidateURL(ctx context.Context, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("http.NewRequest(): %w", err)
+	}
+	routed := r.route(req)
+	var deleted int
+	if r.ReverseIndex {
+		urlIndexKey, _ := r.indexKeysOf(req)
+		_, n, err := routed.invalidateIndex(ctx, urlIndexKey)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	n, err := routed.invalidateVariants(ctx, r.key(req))
+	return deleted + n, err
+}
+
+// InvalidateHost removes every entry stored for a request to host, e.g. to get rid of the responses of a misbehaving
+// upstream, returning the number of removed entries. host may carry a port, default ports excepted. With
+// ReverseIndex, the entries are found through the host index, otherwise every entry is read, which requires
+// providers implementing Scanner. Providers must also implement Deleter. With Routes, every provider is purged.
+func (r Cache) InvalidateHost(ctx context.Context, host string) (int, error) {
+	host = strings.ToLower(host)
+	var deleted int
+	for _, c := range r.perProvider() {
+		n, err := c.invalidateHost(ctx, host)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func (r Cache) invalidateHost(ctx context.Context, host string) (int, error) {
+	var deleted int
+	if r.ReverseIndex {
+		index, n, err := r.invalidateIndex(ctx, r.namespaced(hostIndexKeyPrefix+host))
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		if index != nil && !index.Truncated {
+			return deleted, nil
+		}
+	}
+
+	scanner, ok := r.currentProvider().(Scanner)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Scanner", ErrNotSupported, r.providerName())
+	}
+	var keys []string
+	err := scanner.Scan(ctx, r.Namespace, func(key string) error {
+		if r.internalKey(key) {
+			return nil
+		}
+		if entry := r.scannedEntry(ctx, key); entry != nil && entryHost(entry) == host {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, providerError("scan", r.Namespace, err)
+	}
+
+	for _, key := range keys {
+		if err := r.invalidate(ctx, key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// entryHost returns the canonical host of the URL entry was stored for, or an empty string if unknown.
+func entryHost(entry *cacheEntry) string {
+	u, err := url.Parse(entry.URL)
+	if err != nil || entry.URL == "" {
+		return ""
+	}
+	canonical, err := url.Parse(CanonicalURL(u, CanonicalOptions{}))
+	if err != nil {
+		return ""
+	}
+	return canonical.Host
+}
+
+// Purge removes every entry whose key starts with prefix, returning the number of removed entries. Note that
+// prefixes are matched against the keys handed to the provider after the Namespace, i.e. after hashing, tenant and
+// partition prefixes. Requires a provider implementing both Scanner and Deleter. With Routes, every provider is
+// purged.
+func (r Cache) Purge(ctx context.Context, prefix string) (int, error) {
+	var deleted int
+	for _, c := range r.perProvider() {
+		n, err := c.purge(ctx, prefix)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func (r Cache) purge(ctx context.Context, prefix string) (int, error) {
+	scanner, ok := r.currentProvider().(Scanner)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Scanner", ErrNotSupported, r.providerName())
+	}
+	if _, ok := r.currentProvider().(Deleter); !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Deleter", ErrNotSupported, r.providerName())
+	}
+
+	prefix = r.namespaced(prefix)
+	var deleted int
+	err := scanner.Scan(ctx, prefix, func(key string) error {
+		if err := r.invalidate(ctx, key); err != nil {
+			return err
+		}
+		if !r.internalKey(key) {
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return deleted, providerError("scan", prefix, err)
+	}
+	return deleted, nil
+}
+
+// Flush removes every key of the cache from the provider, returning the number of removed entries. It returns
+// ErrNoNamespace if the cache has no Namespace, as other data of the provider would be removed too.
+func (r Cache) Flush(ctx context.Context) (int, error) {
+	if r.Namespace == "" {
+		return 0, ErrNoNamespace
+	}
+	return r.Purge(ctx, "")
+}
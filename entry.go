@@ -3,7 +3,9 @@ package cache
 import (
 	"bytes"
 	"io"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -12,12 +14,34 @@ type cacheEntry struct {
 	StatusCode int               `json:"status_code"`
 	Data       []byte            `json:"data"`
 	Headers    map[string]string `json:"headers"`
+	Delta      time.Duration     `json:"delta,omitempty"`      // time taken to fetch the entry from the origin
+	Expires    time.Time         `json:"expires,omitempty"`    // computed expiry, takes precedence over the Expires header
+	URL        string            `json:"url,omitempty"`        // URL of the request, without its sensitive parameters
+	Codec      string            `json:"codec,omitempty"`      // codec compressing Data, see EntryCompression
+	Request    *EntryRequest     `json:"request,omitempty"`    // request the entry was fetched for, see RequestMetadata
+	HeldUntil  time.Time         `json:"held_until,omitempty"` // the origin asked not to be retried before, see RetryAfter
+	Sliding    time.Duration     `json:"sliding,omitempty"`    // sliding window the provider expires the entry after, see slide
+
+	// Vary and Variants are only set on variant indexes, stored in place of the entries of responses with a Vary
+	// header, see writeEntry.
+	Vary     []string `json:"vary,omitempty"`     // request headers selecting the variant
+	Variants []string `json:"variants,omitempty"` // keys of the known variants
+}
+
+// valid reports whether e was written by the cache, rather than being another JSON value of a shared provider.
+func (e cacheEntry) valid() bool {
+	return !e.Ts.IsZero() && (e.isIndex() || e.StatusCode != 0)
+}
+
+// isIndex reports whether the entry is a variant index rather than a response.
+func (e cacheEntry) isIndex() bool {
+	return len(e.Vary) > 0
 }
 
 func (e cacheEntry) asHttpResponse(req *http.Request) *http.Response {
 	headers := make(map[string][]string)
 	for k, v := range e.Headers {
-		headers[k] = []string{v}
+		headers[http.CanonicalHeaderKey(k)] = []string{v}
 	}
 
 	return &http.Response{
@@ -29,17 +53,64 @@ func (e cacheEntry) asHttpResponse(req *http.Request) *http.Response {
 	}
 }
 
-// expired returns true if the entry is expired.
-func (e cacheEntry) expired() bool {
+// header returns the value of the named header, regardless of how its name was capitalized when stored.
+func (e cacheEntry) header(name string) string {
+	if v, ok := e.Headers[http.CanonicalHeaderKey(name)]; ok {
+		return v
+	}
+	for k, v := range e.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// expiresAt returns the moment the entry expires. Returns false if the expiry is unknown.
+func (e cacheEntry) expiresAt() (time.Time, bool) {
+	if !e.Expires.IsZero() {
+		return e.Expires, true
+	}
+
 	expiry, ok := e.Headers["Expires"]
 	if !ok {
-		return true
+		return time.Time{}, false
 	}
 
 	expires, err := time.Parse(time.RFC1123, expiry)
 	if err != nil {
+		return time.Time{}, false
+	}
+
+	return expires, true
+}
+
+// expired returns true if the entry is expired at the given moment.
+func (e cacheEntry) expired(now time.Time) bool {
+	expires, ok := e.expiresAt()
+	if !ok {
 		return true
 	}
 
-	return expires.Before(time.Now())
+	return expires.Before(now)
+}
+
+// held reports whether the origin asked not to be retried for the entry until after the given moment.
+func (e cacheEntry) held(now time.Time) bool {
+	return e.HeldUntil.After(now)
+}
+
+// expiresEarly implements the XFetch probabilistic early expiration check, where rnd is a random number in [0, 1).
+// The closer the entry is to its expiry and the longer it took to fetch, the more likely it is to expire early.
+func (e cacheEntry) expiresEarly(now time.Time, beta float64, rnd float64) bool {
+	if beta <= 0 || e.Delta <= 0 || rnd <= 0 {
+		return false
+	}
+	expires, ok := e.expiresAt()
+	if !ok {
+		return false
+	}
+
+	gap := time.Duration(-float64(e.Delta) * beta * math.Log(rnd))
+	return !now.Add(gap).Before(expires)
 }
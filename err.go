@@ -1,9 +1,81 @@
 package cache
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
 	ErrCacheExpired       = errors.New("cache expired")
 	ErrCacheExpiryIgnored = errors.New("cache expiry ignored")
 	ErrCacheMiss          = errors.New("cache miss")
+	ErrHostLimit          = errors.New("too many concurrent requests to host")
+	ErrNotSupported       = errors.New("operation not supported by provider")
+	ErrNotRecorded        = errors.New("request not recorded")
+	ErrStaleServed        = errors.New("stale entry served") // returned along with the response, see WithStaleError
+	ErrValidatorMismatch  = errors.New("origin answered not modified, but there is no entry to serve")
 )
+
+// ProviderError is returned when a provider operation fails.
+type ProviderError struct {
+	Op  string // failed operation: "get", "set", "delete" or "scan"
+	Key string // key of the operation, or the prefix of a scan
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("provider %s %q: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// providerError wraps err in a ProviderError, unless it already is one. Operations a provider reports as
+// errors.ErrUnsupported, as decorators such as metricsprovider do, fail with ErrNotSupported instead.
+func providerError(op string, key string, err error) error {
+	if errors.Is(err, errors.ErrUnsupported) && !errors.Is(err, ErrNotSupported) {
+		return fmt.Errorf("%w: %s: %w", ErrNotSupported, op, err)
+	}
+	var pe *ProviderError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return &ProviderError{Op: op, Key: key, Err: err}
+}
+
+// OriginError is returned when a request to the origin fails, and no cached entry could be served instead.
+type OriginError struct {
+	Method string
+	URL    string
+	Key    string // cache key of the request, empty if the request bypassed the cache
+	Err    error
+}
+
+func (e *OriginError) Error() string {
+	return fmt.Sprintf("origin %s %s: %v", e.Method, e.URL, e.Err)
+}
+
+func (e *OriginError) Unwrap() error {
+	return e.Err
+}
+
+// StaleEntryError is returned along with an entry that is no longer fresh. Err is ErrCacheExpired,
+// ErrCacheExpiryIgnored or ErrStaleServed.
+type StaleEntryError struct {
+	Key     string
+	Expires time.Time // zero if the entry has no known expiry
+	Err     error
+}
+
+func (e *StaleEntryError) Error() string {
+	if e.Expires.IsZero() {
+		return fmt.Sprintf("%v: %q", e.Err, e.Key)
+	}
+	return fmt.Sprintf("%v: %q expired at %s", e.Err, e.Key, e.Expires.Format(time.RFC3339))
+}
+
+func (e *StaleEntryError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarMu serializes PublishExpvar calls, expvar.Publish panicking on names published in the meantime.
+var expvarMu sync.Mutex
+
+// PublishExpvar publishes the cache statistics as an expvar variable with the given name, so they show up in
+// /debug/vars. Returns an error if the name is already in use.
+func (r Cache) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q is already published", name)
+	}
+
+	expvar.Publish(name, expvar.Func(func() any {
+		stats := r.Stats()
+		return struct {
+			Stats
+			HitRatio float64 `json:"hit_ratio"`
+		}{Stats: stats, HitRatio: stats.HitRatio()}
+	}))
+	return nil
+}
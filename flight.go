@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// flightCall is an in-flight or completed origin fetch shared by every caller asking for the same key.
+type flightCall struct {
+	done chan struct{} // closed once fn returned
+
+	result *fetchResult
+	err    error
+
+	url     string
+	start   time.Time
+	end     time.Time
+	waiters int
+
+	refs   int                // callers that didn't go away, the one that started the call included
+	cancel context.CancelFunc // cancels the context fn runs with
+}
+
+// flightGroup coalesces concurrent fetches for the same key so only one of them reaches the origin.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+	done  map[string]*flightCall // calls completed within their dedup window
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: make(map[string]*flightCall),
+		done:  make(map[string]*flightCall),
+	}
+}
+
+// do executes fn for the given key, making sure only one execution is in-flight at a time. Concurrent callers
+// for the same key wait for the in-flight execution and receive its result. shared reports whether the result
+// came from another caller. url is the origin URL fetched by fn, if any, for introspection.
+//
+// fn runs on a context keeping the values of ctx, but only canceled once every caller went away, so that the caller
+// that started the execution going away doesn't fail the others. The context of an unbuffered response, only handed
+// to that caller, is canceled once its body is closed. A panic of fn is returned as an error to every caller.
+//
+// With a positive window, a successful result is also handed to the callers arriving within window after the
+// execution completed, unless it is an unbuffered response.
+func (g *flightGroup) do(ctx context.Context, key string, url string, window time.Duration, fn func(ctx context.Context) (*fetchResult, error)) (result *fetchResult, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		c.refs++
+		g.mu.Unlock()
+		stop := context.AfterFunc(ctx, func() { g.leave(c) })
+		select {
+		case <-c.done:
+			if stop() {
+				g.leave(c)
+			}
+			return c.result, true, c.err
+		case <-ctx.Done():
+			g.mu.Lock()
+			c.waiters--
+			g.mu.Unlock()
+			return nil, true, ctx.Err()
+		}
+	}
+	if c, ok := g.done[key]; ok && window > 0 && time.Since(c.end) < window {
+		g.mu.Unlock()
+		return c.result, true, nil
+	}
+	fnCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c := &flightCall{done: make(chan struct{}), url: url, start: time.Now(), refs: 1, cancel: cancel}
+	g.calls[key] = c
+	g.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() { g.leave(c) })
+
+	g.run(fnCtx, key, c, window, fn)
+	if c.err == nil && c.result.resp != nil {
+		// the caller reads the body, possibly until it goes away
+		c.result.resp.Body = &releaseOnClose{ReadCloser: c.result.resp.Body, release: func() {
+			stop()
+			cancel()
+		}}
+	} else {
+		stop()
+		cancel()
+	}
+	return c.result, false, c.err
+}
+
+// run executes fn for the call c of key, then releases the callers waiting for it.
+func (g *flightGroup) run(ctx context.Context, key string, c *flightCall, window time.Duration, fn func(ctx context.Context) (*fetchResult, error)) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.result, c.err = nil, fmt.Errorf("fetch panicked: %v", p)
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		if window > 0 && c.err == nil && c.result.resp == nil {
+			c.end = time.Now()
+			g.done[key] = c
+			time.AfterFunc(window, func() { g.forget(key, c) })
+		}
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.result, c.err = fn(ctx)
+}
+
+// leave records that a caller of c went away, canceling c once they all did.
+func (g *flightGroup) leave(c *flightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c.refs--
+	if c.refs == 0 {
+		c.cancel()
+	}
+}
+
+// forget drops the completed call c of key, unless a newer call replaced it.
+func (g *flightGroup) forget(key string, c *flightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.done[key] == c {
+		delete(g.done, key)
+	}
+}
+
+// waiters returns the number of callers waiting on the in-flight fetch for key.
+func (g *flightGroup) waiters(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.calls[key]
+	if !ok {
+		return 0
+	}
+	return c.waiters
+}
+
+// InFlight describes an origin fetch in progress.
+type InFlight struct {
+	Key     string    `json:"key"`
+	URL     string    `json:"url,omitempty"` // empty for GetOrSet computations
+	Start   time.Time `json:"start"`
+	Waiters int       `json:"waiters"` // callers waiting for the fetch, besides the one that started it
+}
+
+// list returns the calls in progress, oldest first.
+func (g *flightGroup) list() []InFlight {
+	g.mu.Lock()
+	calls := make([]InFlight, 0, len(g.calls))
+	for key, c := range g.calls {
+		calls = append(calls, InFlight{Key: key, URL: c.url, Start: c.start, Waiters: c.waiters})
+	}
+	g.mu.Unlock()
+
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Start.Before(calls[j].Start) })
+	return calls
+}
+
+// InFlight returns the origin fetches in progress, oldest first, to debug stuck origins. Fetches are only tracked
+// when coalescing is enabled, see DisableCoalescing.
+func (r Cache) InFlight() []InFlight {
+	if r.flights == nil {
+		return nil
+	}
+	return r.flights.list()
+}
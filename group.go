@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const groupKeyPrefix = "group:"
+
+// GroupOptions configures a group, see Group. Zero values keep the options of the parent cache.
+type GroupOptions struct {
+	StatusTTLs        StatusTTLs    // default lifetimes by status code, see Cache.StatusTTLs
+	SlidingExpiration time.Duration // see Cache.SlidingExpiration
+	StaleIfError      time.Duration // see Cache.StaleIfError
+	Policy            *Policy       // policy rules of the group, replacing those of the parent cache
+}
+
+type groupRegistry struct {
+	mu     sync.Mutex
+	groups map[string]*Cache
+}
+
+func newGroupRegistry() *groupRegistry {
+	return &groupRegistry{groups: make(map[string]*Cache)}
+}
+
+// Group returns a view of the cache for a logical group of requests, such as "geo" or "pricing". The group shares the
+// provider, the HTTP client and the background workers of the cache, but stores its entries in its own key namespace
+// and has its own defaults, policy and statistics. Calls made through a group also count in the statistics of the
+// cache.
+//
+// The group is created by the first call for name, with opts; later calls return the same group and ignore opts.
+// Keys of a group contain "group:<name>:", after the tenant and partition prefixes if any: PurgeGroup removes a group
+// whatever the tenants and partitions of its entries.
+func (r Cache) Group(name string, opts GroupOptions) *Cache {
+	if r.groups == nil {
+		// not built with New
+		return r.newGroup(name, opts)
+	}
+	r.groups.mu.Lock()
+	defer r.groups.mu.Unlock()
+
+	if g, ok := r.groups.groups[name]; ok {
+		return g
+	}
+	g := r.newGroup(name, opts)
+	r.groups.groups[name] = g
+	return g
+}
+
+func (r Cache) newGroup(name string, opts GroupOptions) *Cache {
+	g := r
+	g.groupPrefix = r.groupPrefix + groupKeyPrefix + url.QueryEscape(name) + ":"
+	g.groups = newGroupRegistry()
+	g.counters = &counters{latencies: newLatencies(), endpoints: newEndpointLatencies(), parent: r.counters}
+	if opts.StatusTTLs != nil {
+		g.StatusTTLs = opts.StatusTTLs
+	}
+	if opts.SlidingExpiration != 0 {
+		g.SlidingExpiration = opts.SlidingExpiration
+	}
+	if opts.StaleIfError != 0 {
+		g.StaleIfError = opts.StaleIfError
+	}
+	if opts.Policy != nil {
+		g.Policy = opts.Policy
+	}
+	return &g
+}
+
+// PurgeGroup removes every entry of the group called name, see Group, whatever its tenant and partition, returning the
+// number of removed entries. Requires a provider implementing both Scanner and Deleter. With Routes, every provider is
+// purged.
+func (r Cache) PurgeGroup(ctx context.Context, name string) (int, error) {
+	prefix := r.groupPrefix + groupKeyPrefix + url.QueryEscape(name) + ":"
+	var deleted int
+	for _, c := range r.perProvider() {
+		n, err := c.purgeGroup(ctx, prefix)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func (r Cache) purgeGroup(ctx context.Context, prefix string) (int, error) {
+	scanner, ok := r.currentProvider().(Scanner)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Scanner", ErrNotSupported, r.providerName())
+	}
+	if _, ok := r.currentProvider().(Deleter); !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Deleter", ErrNotSupported, r.providerName())
+	}
+
+	var deleted int
+	err := scanner.Scan(ctx, r.Namespace, func(key string) error {
+		if r.internalKey(key) || !strings.HasPrefix(unscoped(strings.TrimPrefix(key, r.Namespace)), prefix) {
+			return nil
+		}
+		if err := r.invalidate(ctx, key); err != nil {
+			return err
+		}
+		deleted++
+		return nil
+	})
+	if err != nil {
+		return deleted, providerError("scan", r.Namespace, err)
+	}
+	return deleted, nil
+}
+
+// unscoped returns key without its tenant and partition prefixes, see tenantKey and partitionKey.
+func unscoped(key string) string {
+	for _, prefix := range []string{tenantKeyPrefix, partitionKeyPrefix} {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if _, rest, ok := strings.Cut(key[len(prefix):], ":"); ok {
+			key = rest
+		}
+	}
+	return key
+}
@@ -0,0 +1,244 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR 1.2 document, limited to the fields the cache can fill in. See http://www.softwareishard.com/blog/har-12-spec/.
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []struct{}  `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []struct{}  `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	Content     harContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func writeHAR(w io.Writer, entries []harEntry) error {
+	var doc harLog
+	doc.Log.Version = "1.2"
+	doc.Log.Creator = harCreator{Name: "github.com/lsmoura/cache", Version: "1"}
+	doc.Log.Entries = entries
+	if doc.Log.Entries == nil {
+		doc.Log.Entries = []harEntry{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func newHarEntry(method string, rawURL string, reqHeader http.Header, statusCode int, respHeader http.Header, body []byte, start time.Time, duration time.Duration) harEntry {
+	ms := float64(duration) / float64(time.Millisecond)
+	entry := harEntry{
+		StartedDateTime: start,
+		Time:            ms,
+		Request: harRequest{
+			Method:      method,
+			URL:         rawURL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []struct{}{},
+			Headers:     harHeaders(reqHeader),
+			QueryString: []harHeader{},
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: harResponse{
+			Status:      statusCode,
+			StatusText:  http.StatusText(statusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []struct{}{},
+			Headers:     harHeaders(respHeader),
+			Content:     harContent{Size: len(body), MimeType: respHeader.Get("Content-Type")},
+			RedirectURL: respHeader.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(body),
+		},
+		Timings: harTimings{Send: 0, Wait: ms, Receive: 0},
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		entry.Request.QueryString = harHeaders(http.Header(u.Query()))
+	}
+	if utf8.Valid(body) {
+		entry.Response.Content.Text = string(body)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+		entry.Response.Content.Encoding = "base64"
+	}
+	return entry
+}
+
+// harHeaders flattens h into name/value pairs, sorted by name.
+func harHeaders(h http.Header) []harHeader {
+	headers := make([]harHeader, 0, len(h))
+	for name, values := range h {
+		for _, value := range values {
+			headers = append(headers, harHeader{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+// ExportHAR writes every entry stored by the provider to w as a HAR file, returning the number of exported entries.
+// Entries are exported as GET requests to their key, which is only a meaningful URL without a custom KeyGenerator or
+// KeyHash. Requires a provider implementing Scanner.
+func (r Cache) ExportHAR(ctx context.Context, w io.Writer) (int, error) {
+	scanner, ok := r.currentProvider().(Scanner)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Scanner", ErrNotSupported, r.providerName())
+	}
+
+	var entries []harEntry
+	err := scanner.Scan(ctx, r.Namespace, func(key string) error {
+		if r.internalKey(key) {
+			return nil
+		}
+		entry, err := r.read(ctx, key)
+		if err != nil && !errors.Is(err, ErrCacheExpired) && !errors.Is(err, ErrCacheExpiryIgnored) {
+			return err
+		}
+		if entry == nil || entry.isIndex() {
+			return nil
+		}
+		header := http.Header{}
+		for name, value := range entry.Headers {
+			header.Set(name, value)
+		}
+		he := newHarEntry(http.MethodGet, key, nil, entry.StatusCode, header, entry.Data, entry.Ts, entry.Delta)
+		he.Comment = "cached entry"
+		entries = append(entries, he)
+		return nil
+	})
+	if err != nil {
+		return len(entries), providerError("scan", r.Namespace, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].StartedDateTime.Before(entries[j].StartedDateTime) })
+
+	return len(entries), writeHAR(w, entries)
+}
+
+// HARRecorder is an HttpRequester recording the requests it forwards, to be written as a HAR file. Wrap a Cache with
+// it to record the traffic answered by Do, or set it as the HttpClient of a cache to record origin traffic only.
+// Response bodies are buffered in memory.
+type HARRecorder struct {
+	next HttpRequester
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+// NewHARRecorder returns a recorder forwarding requests to next.
+func NewHARRecorder(next HttpRequester) *HARRecorder {
+	return &HARRecorder{next: next}
+}
+
+// Do forwards req to the wrapped requester and records the exchange.
+func (h *HARRecorder) Do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := h.next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if resp.Body != nil {
+		body, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("io.ReadAll(): %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	entry := newHarEntry(req.Method, req.URL.String(), req.Header, resp.StatusCode, resp.Header, body, start, time.Since(start))
+
+	h.mu.Lock()
+	h.entries = append(h.entries, entry)
+	h.mu.Unlock()
+
+	return resp, nil
+}
+
+// WriteHAR writes the recorded exchanges to w as a HAR file.
+func (h *HARRecorder) WriteHAR(w io.Writer) error {
+	h.mu.Lock()
+	entries := append([]harEntry(nil), h.entries...)
+	h.mu.Unlock()
+
+	return writeHAR(w, entries)
+}
+
+// Reset discards the recorded exchanges.
+func (h *HARRecorder) Reset() {
+	h.mu.Lock()
+	h.entries = nil
+	h.mu.Unlock()
+}
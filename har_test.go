@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHARRecorder(t *testing.T) {
+	const cacheURL = "http://example.com/?q=1"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Content-Type": "text/plain",
+					"Expires":      time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	c := New(memoryprovider.New())
+	c.HttpClient = &requester
+	recorder := NewHARRecorder(c)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+		require.NoError(t, err, "http.NewRequest")
+		resp, err := recorder.Do(req)
+		require.NoError(t, err, "recorder.Do")
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Hello World", string(body), "Expected the body to survive recording")
+	}
+	require.Equal(t, 1, requester.requestCount)
+
+	var buf bytes.Buffer
+	require.NoError(t, recorder.WriteHAR(&buf))
+	var doc harLog
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	require.Equal(t, "1.2", doc.Log.Version)
+	require.Len(t, doc.Log.Entries, 2)
+	require.Equal(t, cacheURL, doc.Log.Entries[0].Request.URL)
+	require.Equal(t, []harHeader{{Name: "q", Value: "1"}}, doc.Log.Entries[0].Request.QueryString)
+	require.Equal(t, "Hello World", doc.Log.Entries[1].Response.Content.Text)
+	require.Equal(t, "text/plain", doc.Log.Entries[1].Response.Content.MimeType)
+
+	buf.Reset()
+	n, err := c.ExportHAR(context.Background(), &buf)
+	require.NoError(t, err, "cache.ExportHAR")
+	require.Equal(t, 1, n)
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	require.Len(t, doc.Log.Entries, 1)
+	require.Equal(t, 200, doc.Log.Entries[0].Response.Status)
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Event describes a finished call to Do.
+type Event struct {
+	Start      time.Time
+	Method     string
+	URL        string
+	Key        string        // cache key, empty if the request bypassed the cache
+	Status     CacheStatus   // how the call was answered, empty if the request bypassed the cache
+	Source     LatencySource // where the response came from
+	Endpoint   string        // Name of the policy rule matching the request, if any
+	StatusCode int           // status code of the returned response, or 0 on error
+	Size       int64         // length of the returned response body, or -1 if unknown, as on error
+	Duration   time.Duration // time spent in Do
+	Err        error
+}
+
+// Hooks are called on cache activity, to feed metrics and other observers. Hooks must be safe for concurrent use and
+// should return quickly, as they are called on the response path. Nil hooks are skipped.
+type Hooks struct {
+	OnDo            func(ctx context.Context, event Event)
+	OnStore         func(ctx context.Context, key string, size int) // an entry of size bytes was written to the provider
+	OnProviderError func(ctx context.Context, op string, err error) // a provider operation ("get", "set", ...) failed
+}
+
+// MergeHooks returns hooks calling each of the given hooks, in order.
+func MergeHooks(hooks ...Hooks) Hooks {
+	var merged Hooks
+	for _, h := range hooks {
+		h := h
+		if h.OnDo != nil {
+			prev := merged.OnDo
+			merged.OnDo = func(ctx context.Context, event Event) {
+				if prev != nil {
+					prev(ctx, event)
+				}
+				h.OnDo(ctx, event)
+			}
+		}
+		if h.OnStore != nil {
+			prev := merged.OnStore
+			merged.OnStore = func(ctx context.Context, key string, size int) {
+				if prev != nil {
+					prev(ctx, key, size)
+				}
+				h.OnStore(ctx, key, size)
+			}
+		}
+		if h.OnProviderError != nil {
+			prev := merged.OnProviderError
+			merged.OnProviderError = func(ctx context.Context, op string, err error) {
+				if prev != nil {
+					prev(ctx, op, err)
+				}
+				h.OnProviderError(ctx, op, err)
+			}
+		}
+	}
+	return merged
+}
+
+// recordDo updates the statistics and calls the hooks for a finished call to Do.
+func (r Cache) recordDo(ctx context.Context, event Event) {
+	for c := r.counters; c != nil; c = c.parent {
+		c.requests.Add(1)
+		switch {
+		case event.Err != nil:
+			c.errors.Add(1)
+		case event.Status.FromCache():
+			c.hits.Add(1)
+		case event.Status != "":
+			c.misses.Add(1)
+		}
+		if event.Err == nil && c.latencies != nil {
+			c.latencies.observe(event.Source, event.Duration)
+		}
+		if event.Err == nil && event.Endpoint != "" && c.endpoints != nil {
+			c.endpoints.observe(event.Endpoint, event.Source, event.Duration)
+		}
+	}
+	if tenant := Tenant(ctx); tenant != "" && r.tenants != nil {
+		r.tenants.recordDo(tenant, event)
+	}
+	if r.Hooks.OnDo != nil {
+		r.Hooks.OnDo(ctx, event)
+	}
+}
+
+func (r Cache) recordStore(ctx context.Context, key string, size int) {
+	if r.Hooks.OnStore != nil {
+		r.Hooks.OnStore(ctx, key, size)
+	}
+}
+
+func (r Cache) recordProviderError(ctx context.Context, op string, err error) {
+	for c := r.counters; c != nil; c = c.parent {
+		c.providerErrors.Add(1)
+	}
+	if r.Hooks.OnProviderError != nil {
+		r.Hooks.OnProviderError(ctx, op, err)
+	}
+}
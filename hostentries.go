@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// limitHostIndex removes the oldest keys of a host index, along with their entries, until at most limit keys are
+// left. Keys listed in keep, just stored, are never removed.
+func (r Cache) limitHostIndex(ctx context.Context, index *keyIndex, limit int, keep []string) {
+	if limit <= 0 || len(index.Keys) <= limit {
+		return
+	}
+	excess := len(index.Keys) - limit
+	kept := index.Keys[:0]
+	for _, key := range index.Keys {
+		if excess == 0 || containsKey(keep, key) {
+			kept = append(kept, key)
+			continue
+		}
+		if _, err := r.invalidateVariants(ctx, key); err != nil {
+			r.logError(ctx, "error removing entry over the host limit", "key", key, "provider", r.providerName(), "error", err)
+			kept = append(kept, key)
+			continue
+		}
+		r.logDebug(ctx, "entry removed over the host limit", "key", key, "max-entries", limit)
+		excess--
+	}
+	index.Keys = kept
+}
+
+// hostEntries gathers the entries of the hosts limited by MaxEntriesPerHost while sweeping.
+type hostEntries struct {
+	limit int
+	keys  []string
+	ts    []time.Time
+}
+
+func (h *hostEntries) Len() int           { return len(h.keys) }
+func (h *hostEntries) Less(i, j int) bool { return h.ts[i].After(h.ts[j]) }
+func (h *hostEntries) Swap(i, j int) {
+	h.keys[i], h.keys[j] = h.keys[j], h.keys[i]
+	h.ts[i], h.ts[j] = h.ts[j], h.ts[i]
+}
+
+// trackHostEntry records the entry stored under key if its host is limited by MaxEntriesPerHost.
+func (r Cache) trackHostEntry(hosts map[string]*hostEntries, key string, entry *cacheEntry) {
+	u, err := url.Parse(entry.URL)
+	if err != nil || entry.URL == "" {
+		return
+	}
+	limit := r.Policy.match(u).maxEntriesPerHost()
+	if limit <= 0 {
+		return
+	}
+	host := entryHost(entry)
+	h, ok := hosts[host]
+	if !ok {
+		h = &hostEntries{limit: limit}
+		hosts[host] = h
+	}
+	h.keys = append(h.keys, key)
+	h.ts = append(h.ts, entry.Ts)
+}
+
+// overHostLimits returns the keys of the oldest entries of the hosts storing more entries than allowed.
+func overHostLimits(hosts map[string]*hostEntries) []string {
+	var keys []string
+	for _, h := range hosts {
+		if h.Len() <= h.limit {
+			continue
+		}
+		sort.Sort(h)
+		keys = append(keys, h.keys[h.limit:]...)
+	}
+	return keys
+}
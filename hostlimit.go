@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HostLimit caps the number of concurrent origin requests per host.
+type HostLimit struct {
+	MaxConcurrent int // maximum number of concurrent origin requests per host
+
+	// MaxWait is how long excess requests wait for a slot before failing with ErrHostLimit. Zero waits for as long
+	// as the request context allows, a negative value sheds excess requests immediately.
+	MaxWait time.Duration
+}
+
+type hostLimiter struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter() *hostLimiter {
+	return &hostLimiter{slots: make(map[string]chan struct{})}
+}
+
+func (h *hostLimiter) sem(host string, size int) chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sem, ok := h.slots[host]
+	if !ok {
+		sem = make(chan struct{}, size)
+		h.slots[host] = sem
+	}
+	return sem
+}
+
+// acquire takes a slot for host, according to cfg. The returned release function must be called exactly once.
+func (h *hostLimiter) acquire(ctx context.Context, host string, cfg HostLimit) (release func(), err error) {
+	sem := h.sem(host, cfg.MaxConcurrent)
+	release = func() { <-sem }
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if cfg.MaxWait < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrHostLimit, host)
+	}
+
+	var timeout <-chan time.Time
+	if cfg.MaxWait > 0 {
+		timer := time.NewTimer(cfg.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, fmt.Errorf("%w: %s", ErrHostLimit, host)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releaseOnClose releases a host slot once the response body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// roundTrip sends req to the origin, honouring the per-host concurrency limit.
+func (r Cache) roundTrip(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if r.HostLimit == nil || r.HostLimit.MaxConcurrent <= 0 || r.hosts == nil {
+		return r.httpClient(ctx).Do(req)
+	}
+
+	release, err := r.hosts.acquire(ctx, req.URL.Host, *r.HostLimit)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.httpClient(ctx).Do(req)
+	if err != nil || resp.Body == nil {
+		release()
+		return resp, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+
+	return resp, nil
+}
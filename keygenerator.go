@@ -1,7 +1,151 @@
 package cache
 
-import "net/http"
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
 
+// KeyHash selects how generated keys are hashed before being handed to the provider.
+type KeyHash int
+
+const (
+	KeyHashNone         KeyHash = iota // keys are used as generated
+	KeyHashSHA256Hex                   // keys are replaced by their hex encoded SHA-256 digest
+	KeyHashSHA256Base64                // keys are replaced by their unpadded, url-safe base64 encoded SHA-256 digest
+)
+
+func (h KeyHash) apply(key string) string {
+	switch h {
+	case KeyHashSHA256Hex:
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	case KeyHashSHA256Base64:
+		sum := sha256.Sum256([]byte(key))
+		return base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	return key
+}
+
+// processKeySecret is the KeySecret of the caches without one.
+var processKeySecret = func() []byte {
+	secret := make([]byte, sha256.Size)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("cache: drawing key secret: %v", err))
+	}
+	return secret
+}()
+
+// keyDigest returns the hex encoded HMAC-SHA256 digest of a credential put in keys, keyed by KeySecret.
+func (r Cache) keyDigest(value string) string {
+	secret := r.KeySecret
+	if len(secret) == 0 {
+		secret = processKeySecret
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authorizationScope appends a digest of the principal of req to key: the principal set with WithPrincipal, or else the
+// Authorization header. Anonymous requests keep their key.
+func (r Cache) authorizationScope(key string, req *http.Request) string {
+	principal := Principal(req.Context())
+	if principal == "" {
+		principal = req.Header.Get("Authorization")
+	}
+	if principal == "" {
+		return key
+	}
+	return key + "|auth:" + r.keyDigest(principal)
+}
+
+// TrailingSlash controls how trailing slashes are normalized when canonicalizing URLs.
+type TrailingSlash int
+
+const (
+	TrailingSlashKeep  TrailingSlash = iota // paths are left as they are
+	TrailingSlashStrip                      // trailing slashes are removed, except for the root path
+	TrailingSlashAdd                        // a trailing slash is added to every path
+)
+
+// CanonicalOptions configures URL canonicalization.
+type CanonicalOptions struct {
+	TrailingSlash TrailingSlash
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// CanonicalURL returns a normalized representation of u: scheme and host are lowercased, default ports are dropped,
+// dot segments are resolved, an empty path becomes "/" and the fragment is removed.
+func CanonicalURL(u *url.URL, opts CanonicalOptions) string {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	c.Fragment = ""
+	c.RawFragment = ""
+
+	host := strings.ToLower(c.Host)
+	if h, port, err := net.SplitHostPort(host); err == nil && defaultPorts[c.Scheme] == port {
+		host = h
+		if strings.Contains(host, ":") {
+			// IPv6 literal
+			host = "[" + host + "]"
+		}
+	}
+	c.Host = host
+
+	if c.Opaque != "" {
+		return c.String()
+	}
+
+	p := c.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	trailing := strings.HasSuffix(p, "/")
+	p = path.Clean(p)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	switch opts.TrailingSlash {
+	case TrailingSlashKeep:
+		if trailing && p != "/" {
+			p += "/"
+		}
+	case TrailingSlashAdd:
+		if p != "/" {
+			p += "/"
+		}
+	}
+
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		c.Path = unescaped
+		c.RawPath = p
+	}
+
+	return c.String()
+}
+
+// CanonicalKeyGenerator returns a KeyGenerator using the canonical form of the request URL as the key.
+func CanonicalKeyGenerator(opts CanonicalOptions) KeyGenerator {
+	return func(req *http.Request) string {
+		return CanonicalURL(req.URL, opts)
+	}
+}
+
+// DefaultKeyGenerator uses the canonical request URL as the key, keeping trailing slashes as requested.
 func DefaultKeyGenerator(req *http.Request) string {
-	return req.URL.String()
+	return CanonicalURL(req.URL, CanonicalOptions{})
 }
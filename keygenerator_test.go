@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts CanonicalOptions
+		want string
+	}{
+		{in: "http://example.com", want: "http://example.com/"},
+		{in: "http://example.com:80/", want: "http://example.com/"},
+		{in: "HTTP://Example.COM/Path", want: "http://example.com/Path"},
+		{in: "https://example.com:443/a", want: "https://example.com/a"},
+		{in: "https://example.com:8443/a", want: "https://example.com:8443/a"},
+		{in: "http://example.com:443/a", want: "http://example.com:443/a"},
+		{in: "http://[::1]:80/a", want: "http://[::1]/a"},
+		{in: "http://example.com/a/./b/../c", want: "http://example.com/a/c"},
+		{in: "http://example.com/a/b/", want: "http://example.com/a/b/"},
+		{in: "http://example.com/a%20b?q=1#frag", want: "http://example.com/a%20b?q=1"},
+		{in: "http://example.com/a/b/", opts: CanonicalOptions{TrailingSlash: TrailingSlashStrip}, want: "http://example.com/a/b"},
+		{in: "http://example.com/", opts: CanonicalOptions{TrailingSlash: TrailingSlashStrip}, want: "http://example.com/"},
+		{in: "http://example.com/a/b", opts: CanonicalOptions{TrailingSlash: TrailingSlashAdd}, want: "http://example.com/a/b/"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		require.NoError(t, err, "url.Parse")
+		require.Equal(t, tt.want, CanonicalURL(u, tt.opts), tt.in)
+	}
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencySource tells where the response of a call to Do came from, to compare the latency of cached responses with
+// the latency of the origin.
+type LatencySource string
+
+const (
+	SourceCache        LatencySource = "cache"        // served from the cache, see CacheStatus.FromCache
+	SourceRevalidation LatencySource = "revalidation" // an entry was revalidated by a 304 origin response
+	SourceOrigin       LatencySource = "origin"       // fully fetched from the origin, expired entries included
+)
+
+// source returns where the response of the call came from.
+func (i callInfo) source() LatencySource {
+	switch {
+	case i.stat.FromCache():
+		return SourceCache
+	case i.revalidated:
+		return SourceRevalidation
+	}
+	return SourceOrigin
+}
+
+// LatencyBuckets are the upper bounds of the buckets of the latency histograms of Stats.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram is a snapshot of the latencies of successful calls to Do.
+type LatencyHistogram struct {
+	Count   int64         `json:"count"`
+	Sum     time.Duration `json:"sum"`
+	Buckets []int64       `json:"buckets"` // cumulative number of calls at or under each of LatencyBuckets
+}
+
+// Mean returns the mean latency, or 0 if there were no calls.
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+type latencyHistogram struct {
+	count   atomic.Int64
+	sum     atomic.Int64
+	buckets []atomic.Int64 // non-cumulative, the last one counts calls over every bound
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{buckets: make([]atomic.Int64, len(LatencyBuckets)+1)}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	s := LatencyHistogram{
+		Count:   h.count.Load(),
+		Sum:     time.Duration(h.sum.Load()),
+		Buckets: make([]int64, len(LatencyBuckets)),
+	}
+	var total int64
+	for i := range s.Buckets {
+		total += h.buckets[i].Load()
+		s.Buckets[i] = total
+	}
+	return s
+}
+
+// latencies holds a histogram per LatencySource.
+type latencies struct {
+	cache        *latencyHistogram
+	revalidation *latencyHistogram
+	origin       *latencyHistogram
+}
+
+func newLatencies() *latencies {
+	return &latencies{
+		cache:        newLatencyHistogram(),
+		revalidation: newLatencyHistogram(),
+		origin:       newLatencyHistogram(),
+	}
+}
+
+func (l *latencies) observe(source LatencySource, d time.Duration) {
+	switch source {
+	case SourceCache:
+		l.cache.observe(d)
+	case SourceRevalidation:
+		l.revalidation.observe(d)
+	default:
+		l.origin.observe(d)
+	}
+}
+
+// EndpointLatency holds the latency histograms of the calls to an endpoint, by LatencySource.
+type EndpointLatency struct {
+	Cache        LatencyHistogram `json:"cache"`
+	Revalidation LatencyHistogram `json:"revalidation"`
+	Origin       LatencyHistogram `json:"origin"`
+}
+
+// endpointLatencies holds the latencies of each endpoint, named after the policy rules.
+type endpointLatencies struct {
+	mu        sync.Mutex
+	endpoints map[string]*latencies
+}
+
+func newEndpointLatencies() *endpointLatencies {
+	return &endpointLatencies{endpoints: make(map[string]*latencies)}
+}
+
+func (e *endpointLatencies) observe(endpoint string, source LatencySource, d time.Duration) {
+	e.mu.Lock()
+	l, ok := e.endpoints[endpoint]
+	if !ok {
+		l = newLatencies()
+		e.endpoints[endpoint] = l
+	}
+	e.mu.Unlock()
+	l.observe(source, d)
+}
+
+// snapshot returns the histograms of every endpoint, or nil if there are none.
+func (e *endpointLatencies) snapshot() map[string]EndpointLatency {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.endpoints) == 0 {
+		return nil
+	}
+	s := make(map[string]EndpointLatency, len(e.endpoints))
+	for endpoint, l := range e.endpoints {
+		s[endpoint] = EndpointLatency{
+			Cache:        l.cache.snapshot(),
+			Revalidation: l.revalidation.snapshot(),
+			Origin:       l.origin.snapshot(),
+		}
+	}
+	return s
+}
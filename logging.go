@@ -1,6 +1,9 @@
 package cache
 
-import "context"
+import (
+	"context"
+	"fmt"
+)
 
 type Logger interface {
 	Debug(msg string, params ...any)
@@ -8,6 +11,13 @@ type Logger interface {
 	Error(msg string, params ...any)
 }
 
+// LoggerWither is an optional interface for loggers able to carry key-value pairs on every message. When a logger
+// implements it, the cache uses With to attach fields instead of accumulating them itself.
+type LoggerWither interface {
+	Logger
+	With(params ...any) Logger
+}
+
 type LoggerExtractor func(ctx context.Context) Logger
 
 type logLevel int
@@ -50,9 +60,16 @@ func (l *internalLogger) Error(msg string, keyvals ...any) {
 }
 
 func (l *internalLogger) With(keyvals ...any) *internalLogger {
+	if wither, ok := l.logger.(LoggerWither); ok {
+		return &internalLogger{
+			logger:  wither.With(keyvals...),
+			keyvals: l.keyvals,
+		}
+	}
+
 	return &internalLogger{
 		logger:  l.logger,
-		keyvals: append(l.keyvals, keyvals...),
+		keyvals: append(l.keyvals[:len(l.keyvals):len(l.keyvals)], keyvals...),
 	}
 }
 
@@ -71,10 +88,7 @@ func (r Cache) log(ctx context.Context, level logLevel, msg string, keyvalues ..
 	if r.LogExtractor == nil {
 		return
 	}
-	logger := r.LogExtractor(ctx)
-	if logger == nil {
-		return
-	}
+	logger := r.logger(ctx)
 
 	switch level {
 	case logLevelDebug:
@@ -88,6 +102,7 @@ func (r Cache) log(ctx context.Context, level logLevel, msg string, keyvalues ..
 	}
 }
 
+// logger returns the logger of the call, carrying the fields attached with WithLogFields.
 func (r Cache) logger(ctx context.Context) Logger {
 	if r.LogExtractor == nil {
 		return nilLogger
@@ -96,6 +111,12 @@ func (r Cache) logger(ctx context.Context) Logger {
 	if logger == nil {
 		return nilLogger
 	}
+	if fields := LogFields(ctx); len(fields) > 0 {
+		if wither, ok := logger.(LoggerWither); ok {
+			return wither.With(fields...)
+		}
+		return &internalLogger{logger: logger, keyvals: fields}
+	}
 
 	return logger
 }
@@ -111,3 +132,8 @@ func (r Cache) logInfo(ctx context.Context, msg string, keyvalues ...any) {
 func (r Cache) logError(ctx context.Context, msg string, keyvalues ...any) {
 	r.log(ctx, logLevelError, msg, keyvalues...)
 }
+
+// providerName describes the provider in log entries.
+func (r Cache) providerName() string {
+	return fmt.Sprintf("%T", r.currentProvider())
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/lsmoura/cache/memoryprovider"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"log/slog"
 	"net/http"
 	"strings"
 	"testing"
@@ -150,3 +151,173 @@ func TestInternalLogger(t *testing.T) {
 	event.Info("hello world2")
 	assert.Equal(t, buf.String(), "INFO hello world2 key=value\n")
 }
+
+func TestSlogExtractor(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	var fallback, scoped bytes.Buffer
+	cache.LogExtractor = SlogExtractor(slog.New(slog.NewTextHandler(&fallback, nil)))
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err)
+	_, err = cache.Do(req)
+	require.NoError(t, err)
+	assert.Contains(t, fallback.String(), "cache=miss")
+
+	ctx := WithSlogLogger(context.Background(), slog.New(slog.NewTextHandler(&scoped, nil)).With("request_id", "abc"))
+	_, err = cache.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	assert.Contains(t, scoped.String(), "request_id=abc")
+	assert.Contains(t, scoped.String(), "cache=hit")
+	assert.NotContains(t, fallback.String(), "cache=hit")
+}
+
+func TestLogging_CorruptedEntry(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	provider := memoryprovider.New()
+	require.NoError(t, provider.Set(context.Background(), cacheURL, []byte("not json"), 0))
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {Ts: time.Now(), StatusCode: 200, Data: []byte("Hello World")},
+		},
+	}
+	cache := New(provider)
+	cache.HttpClient = &requester
+
+	logger := fakeLogger{buf: &bytes.Buffer{}}
+	cache.LogExtractor = func(context.Context) Logger { return &logger }
+
+	req, err := http.NewRequest(http.MethodGet, cacheURL, nil)
+	require.NoError(t, err)
+	_, err = cache.Do(req)
+	require.NoError(t, err)
+
+	assert.Contains(t, logger.String(), "ERROR error unmarshalling cache entry key=http://example.com/ provider=*memoryprovider.MemoryProvider")
+	assert.Contains(t, logger.String(), "cache=miss")
+}
+
+// fakeWitherLogger is a fakeLogger implementing LoggerWither.
+type fakeWitherLogger struct {
+	*fakeLogger
+	withCalls *int
+}
+
+func (l fakeWitherLogger) With(params ...any) Logger {
+	*l.withCalls++
+	return fakeWitherLogger{
+		fakeLogger: &fakeLogger{buf: l.buf, params: append(l.params[:len(l.params):len(l.params)], params...)},
+		withCalls:  l.withCalls,
+	}
+}
+
+func TestInternalLogger_Wither(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	var withCalls int
+	logger := &internalLogger{logger: fakeWitherLogger{fakeLogger: &fakeLogger{buf: &buf}, withCalls: &withCalls}}
+
+	event := logger.With("key", "value")
+	sibling := event.With("sibling", "value")
+	event = event.With("key2", "value2")
+	event.Info("hello world", "key3", "value3")
+
+	assert.Equal(t, 3, withCalls, "Expected With to be delegated to the logger")
+	assert.Equal(t, "INFO hello world key=value key2=value2 key3=value3\n", buf.String())
+
+	buf.Reset()
+	sibling.Info("hello world")
+	assert.Equal(t, "INFO hello world key=value sibling=value\n", buf.String())
+}
+
+func TestInternalLogger_Siblings(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger := (&internalLogger{logger: &fakeLogger{buf: &buf}}).With("a", 1)
+
+	first := logger.With("b", 2)
+	second := logger.With("c", 3)
+
+	first.Info("first")
+	second.Info("second")
+	assert.Equal(t, "INFO first a=1 b=2\nINFO second a=1 c=3\n", buf.String())
+}
+
+func TestWithLogFields(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+			},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	logger := fakeLogger{buf: &bytes.Buffer{}}
+	cache.LogExtractor = func(context.Context) Logger { return &logger }
+
+	ctx := WithLogFields(WithLogFields(context.Background(), "request_id", "abc"), "trace_id", "123")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+	require.NoError(t, err)
+	_, err = cache.Do(req)
+	require.NoError(t, err)
+
+	for _, line := range strings.Split(strings.TrimSpace(logger.String()), "\n") {
+		assert.Contains(t, line, "request_id=abc trace_id=123")
+	}
+	assert.Contains(t, logger.String(), "cache=miss")
+}
+
+func TestCache_KeyCollisionCheck(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Format(time.RFC1123)
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			"http://example.com/a": {StatusCode: 200, Data: []byte("a"), Headers: map[string]string{"Expires": expires}},
+			"http://example.com/b": {StatusCode: 200, Data: []byte("b"), Headers: map[string]string{"Expires": expires}},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.KeyGenerator = func(req *http.Request) string { return strings.ToLower(req.URL.Hostname()) }
+	cache.KeyCollisionCheck = true
+
+	logger := fakeLogger{buf: &bytes.Buffer{}}
+	cache.LogExtractor = func(context.Context) Logger { return &logger }
+
+	for _, u := range []string{"http://example.com/a", "http://EXAMPLE.com:80/a"} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		_, err = cache.Do(req)
+		require.NoError(t, err)
+	}
+	assert.NotContains(t, logger.String(), "collision", "Expected equivalent URLs not to be reported")
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/b", nil)
+	require.NoError(t, err)
+	_, err = cache.Do(req)
+	require.NoError(t, err)
+	assert.Contains(t, logger.String(), "ERROR cache key collision key=example.com stored-url=http://example.com/a requested-url=http://example.com/b")
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// neverExpires is the expiry of values stored without a TTL.
+var neverExpires = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// GetOrSet returns the value stored under key, calling fn to compute and store it when it is missing or expired.
+// Values are stored for ttl, or without expiry if ttl is zero or negative. Concurrent calls for the same key share a
+// single call to fn unless DisableCoalescing is set. Errors returned by fn are not cached.
+//
+// Values are stored alongside HTTP responses, so keys must not collide with the keys of cached requests.
+func (r Cache) GetOrSet(ctx context.Context, key string, ttl time.Duration, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	key = r.namespaced(tenantKey(ctx, key))
+	entry, err := r.read(ctx, key)
+	if err == nil && entry != nil {
+		return entry.Data, nil
+	}
+	if err != nil && !errors.Is(err, ErrCacheExpired) && !errors.Is(err, ErrCacheExpiryIgnored) {
+		r.logError(ctx, "error reading value", "key", key, "error", err)
+		if r.ReadFailurePolicy == FailureStrict {
+			return nil, err
+		}
+	}
+
+	compute := func(ctx context.Context) (*fetchResult, error) {
+		start := time.Now()
+		data, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		e := r.valueEntry(data, ttl, start)
+		if err := r.write(ctx, key, e); err != nil {
+			if r.WriteFailurePolicy == FailureStrict {
+				return nil, err
+			}
+			// the value is still good, it just won't be cached
+			r.logError(ctx, "error storing value", "key", key, "error", err)
+		}
+		return &fetchResult{entry: e}, nil
+	}
+
+	var result *fetchResult
+	if r.DisableCoalescing || r.flights == nil {
+		result, err = compute(ctx)
+	} else {
+		result, _, err = r.flights.do(ctx, key, "", 0, compute)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.entry.Data, nil
+}
+
+// valueEntry builds the entry storing data for ttl. start is the moment the computation of the value started.
+func (r Cache) valueEntry(data []byte, ttl time.Duration, start time.Time) *cacheEntry {
+	now := r.now()
+	e := &cacheEntry{Ts: now, StatusCode: http.StatusOK, Data: data, Delta: time.Since(start), Expires: neverExpires}
+	if ttl > 0 {
+		e.Expires = now.Add(ttl)
+		if r.TTLJitter > 0 {
+			e.Expires = jitter(e.Expires, now, r.TTLJitter)
+		}
+	}
+	return e
+}
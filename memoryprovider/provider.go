@@ -1,37 +1,267 @@
 package memoryprovider
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
+type item struct {
+	value   []byte
+	expires time.Time
+}
+
+func (i item) expired(now time.Time) bool {
+	return !i.expires.IsZero() && !now.Before(i.expires)
+}
+
+// EvictReason tells why an item was removed from the provider.
+type EvictReason string
+
+const (
+	EvictExpired EvictReason = "expired" // the item outlived its expiry
+	EvictDeleted EvictReason = "deleted" // the item was deleted
+)
+
 type MemoryProvider struct {
-	data map[string][]byte
+	mu    sync.RWMutex
+	data  map[string]item
+	bytes int64 // size of the stored values
+
+	// OnEvict, if set, is called after an item is removed, with the reason of the removal. Expired items are removed
+	// when read or on Sweep. It must be set before the provider is used.
+	OnEvict func(key string, value []byte, reason EvictReason)
+
+	// SnapshotPath, if set, is the file Snapshot writes the items to and Load reads them from, so that they survive
+	// a restart. The cache snapshots its providers on Shutdown.
+	SnapshotPath string
 }
 
 func New() *MemoryProvider {
 	return &MemoryProvider{
-		data: make(map[string][]byte),
+		data: make(map[string]item),
+	}
+}
+
+func newItem(value []byte, expiry time.Duration) item {
+	i := item{value: value}
+	if expiry > 0 {
+		i.expires = time.Now().Add(expiry)
 	}
+	return i
 }
 
 func (p *MemoryProvider) Get(_ context.Context, key string) ([]byte, error) {
+	p.mu.RLock()
 	if p.data == nil {
+		p.mu.RUnlock()
 		return nil, fmt.Errorf("memory provider is not initialized")
 	}
 	data, ok := p.data[key]
+	p.mu.RUnlock()
 	if !ok {
 		return nil, nil
 	}
+	if data.expired(time.Now()) {
+		p.evictExpired(key)
+		return nil, nil
+	}
+
+	return data.value, nil
+}
+
+func (p *MemoryProvider) GetMulti(ctx context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := p.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// evictExpired removes the item stored under key if it is still expired.
+func (p *MemoryProvider) evictExpired(key string) {
+	p.mu.Lock()
+	data, ok := p.data[key]
+	if !ok || !data.expired(time.Now()) {
+		p.mu.Unlock()
+		return
+	}
+	p.remove(key, data)
+	p.mu.Unlock()
+
+	p.notify(key, data.value, EvictExpired)
+}
+
+// store sets the item stored under key. Must be called with the lock held.
+func (p *MemoryProvider) store(key string, i item) {
+	if prev, ok := p.data[key]; ok {
+		p.bytes -= int64(len(prev.value))
+	}
+	p.data[key] = i
+	p.bytes += int64(len(i.value))
+}
+
+// remove deletes the item stored under key. Must be called with the lock held.
+func (p *MemoryProvider) remove(key string, i item) {
+	delete(p.data, key)
+	p.bytes -= int64(len(i.value))
+}
+
+// Size returns the number of bytes of the stored values, including expired items not removed yet.
+func (p *MemoryProvider) Size(_ context.Context) (int64, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.bytes, nil
+}
+
+func (p *MemoryProvider) notify(key string, value []byte, reason EvictReason) {
+	if p.OnEvict != nil {
+		p.OnEvict(key, value, reason)
+	}
+}
+
+// Sweep removes every expired item, returning the number of removed items.
+func (p *MemoryProvider) Sweep() int {
+	type evicted struct {
+		key   string
+		value []byte
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	var removed []evicted
+	for key, data := range p.data {
+		if data.expired(now) {
+			p.remove(key, data)
+			removed = append(removed, evicted{key: key, value: data.value})
+		}
+	}
+	p.mu.Unlock()
+
+	for _, e := range removed {
+		p.notify(e.key, e.value, EvictExpired)
+	}
+	return len(removed)
+}
+
+func (p *MemoryProvider) Set(_ context.Context, key string, value []byte, expiry time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.data == nil {
+		return fmt.Errorf("memory provider is not initialized")
+	}
+	p.store(key, newItem(value, expiry))
+	return nil
+}
+
+func (p *MemoryProvider) SetMulti(ctx context.Context, keys []string, values [][]byte, expiry time.Duration) error {
+	for i, key := range keys {
+		if err := p.Set(ctx, key, values[i], expiry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) SetNX(_ context.Context, key string, value []byte, expiry time.Duration) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.data == nil {
+		return false, fmt.Errorf("memory provider is not initialized")
+	}
+	if current, ok := p.data[key]; ok && !current.expired(time.Now()) {
+		return false, nil
+	}
+	p.store(key, newItem(value, expiry))
+	return true, nil
+}
+
+func (p *MemoryProvider) Touch(_ context.Context, key string, expiry time.Duration) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	return data, nil
+	if p.data == nil {
+		return false, fmt.Errorf("memory provider is not initialized")
+	}
+	current, ok := p.data[key]
+	if !ok || current.expired(time.Now()) {
+		return false, nil
+	}
+	p.data[key] = newItem(current.value, expiry)
+	return true, nil
 }
 
-func (p *MemoryProvider) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+func (p *MemoryProvider) Delete(_ context.Context, key string) error {
+	p.mu.Lock()
+
 	if p.data == nil {
+		p.mu.Unlock()
 		return fmt.Errorf("memory provider is not initialized")
 	}
-	p.data[key] = value
+	data, ok := p.data[key]
+	if ok {
+		p.remove(key, data)
+	}
+	p.mu.Unlock()
+
+	if ok {
+		p.notify(key, data.value, EvictDeleted)
+	}
+	return nil
+}
+
+func (p *MemoryProvider) DeleteIfEqual(_ context.Context, key string, value []byte) (bool, error) {
+	p.mu.Lock()
+
+	if p.data == nil {
+		p.mu.Unlock()
+		return false, fmt.Errorf("memory provider is not initialized")
+	}
+	data, ok := p.data[key]
+	ok = ok && !data.expired(time.Now()) && bytes.Equal(data.value, value)
+	if ok {
+		p.remove(key, data)
+	}
+	p.mu.Unlock()
+
+	if ok {
+		p.notify(key, data.value, EvictDeleted)
+	}
+	return ok, nil
+}
+
+func (p *MemoryProvider) Scan(ctx context.Context, prefix string, fn func(key string) error) error {
+	p.mu.RLock()
+	if p.data == nil {
+		p.mu.RUnlock()
+		return fmt.Errorf("memory provider is not initialized")
+	}
+	now := time.Now()
+	keys := make([]string, 0, len(p.data))
+	for key, data := range p.data {
+		if strings.HasPrefix(key, prefix) && !data.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -2,7 +2,10 @@ package memoryprovider
 
 import (
 	"context"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestMemoryProvider_SetGet(t *testing.T) {
@@ -49,3 +52,202 @@ func TestMemoryProvider_UnsetKey(t *testing.T) {
 		t.Fatal("value should be nil")
 	}
 }
+
+func TestMemoryProvider_Expiry(t *testing.T) {
+	provider := New()
+
+	const testKey = "key"
+	if err := provider.Set(context.Background(), testKey, []byte("value"), time.Millisecond); err != nil {
+		t.Fatal("cannot set value", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	value, err := provider.Get(context.Background(), testKey)
+	if err != nil {
+		t.Fatal("cannot get value", err)
+	}
+	if value != nil {
+		t.Fatal("expired value should be nil")
+	}
+}
+
+func TestMemoryProvider_Touch(t *testing.T) {
+	provider := New()
+	ctx := context.Background()
+
+	const testKey = "key"
+	if ok, err := provider.Touch(ctx, testKey, time.Minute); err != nil || ok {
+		t.Fatal("Touch should not find a missing key", err)
+	}
+	if err := provider.Set(ctx, testKey, []byte("value"), 5*time.Millisecond); err != nil {
+		t.Fatal("cannot set value", err)
+	}
+	if ok, err := provider.Touch(ctx, testKey, time.Minute); err != nil || !ok {
+		t.Fatal("Touch should find the key", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if value, _ := provider.Get(ctx, testKey); string(value) != "value" {
+		t.Fatal("touched value should not expire")
+	}
+}
+
+func TestMemoryProvider_SetNX(t *testing.T) {
+	provider := New()
+	ctx := context.Background()
+
+	const testKey = "key"
+	if ok, err := provider.SetNX(ctx, testKey, []byte("first"), 0); err != nil || !ok {
+		t.Fatal("first SetNX should succeed", err)
+	}
+	if ok, err := provider.SetNX(ctx, testKey, []byte("second"), 0); err != nil || ok {
+		t.Fatal("second SetNX should not set the value", err)
+	}
+	if value, _ := provider.Get(ctx, testKey); string(value) != "first" {
+		t.Fatal("value should not be overwritten by SetNX")
+	}
+
+	if err := provider.Delete(ctx, testKey); err != nil {
+		t.Fatal("cannot delete value", err)
+	}
+	if ok, err := provider.SetNX(ctx, testKey, []byte("third"), 0); err != nil || !ok {
+		t.Fatal("SetNX should succeed after delete", err)
+	}
+}
+
+func TestMemoryProvider_Scan(t *testing.T) {
+	provider := New()
+	ctx := context.Background()
+
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		if err := provider.Set(ctx, key, []byte("value"), 0); err != nil {
+			t.Fatal("cannot set value", err)
+		}
+	}
+
+	var keys []string
+	if err := provider.Scan(ctx, "a/", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		t.Fatal("cannot scan keys", err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "a/1" || keys[1] != "a/2" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestMemoryProvider_OnEvict(t *testing.T) {
+	ctx := context.Background()
+	provider := New()
+
+	evicted := map[string]EvictReason{}
+	provider.OnEvict = func(key string, value []byte, reason EvictReason) {
+		evicted[key] = reason
+	}
+
+	for key, expiry := range map[string]time.Duration{"read": time.Millisecond, "swept": time.Millisecond, "deleted": 0, "kept": 0} {
+		if err := provider.Set(ctx, key, []byte("value"), expiry); err != nil {
+			t.Fatal("cannot set value", err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := provider.Get(ctx, "read"); err != nil {
+		t.Fatal("cannot get value", err)
+	}
+	if err := provider.Delete(ctx, "deleted"); err != nil {
+		t.Fatal("cannot delete value", err)
+	}
+	if removed := provider.Sweep(); removed != 1 {
+		t.Fatalf("expected 1 swept item, got %d", removed)
+	}
+
+	expected := map[string]EvictReason{"read": EvictExpired, "swept": EvictExpired, "deleted": EvictDeleted}
+	if len(evicted) != len(expected) {
+		t.Fatalf("expected evictions %v, got %v", expected, evicted)
+	}
+	for key, reason := range expected {
+		if evicted[key] != reason {
+			t.Fatalf("expected %q to be evicted with reason %q, got %q", key, reason, evicted[key])
+		}
+	}
+}
+
+func TestMemoryProvider_Size(t *testing.T) {
+	ctx := context.Background()
+	provider := New()
+
+	size := func() int64 {
+		n, err := provider.Size(ctx)
+		if err != nil {
+			t.Fatal("cannot get size", err)
+		}
+		return n
+	}
+
+	if err := provider.Set(ctx, "a", []byte("12345"), 0); err != nil {
+		t.Fatal("cannot set value", err)
+	}
+	if err := provider.Set(ctx, "b", []byte("123"), time.Millisecond); err != nil {
+		t.Fatal("cannot set value", err)
+	}
+	if n := size(); n != 8 {
+		t.Fatalf("expected 8 bytes, got %d", n)
+	}
+
+	if err := provider.Set(ctx, "a", []byte("12"), 0); err != nil {
+		t.Fatal("cannot set value", err)
+	}
+	if n := size(); n != 5 {
+		t.Fatalf("expected overwritten values to be replaced, got %d bytes", n)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	provider.Sweep()
+	if err := provider.Delete(ctx, "a"); err != nil {
+		t.Fatal("cannot delete value", err)
+	}
+	if n := size(); n != 0 {
+		t.Fatalf("expected 0 bytes, got %d", n)
+	}
+}
+
+func TestMemoryProvider_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	provider := New()
+	provider.SnapshotPath = path
+	if err := provider.Set(ctx, "kept", []byte("value"), time.Hour); err != nil {
+		t.Fatal("cannot set value", err)
+	}
+	if err := provider.Set(ctx, "expired", []byte("value"), time.Millisecond); err != nil {
+		t.Fatal("cannot set value", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := provider.Snapshot(ctx); err != nil {
+		t.Fatal("cannot snapshot", err)
+	}
+
+	restored := New()
+	restored.SnapshotPath = path
+	if err := restored.Load(); err != nil {
+		t.Fatal("cannot load snapshot", err)
+	}
+	if value, err := restored.Get(ctx, "kept"); err != nil || string(value) != "value" {
+		t.Fatalf("expected the snapshotted value, got %q (%v)", value, err)
+	}
+	if value, _ := restored.Get(ctx, "expired"); value != nil {
+		t.Fatal("expected expired values not to be snapshotted")
+	}
+
+	missing := New()
+	missing.SnapshotPath = filepath.Join(t.TempDir(), "missing")
+	if err := missing.Load(); err != nil {
+		t.Fatal("expected a missing snapshot not to be an error", err)
+	}
+}
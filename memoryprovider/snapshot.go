@@ -0,0 +1,85 @@
+package memoryprovider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type snapshotItem struct {
+	Key     string    `json:"key"`
+	Value   []byte    `json:"value"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Snapshot writes the unexpired items to SnapshotPath, replacing the previous snapshot atomically, so that Load can
+// restore them after a restart. Does nothing if SnapshotPath is empty.
+func (p *MemoryProvider) Snapshot(ctx context.Context) error {
+	if p.SnapshotPath == "" {
+		return nil
+	}
+
+	p.mu.RLock()
+	now := time.Now()
+	items := make([]snapshotItem, 0, len(p.data))
+	for key, data := range p.data {
+		if !data.expired(now) {
+			items = append(items, snapshotItem{Key: key, Value: data.value, Expires: data.expires})
+		}
+	}
+	p.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p.SnapshotPath), filepath.Base(p.SnapshotPath)+".*")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp(): %w", err)
+	}
+	defer os.Remove(f.Name())
+	if err := json.NewEncoder(f).Encode(items); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("json.Encode(): %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p.SnapshotPath)
+}
+
+// Load restores the items of the snapshot written to SnapshotPath, skipping the ones expired since. A missing
+// snapshot is not an error.
+func (p *MemoryProvider) Load() error {
+	if p.SnapshotPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p.SnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var items []snapshotItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("json.Unmarshal(): %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.data == nil {
+		return fmt.Errorf("memory provider is not initialized")
+	}
+	now := time.Now()
+	for _, snapshotted := range items {
+		i := item{value: snapshotted.Value, expires: snapshotted.Expires}
+		if !i.expired(now) {
+			p.store(snapshotted.Key, i)
+		}
+	}
+	return nil
+}
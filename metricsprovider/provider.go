@@ -0,0 +1,256 @@
+// Package metricsprovider decorates a cache provider to measure its operations, independently of the cache
+// statistics, so the latency and errors of the backend itself are observable.
+package metricsprovider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lsmoura/cache"
+)
+
+// Operations reported to the sink.
+const (
+	OpGet           = "get"
+	OpGetMulti      = "get_multi"
+	OpSet           = "set"
+	OpSetMulti      = "set_multi"
+	OpSetNX         = "set_nx"
+	OpTouch         = "touch"
+	OpDelete        = "delete"
+	OpDeleteIfEqual = "delete_if_equal"
+	OpScan          = "scan"
+	OpSize          = "size"
+	OpSnapshot      = "snapshot"
+)
+
+// Sink receives the measurements of a wrapped provider. It is called synchronously after every operation, and must be
+// safe for concurrent use.
+type Sink interface {
+	Observe(op string, duration time.Duration, err error)
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(op string, duration time.Duration, err error)
+
+func (f SinkFunc) Observe(op string, duration time.Duration, err error) {
+	f(op, duration, err)
+}
+
+// MetricsProvider is a provider reporting the duration and outcome of every operation of the provider it wraps.
+//
+// It implements every optional interface of the cache (Locker, Unlocker, Toucher, Deleter, Scanner, MultiGetter,
+// MultiSetter and Sizer). When the wrapped provider doesn't implement one, the corresponding methods return
+// errors.ErrUnsupported and are not reported, except GetMulti and SetMulti which fall back to one Get or Set per key.
+type MetricsProvider struct {
+	provider cache.Provider
+	sink     Sink
+}
+
+// Wrap returns a provider measuring the operations of p into sink.
+func Wrap(p cache.Provider, sink Sink) *MetricsProvider {
+	return &MetricsProvider{provider: p, sink: sink}
+}
+
+// Unwrap returns the wrapped provider.
+func (p *MetricsProvider) Unwrap() cache.Provider {
+	return p.provider
+}
+
+func (p *MetricsProvider) observe(op string, start time.Time, err error) {
+	p.sink.Observe(op, time.Since(start), err)
+}
+
+func (p *MetricsProvider) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	value, err := p.provider.Get(ctx, key)
+	p.observe(OpGet, start, err)
+	return value, err
+}
+
+func (p *MetricsProvider) GetMulti(ctx context.Context, keys []string) ([][]byte, error) {
+	getter, ok := p.provider.(cache.MultiGetter)
+	if !ok {
+		values := make([][]byte, len(keys))
+		for i, key := range keys {
+			value, err := p.Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+
+	start := time.Now()
+	values, err := getter.GetMulti(ctx, keys)
+	p.observe(OpGetMulti, start, err)
+	return values, err
+}
+
+func (p *MetricsProvider) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	start := time.Now()
+	err := p.provider.Set(ctx, key, value, expiry)
+	p.observe(OpSet, start, err)
+	return err
+}
+
+func (p *MetricsProvider) SetMulti(ctx context.Context, keys []string, values [][]byte, expiry time.Duration) error {
+	setter, ok := p.provider.(cache.MultiSetter)
+	if !ok {
+		for i, key := range keys {
+			if err := p.Set(ctx, key, values[i], expiry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start := time.Now()
+	err := setter.SetMulti(ctx, keys, values, expiry)
+	p.observe(OpSetMulti, start, err)
+	return err
+}
+
+func (p *MetricsProvider) SetNX(ctx context.Context, key string, value []byte, expiry time.Duration) (bool, error) {
+	locker, ok := p.provider.(cache.Locker)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	start := time.Now()
+	set, err := locker.SetNX(ctx, key, value, expiry)
+	p.observe(OpSetNX, start, err)
+	return set, err
+}
+
+func (p *MetricsProvider) DeleteIfEqual(ctx context.Context, key string, value []byte) (bool, error) {
+	unlocker, ok := p.provider.(cache.Unlocker)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	start := time.Now()
+	deleted, err := unlocker.DeleteIfEqual(ctx, key, value)
+	p.observe(OpDeleteIfEqual, start, err)
+	return deleted, err
+}
+
+func (p *MetricsProvider) Touch(ctx context.Context, key string, expiry time.Duration) (bool, error) {
+	toucher, ok := p.provider.(cache.Toucher)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	start := time.Now()
+	touched, err := toucher.Touch(ctx, key, expiry)
+	p.observe(OpTouch, start, err)
+	return touched, err
+}
+
+func (p *MetricsProvider) Delete(ctx context.Context, key string) error {
+	deleter, ok := p.provider.(cache.Deleter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	start := time.Now()
+	err := deleter.Delete(ctx, key)
+	p.observe(OpDelete, start, err)
+	return err
+}
+
+func (p *MetricsProvider) Scan(ctx context.Context, prefix string, fn func(key string) error) error {
+	scanner, ok := p.provider.(cache.Scanner)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	start := time.Now()
+	err := scanner.Scan(ctx, prefix, fn)
+	p.observe(OpScan, start, err)
+	return err
+}
+
+func (p *MetricsProvider) Size(ctx context.Context) (int64, error) {
+	sizer, ok := p.provider.(cache.Sizer)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	start := time.Now()
+	size, err := sizer.Size(ctx)
+	p.observe(OpSize, start, err)
+	return size, err
+}
+
+func (p *MetricsProvider) Snapshot(ctx context.Context) error {
+	snapshotter, ok := p.provider.(cache.Snapshotter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	start := time.Now()
+	err := snapshotter.Snapshot(ctx)
+	p.observe(OpSnapshot, start, err)
+	return err
+}
+
+// Close closes the wrapped provider, if it implements io.Closer.
+func (p *MetricsProvider) Close() error {
+	if closer, ok := p.provider.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// OpStats summarizes the measurements of one operation.
+type OpStats struct {
+	Calls    int64         `json:"calls"`
+	Errors   int64         `json:"errors"`
+	Duration time.Duration `json:"duration"` // total duration of the calls
+	Max      time.Duration `json:"max"`      // duration of the slowest call
+}
+
+// Mean returns the mean duration of the calls, or 0 if there were none.
+func (s OpStats) Mean() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Duration / time.Duration(s.Calls)
+}
+
+// Recorder is a Sink keeping counters in memory, per operation.
+type Recorder struct {
+	mu  sync.Mutex
+	ops map[string]OpStats
+}
+
+// NewRecorder returns an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{ops: make(map[string]OpStats)}
+}
+
+func (r *Recorder) Observe(op string, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.ops[op]
+	s.Calls++
+	if err != nil {
+		s.Errors++
+	}
+	s.Duration += duration
+	if duration > s.Max {
+		s.Max = duration
+	}
+	r.ops[op] = s
+}
+
+// Snapshot returns the counters of every operation observed so far.
+func (r *Recorder) Snapshot() map[string]OpStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := make(map[string]OpStats, len(r.ops))
+	for op, s := range r.ops {
+		snapshot[op] = s
+	}
+	return snapshot
+}
@@ -0,0 +1,106 @@
+package metricsprovider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache"
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+)
+
+type failingProvider struct{}
+
+func (failingProvider) Get(context.Context, string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingProvider) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("connection refused")
+}
+
+type staticRequester struct{}
+
+func (staticRequester) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Expires": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+		Body:       io.NopCloser(strings.NewReader("Hello World")),
+		Request:    req,
+	}, nil
+}
+
+func TestWrap(t *testing.T) {
+	ctx := context.Background()
+	recorder := NewRecorder()
+	p := Wrap(memoryprovider.New(), recorder)
+
+	c := cache.New(p)
+	c.HttpClient = staticRequester{}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		_, err = c.Do(req)
+		require.NoError(t, err, "c.Do")
+	}
+	require.NoError(t, c.Invalidate(ctx, "http://example.com/"), "c.Invalidate")
+
+	stats := recorder.Snapshot()
+	require.Equal(t, int64(3), stats[OpGet].Calls, "Expected a read per call to Do and one for Invalidate")
+	require.Equal(t, int64(1), stats[OpSet].Calls)
+	require.Equal(t, int64(1), stats[OpDelete].Calls)
+	require.Zero(t, stats[OpGet].Errors)
+	require.GreaterOrEqual(t, stats[OpGet].Max, stats[OpGet].Mean())
+
+	values, err := p.GetMulti(ctx, []string{"a", "b"})
+	require.NoError(t, err, "p.GetMulti")
+	require.Equal(t, [][]byte{nil, nil}, values)
+	require.Equal(t, int64(1), recorder.Snapshot()[OpGetMulti].Calls)
+}
+
+func TestWrap_Errors(t *testing.T) {
+	ctx := context.Background()
+	recorder := NewRecorder()
+	p := Wrap(failingProvider{}, recorder)
+
+	_, err := p.Get(ctx, "key")
+	require.Error(t, err)
+	require.Error(t, p.Set(ctx, "key", []byte("value"), 0))
+	require.Truef(t, errors.Is(p.Delete(ctx, "key"), errors.ErrUnsupported), "Expected Delete to be unsupported")
+	_, err = p.Touch(ctx, "key", time.Minute)
+	require.Truef(t, errors.Is(err, errors.ErrUnsupported), "Expected Touch to be unsupported")
+	_, err = p.Size(ctx)
+	require.Truef(t, errors.Is(err, errors.ErrUnsupported), "Expected Size to be unsupported")
+
+	// falls back to Get
+	_, err = p.GetMulti(ctx, []string{"key"})
+	require.Error(t, err)
+
+	require.Equal(t, map[string]OpStats{
+		OpGet: {Calls: 2, Errors: 2},
+		OpSet: {Calls: 1, Errors: 1},
+	}, zeroDurations(recorder.Snapshot()))
+
+	// the cache doesn't report stored bytes of unsupported providers
+	c := cache.New(p)
+	require.Zero(t, c.Stats().BytesStored)
+
+	// nor does it fail with a provider error on unsupported operations
+	c = cache.New(Wrap(struct{ cache.Provider }{memoryprovider.New()}, recorder))
+	require.ErrorIs(t, c.Invalidate(ctx, "key"), cache.ErrNotSupported)
+	_, err = c.Purge(ctx, "")
+	require.ErrorIs(t, err, cache.ErrNotSupported)
+}
+
+func zeroDurations(stats map[string]OpStats) map[string]OpStats {
+	for op, s := range stats {
+		s.Duration, s.Max = 0, 0
+		stats[op] = s
+	}
+	return stats
+}
@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNoNamespace is returned by Flush when the cache has no Namespace: without one, the keys of the cache can't be
+// told apart from other data stored in the provider.
+var ErrNoNamespace = errors.New("cache has no namespace")
+
+// namespaced prefixes key with the Namespace of the cache.
+func (r Cache) namespaced(key string) string {
+	return r.Namespace + key
+}
+
+// sidecarKey returns the sidecar key with the given prefix, such as accessKeyPrefix, of the entry stored under key.
+// Sidecar keys stay within the namespace.
+func (r Cache) sidecarKey(prefix string, key string) string {
+	return r.Namespace + prefix + strings.TrimPrefix(key, r.Namespace)
+}
+
+// internalKey reports whether key holds data of the cache itself rather than an entry.
+func (r Cache) internalKey(key string) bool {
+	key = strings.TrimPrefix(key, r.Namespace)
+	return strings.HasPrefix(key, stampedeLockPrefix) || strings.HasPrefix(key, accessKeyPrefix) ||
+		strings.HasPrefix(key, urlIndexKeyPrefix) || strings.HasPrefix(key, hostIndexKeyPrefix)
+}
@@ -0,0 +1,17 @@
+package cache
+
+import (
+	"context"
+	"net/url"
+)
+
+const partitionKeyPrefix = "partition:"
+
+// partitionKey prefixes key with the partition of ctx, if any.
+func partitionKey(ctx context.Context, key string) string {
+	partition := Partition(ctx)
+	if partition == "" {
+		return key
+	}
+	return partitionKeyPrefix + url.QueryEscape(partition) + ":" + key
+}
@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// PolicyRule overrides the default, header-driven, behaviour of the cache for matching requests. Every set criterion
+// must match; a rule without criteria matches every request.
+type PolicyRule struct {
+	Name    string `json:"name,omitempty"`    // endpoint the latencies of matching calls are recorded for, see Stats.EndpointLatency
+	Host    string `json:"host,omitempty"`    // glob matched against the request host, as in path.Match
+	Pattern string `json:"pattern,omitempty"` // regular expression matched against the full request URL
+
+	Bypass      bool          `json:"bypass,omitempty"`        // neither read nor store entries
+	OnlyCached  bool          `json:"only_cached,omitempty"`   // never go to the origin, see WithOnlyCached
+	TTL         time.Duration `json:"ttl,omitempty"`           // freshness lifetime of stored entries, overriding the headers
+	NegativeTTL time.Duration `json:"negative_ttl,omitempty"`  // freshness lifetime of 4xx and 5xx responses, overriding TTL and the headers
+	MaxBodySize int64         `json:"max_body_size,omitempty"` // larger responses are not stored, 0 for no limit
+	Sliding     time.Duration `json:"sliding,omitempty"`       // sliding expiration, overriding the SlidingExpiration of the cache
+
+	// MaxEntriesPerHost caps the number of entries stored for each host, the oldest entries being removed first.
+	// It is enforced on store with ReverseIndex, up to the size of the host index, and by Sweep otherwise.
+	MaxEntriesPerHost int `json:"max_entries_per_host,omitempty"`
+
+	// VaryCookies lists cookies whose values are part of the cache key, e.g. a language or A/B test cookie.
+	VaryCookies []string `json:"vary_cookies,omitempty"`
+	// BypassCookies lists cookies, e.g. session cookies, whose presence makes requests bypass the cache.
+	BypassCookies []string `json:"bypass_cookies,omitempty"`
+
+	// Alias makes matching requests share the entry of an equivalent URL, e.g. "/" for "/index.html": the part of the
+	// request URL matching Pattern is replaced by Alias, as in regexp.ReplaceAllString, to generate the key. Requests
+	// still reach the origin with their own URL. Requires Pattern.
+	Alias string `json:"alias,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+func (p *PolicyRule) compile() error {
+	if p.Host != "" {
+		if _, err := path.Match(p.Host, ""); err != nil {
+			return fmt.Errorf("host %q: %w", p.Host, err)
+		}
+	}
+	if p.Pattern != "" {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %w", p.Pattern, err)
+		}
+		p.pattern = re
+	}
+	if p.Alias != "" && p.pattern == nil {
+		return fmt.Errorf("alias %q without pattern", p.Alias)
+	}
+	if p.TTL < 0 || p.NegativeTTL < 0 || p.MaxBodySize < 0 || p.Sliding < 0 || p.MaxEntriesPerHost < 0 {
+		return fmt.Errorf("negative ttl, negative_ttl, max_body_size, sliding or max_entries_per_host")
+	}
+	return nil
+}
+
+func (p *PolicyRule) matches(u *url.URL) bool {
+	if p.Host != "" {
+		if ok, _ := path.Match(p.Host, u.Hostname()); !ok {
+			return false
+		}
+	}
+	if p.pattern != nil && !p.pattern.MatchString(u.String()) {
+		return false
+	}
+	return true
+}
+
+// bypasses reports whether req must neither read nor store entries.
+func (p *PolicyRule) bypasses(req *http.Request) bool {
+	if p == nil {
+		return false
+	}
+	if p.Bypass {
+		return true
+	}
+	for _, name := range p.BypassCookies {
+		if _, err := req.Cookie(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// varyKey appends the values of the VaryCookies of the rule to key. Missing cookies count as empty values.
+func (p *PolicyRule) varyKey(key string, req *http.Request) string {
+	if p == nil || len(p.VaryCookies) == 0 {
+		return key
+	}
+	values := make(url.Values, len(p.VaryCookies))
+	for _, name := range p.VaryCookies {
+		var value string
+		if cookie, err := req.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		values.Set(name, value)
+	}
+	return key + "|cookies:" + values.Encode()
+}
+
+// alias returns the URL the key of requests for u is generated from: u rewritten by the Alias of the rule, or u itself.
+func (p *PolicyRule) alias(u *url.URL) *url.URL {
+	if p == nil || p.Alias == "" {
+		return u
+	}
+	aliased, err := url.Parse(p.pattern.ReplaceAllString(u.String(), p.Alias))
+	if err != nil {
+		return u
+	}
+	return aliased
+}
+
+// ttl returns the freshness lifetime the rule imposes on a response with the given status code, or 0 to keep the
+// one of the headers.
+func (p *PolicyRule) ttl(statusCode int) time.Duration {
+	if p == nil {
+		return 0
+	}
+	if statusCode >= 400 && p.NegativeTTL > 0 {
+		return p.NegativeTTL
+	}
+	return p.TTL
+}
+
+// storable reports whether a response body of the given size may be stored.
+func (p *PolicyRule) storable(size int) bool {
+	return p == nil || p.MaxBodySize <= 0 || int64(size) <= p.MaxBodySize
+}
+
+// maxEntriesPerHost returns the number of entries the rule allows per host, or 0 for no limit.
+func (p *PolicyRule) maxEntriesPerHost() int {
+	if p == nil {
+		return 0
+	}
+	return p.MaxEntriesPerHost
+}
+
+// Policy is an ordered list of rules, the first rule matching a request applies. Rules can be replaced at runtime,
+// see Update and Watch.
+type Policy struct {
+	rules atomic.Pointer[[]PolicyRule]
+}
+
+// NewPolicy validates rules and returns a policy applying them.
+func NewPolicy(rules ...PolicyRule) (*Policy, error) {
+	p := &Policy{}
+	if err := p.Update(rules...); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Update validates rules and atomically replaces the rules of the policy. On error, the current rules are kept.
+func (p *Policy) Update(rules ...PolicyRule) error {
+	compiled := make([]PolicyRule, len(rules))
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		compiled[i] = rule
+	}
+	p.rules.Store(&compiled)
+	return nil
+}
+
+// PolicySource loads policy rules, see FilePolicySource.
+type PolicySource func(ctx context.Context) ([]PolicyRule, error)
+
+// FilePolicySource loads the rules of a configuration file, see LoadConfig.
+func FilePolicySource(path string) PolicySource {
+	return func(context.Context) ([]PolicyRule, error) {
+		config, err := LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		return config.policyRules(), nil
+	}
+}
+
+// Watch loads the rules of source every interval and updates the policy with them, until ctx is done. Failures to
+// load or validate the rules are passed to onError, if not nil, and leave the current rules in place. Watch blocks,
+// and returns the context error, or an error right away if interval is not positive.
+func (p *Policy) Watch(ctx context.Context, interval time.Duration, source PolicySource, onError func(error)) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid watch interval %s", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		rules, err := source(ctx)
+		if err == nil {
+			err = p.Update(rules...)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// resolvedRule is the policy rule matching the request of a call, see Cache.withPolicyRule.
+type resolvedRule struct {
+	policy *Policy
+	url    string
+	rule   *PolicyRule
+}
+
+// withPolicyRule returns a copy of ctx carrying rule, the policy rule matching req, so that the rest of the call
+// doesn't match the rules again, nor sees them change halfway through when they are updated.
+func (r Cache) withPolicyRule(ctx context.Context, req *http.Request, rule *PolicyRule) context.Context {
+	if r.Policy == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKeyPolicyRule, resolvedRule{policy: r.Policy, url: req.URL.String(), rule: rule})
+}
+
+// policyRule returns the policy rule matching req, or nil: the one carried by its context, if any, or the first rule
+// matching its URL without the sensitive parameters.
+func (r Cache) policyRule(req *http.Request) *PolicyRule {
+	if r.Policy == nil {
+		return nil
+	}
+	if v, ok := req.Context().Value(contextKeyPolicyRule).(resolvedRule); ok && v.policy == r.Policy && v.url == req.URL.String() {
+		return v.rule
+	}
+	return r.Policy.match(stripParams(req.URL, r.SensitiveParams))
+}
+
+// match returns the first rule matching u, or nil.
+func (p *Policy) match(u *url.URL) *PolicyRule {
+	if p == nil {
+		return nil
+	}
+	rules := p.rules.Load()
+	if rules == nil {
+		return nil
+	}
+	for i := range *rules {
+		if (*rules)[i].matches(u) {
+			return &(*rules)[i]
+		}
+	}
+	return nil
+}
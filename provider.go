@@ -5,6 +5,10 @@ import (
 	"time"
 )
 
+// Provider stores the cache entries. Providers may implement the optional interfaces below to enable more features.
+// Decorators, which can't know whether the provider they wrap implements an optional interface, may implement it
+// anyway and return errors.ErrUnsupported: the cache then behaves as if it wasn't implemented, or fails the call
+// needing it with that error.
 type Provider interface {
 	// Get returns the value for the given key. Should only return an error if the value could be checked for existence or if communication fails.
 	// If the value is not found just return nil, nil.
@@ -13,3 +17,59 @@ type Provider interface {
 	// Set sets the value for the given key. Should return an error if the value could not be set.
 	Set(ctx context.Context, key string, value []byte, expiry time.Duration) error
 }
+
+// Locker is an optional interface implemented by providers able to atomically set a key only if it does not exist.
+// It is used to coordinate refreshes across processes sharing the same provider.
+type Locker interface {
+	// SetNX sets the value for the given key only if the key does not exist yet. Returns true if the value was set.
+	SetNX(ctx context.Context, key string, value []byte, expiry time.Duration) (bool, error)
+}
+
+// Unlocker is an optional interface implemented by providers able to atomically remove a key only if it holds a given
+// value. It is used to release refresh locks without removing a lock taken by another process in the meantime.
+type Unlocker interface {
+	// DeleteIfEqual removes the given key only if it holds value. Returns true if the key was removed.
+	DeleteIfEqual(ctx context.Context, key string, value []byte) (bool, error)
+}
+
+// Deleter is an optional interface implemented by providers able to remove keys.
+type Deleter interface {
+	// Delete removes the given key. Deleting a key that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Scanner is an optional interface implemented by providers able to iterate over their keys.
+type Scanner interface {
+	// Scan calls fn for every key starting with prefix, stopping at the first error returned by fn. Keys added or
+	// removed during the scan may or may not be visited.
+	Scan(ctx context.Context, prefix string, fn func(key string) error) error
+}
+
+// Toucher is an optional interface implemented by providers able to change the expiry of a key without rewriting its
+// value. It is used by sliding expiration, see SlidingExpiration.
+type Toucher interface {
+	// Touch sets the expiry of the given key, 0 meaning no expiry. Returns false if the key does not exist.
+	Touch(ctx context.Context, key string, expiry time.Duration) (bool, error)
+}
+
+// MultiGetter is an optional interface implemented by providers able to read many keys in a single round trip. It is
+// used by DoBatch.
+type MultiGetter interface {
+	// GetMulti returns the values for the given keys, in the same order. Missing keys have a nil value.
+	GetMulti(ctx context.Context, keys []string) ([][]byte, error)
+}
+
+// MultiSetter is an optional interface implemented by providers able to write many keys in a single round trip. It is
+// used by WriteBatching.
+type MultiSetter interface {
+	// SetMulti sets the values for the given keys, in the same order, with the same expiry. It may fail after
+	// writing some of the values.
+	SetMulti(ctx context.Context, keys []string, values [][]byte, expiry time.Duration) error
+}
+
+// Snapshotter is an optional interface implemented by providers able to persist their content, e.g. to disk, so that
+// it survives a restart. It is used by Shutdown, which also closes the providers implementing io.Closer.
+type Snapshotter interface {
+	// Snapshot persists the stored keys. It may do nothing if the provider was not configured to persist them.
+	Snapshot(ctx context.Context) error
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// withRange answers the Range header of req from resp, the full response built from entry: a single satisfiable
+// range gets a 206 Partial Content response and an unsatisfiable one a 416. Other requests, multiple ranges and
+// mismatching If-Range validators get resp as is, as allowed by RFC 9110.
+func withRange(req *http.Request, entry *cacheEntry, resp *http.Response) *http.Response {
+	header := req.Header.Get("Range")
+	if header == "" || req.Method != http.MethodGet || entry.StatusCode != http.StatusOK {
+		return resp
+	}
+	if ifRange := req.Header.Get("If-Range"); ifRange != "" {
+		// weak validators never match
+		if strings.HasPrefix(ifRange, "W/") || (ifRange != entry.header("ETag") && ifRange != entry.header("Last-Modified")) {
+			return resp
+		}
+	}
+
+	size := int64(len(entry.Data))
+	first, last, ok, satisfiable := byteRange(header, size)
+	if !ok {
+		return resp
+	}
+	if !satisfiable {
+		resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		resp.Header.Set("Content-Length", "0")
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+		resp.ContentLength = 0
+		return resp
+	}
+
+	part := entry.Data[first : last+1]
+	resp.StatusCode = http.StatusPartialContent
+	resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(part)))
+	resp.Body = io.NopCloser(bytes.NewReader(part))
+	resp.ContentLength = int64(len(part))
+	return resp
+}
+
+// byteRange parses a Range header against a body of the given size, returning the positions of the first and last
+// bytes of the range. ok is false for headers to be ignored, such as multiple ranges, other units or invalid ranges.
+// satisfiable is false when the range lies outside of the body.
+func byteRange(header string, size int64) (first, last int64, ok, satisfiable bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, false
+	}
+	from, to, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, false
+	}
+
+	if from == "" {
+		// suffix range, the last n bytes
+		n, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, false
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, true
+	}
+
+	first, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, false, false
+	}
+	last = size - 1
+	if to != "" {
+		end, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || end < first {
+			return 0, 0, false, false
+		}
+		if end < last {
+			last = end
+		}
+	}
+	if first >= size {
+		return 0, 0, true, false
+	}
+	return first, last, true, true
+}
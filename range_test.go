@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteRange(t *testing.T) {
+	tests := []struct {
+		header      string
+		first, last int64
+		ok          bool
+		satisfiable bool
+	}{
+		{header: "bytes=0-4", first: 0, last: 4, ok: true, satisfiable: true},
+		{header: "bytes=6-", first: 6, last: 10, ok: true, satisfiable: true},
+		{header: "bytes=6-100", first: 6, last: 10, ok: true, satisfiable: true},
+		{header: "bytes=-5", first: 6, last: 10, ok: true, satisfiable: true},
+		{header: "bytes=-100", first: 0, last: 10, ok: true, satisfiable: true},
+		{header: "bytes=11-", ok: true, satisfiable: false},
+		{header: "bytes=-0", ok: true, satisfiable: false},
+		{header: "bytes=0-1,4-5", ok: false},
+		{header: "bytes=5-1", ok: false},
+		{header: "items=0-1", ok: false},
+		{header: "bytes=a-b", ok: false},
+	}
+
+	for _, tt := range tests {
+		first, last, ok, satisfiable := byteRange(tt.header, 11)
+		require.Equal(t, tt.ok, ok, tt.header)
+		require.Equal(t, tt.satisfiable, satisfiable, tt.header)
+		if satisfiable {
+			require.Equal(t, tt.first, first, tt.header)
+			require.Equal(t, tt.last, last, tt.header)
+		}
+	}
+}
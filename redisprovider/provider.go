@@ -3,6 +3,7 @@ package redisprovider
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -40,6 +41,21 @@ func (p *RedisProvider) Get(_ context.Context, key string) ([]byte, error) {
 	return []byte(value), nil
 }
 
+func (p *RedisProvider) GetMulti(_ context.Context, keys []string) ([][]byte, error) {
+	results, err := p.client.MGet(keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis.MGet(): %w", err)
+	}
+
+	values := make([][]byte, len(keys))
+	for i, result := range results {
+		if value, ok := result.(string); ok && value != "" {
+			values[i] = []byte(value)
+		}
+	}
+	return values, nil
+}
+
 func (p *RedisProvider) Set(_ context.Context, key string, value []byte, expiry time.Duration) error {
 	cmd := p.client.Set(key, value, expiry)
 	if err := cmd.Err(); err != nil {
@@ -47,3 +63,91 @@ func (p *RedisProvider) Set(_ context.Context, key string, value []byte, expiry
 	}
 	return nil
 }
+
+func (p *RedisProvider) SetMulti(_ context.Context, keys []string, values [][]byte, expiry time.Duration) error {
+	pipe := p.client.Pipeline()
+	for i, key := range keys {
+		pipe.Set(key, values[i], expiry)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("redis.Pipeline.Exec(): %w", err)
+	}
+	return nil
+}
+
+func (p *RedisProvider) SetNX(_ context.Context, key string, value []byte, expiry time.Duration) (bool, error) {
+	ok, err := p.client.SetNX(key, value, expiry).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis.SetNX(): %w", err)
+	}
+	return ok, nil
+}
+
+func (p *RedisProvider) Touch(_ context.Context, key string, expiry time.Duration) (bool, error) {
+	if expiry <= 0 {
+		// PERSIST reports false for keys without an expiry too
+		exists, err := p.client.Exists(key).Result()
+		if err != nil {
+			return false, fmt.Errorf("redis.Exists(): %w", err)
+		}
+		if err := p.client.Persist(key).Err(); err != nil {
+			return false, fmt.Errorf("redis.Persist(): %w", err)
+		}
+		return exists > 0, nil
+	}
+	ok, err := p.client.Expire(key, expiry).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis.Expire(): %w", err)
+	}
+	return ok, nil
+}
+
+// deleteIfEqual removes KEYS[1] only if it holds ARGV[1].
+var deleteIfEqual = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+func (p *RedisProvider) DeleteIfEqual(_ context.Context, key string, value []byte) (bool, error) {
+	deleted, err := deleteIfEqual.Run(p.client, []string{key}, value).Int64()
+	if err != nil {
+		return false, fmt.Errorf("redis.Eval(): %w", err)
+	}
+	return deleted > 0, nil
+}
+
+func (p *RedisProvider) Delete(_ context.Context, key string) error {
+	if err := p.client.Del(key).Err(); err != nil {
+		return fmt.Errorf("redis.Del(): %w", err)
+	}
+	return nil
+}
+
+// globEscaper escapes the characters with a special meaning in redis MATCH patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (p *RedisProvider) Scan(ctx context.Context, prefix string, fn func(key string) error) error {
+	match := globEscaper.Replace(prefix) + "*"
+
+	var cursor uint64
+	for {
+		keys, next, err := p.client.Scan(cursor, match, 100).Result()
+		if err != nil {
+			return fmt.Errorf("redis.Scan(): %w", err)
+		}
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Close closes the connections to the Redis server.
+func (p *RedisProvider) Close() error {
+	return p.client.Close()
+}
@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RefreshAhead configures the background refresh-ahead subsystem. Frequently accessed entries are revalidated
+// against the origin shortly before they expire, so callers rarely see a miss.
+type RefreshAhead struct {
+	Window    time.Duration // entries expiring within this window are refreshed in the background
+	MinHits   int           // number of hits an entry needs before being refreshed ahead, defaults to 1
+	Workers   int           // maximum number of concurrent refreshes, defaults to 4
+	QueueSize int           // maximum number of pending refreshes, defaults to 64. Refreshes are dropped when full
+	Interval  time.Duration // minimum interval between two refreshes, or 0 for no rate limit
+	Timeout   time.Duration // timeout for each refresh, independent of the request that triggered it, defaults to 30 seconds
+
+	// DropPolicy defines which refresh is dropped when the queue is full. Refreshes of a key already pending are
+	// always skipped, and counted in Stats.
+	DropPolicy RefreshDropPolicy
+}
+
+// RefreshDropPolicy defines which refresh is dropped when the refresh-ahead queue is full.
+type RefreshDropPolicy int
+
+const (
+	RefreshDropNewest RefreshDropPolicy = iota // drop the refresh being scheduled
+	RefreshDropOldest                          // drop the oldest pending refresh to make room for the new one
+)
+
+const (
+	defaultRefreshWorkers   = 4
+	defaultRefreshQueueSize = 64
+	defaultRefreshTimeout   = 30 * time.Second
+	maxRefreshTrackedKeys   = 10000
+)
+
+type refreshJob struct {
+	cache Cache
+	req   *http.Request
+	key   string
+	entry *cacheEntry
+}
+
+type refresher struct {
+	once  sync.Once
+	queue chan refreshJob
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	mu       sync.Mutex
+	hits     map[string]int
+	pending  map[string]struct{}
+	closed   bool
+	draining bool // no refreshes are scheduled anymore, see drain
+
+	scheduled atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	dropped   atomic.Int64
+	deduped   atomic.Int64
+	queued    atomic.Int64
+	inFlight  atomic.Int64
+}
+
+func newRefresher() *refresher {
+	return &refresher{
+		stop:    make(chan struct{}),
+		hits:    make(map[string]int),
+		pending: make(map[string]struct{}),
+	}
+}
+
+func (f *refresher) start(cfg RefreshAhead) {
+	f.once.Do(func() {
+		workers := cfg.Workers
+		if workers <= 0 {
+			workers = defaultRefreshWorkers
+		}
+		size := cfg.QueueSize
+		if size <= 0 {
+			size = defaultRefreshQueueSize
+		}
+		f.queue = make(chan refreshJob, size)
+
+		var limiter <-chan time.Time
+		if cfg.Interval > 0 {
+			ticker := time.NewTicker(cfg.Interval)
+			limiter = ticker.C
+			f.wg.Add(1)
+			go func() {
+				defer f.wg.Done()
+				<-f.stop
+				ticker.Stop()
+			}()
+		}
+
+		for i := 0; i < workers; i++ {
+			f.wg.Add(1)
+			go f.work(limiter)
+		}
+	})
+}
+
+func (f *refresher) work(limiter <-chan time.Time) {
+	defer f.wg.Done()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case job := <-f.queue:
+			// counted in flight before leaving the queue, so that drain never misses it
+			f.inFlight.Add(1)
+			f.queued.Add(-1)
+			if limiter != nil {
+				select {
+				case <-f.stop:
+					f.inFlight.Add(-1)
+					return
+				case <-limiter:
+				}
+			}
+			f.refresh(job)
+			f.inFlight.Add(-1)
+		}
+	}
+}
+
+func (f *refresher) refresh(job refreshJob) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.pending, job.key)
+		f.mu.Unlock()
+	}()
+
+	if !job.cache.allowRefresh(job.key) {
+		f.dropped.Add(1)
+		return
+	}
+
+	var timeout time.Duration
+	if job.cache.RefreshAhead != nil {
+		timeout = job.cache.RefreshAhead.Timeout
+	}
+	if timeout <= 0 {
+		timeout = defaultRefreshTimeout
+	}
+	// the request carries the detached context of the call that scheduled the refresh
+	ctx, cancel := context.WithTimeout(job.req.Context(), timeout)
+	defer cancel()
+	ctx, span := job.cache.startSpan(ctx, "cache.Refresh", attribute.String("cache.key", job.key))
+
+	req := job.req.WithContext(ctx)
+	var (
+		result *fetchResult
+		err    error
+	)
+	defer func() { endSpan(span, err) }()
+	if job.cache.DisableCoalescing || job.cache.flights == nil {
+		result, err = job.cache.fetch(ctx, req, job.key, job.entry)
+	} else {
+		deadline, _ := ctx.Deadline()
+		result, _, err = job.cache.flights.do(ctx, job.key, job.cache.logURL(job.req), 0, func(ctx context.Context) (*fetchResult, error) {
+			// shared fetches don't keep the deadline of their callers
+			ctx, cancel := context.WithDeadline(ctx, deadline)
+			defer cancel()
+			return job.cache.fetch(ctx, req.WithContext(ctx), job.key, job.entry)
+		})
+	}
+	if err == nil && result.resp != nil {
+		// streams are not stored, there is nothing to refresh
+		_ = result.resp.Body.Close()
+	}
+	if err == nil && result.stat == CacheStatusStaleError {
+		err = errors.New("origin failed")
+	}
+	if err != nil {
+		f.failed.Add(1)
+		job.cache.logError(ctx, "error refreshing entry ahead", "key", job.key, "error", err)
+		return
+	}
+	f.completed.Add(1)
+}
+
+// touch records a hit for the given entry and schedules a background refresh when it is hot and about to expire, or
+// when the request was made with WithRefreshAhead and the entry expires within its window.
+func (f *refresher) touch(r Cache, req *http.Request, key string, entry *cacheEntry) {
+	var cfg RefreshAhead
+	if r.RefreshAhead != nil {
+		cfg = *r.RefreshAhead
+	}
+	if window := RefreshAheadWindow(req.Context()); window > 0 {
+		// refresh on the first hit
+		cfg.Window, cfg.MinHits = window, 1
+	}
+	if cfg.Window <= 0 {
+		return
+	}
+	expires, ok := entry.expiresAt()
+	if !ok {
+		return
+	}
+
+	f.mu.Lock()
+	if f.closed || f.draining {
+		f.mu.Unlock()
+		return
+	}
+	if _, ok := f.hits[key]; ok || len(f.hits) < maxRefreshTrackedKeys {
+		f.hits[key]++
+	}
+	minHits := cfg.MinHits
+	if minHits <= 0 {
+		minHits = 1
+	}
+	if f.hits[key] < minHits || expires.Sub(r.now()) > cfg.Window {
+		f.mu.Unlock()
+		return
+	}
+	if _, ok := f.pending[key]; ok {
+		f.mu.Unlock()
+		f.deduped.Add(1)
+		return
+	}
+	f.pending[key] = struct{}{}
+	delete(f.hits, key)
+
+	// started and queued with the lock held, so that close can't run in between
+	f.start(cfg)
+	job := refreshJob{cache: r, req: req.Clone(detach(req.Context())), key: key, entry: entry}
+	if f.enqueue(job, cfg.DropPolicy) {
+		f.mu.Unlock()
+		f.scheduled.Add(1)
+		return
+	}
+	delete(f.pending, key)
+	f.mu.Unlock()
+	f.dropped.Add(1)
+}
+
+// enqueue queues job, making room for it by dropping the oldest pending refresh with RefreshDropOldest. Returns false
+// if job was dropped. Must be called with the lock held.
+func (f *refresher) enqueue(job refreshJob, policy RefreshDropPolicy) bool {
+	f.queued.Add(1)
+	select {
+	case f.queue <- job:
+		return true
+	default:
+	}
+	if policy != RefreshDropOldest {
+		f.queued.Add(-1)
+		return false
+	}
+	select {
+	case oldest := <-f.queue:
+		f.queued.Add(-1)
+		f.dropped.Add(1)
+		delete(f.pending, oldest.key)
+	default:
+	}
+	select {
+	case f.queue <- job:
+		return true
+	default:
+		f.queued.Add(-1)
+		return false
+	}
+}
+
+// drain stops scheduling refreshes and waits for the pending ones to complete, or for ctx to be done.
+func (f *refresher) drain(ctx context.Context) {
+	f.mu.Lock()
+	f.draining = true
+	f.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for f.queued.Load() > 0 || f.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// close stops the workers, discarding pending refreshes, and waits for in-flight refreshes to finish.
+func (f *refresher) close() {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	f.closed = true
+	f.mu.Unlock()
+
+	close(f.stop)
+	f.wg.Wait()
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxRefreshLimitedKeys = 10000
+
+// refreshLimiter remembers when keys were last requested from the origin, to enforce MinRefreshInterval.
+type refreshLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newRefreshLimiter() *refreshLimiter {
+	return &refreshLimiter{last: make(map[string]time.Time)}
+}
+
+// allow reports whether key may be requested from the origin at now, recording the request if so.
+func (l *refreshLimiter) allow(key string, now time.Time, interval time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if last, ok := l.last[key]; ok && now.Sub(last) < interval {
+		return false
+	}
+	if len(l.last) >= maxRefreshLimitedKeys {
+		for k, last := range l.last {
+			if now.Sub(last) >= interval {
+				delete(l.last, k)
+			}
+		}
+		if len(l.last) >= maxRefreshLimitedKeys {
+			// too many keys to track, don't limit rather than growing unbounded
+			return true
+		}
+	}
+	l.last[key] = now
+	return true
+}
+
+// allowRefresh reports whether key may be requested from the origin, according to MinRefreshInterval.
+func (r Cache) allowRefresh(key string) bool {
+	if r.MinRefreshInterval <= 0 || r.refreshLimits == nil {
+		return true
+	}
+	return r.refreshLimits.allow(key, r.now(), r.MinRefreshInterval)
+}
+
+// cachedEntry returns the entry matching req stored under key, even if expired, or nil.
+func (r Cache) cachedEntry(ctx context.Context, key string, req *http.Request) *cacheEntry {
+	ctx = WithIgnoreExpired(ctx, true)
+	entry, err := r.lookup(ctx, key)
+	_, entry, err = r.resolveVariant(ctx, key, req, entry, err)
+	if err != nil && !errors.Is(err, ErrCacheExpiryIgnored) {
+		return nil
+	}
+	return entry
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+)
+
+// RequestMetadata configures the recording, in every stored entry, of the request the entry was fetched for, so
+// that admin tooling and exports can tell how it was produced. See EntryInfo.Request.
+type RequestMetadata struct {
+	// Headers lists the request headers recorded. Leave credentials such as Authorization or Cookie out, entries
+	// being readable by anyone with access to the provider.
+	Headers []string
+}
+
+// EntryRequest describes the request an entry was fetched for, recorded with the RequestMetadata option.
+type EntryRequest struct {
+	Method   string            `json:"method"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	BodyHash string            `json:"body_hash,omitempty"` // hex encoded SHA-256 of the request body, if any
+}
+
+// requestMetadata returns the metadata of req to record in its entry, or nil without RequestMetadata. The body is
+// hashed only when it can be read again through GetBody, as it is sent to the origin.
+func (r Cache) requestMetadata(req *http.Request) *EntryRequest {
+	if r.RequestMetadata == nil {
+		return nil
+	}
+	meta := &EntryRequest{Method: req.Method}
+	if meta.Method == "" {
+		meta.Method = http.MethodGet
+	}
+	for _, name := range r.RequestMetadata.Headers {
+		if value := req.Header.Get(name); value != "" {
+			if meta.Headers == nil {
+				meta.Headers = make(map[string]string)
+			}
+			meta.Headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			h := sha256.New()
+			if _, err := io.Copy(h, body); err == nil {
+				meta.BodyHash = hex.EncodeToString(h.Sum(nil))
+			}
+			_ = body.Close()
+		}
+	}
+	return meta
+}
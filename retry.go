@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Retry configures retries of failed origin requests. Only idempotent requests are retried.
+type Retry struct {
+	Attempts    int           // total number of attempts, including the first one
+	BaseDelay   time.Duration // delay before the first retry, doubled on every subsequent retry
+	MaxDelay    time.Duration // upper bound of the delay between retries, or 0 for no bound
+	StatusCodes []int         // status codes worth retrying, defaults to 502, 503 and 504
+}
+
+var defaultRetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+func (c Retry) retryable(statusCode int) bool {
+	codes := c.StatusCodes
+	if codes == nil {
+		codes = defaultRetryStatusCodes
+	}
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Retry) delay(retry int) time.Duration {
+	d := c.BaseDelay
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if c.MaxDelay > 0 && d >= c.MaxDelay {
+			break
+		}
+	}
+	if c.MaxDelay > 0 && d > c.MaxDelay {
+		return c.MaxDelay
+	}
+	return d
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// originDo sends req to the origin, retrying transient failures according to the Retry configuration.
+func (r Cache) originDo(ctx context.Context, req *http.Request) (*http.Response, error) {
+	ctx, span := r.startSpan(ctx, "cache.origin",
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", r.logURL(req)),
+	)
+	if span.IsRecording() {
+		req = req.WithContext(ctx)
+	}
+
+	resp, err := r.retryDo(ctx, req)
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	}
+	endSpan(span, err)
+
+	return resp, err
+}
+
+func (r Cache) retryDo(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if r.Retry == nil || r.Retry.Attempts <= 1 || !idempotent(req) {
+		return r.roundTrip(ctx, req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := r.roundTrip(ctx, req)
+		if attempt >= r.Retry.Attempts {
+			return resp, err
+		}
+		if err == nil && !r.Retry.retryable(resp.StatusCode) {
+			return resp, nil
+		}
+		if err == nil {
+			if err := resp.Body.Close(); err != nil {
+				r.logInfo(ctx, "error closing response body", "error", err)
+			}
+			r.logDebug(ctx, "retrying origin request", "url", r.logURL(req), "attempt", attempt, "status", resp.StatusCode)
+		} else {
+			r.logDebug(ctx, "retrying origin request", "url", r.logURL(req), "attempt", attempt, "error", err)
+		}
+
+		timer := time.NewTimer(r.Retry.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
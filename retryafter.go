@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryAfter returns how long resp, a 429 or 503 origin response, asks clients to wait before retrying according to
+// its Retry-After header, capped to RetryAfter. Returns false for other responses, or when RetryAfter is not set.
+func (r Cache) retryAfter(resp *http.Response) (time.Duration, bool) {
+	if r.RetryAfter <= 0 || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		wait = t.Sub(r.now())
+	}
+	if wait <= 0 {
+		return 0, false
+	}
+	return min(wait, r.RetryAfter), true
+}
+
+// holdOff answers a call the origin turned down with resp for wait, see RetryAfter. The entry, if any, is marked as
+// held for wait and served as stale until then; otherwise resp is stored, as any other response, also marked as held
+// so that the origin is left alone either way.
+func (r Cache) holdOff(ctx context.Context, key string, req *http.Request, resp *http.Response, entry *cacheEntry, wait time.Duration, start time.Time, rule *PolicyRule) (*fetchResult, error) {
+	heldUntil := r.now().Add(wait)
+	if entry != nil {
+		if err := resp.Body.Close(); err != nil {
+			r.logInfo(ctx, "error closing response body", "error", err)
+		}
+		r.logError(ctx, "origin asked to retry later, serving entry", "key", key, "status", resp.StatusCode, "retry-after", wait)
+		held := *entry
+		held.HeldUntil = heldUntil
+		if !r.skipWrite(ctx, key) {
+			if err := r.writeEntry(ctx, key, req, &held); err != nil {
+				if err := r.writeFailed(ctx, key, err); err != nil {
+					return &fetchResult{entry: &held, stat: CacheStatusStaleError}, err
+				}
+			}
+		}
+		return &fetchResult{key: r.storageKey(key, req, &held), entry: &held, stat: CacheStatusStaleError}, nil
+	}
+
+	passthrough, err := r.passthrough(ctx, key, resp, rule)
+	if err != nil {
+		return nil, &OriginError{Method: req.Method, URL: r.logURL(req), Key: key, Err: err}
+	}
+	if passthrough != nil {
+		return &fetchResult{resp: passthrough, stat: CacheStatusBypass}, nil
+	}
+	held, err := r.buffer(ctx, req, resp, start)
+	if err != nil {
+		return nil, &OriginError{Method: req.Method, URL: r.logURL(req), Key: key, Err: err}
+	}
+	r.logInfo(ctx, "origin asked to retry later, storing its response", "key", key, "status", resp.StatusCode, "retry-after", wait)
+	held.HeldUntil = heldUntil
+	if held, err = r.storeEntry(ctx, key, req, resp, held, rule); err != nil {
+		return &fetchResult{entry: held}, fmt.Errorf("r.store(): %w", err)
+	}
+	return &fetchResult{key: r.storageKey(key, req, held), entry: held}, nil
+}
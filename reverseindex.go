@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Prefixes of the sidecar keys of the reverse index, see ReverseIndex.
+const (
+	urlIndexKeyPrefix  = "index:url:"
+	hostIndexKeyPrefix = "index:host:"
+	maxIndexedKeys     = 1000 // keys remembered by an index, older ones are dropped
+)
+
+// keyIndex lists the keys stored for a URL or a host.
+type keyIndex struct {
+	Keys      []string `json:"keys"`
+	Truncated bool     `json:"truncated,omitempty"` // older keys were dropped
+}
+
+// indexKeysOf returns the keys of the URL and host indexes of req.
+func (r Cache) indexKeysOf(req *http.Request) (urlIndexKey, hostIndexKey string) {
+	canonical := CanonicalURL(r.resourceURL(req), CanonicalOptions{})
+	host := strings.ToLower(req.URL.Host)
+	if u, err := url.Parse(canonical); err == nil {
+		host = u.Host
+	}
+	return r.namespaced(urlIndexKeyPrefix + canonical), r.namespaced(hostIndexKeyPrefix + host)
+}
+
+func (r Cache) readIndex(ctx context.Context, indexKey string) (*keyIndex, error) {
+	value, err := r.currentProvider().Get(ctx, indexKey)
+	if err != nil {
+		r.recordProviderError(ctx, "get", err)
+		return nil, &ProviderError{Op: "get", Key: indexKey, Err: err}
+	}
+	var index keyIndex
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, &index); err != nil {
+			r.logError(ctx, "error unmarshalling key index", "key", indexKey, "error", err)
+		}
+	}
+	return &index, nil
+}
+
+// indexKeys adds keys, stored for req, to the URL and host indexes when ReverseIndex is set. Concurrent writes from
+// several processes may be lost, so indexes are best effort.
+func (r Cache) indexKeys(ctx context.Context, req *http.Request, keys ...string) {
+	if !r.ReverseIndex || req == nil {
+		return
+	}
+	urlIndexKey, hostIndexKey := r.indexKeysOf(req)
+	for _, indexKey := range []string{urlIndexKey, hostIndexKey} {
+		index, err := r.readIndex(ctx, indexKey)
+		if err != nil {
+			r.logError(ctx, "error reading key index", "key", indexKey, "provider", r.providerName(), "error", err)
+			continue
+		}
+		changed := false
+		for _, key := range keys {
+			if !containsKey(index.Keys, key) {
+				index.Keys = append(index.Keys, key)
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if indexKey == hostIndexKey {
+			r.limitHostIndex(ctx, index, r.policyRule(req).maxEntriesPerHost(), keys)
+		}
+		if len(index.Keys) > maxIndexedKeys {
+			index.Keys = index.Keys[len(index.Keys)-maxIndexedKeys:]
+			index.Truncated = true
+		}
+		data, err := json.Marshal(index)
+		if err != nil {
+			continue
+		}
+		if err := r.providerSet(ctx, indexKey, data, 0); err != nil {
+			r.logError(ctx, "error writing key index", "key", indexKey, "provider", r.providerName(), "error", err)
+		}
+	}
+}
+
+// invalidateIndex removes every key listed by the index stored under indexKey, then the index itself. Returns the
+// index, or nil if there was none, and the number of removed responses.
+func (r Cache) invalidateIndex(ctx context.Context, indexKey string) (*keyIndex, int, error) {
+	index, err := r.readIndex(ctx, indexKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(index.Keys) == 0 && !index.Truncated {
+		return nil, 0, nil
+	}
+	var deleted int
+	for _, key := range index.Keys {
+		n, err := r.invalidateVariants(ctx, key)
+		deleted += n
+		if err != nil {
+			return index, deleted, err
+		}
+	}
+	return index, deleted, r.invalidate(ctx, indexKey)
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+)
+
+// ProviderRoute sends the entries of matching requests to another provider than the one of the cache. Every set
+// criterion must match; a route without criteria matches every request.
+type ProviderRoute struct {
+	Scheme   string         // request scheme, e.g. "https", or empty for any scheme
+	Host     string         // glob matched against the request host, as in path.Match, e.g. "*.example.com"
+	Pattern  *regexp.Regexp // matched against the full request URL, or nil
+	Provider Provider
+}
+
+func (p ProviderRoute) matches(u *url.URL) bool {
+	if p.Scheme != "" && p.Scheme != u.Scheme {
+		return false
+	}
+	if p.Host != "" {
+		if ok, _ := path.Match(p.Host, u.Hostname()); !ok {
+			return false
+		}
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(u.String()) {
+		return false
+	}
+	return true
+}
+
+// route returns a copy of the cache using the provider of the first route matching req, or the cache itself if no
+// route matches.
+func (r Cache) route(req *http.Request) Cache {
+	for i, route := range r.Routes {
+		if route.Provider != nil && route.matches(req.URL) {
+			return r.withRoute(i)
+		}
+	}
+	return r
+}
+
+// withRoute returns a copy of the cache bound to the provider of Routes[i].
+func (r Cache) withRoute(i int) Cache {
+	r.provider = r.Routes[i].Provider
+	r.bound = providerID{route: i + 1}
+	return r
+}
+
+// perProvider returns the cache, then copies of it bound to the providers of its routes: one per provider the cache
+// may store entries in.
+func (r Cache) perProvider() []Cache {
+	caches := []Cache{r}
+	for i, route := range r.Routes {
+		if route.Provider == nil {
+			continue
+		}
+		known := false
+		for _, c := range caches {
+			if sameProvider(c.currentProvider(), route.Provider) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			caches = append(caches, r.withRoute(i))
+		}
+	}
+	return caches
+}
+
+// sameProvider reports whether a and b are the same provider. Providers that can't be compared, such as structs holding
+// a slice, are reported different rather than panicking.
+func sameProvider(a, b Provider) bool {
+	if !reflect.ValueOf(a).Comparable() || !reflect.ValueOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultSensitiveParams is a list of common query parameters carrying credentials, to be used as SensitiveParams.
+var DefaultSensitiveParams = []string{"api_key", "apikey", "access_token", "token", "signature", "sig", "password"}
+
+// stripParams returns a copy of u without the query parameters listed in names, matched case-insensitively, keeping
+// the order of the other parameters. Returns u itself when it has none of them.
+func stripParams(u *url.URL, names []string) *url.URL {
+	return rewriteParams(u, names, func(string) (string, bool) { return "", false })
+}
+
+// digestParams returns a copy of u with the values of the query parameters listed in names, matched
+// case-insensitively, replaced by their keyDigest. Returns u itself when it has none of them.
+func (r Cache) digestParams(u *url.URL, names []string) *url.URL {
+	return rewriteParams(u, names, func(value string) (string, bool) {
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		return r.keyDigest(value), true
+	})
+}
+
+// rewriteParams returns a copy of u where the values of the query parameters listed in names, matched
+// case-insensitively, are replaced by fn, or the parameters removed when fn returns false. Returns u itself when it
+// has none of them.
+func rewriteParams(u *url.URL, names []string, fn func(value string) (string, bool)) *url.URL {
+	if len(names) == 0 || u.RawQuery == "" {
+		return u
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	kept := params[:0:0]
+	rewritten := false
+	for _, param := range params {
+		rawName, value, _ := strings.Cut(param, "=")
+		name := rawName
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !containsFold(names, name) {
+			kept = append(kept, param)
+			continue
+		}
+		rewritten = true
+		if value, ok := fn(value); ok {
+			kept = append(kept, rawName+"="+value)
+		}
+	}
+	if !rewritten {
+		return u
+	}
+
+	c := *u
+	c.RawQuery = strings.Join(kept, "&")
+	return &c
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// logURL returns the URL of req as recorded in logs, spans and errors, without the sensitive parameters.
+func (r Cache) logURL(req *http.Request) string {
+	return stripParams(req.URL, r.SensitiveParams).String()
+}
+
+// resourceURL returns the URL of the resource req is for: without the sensitive parameters, and the URL it is an alias
+// of, if any. Entries record it, and the reverse index lists the keys stored for it.
+func (r Cache) resourceURL(req *http.Request) *url.URL {
+	return r.policyRule(req).alias(stripParams(req.URL, r.SensitiveParams))
+}
+
+// keyRequest returns the request to generate the key of req from: a shallow copy with the sensitive parameters
+// replaced by their digest, so that requests made with different credentials don't share entries, and with the URL
+// it is an alias of, if any.
+func (r Cache) keyRequest(req *http.Request) *http.Request {
+	u := r.policyRule(req).alias(r.digestParams(req.URL, r.SensitiveParams))
+	if u == req.URL {
+		return req
+	}
+	c := *req
+	c.URL = u
+	return &c
+}
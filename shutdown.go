@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Shutdown gracefully stops the cache: refreshes are no longer scheduled, pending refreshes, writes and write retries
+// are completed, the background workers are stopped as with Close, then the providers implementing Snapshotter are
+// snapshotted and the ones implementing io.Closer are closed. With Routes, every provider is. If ctx is done first,
+// Shutdown returns its error, leaving the pending work to complete in the background, and the providers open; pending
+// write retries are then abandoned.
+func (r Cache) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if r.refresher != nil {
+			r.refresher.drain(ctx)
+		}
+		// flush the buffered and queued writes first, their failures may be retried
+		if r.batcher != nil {
+			r.batcher.close()
+		}
+		if r.writer != nil {
+			r.writer.close()
+		}
+		if r.retrier != nil {
+			r.retrier.drain(ctx)
+		}
+		_ = r.Close()
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var errs []error
+	for _, c := range r.perProvider() {
+		p := c.currentProvider()
+		if snapshotter, ok := p.(Snapshotter); ok {
+			if err := snapshotter.Snapshot(ctx); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+				errs = append(errs, fmt.Errorf("snapshot %T: %w", p, err))
+			}
+		}
+		if closer, ok := p.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close %T: %w", p, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// maxSitemapDepth is the maximum nesting of sitemap indexes followed by WarmSitemap.
+const maxSitemapDepth = 3
+
+// Sitemap lists the URLs of a sitemap, along with the nested sitemaps of sitemap indexes.
+type Sitemap struct {
+	URLs     []string
+	Sitemaps []string
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapXML struct {
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+// ParseSitemap reads a sitemap.xml, a sitemap index, or a plain list of URLs with one URL per line, where empty lines
+// and lines starting with # are ignored. Gzip compressed content is decompressed.
+func ParseSitemap(r io.Reader) (*Sitemap, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip.NewReader(): %w", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll(): %w", err)
+	}
+
+	var sitemap Sitemap
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '<' {
+		var doc sitemapXML
+		if err := xml.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("xml.Unmarshal(): %w", err)
+		}
+		for _, u := range doc.URLs {
+			if loc := strings.TrimSpace(u.Loc); loc != "" {
+				sitemap.URLs = append(sitemap.URLs, loc)
+			}
+		}
+		for _, s := range doc.Sitemaps {
+			if loc := strings.TrimSpace(s.Loc); loc != "" {
+				sitemap.Sitemaps = append(sitemap.Sitemaps, loc)
+			}
+		}
+		return &sitemap, nil
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sitemap.URLs = append(sitemap.URLs, line)
+	}
+	return &sitemap, nil
+}
+
+// WarmSitemap warms the cache with the URLs of a sitemap, see ParseSitemap and Warm. source is either the URL of the
+// sitemap, fetched without going through the cache, or the path of a local file. Nested sitemaps of sitemap indexes
+// are followed, as long as they are http or https URLs: an index never makes the process read local files.
+func (r Cache) WarmSitemap(ctx context.Context, source string, opts WarmOptions) (WarmResult, error) {
+	urls, err := r.sitemapURLs(ctx, source, 0)
+	if err != nil {
+		return WarmResult{}, err
+	}
+	return r.Warm(ctx, urls, opts)
+}
+
+func (r Cache) sitemapURLs(ctx context.Context, source string, depth int) ([]string, error) {
+	sitemap, err := r.loadSitemap(ctx, source, depth == 0)
+	if err != nil {
+		return nil, fmt.Errorf("sitemap %s: %w", source, err)
+	}
+	urls := sitemap.URLs
+	if len(sitemap.Sitemaps) > 0 && depth >= maxSitemapDepth {
+		return nil, fmt.Errorf("sitemap %s: sitemap indexes nested too deeply", source)
+	}
+	for _, nested := range sitemap.Sitemaps {
+		if !isHTTPURL(nested) {
+			return nil, fmt.Errorf("sitemap %s: nested sitemap %q is not an absolute http or https URL", source, nested)
+		}
+		nestedURLs, err := r.sitemapURLs(ctx, nested, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, nestedURLs...)
+	}
+	return urls, nil
+}
+
+// isHTTPURL reports whether s is an absolute http or https URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// loadSitemap reads the sitemap at source, a URL or, if local is set, the path of a file.
+func (r Cache) loadSitemap(ctx context.Context, source string, local bool) (*Sitemap, error) {
+	if !isHTTPURL(source) {
+		if !local {
+			return nil, fmt.Errorf("not an absolute http or https URL")
+		}
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseSitemap(f)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest(): %w", err)
+	}
+	resp, err := r.httpClient(ctx).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return ParseSitemap(resp.Body)
+}
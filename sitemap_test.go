@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSitemap(t *testing.T) {
+	const urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/</loc></url>
+  <url><loc> http://example.com/about </loc><lastmod>2024-01-01</lastmod></url>
+</urlset>`
+
+	sitemap, err := ParseSitemap(strings.NewReader(urlset))
+	require.NoError(t, err, "ParseSitemap")
+	require.Equal(t, []string{"http://example.com/", "http://example.com/about"}, sitemap.URLs)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err = gz.Write([]byte(urlset))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	sitemap, err = ParseSitemap(&compressed)
+	require.NoError(t, err, "ParseSitemap")
+	require.Len(t, sitemap.URLs, 2)
+
+	sitemap, err = ParseSitemap(strings.NewReader(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://example.com/products.xml</loc></sitemap>
+</sitemapindex>`))
+	require.NoError(t, err, "ParseSitemap")
+	require.Empty(t, sitemap.URLs)
+	require.Equal(t, []string{"http://example.com/products.xml"}, sitemap.Sitemaps)
+
+	sitemap, err = ParseSitemap(strings.NewReader("# deploy list\nhttp://example.com/a\n\n  http://example.com/b\n"))
+	require.NoError(t, err, "ParseSitemap")
+	require.Equal(t, []string{"http://example.com/a", "http://example.com/b"}, sitemap.URLs)
+
+	_, err = ParseSitemap(strings.NewReader("<urlset><url>"))
+	require.Error(t, err, "Expected invalid XML to be rejected")
+}
+
+func TestCache_WarmSitemap(t *testing.T) {
+	page := func(body string) *cacheEntry {
+		return &cacheEntry{
+			StatusCode: 200,
+			Data:       []byte(body),
+			Headers:    map[string]string{"Expires": time.Now().Add(time.Hour).Format(time.RFC1123)},
+		}
+	}
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			"http://example.com/products.xml": page(`<urlset>
+  <url><loc>http://example.com/products/1</loc></url>
+  <url><loc>http://example.com/products/2</loc></url>
+  <url><loc>http://example.com/products/2?print=1</loc></url>
+</urlset>`),
+			"http://example.com/":           page("home"),
+			"http://example.com/products/1": page("product 1"),
+			"http://example.com/products/2": page("product 2"),
+			"http://example.com/missing":    {StatusCode: http.StatusNotFound},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	ctx := context.Background()
+
+	index := filepath.Join(t.TempDir(), "sitemap.xml")
+	require.NoError(t, os.WriteFile(index, []byte(`<sitemapindex>
+  <sitemap><loc>http://example.com/products.xml</loc></sitemap>
+</sitemapindex>`), 0o600))
+
+	result, err := cache.WarmSitemap(ctx, index, WarmOptions{
+		Concurrency: 1,
+		Include:     []*regexp.Regexp{regexp.MustCompile(`/products/`)},
+		Exclude:     []*regexp.Regexp{regexp.MustCompile(`print=`)},
+	})
+	require.NoError(t, err, "cache.WarmSitemap")
+	require.Equal(t, WarmResult{Requested: 2, Skipped: 1}, result)
+
+	for _, u := range []string{"http://example.com/products/1", "http://example.com/products/2"} {
+		_, err := cache.PeekKey(ctx, u)
+		require.NoError(t, err, "Expected %s to be warmed", u)
+	}
+	_, err = cache.PeekKey(ctx, "http://example.com/products.xml")
+	require.Truef(t, errors.Is(err, ErrCacheMiss), "Expected the sitemap not to be cached, got %v", err)
+
+	result, err = cache.Warm(ctx, []string{"http://example.com/", "http://example.com/missing"}, WarmOptions{})
+	require.NoError(t, err, "cache.Warm")
+	require.Equal(t, WarmResult{Requested: 2, Failed: 1}, result)
+}
+
+func TestCache_WarmSitemapLocalNested(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("http://example.com/secret\n"), 0o600))
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			"http://example.com/sitemap.xml": {StatusCode: 200, Data: []byte(`<sitemapindex>
+  <sitemap><loc>` + secret + `</loc></sitemap>
+</sitemapindex>`)},
+		},
+	}
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+
+	_, err := cache.WarmSitemap(context.Background(), "http://example.com/sitemap.xml", WarmOptions{})
+	require.ErrorContains(t, err, "not an absolute http or https URL", "Expected remote indexes not to reach local files")
+	require.Equal(t, 1, requester.requestCount)
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// slideRewriteFraction is the fraction of the sliding window left to an entry under which it is rewritten, when the
+// provider can't change its expiry in place.
+const slideRewriteFraction = 2
+
+// slidingWindow returns the sliding expiration applying to a request matching rule, or 0.
+func (r Cache) slidingWindow(rule *PolicyRule) time.Duration {
+	if rule != nil && rule.Sliding > 0 {
+		return rule.Sliding
+	}
+	return r.SlidingExpiration
+}
+
+// slide pushes the expiry of an entry that was just served forward, according to the sliding expiration. Entries are
+// never made to expire sooner.
+//
+// Providers implementing Toucher only have the expiry of the key extended on every hit: the entry is written once
+// without an expiry of its own, the provider dropping it once unused for the window. Otherwise, the entry is rewritten
+// with a later expiry, only once less than 1/slideRewriteFraction of the window is left, to avoid a write per hit.
+func (r Cache) slide(ctx context.Context, key string, entry *cacheEntry, rule *PolicyRule) {
+	window := r.slidingWindow(rule)
+	if window <= 0 {
+		return
+	}
+
+	if entry.Sliding > 0 {
+		if _, err := r.touch(ctx, key, window); err != nil {
+			r.logError(ctx, "error extending entry expiry", "key", key, "provider", r.providerName(), "error", err)
+		}
+		return
+	}
+
+	now := r.now()
+	current, ok := entry.expiresAt()
+	if ok && !current.Before(now.Add(window)) {
+		return
+	}
+
+	found, err := r.touch(ctx, key, window)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		if ok && current.Sub(now) >= window/slideRewriteFraction {
+			return
+		}
+		slid := *entry
+		slid.Expires = now.Add(window)
+		if err := r.write(ctx, key, &slid); err != nil {
+			r.logError(ctx, "error extending entry expiry", "key", key, "provider", r.providerName(), "error", err)
+		}
+	case err != nil:
+		r.logError(ctx, "error extending entry expiry", "key", key, "provider", r.providerName(), "error", err)
+	case found:
+		// from now on, the provider expires the entry
+		slid := *entry
+		slid.Expires, slid.Sliding = neverExpires, window
+		if err := r.writeExpiring(ctx, key, &slid, window); err != nil {
+			r.logError(ctx, "error extending entry expiry", "key", key, "provider", r.providerName(), "error", err)
+		}
+	}
+}
+
+// touch sets the provider expiry of key. Returns an error wrapping errors.ErrUnsupported if the provider doesn't
+// implement Toucher.
+func (r Cache) touch(ctx context.Context, key string, expiry time.Duration) (bool, error) {
+	toucher, ok := r.currentProvider().(Toucher)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	found, err := toucher.Touch(ctx, key, expiry)
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return false, providerError("touch", key, err)
+	}
+	return found, err
+}
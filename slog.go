@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// SlogLogger adapts a standard library structured logger to the Logger interface.
+func SlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) Debug(msg string, params ...any) {
+	l.logger.Debug(msg, params...)
+}
+
+func (l *slogLogger) Info(msg string, params ...any) {
+	l.logger.Info(msg, params...)
+}
+
+func (l *slogLogger) Error(msg string, params ...any) {
+	l.logger.Error(msg, params...)
+}
+
+func (l *slogLogger) With(params ...any) Logger {
+	return &slogLogger{logger: l.logger.With(params...)}
+}
+
+// WithSlogLogger returns a copy of parent context carrying the given logger, to be used by SlogExtractor.
+func WithSlogLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKeySlogLogger, logger)
+}
+
+// SlogExtractor returns a LoggerExtractor using the logger set by WithSlogLogger, or fallback if the context carries
+// none. A nil fallback uses slog.Default().
+func SlogExtractor(fallback *slog.Logger) LoggerExtractor {
+	return func(ctx context.Context) Logger {
+		if ctx != nil {
+			if logger, ok := ctx.Value(contextKeySlogLogger).(*slog.Logger); ok && logger != nil {
+				return SlogLogger(logger)
+			}
+		}
+		if fallback == nil {
+			return SlogLogger(slog.Default())
+		}
+		return SlogLogger(fallback)
+	}
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HeaderCacheStaleFor is set on stale responses to the number of seconds since the served entry expired.
+const HeaderCacheStaleFor = "X-Cache-Stale-For"
+
+// Warning header values of stale responses, as defined by RFC 7234.
+const (
+	warningStale              = `110 - "Response is Stale"`
+	warningRevalidationFailed = `111 - "Revalidation Failed"`
+)
+
+// staleFor returns how long the served entry had been expired at now, or false if the response was not served from an
+// expired entry.
+func (i callInfo) staleFor(now time.Time) (time.Duration, bool) {
+	if i.expires.IsZero() || !i.expires.Before(now) {
+		return 0, false
+	}
+	return now.Sub(i.expires), true
+}
+
+// markStale sets the Warning and X-Cache-Stale-For headers on responses served from an expired entry, so downstream
+// consumers can tell them apart.
+func (r Cache) markStale(resp *http.Response, info callInfo) {
+	staleFor, ok := info.staleFor(r.now())
+	if !ok {
+		return
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	warning := warningStale
+	if info.stat == CacheStatusStaleError {
+		warning = warningRevalidationFailed
+	}
+	resp.Header.Set("Warning", warning)
+	resp.Header.Set(HeaderCacheStaleFor, strconv.FormatInt(int64(staleFor.Seconds()), 10))
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const stampedeLockPrefix = "lock:"
+
+// lockReleaseTimeout bounds the release of a refresh lock, done even when the caller went away.
+const lockReleaseTimeout = 5 * time.Second
+
+// acquireRefresh tries to take the cross-process refresh lock for key. It returns true when the caller should go
+// to the origin, either because it holds the lock or because locking is not available. The returned release
+// function must be called once the refresh is done. It only removes the lock if it is still held by the caller, so
+// that a lock taken by another process once this one lapsed is left alone.
+func (r Cache) acquireRefresh(ctx context.Context, key string) (release func(), acquired bool) {
+	noop := func() {}
+	if r.StampedeLockTTL <= 0 {
+		return noop, true
+	}
+	locker, ok := r.currentProvider().(Locker)
+	if !ok {
+		return noop, true
+	}
+
+	lockKey := r.sidecarKey(stampedeLockPrefix, key)
+	token := lockToken()
+	ok, err := locker.SetNX(ctx, lockKey, token, r.StampedeLockTTL)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return noop, true
+	}
+	if err != nil {
+		r.recordProviderError(ctx, "lock", err)
+		r.logError(ctx, "error acquiring refresh lock", "key", key, "provider", r.providerName(), "error", err)
+		return noop, true
+	}
+	if !ok {
+		return noop, false
+	}
+
+	return func() {
+		// the lock is held until it expires unless released, canceled call or not
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lockReleaseTimeout)
+		defer cancel()
+		if err := r.releaseLock(ctx, lockKey, token); err != nil {
+			r.recordProviderError(ctx, "unlock", err)
+			r.logError(ctx, "error releasing refresh lock", "key", key, "provider", r.providerName(), "error", err)
+		}
+	}, true
+}
+
+// lockToken returns a random value identifying the holder of a lock.
+func lockToken() []byte {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return []byte(time.Now().Format(time.RFC3339Nano))
+	}
+	return []byte(hex.EncodeToString(b))
+}
+
+// releaseLock removes lockKey if it still holds token. Without an Unlocker, the lock is read before being removed,
+// leaving a short window for another process to take it in between.
+func (r Cache) releaseLock(ctx context.Context, lockKey string, token []byte) error {
+	if unlocker, ok := r.currentProvider().(Unlocker); ok {
+		_, err := unlocker.DeleteIfEqual(ctx, lockKey, token)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	deleter, ok := r.currentProvider().(Deleter)
+	if !ok {
+		// the lock will expire by itself
+		return nil
+	}
+	value, err := r.currentProvider().Get(ctx, lockKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(value, token) {
+		// lapsed, and maybe taken by another process
+		return nil
+	}
+	err = deleter.Delete(ctx, lockKey)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	return err
+}
+
+// waitFresh polls the provider for up to StampedeWait, waiting for the instance holding the refresh lock to store
+// a fresh entry for key matching req.
+func (r Cache) waitFresh(ctx context.Context, key string, req *http.Request) *cacheEntry {
+	if r.StampedeWait <= 0 {
+		return nil
+	}
+
+	interval := r.StampedeWait / 10
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(r.StampedeWait)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout.C:
+			return nil
+		case <-ticker.C:
+			entry, err := r.read(ctx, key)
+			_, entry, err = r.resolveVariant(ctx, key, req, entry, err)
+			if err == nil && entry != nil {
+				return entry
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the cache statistics.
+type Stats struct {
+	Requests       int64 `json:"requests"`        // calls to Do
+	Hits           int64 `json:"hits"`            // calls answered from the cache
+	Misses         int64 `json:"misses"`          // calls answered by the origin
+	Errors         int64 `json:"errors"`          // calls that returned an error
+	ProviderErrors int64 `json:"provider_errors"` // failed provider operations
+
+	RefreshScheduled int64 `json:"refresh_scheduled"` // refresh-ahead jobs queued
+	RefreshCompleted int64 `json:"refresh_completed"` // refresh-ahead jobs that revalidated their entry
+	RefreshFailed    int64 `json:"refresh_failed"`    // refresh-ahead jobs that failed
+	RefreshDropped   int64 `json:"refresh_dropped"`   // refresh-ahead jobs dropped because the queue was full or MinRefreshInterval
+	RefreshDeduped   int64 `json:"refresh_deduped"`   // refresh-ahead jobs skipped because a refresh of the key was pending
+	RefreshQueued    int64 `json:"refresh_queued"`    // refresh-ahead jobs waiting for a worker
+	RefreshInFlight  int64 `json:"refresh_in_flight"` // refresh-ahead jobs running
+
+	WriteQueueDepth int64 `json:"write_queue_depth"` // asynchronous or buffered writes waiting to be written
+	WritesDropped   int64 `json:"writes_dropped"`    // asynchronous writes dropped because the queue was full
+	WritesFailed    int64 `json:"writes_failed"`     // asynchronous or buffered writes the provider failed to store
+	WriteBatches    int64 `json:"write_batches"`     // batches of buffered writes flushed, see WriteBatching
+	WriteRetries    int64 `json:"write_retries"`     // retries of failed writes, see WriteRetry
+	WritesAbandoned int64 `json:"writes_abandoned"`  // failed writes given up after their last retry, or on Close
+
+	BytesWritten   int64 `json:"bytes_written"`   // bytes written to the providers
+	BytesStored    int64 `json:"bytes_stored"`    // bytes stored in the providers, when every provider implements Sizer or with a MemoryBudget
+	BudgetRejected int64 `json:"budget_rejected"` // writes skipped because the memory budget was exceeded
+	SweptEntries   int64 `json:"swept_entries"`   // expired entries removed by Sweep
+
+	EntriesCompressed int64 `json:"entries_compressed"` // entries written with a compressed body, see EntryCompression
+	BytesSaved        int64 `json:"bytes_saved"`        // body bytes saved by entry compression
+
+	CacheLatency        LatencyHistogram `json:"cache_latency"`        // latency of the calls served from the cache
+	RevalidationLatency LatencyHistogram `json:"revalidation_latency"` // latency of the calls revalidating an entry
+	OriginLatency       LatencyHistogram `json:"origin_latency"`       // latency of the calls fetched from the origin
+
+	// EndpointLatency holds the same histograms for each endpoint: the calls matching a policy rule with a Name.
+	EndpointLatency map[string]EndpointLatency `json:"endpoint_latency,omitempty"`
+}
+
+// HitRatio returns the ratio of cacheable calls answered from the cache, or 0 if there were none.
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+type counters struct {
+	requests       atomic.Int64
+	hits           atomic.Int64
+	misses         atomic.Int64
+	errors         atomic.Int64
+	providerErrors atomic.Int64
+
+	latencies *latencies
+	endpoints *endpointLatencies
+	parent    *counters // counters of the cache a group belongs to, see Group
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (r Cache) Stats() Stats {
+	var s Stats
+	if r.counters != nil {
+		s.Requests = r.counters.requests.Load()
+		s.Hits = r.counters.hits.Load()
+		s.Misses = r.counters.misses.Load()
+		s.Errors = r.counters.errors.Load()
+		s.ProviderErrors = r.counters.providerErrors.Load()
+		if r.counters.latencies != nil {
+			s.CacheLatency = r.counters.latencies.cache.snapshot()
+			s.RevalidationLatency = r.counters.latencies.revalidation.snapshot()
+			s.OriginLatency = r.counters.latencies.origin.snapshot()
+		}
+		if r.counters.endpoints != nil {
+			s.EndpointLatency = r.counters.endpoints.snapshot()
+		}
+	}
+	if r.refresher != nil {
+		s.RefreshScheduled = r.refresher.scheduled.Load()
+		s.RefreshCompleted = r.refresher.completed.Load()
+		s.RefreshFailed = r.refresher.failed.Load()
+		s.RefreshDropped = r.refresher.dropped.Load()
+		s.RefreshDeduped = r.refresher.deduped.Load()
+		s.RefreshQueued = r.refresher.queued.Load()
+		s.RefreshInFlight = r.refresher.inFlight.Load()
+	}
+	if r.writer != nil {
+		s.WriteQueueDepth = r.writer.depth.Load()
+		s.WritesDropped = r.writer.dropped.Load()
+		s.WritesFailed = r.writer.failed.Load()
+	}
+	if r.batcher != nil {
+		s.WriteQueueDepth += r.batcher.depth.Load()
+		s.WritesFailed += r.batcher.failed.Load()
+		s.WriteBatches = r.batcher.flushed.Load()
+	}
+	if r.retrier != nil {
+		s.WriteRetries = r.retrier.retries.Load()
+		s.WritesAbandoned = r.retrier.abandoned.Load()
+	}
+	if r.budget != nil {
+		s.BytesWritten = r.budget.written.Load()
+		s.BudgetRejected = r.budget.rejected.Load()
+		if s.BytesStored = r.storedBytes(context.Background()); s.BytesStored < 0 {
+			r.budget.mu.Lock()
+			r.budget.prune(r.now())
+			s.BytesStored = r.budget.stored
+			r.budget.mu.Unlock()
+		}
+	}
+	if r.sweeper != nil {
+		s.SweptEntries = r.sweeper.deleted.Load()
+	}
+	if r.compressor != nil {
+		s.EntriesCompressed = r.compressor.compressed.Load()
+		s.BytesSaved = r.compressor.saved.Load()
+	}
+	return s
+}
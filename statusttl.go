@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// StatusTTLs sets default freshness lifetimes by response status code, for responses whose headers don't set one.
+// Keys are status codes, e.g. "404", or classes, e.g. "5xx", exact codes taking precedence. A negative lifetime
+// prevents responses with the status from being stored, unless a policy rule sets their TTL.
+type StatusTTLs map[string]time.Duration
+
+// lookup returns the lifetime set for the given status code.
+func (t StatusTTLs) lookup(statusCode int) (time.Duration, bool) {
+	if len(t) == 0 {
+		return 0, false
+	}
+	if ttl, ok := t[strconv.Itoa(statusCode)]; ok {
+		return ttl, true
+	}
+	ttl, ok := t[strconv.Itoa(statusCode/100)+"xx"]
+	return ttl, ok
+}
+
+// storable reports whether responses with the given status code may be stored.
+func (t StatusTTLs) storable(statusCode int) bool {
+	ttl, ok := t.lookup(statusCode)
+	return !ok || ttl >= 0
+}
+
+// Validate checks that every key is a status code or a status class.
+func (t StatusTTLs) Validate() error {
+	for key := range t {
+		if len(key) != 3 || key[0] < '1' || key[0] > '5' {
+			return fmt.Errorf("invalid status %q, expected a code such as 404 or a class such as 5xx", key)
+		}
+		if key[1:] == "xx" {
+			continue
+		}
+		if _, err := strconv.Atoi(key); err != nil {
+			return fmt.Errorf("invalid status %q, expected a code such as 404 or a class such as 5xx", key)
+		}
+	}
+	return nil
+}
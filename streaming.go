@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamingContentTypes are the media types of responses that may never end, such as server-sent events.
+var streamingContentTypes = map[string]struct{}{
+	"text/event-stream":         {},
+	"multipart/x-mixed-replace": {},
+	"application/x-ndjson":      {},
+	"application/stream+json":   {},
+}
+
+// streaming reports whether resp is a stream that must be handed over to the caller as it arrives.
+func streaming(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	_, ok := streamingContentTypes[mediaType]
+	return ok
+}
+
+// passthrough returns resp when it must not be buffered nor stored: partial content, streams, and bodies larger than
+// the MaxBodySize of the rule. Bodies of unknown length are read until they end, grow larger than MaxBodySize, or
+// 64 MiB without it, or stay idle for StreamIdleTimeout, as chunked long polls do: the returned response still carries
+// the bytes read. Returns nil when resp can be stored. WithForceStore doesn't apply: a stream would be read forever.
+func (r Cache) passthrough(ctx context.Context, key string, resp *http.Response, rule *PolicyRule) (*http.Response, error) {
+	if resp.StatusCode == http.StatusPartialContent {
+		// a fragment must never be served as the whole resource
+		r.logDebug(ctx, "partial content, not stored", "key", key)
+		return resp, nil
+	}
+	if streaming(resp) {
+		r.logDebug(ctx, "streaming response, not stored", "key", key, "content-type", resp.Header.Get("Content-Type"))
+		return resp, nil
+	}
+	limit := int64(defaultMaxDecodedSize)
+	if rule != nil && rule.MaxBodySize > 0 {
+		limit = rule.MaxBodySize
+		if resp.ContentLength > limit {
+			r.logDebug(ctx, "response too large to be stored", "key", key, "size", resp.ContentLength)
+			return resp, nil
+		}
+	}
+	if resp.ContentLength >= 0 {
+		return nil, nil
+	}
+	if r.StreamIdleTimeout <= 0 {
+		head, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+		if err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+		if int64(len(head)) <= limit {
+			resp.Body = readCloser{Reader: bytes.NewReader(head), Closer: resp.Body}
+			return nil, nil
+		}
+		r.logDebug(ctx, "response too large to be stored", "key", key, "max-size", limit)
+		resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(head), resp.Body), Closer: resp.Body}
+		return resp, nil
+	}
+
+	body := readInBackground(resp.Body)
+	idle := time.NewTimer(r.StreamIdleTimeout)
+	defer idle.Stop()
+	var head []byte
+	for {
+		select {
+		case chunk, ok := <-body.chunks:
+			if !ok {
+				if !errors.Is(body.err, io.EOF) {
+					_ = body.Close()
+					return nil, body.err
+				}
+				resp.Body = readCloser{Reader: bytes.NewReader(head), Closer: body}
+				return nil, nil
+			}
+			head = append(head, chunk...)
+			if int64(len(head)) > limit {
+				r.logDebug(ctx, "response too large to be stored", "key", key, "max-size", limit)
+				resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(head), body), Closer: body}
+				return resp, nil
+			}
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(r.StreamIdleTimeout)
+		case <-idle.C:
+			r.logDebug(ctx, "idle response body, not stored", "key", key, "read", len(head))
+			resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(head), body), Closer: body}
+			return resp, nil
+		}
+	}
+}
+
+// backgroundBody reads a body in the background, so that waiting for it can be given up without losing data.
+type backgroundBody struct {
+	chunks  chan []byte   // closed once the body is over
+	err     error         // error ending the body, set before chunks is closed
+	done    chan struct{} // closed by Close
+	once    sync.Once
+	body    io.ReadCloser
+	pending []byte
+}
+
+func readInBackground(body io.ReadCloser) *backgroundBody {
+	b := &backgroundBody{chunks: make(chan []byte), done: make(chan struct{}), body: body}
+	go func() {
+		defer close(b.chunks)
+		for {
+			buf := make([]byte, 32<<10)
+			n, err := body.Read(buf)
+			if n > 0 {
+				select {
+				case b.chunks <- buf[:n]:
+				case <-b.done:
+					b.err = io.ErrClosedPipe
+					return
+				}
+			}
+			if err != nil {
+				b.err = err
+				return
+			}
+		}
+	}()
+	return b
+}
+
+func (b *backgroundBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		chunk, ok := <-b.chunks
+		if !ok {
+			return 0, b.err
+		}
+		b.pending = chunk
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *backgroundBody) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return b.body.Close()
+}
+
+// readCloser combines a reader with the closer of the body it was built from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
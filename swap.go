@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// providerSlot holds the provider in use, along with the asynchronous writes pending against it.
+type providerSlot struct {
+	provider Provider
+
+	mu      sync.RWMutex
+	retired bool
+	writes  sync.WaitGroup
+}
+
+func newProviderSlot(p Provider) *atomic.Pointer[providerSlot] {
+	slot := &atomic.Pointer[providerSlot]{}
+	slot.Store(&providerSlot{provider: p})
+	return slot
+}
+
+// providerID identifies the provider a copy of the cache is bound to, without comparing providers themselves, which
+// may not be comparable.
+type providerID struct {
+	route int           // 1 + the index of the route in Routes, or 0 for the cache's own provider
+	slot  *providerSlot // slot of the own provider the copy is pinned to, if any
+}
+
+// currentProvider returns the provider entries are read from and written to.
+func (r Cache) currentProvider() Provider {
+	if r.provider != nil || r.slot == nil {
+		return r.provider
+	}
+	return r.slot.Load().provider
+}
+
+// pinProvider returns a copy of the cache bound to the current provider, for an asynchronous write to it. done must be
+// called once the write is finished.
+func (r Cache) pinProvider() (pinned Cache, done func()) {
+	if r.provider != nil || r.slot == nil {
+		return r, func() {}
+	}
+	for {
+		s := r.slot.Load()
+		s.mu.RLock()
+		if s.retired {
+			// swapped in the meantime
+			s.mu.RUnlock()
+			continue
+		}
+		s.writes.Add(1)
+		s.mu.RUnlock()
+		r.provider, r.bound.slot = s.provider, s
+		return r, s.writes.Done
+	}
+}
+
+// SetProvider atomically replaces the provider of the cache, and of every copy of it, for instance to migrate from an
+// in-memory provider to Redis without a restart. Entries are not copied over. SetProvider returns once the
+// asynchronous writes pending against the previous provider are written, so it can be closed safely.
+func (r *Cache) SetProvider(p Provider) {
+	if r.slot == nil {
+		r.provider = p
+		return
+	}
+
+	prev := r.slot.Swap(&providerSlot{provider: p})
+	prev.mu.Lock()
+	prev.retired = true
+	prev.mu.Unlock()
+	prev.writes.Wait()
+}
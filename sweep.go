@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sweeper configures the background expiry sweeper, for providers without native expiry. Every Interval, the keys of
+// the provider are iterated and the entries expired for longer than Grace are removed, keeping the provider usage
+// proportional to the live entries.
+type Sweeper struct {
+	Interval time.Duration // interval between two sweeps, defaults to 10 minutes
+	Grace    time.Duration // how long expired entries are kept, e.g. to be served stale or revalidated
+}
+
+const defaultSweepInterval = 10 * time.Minute
+
+type sweeper struct {
+	once sync.Once
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+
+	deleted atomic.Int64
+}
+
+func newSweeper() *sweeper {
+	return &sweeper{stop: make(chan struct{})}
+}
+
+// startSweeper starts the background sweeper, if configured and not started yet.
+func (r Cache) startSweeper() {
+	if r.Sweeper == nil || r.sweeper == nil {
+		return
+	}
+	s := r.sweeper
+	s.once.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.closed {
+			return
+		}
+
+		cfg := *r.Sweeper
+		interval := cfg.Interval
+		if interval <= 0 {
+			interval = defaultSweepInterval
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.wg.Add(2)
+		go func() {
+			// abort a sweep in progress on close
+			defer s.wg.Done()
+			<-s.stop
+			cancel()
+		}()
+		go func() {
+			defer s.wg.Done()
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				if _, err := r.Sweep(ctx, cfg.Grace); err != nil && ctx.Err() == nil {
+					r.logError(ctx, "error sweeping expired entries", "provider", r.providerName(), "error", err)
+				}
+			}
+		}()
+	})
+}
+
+func (s *sweeper) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// Sweep removes the entries expired for longer than grace, along with the oldest entries of the hosts storing more
+// than the MaxEntriesPerHost of their policy rule, returning the number of removed entries. Entries without a known
+// expiry are considered expired from the moment they were stored, while keys that can't be read or don't hold an entry
+// are left alone. The access metadata of entries no longer stored, see TrackAccess, is removed as well. Sweep is run in
+// the background with the Sweeper option. Requires a provider implementing both Scanner and Deleter. With Routes, every
+// provider is swept.
+func (r Cache) Sweep(ctx context.Context, grace time.Duration) (int, error) {
+	var deleted int
+	for _, c := range r.perProvider() {
+		n, err := c.sweep(ctx, grace)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func (r Cache) sweep(ctx context.Context, grace time.Duration) (int, error) {
+	scanner, ok := r.currentProvider().(Scanner)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Scanner", ErrNotSupported, r.providerName())
+	}
+	deleter, ok := r.currentProvider().(Deleter)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s does not implement Deleter", ErrNotSupported, r.providerName())
+	}
+
+	deadline := r.now().Add(-grace)
+	var keys, orphans []string
+	hosts := make(map[string]*hostEntries)
+	err := scanner.Scan(ctx, r.Namespace, func(key string) error {
+		if r.internalKey(key) {
+			if r.orphanedAccess(ctx, key) {
+				orphans = append(orphans, key)
+			}
+			return nil
+		}
+		entry := r.scannedEntry(ctx, key)
+		if entry == nil || entry.isIndex() {
+			// indexes don't expire, their variants do
+			return nil
+		}
+		expires, ok := entry.expiresAt()
+		if !ok {
+			expires = entry.Ts
+		}
+		if expires.Before(deadline) {
+			keys = append(keys, key)
+		} else {
+			r.trackHostEntry(hosts, key, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, providerError("scan", r.Namespace, err)
+	}
+	keys = append(keys, overHostLimits(hosts)...)
+
+	for _, key := range orphans {
+		if err := deleter.Delete(ctx, key); err != nil {
+			r.recordProviderError(ctx, "delete", err)
+			return 0, providerError("delete", key, err)
+		}
+	}
+
+	var deleted int
+	for _, key := range keys {
+		if err := r.invalidate(ctx, key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	if r.sweeper != nil {
+		r.sweeper.deleted.Add(int64(deleted))
+	}
+	return deleted, nil
+}
+
+// scannedEntry returns the entry stored under key, a key found by a scan, or nil if there is none. Scans may visit
+// keys the cache doesn't own, e.g. in a provider shared without Namespace: keys that can't be read, such as keys of
+// another type, and values that aren't cache entries are skipped quietly, and must never be deleted.
+func (r Cache) scannedEntry(ctx context.Context, key string) *cacheEntry {
+	value, err := r.currentProvider().Get(ctx, key)
+	if err != nil {
+		r.logDebug(ctx, "skipping unreadable key", "key", key, "provider", r.providerName(), "error", err)
+		return nil
+	}
+	var entry cacheEntry
+	if len(value) == 0 || json.Unmarshal(value, &entry) != nil || !entry.valid() {
+		return nil
+	}
+	if err := decompressEntry(&entry); err != nil {
+		return nil
+	}
+	return &entry
+}
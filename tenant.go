@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tenantKeyPrefix = "tenant:"
+
+// TenantQuota limits the entries stored for a tenant. Zero values mean no limit.
+type TenantQuota struct {
+	MaxEntries int
+	MaxBytes   int64
+}
+
+// TenantStats is a snapshot of the statistics of a tenant. Usage is tracked by the current process only, from the
+// entries it wrote or removed, until they expire; entries evicted earlier by the provider are still accounted for.
+type TenantStats struct {
+	Requests int64 `json:"requests"` // calls to Do
+	Hits     int64 `json:"hits"`     // calls answered from the cache
+	Misses   int64 `json:"misses"`   // calls answered by the origin
+	Entries  int   `json:"entries"`  // stored entries
+	Bytes    int64 `json:"bytes"`    // size of the stored entries
+	Rejected int64 `json:"rejected"` // writes skipped because the tenant was over quota
+}
+
+// tenantKey prefixes key with the tenant of ctx, if any.
+func tenantKey(ctx context.Context, key string) string {
+	tenant := Tenant(ctx)
+	if tenant == "" {
+		return key
+	}
+	return tenantKeyPrefix + url.QueryEscape(tenant) + ":" + key
+}
+
+// tenantOf returns the tenant a key belongs to, or an empty string.
+func tenantOf(key string) string {
+	if !strings.HasPrefix(key, tenantKeyPrefix) {
+		return ""
+	}
+	escaped, _, ok := strings.Cut(key[len(tenantKeyPrefix):], ":")
+	if !ok {
+		return ""
+	}
+	tenant, err := url.QueryUnescape(escaped)
+	if err != nil {
+		return ""
+	}
+	return tenant
+}
+
+// trackedSize is the size of an entry written by the current process, accounted for until the entry lapses.
+type trackedSize struct {
+	size   int
+	lapses time.Time
+}
+
+// lapsed reports whether the entry stopped being accounted for at the given moment.
+func (s trackedSize) lapsed(now time.Time) bool {
+	return !s.lapses.After(now)
+}
+
+type tenantUsage struct {
+	stats TenantStats
+	sizes map[string]trackedSize // size of the stored entries, by key
+}
+
+// prune stops accounting for the entries lapsed at the given moment.
+func (u *tenantUsage) prune(now time.Time) {
+	for key, s := range u.sizes {
+		if s.lapsed(now) {
+			delete(u.sizes, key)
+			u.stats.Entries--
+			u.stats.Bytes -= int64(s.size)
+		}
+	}
+}
+
+type tenantTracker struct {
+	mu      sync.Mutex
+	tenants map[string]*tenantUsage
+}
+
+func newTenantTracker() *tenantTracker {
+	return &tenantTracker{tenants: make(map[string]*tenantUsage)}
+}
+
+func (t *tenantTracker) usage(tenant string) *tenantUsage {
+	u, ok := t.tenants[tenant]
+	if !ok {
+		u = &tenantUsage{sizes: make(map[string]trackedSize)}
+		t.tenants[tenant] = u
+	}
+	return u
+}
+
+// fits reports whether an entry of size bytes can be written under key without putting the tenant over quota. Entries
+// lapsed at the given moment are no longer accounted for.
+func (t *tenantTracker) fits(tenant string, key string, size int, quota TenantQuota, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.usage(tenant)
+	over := func() bool {
+		prev, exists := u.sizes[key]
+		entries, bytes := u.stats.Entries, u.stats.Bytes+int64(size-prev.size)
+		if !exists {
+			entries++
+		}
+		return (quota.MaxEntries > 0 && entries > quota.MaxEntries) || (quota.MaxBytes > 0 && bytes > quota.MaxBytes)
+	}
+	if over() {
+		u.prune(now)
+		if over() {
+			u.stats.Rejected++
+			return false
+		}
+	}
+	return true
+}
+
+// charge accounts for an entry of size bytes written under key, until it lapses.
+func (t *tenantTracker) charge(tenant string, key string, size int, lapses time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.usage(tenant)
+	prev, exists := u.sizes[key]
+	if !exists {
+		u.stats.Entries++
+	}
+	u.stats.Bytes += int64(size - prev.size)
+	u.sizes[key] = trackedSize{size: size, lapses: lapses}
+}
+
+// release accounts for the removal of the entry stored under key.
+func (t *tenantTracker) release(tenant string, key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.usage(tenant)
+	if s, ok := u.sizes[key]; ok {
+		delete(u.sizes, key)
+		u.stats.Entries--
+		u.stats.Bytes -= int64(s.size)
+	}
+}
+
+func (t *tenantTracker) recordDo(tenant string, event Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.usage(tenant)
+	u.stats.Requests++
+	switch {
+	case event.Err != nil:
+	case event.Status.FromCache():
+		u.stats.Hits++
+	case event.Status != "":
+		u.stats.Misses++
+	}
+}
+
+// TenantStats returns a snapshot of the statistics of a tenant, see WithTenant.
+func (r Cache) TenantStats(tenant string) TenantStats {
+	if r.tenants == nil {
+		return TenantStats{}
+	}
+	r.tenants.mu.Lock()
+	defer r.tenants.mu.Unlock()
+
+	if u, ok := r.tenants.tenants[tenant]; ok {
+		u.prune(r.now())
+		return u.stats
+	}
+	return TenantStats{}
+}
+
+// checkTenant checks the quota of the tenant owning key before an entry of size bytes is written under it.
+func (r Cache) checkTenant(ctx context.Context, key string, size int) bool {
+	tenant := tenantOf(strings.TrimPrefix(key, r.Namespace))
+	if tenant == "" || r.tenants == nil {
+		return true
+	}
+	var quota TenantQuota
+	if r.TenantQuotas != nil {
+		quota = r.TenantQuotas(tenant)
+	}
+	if !r.tenants.fits(tenant, key, size, quota, r.now()) {
+		r.logInfo(ctx, "tenant over quota, not storing entry", "tenant", tenant, "key", key, "size", size)
+		return false
+	}
+	return true
+}
+
+// chargeTenant accounts for an entry of size bytes written under key, until it lapses.
+func (r Cache) chargeTenant(key string, size int, lapses time.Time) {
+	if tenant := tenantOf(strings.TrimPrefix(key, r.Namespace)); tenant != "" && r.tenants != nil {
+		r.tenants.charge(tenant, key, size, lapses)
+	}
+}
+
+func (r Cache) releaseTenant(key string) {
+	if tenant := tenantOf(strings.TrimPrefix(key, r.Namespace)); tenant != "" && r.tenants != nil {
+		r.tenants.release(tenant, key)
+	}
+}
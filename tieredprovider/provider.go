@@ -0,0 +1,152 @@
+// Package tieredprovider combines a small, fast provider, typically in memory, with a larger one shared by every
+// instance, typically redis, so that hot entries are served locally while the others stay shared.
+package tieredprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lsmoura/cache"
+)
+
+const (
+	// defaultPromotedTTL is how long values promoted to L1 are kept without L1TTL: L2 doesn't tell how long they
+	// remain valid.
+	defaultPromotedTTL = time.Minute
+	maxTrackedKeys     = 10000
+)
+
+// Promotion decides which values read from L2 are copied to L1, so that large or rarely read values don't evict the
+// hot ones from a small L1. The zero value promotes every value on its first read.
+type Promotion struct {
+	MaxSize int // only values smaller than MaxSize bytes are promoted, 0 for no limit
+	MinHits int // values are promoted on their MinHits-th read from L2, defaults to 1
+}
+
+// TieredProvider reads from L1 first and falls back to L2, promoting the values found there to L1 according to its
+// Promotion policy. Writes go to L2, then to L1 if the value would be promoted on its first read. Once a value is
+// in L2, failures of L1 are ignored, the value being removed from L1 when it can't be written there.
+//
+// It implements cache.Deleter when both layers do, removing keys from both, and otherwise fails with an error matching
+// both cache.ErrNotSupported and errors.ErrUnsupported. Without an L1 implementing cache.Deleter, writes that are not
+// stored in L1 right away leave the previous value there until it expires, see L1TTL.
+type TieredProvider struct {
+	l1, l2 cache.Provider
+
+	// Promotion is the L1 promotion policy. It must be set before the provider is used.
+	Promotion Promotion
+
+	// L1TTL and L2TTL cap the expiry of the values written to each layer, e.g. 30 seconds locally and an hour in
+	// redis, bounding how long an instance may serve a value replaced in L2 by another one. Without L1TTL, values
+	// are kept in L1 for a minute at most. Zero L2TTL keeps the expiry given to Set. Values promoted to L1 expire
+	// after L1TTL, or a minute without it.
+	L1TTL time.Duration
+	L2TTL time.Duration
+
+	mu   sync.Mutex
+	hits map[string]int // reads from L2 of the keys not promoted yet
+}
+
+// New returns a provider layering l1 in front of l2.
+func New(l1, l2 cache.Provider) *TieredProvider {
+	return &TieredProvider{
+		l1:   l1,
+		l2:   l2,
+		hits: make(map[string]int),
+	}
+}
+
+func (p *TieredProvider) Get(ctx context.Context, key string) ([]byte, error) {
+	// a failing L1 is only a slower path
+	if value, err := p.l1.Get(ctx, key); err == nil && value != nil {
+		return value, nil
+	}
+
+	value, err := p.l2.Get(ctx, key)
+	if err != nil || value == nil {
+		return value, err
+	}
+	if p.promote(key, len(value)) {
+		_ = p.l1.Set(ctx, key, value, p.l1TTL())
+	}
+	return value, nil
+}
+
+// promote records a read from L2 of a value of the given size, and reports whether it must be promoted to L1.
+func (p *TieredProvider) promote(key string, size int) bool {
+	if !p.fits(size) {
+		return false
+	}
+	if p.Promotion.MinHits <= 1 {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.hits[key]; !ok && len(p.hits) >= maxTrackedKeys {
+		return false
+	}
+	p.hits[key]++
+	if p.hits[key] < p.Promotion.MinHits {
+		return false
+	}
+	delete(p.hits, key)
+	return true
+}
+
+// l1TTL returns the longest expiry of the values stored in L1.
+func (p *TieredProvider) l1TTL() time.Duration {
+	if p.L1TTL <= 0 {
+		return defaultPromotedTTL
+	}
+	return p.L1TTL
+}
+
+// fits reports whether a value of the given size may be stored in L1.
+func (p *TieredProvider) fits(size int) bool {
+	return p.Promotion.MaxSize <= 0 || size < p.Promotion.MaxSize
+}
+
+func (p *TieredProvider) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	if err := p.l2.Set(ctx, key, value, capTTL(expiry, p.L2TTL)); err != nil {
+		return err
+	}
+	if p.fits(len(value)) && p.Promotion.MinHits <= 1 {
+		if err := p.l1.Set(ctx, key, value, capTTL(expiry, p.l1TTL())); err == nil {
+			return nil
+		}
+	}
+	// the value is only in L2, L1 must not keep serving the previous one. A failing L1 is only a slower path
+	if deleter, ok := p.l1.(cache.Deleter); ok {
+		_ = deleter.Delete(ctx, key)
+	}
+	return nil
+}
+
+// capTTL returns expiry capped by ttl. A zero or negative expiry never expires, and a zero or negative ttl sets no cap.
+func capTTL(expiry, ttl time.Duration) time.Duration {
+	if ttl <= 0 || (expiry > 0 && expiry <= ttl) {
+		return expiry
+	}
+	return ttl
+}
+
+func (p *TieredProvider) Delete(ctx context.Context, key string) error {
+	d1, ok1 := p.l1.(cache.Deleter)
+	d2, ok2 := p.l2.(cache.Deleter)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("%w: %w", cache.ErrNotSupported, errors.ErrUnsupported)
+	}
+
+	p.mu.Lock()
+	delete(p.hits, key)
+	p.mu.Unlock()
+
+	if err := d2.Delete(ctx, key); err != nil {
+		return err
+	}
+	return d1.Delete(ctx, key)
+}
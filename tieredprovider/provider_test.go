@@ -0,0 +1,142 @@
+package tieredprovider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache"
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredProvider(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := memoryprovider.New(), memoryprovider.New()
+	p := New(l1, l2)
+
+	require.NoError(t, p.Set(ctx, "key", []byte("value"), time.Hour))
+	for _, layer := range []*memoryprovider.MemoryProvider{l1, l2} {
+		value, err := layer.Get(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, "value", string(value), "Expected writes to reach both layers")
+	}
+
+	require.NoError(t, l2.Set(ctx, "shared", []byte("value"), time.Hour))
+	value, err := p.Get(ctx, "shared")
+	require.NoError(t, err)
+	require.Equal(t, "value", string(value))
+	value, err = l1.Get(ctx, "shared")
+	require.NoError(t, err)
+	require.Equal(t, "value", string(value), "Expected values read from L2 to be promoted")
+
+	require.NoError(t, p.Delete(ctx, "shared"))
+	value, err = p.Get(ctx, "shared")
+	require.NoError(t, err)
+	require.Nil(t, value, "Expected deleted keys to be removed from both layers")
+}
+
+func TestTieredProvider_DeleteUnsupported(t *testing.T) {
+	l1 := struct{ cache.Provider }{memoryprovider.New()}
+	p := New(l1, memoryprovider.New())
+
+	err := p.Delete(context.Background(), "key")
+	require.Truef(t, errors.Is(err, cache.ErrNotSupported), "Expected ErrNotSupported, got %v", err)
+	require.Truef(t, errors.Is(err, errors.ErrUnsupported), "Expected errors.ErrUnsupported, got %v", err)
+}
+
+func TestTieredProvider_Promotion(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := memoryprovider.New(), memoryprovider.New()
+	p := New(l1, l2)
+	p.Promotion = Promotion{MaxSize: 6, MinHits: 2}
+
+	require.NoError(t, l2.Set(ctx, "small", []byte("value"), time.Hour))
+	require.NoError(t, l2.Set(ctx, "large", []byte("values"), time.Hour))
+
+	for i := 1; i <= 2; i++ {
+		for _, key := range []string{"small", "large"} {
+			value, err := p.Get(ctx, key)
+			require.NoError(t, err)
+			require.NotNil(t, value)
+		}
+		promoted, err := l1.Get(ctx, "small")
+		require.NoError(t, err)
+		require.Equal(t, i == 2, promoted != nil, "Expected values to be promoted on their second read, read %d", i)
+	}
+	promoted, err := l1.Get(ctx, "large")
+	require.NoError(t, err)
+	require.Nil(t, promoted, "Expected values of MaxSize bytes not to be promoted")
+
+	// writes can't be promoted right away, and must not leave the previous value in L1
+	require.NoError(t, p.Set(ctx, "small", []byte("new"), time.Hour))
+	value, err := p.Get(ctx, "small")
+	require.NoError(t, err)
+	require.Equal(t, "new", string(value))
+	promoted, err = l1.Get(ctx, "small")
+	require.NoError(t, err)
+	require.Nil(t, promoted)
+}
+
+// failingProvider fails every write.
+type failingProvider struct {
+	*memoryprovider.MemoryProvider
+}
+
+func (failingProvider) Set(context.Context, string, []byte, time.Duration) error {
+	return errors.New("l1 is full")
+}
+
+func TestTieredProvider_FailingL1(t *testing.T) {
+	ctx := context.Background()
+	l1, l2 := failingProvider{MemoryProvider: memoryprovider.New()}, memoryprovider.New()
+	p := New(l1, l2)
+
+	require.NoError(t, l1.MemoryProvider.Set(ctx, "key", []byte("previous"), time.Hour))
+	require.NoError(t, p.Set(ctx, "key", []byte("value"), time.Hour), "Expected L1 errors to be ignored once L2 is written")
+
+	value, err := p.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, "value", string(value), "Expected the previous value to be removed from L1")
+}
+
+type expiryProvider struct {
+	*memoryprovider.MemoryProvider
+	expiries map[string]time.Duration
+}
+
+func (p *expiryProvider) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	p.expiries[key] = expiry
+	return p.MemoryProvider.Set(ctx, key, value, expiry)
+}
+
+func TestTieredProvider_TTLs(t *testing.T) {
+	ctx := context.Background()
+	l1 := &expiryProvider{MemoryProvider: memoryprovider.New(), expiries: make(map[string]time.Duration)}
+	l2 := &expiryProvider{MemoryProvider: memoryprovider.New(), expiries: make(map[string]time.Duration)}
+	p := New(l1, l2)
+	p.L1TTL = 30 * time.Second
+	p.L2TTL = time.Hour
+
+	require.NoError(t, p.Set(ctx, "long", []byte("value"), 24*time.Hour))
+	require.Equal(t, 30*time.Second, l1.expiries["long"])
+	require.Equal(t, time.Hour, l2.expiries["long"])
+
+	require.NoError(t, p.Set(ctx, "short", []byte("value"), 10*time.Second))
+	require.Equal(t, 10*time.Second, l1.expiries["short"], "Expected shorter expiries to be kept")
+	require.Equal(t, 10*time.Second, l2.expiries["short"])
+
+	require.NoError(t, p.Set(ctx, "forever", []byte("value"), 0))
+	require.Equal(t, 30*time.Second, l1.expiries["forever"], "Expected values without expiry to be capped")
+
+	require.NoError(t, l2.MemoryProvider.Set(ctx, "shared", []byte("value"), time.Hour))
+	_, err := p.Get(ctx, "shared")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, l1.expiries["shared"], "Expected promoted values to expire after L1TTL")
+
+	p.L1TTL = 0
+	require.NoError(t, p.Set(ctx, "forever", []byte("value"), 0))
+	require.Equal(t, defaultPromotedTTL, l1.expiries["forever"], "Expected L1 to be capped without L1TTL")
+	require.Equal(t, time.Hour, l2.expiries["forever"])
+}
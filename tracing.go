@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/lsmoura/cache"
+
+var noopSpan = noop.Span{}
+
+// startSpan starts a span as a child of the span in ctx, if tracing is enabled. When it is not, ctx is returned
+// unchanged along with a span that does nothing.
+func (r Cache) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if r.TracerProvider == nil {
+		return ctx, noopSpan
+	}
+	return r.TracerProvider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endDoSpan records how a call to Do was answered and ends its span.
+func endDoSpan(span trace.Span, info callInfo, resp *http.Response, err error) {
+	if info.key != "" {
+		span.SetAttributes(attribute.String("cache.key", info.key))
+	}
+	if info.stat != "" {
+		span.SetAttributes(attribute.String("cache.status", string(info.stat)))
+	}
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	}
+	endSpan(span, err)
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lsmoura/cache/memoryprovider"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCache_Tracing(t *testing.T) {
+	const cacheURL = "http://example.com/"
+
+	requester := fakeRequester{
+		data: map[string]*cacheEntry{
+			cacheURL: {
+				Ts:         time.Now(),
+				StatusCode: 200,
+				Data:       []byte("Hello World"),
+				Headers: map[string]string{
+					"Expires": time.Now().Add(time.Hour).Format(time.RFC1123),
+				},
+			},
+		},
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	cache := New(memoryprovider.New())
+	cache.HttpClient = &requester
+	cache.TracerProvider = tp
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cacheURL, nil)
+	require.NoError(t, err, "http.NewRequestWithContext")
+	_, err = cache.Do(req)
+	require.NoError(t, err, "cache.Do")
+	parent.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"cache.Do", "cache.provider.Get", "cache.provider.Set", "cache.origin"} {
+		require.Contains(t, spans, name)
+	}
+
+	do := spans["cache.Do"]
+	require.Equal(t, parent.SpanContext().SpanID(), do.Parent().SpanID(), "Expected cache.Do to be a child of the incoming span")
+	require.Equal(t, do.SpanContext().SpanID(), spans["cache.origin"].Parent().SpanID())
+	require.Contains(t, do.Attributes(), attribute.String("cache.status", "miss"))
+	require.Contains(t, do.Attributes(), attribute.String("cache.key", cacheURL))
+	require.Contains(t, do.Attributes(), attribute.Int("http.response.status_code", 200))
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Codec serializes the values of a Typed cache.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec is a Codec using encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// Typed stores values of type T in a cache, handling their serialization. See Cache.GetOrSet.
+type Typed[T any] struct {
+	Cache *Cache
+	Codec Codec // codec used to serialize values, or nil for JSONCodec
+}
+
+// NewTyped returns a typed view of c, serializing values as JSON.
+func NewTyped[T any](c *Cache) *Typed[T] {
+	return &Typed[T]{Cache: c}
+}
+
+func (t *Typed[T]) codec() Codec {
+	if t.Codec == nil {
+		return JSONCodec{}
+	}
+	return t.Codec
+}
+
+// Get returns the value stored under key. Returns an ErrCacheMiss error if there is no such value or it expired,
+// expired values being returned under WithIgnoreExpired and WithMaxStale.
+func (t *Typed[T]) Get(ctx context.Context, key string) (T, error) {
+	var value T
+	entry, err := t.Cache.read(ctx, t.Cache.namespaced(tenantKey(ctx, key)))
+	if err != nil && !errors.Is(err, ErrCacheExpired) && !errors.Is(err, ErrCacheExpiryIgnored) {
+		return value, err
+	}
+	if entry == nil || errors.Is(err, ErrCacheExpired) {
+		return value, ErrCacheMiss
+	}
+	if err := t.codec().Unmarshal(entry.Data, &value); err != nil {
+		return value, fmt.Errorf("codec.Unmarshal(): %w", err)
+	}
+	return value, nil
+}
+
+// Set stores value under key for ttl, or without expiry if ttl is zero or negative.
+func (t *Typed[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	start := time.Now()
+	data, err := t.codec().Marshal(value)
+	if err != nil {
+		return fmt.Errorf("codec.Marshal(): %w", err)
+	}
+	return t.Cache.write(ctx, t.Cache.namespaced(tenantKey(ctx, key)), t.Cache.valueEntry(data, ttl, start))
+}
+
+// GetOrCompute returns the value stored under key, calling fn to compute and store it when it is missing or expired.
+// See Cache.GetOrSet.
+func (t *Typed[T]) GetOrCompute(ctx context.Context, key string, ttl time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	var value T
+	data, err := t.Cache.GetOrSet(ctx, key, ttl, func(ctx context.Context) ([]byte, error) {
+		v, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := t.codec().Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("codec.Marshal(): %w", err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return value, err
+	}
+	if err := t.codec().Unmarshal(data, &value); err != nil {
+		return value, fmt.Errorf("codec.Unmarshal(): %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+)
+
+// validatorHeaders are the request headers making a request conditional on the cached representation.
+var validatorHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// hasValidators reports whether req carries a validator, allowing the origin to answer with a 304 status code.
+func hasValidators(req *http.Request) bool {
+	for _, h := range validatorHeaders {
+		if req.Header.Get(h) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutValidators returns a copy of req without its validators, forcing the origin to send the full response.
+func withoutValidators(ctx context.Context, req *http.Request) *http.Request {
+	req = req.Clone(ctx)
+	for _, h := range validatorHeaders {
+		req.Header.Del(h)
+	}
+	return req
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	variantKeySep = "|vary:"
+	maxVariants   = 16 // variants remembered by an index for invalidation, older ones are forgotten
+)
+
+// parseVary returns the sorted, canonical header names of a Vary header value. any reports whether it contains "*",
+// meaning the response varies on more than request headers and can't be cached.
+func parseVary(value string) (names []string, any bool) {
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "*" {
+			return nil, true
+		}
+		name = http.CanonicalHeaderKey(name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, false
+}
+
+// variantKey returns the key of the variant of the resource stored under key matching the vary headers of req.
+// Accept-Encoding is normalized first, so that "gzip, br" and "br;q=0.8,gzip" select the same variant.
+func variantKey(key string, vary []string, req *http.Request) string {
+	h := sha256.New()
+	for _, name := range vary {
+		h.Write([]byte(name))
+		h.Write([]byte{':'})
+		if name == "Accept-Encoding" {
+			h.Write([]byte(normalizeAcceptEncoding(req.Header.Values(name))))
+		} else {
+			h.Write([]byte(strings.Join(req.Header.Values(name), ",")))
+		}
+		h.Write([]byte{'\n'})
+	}
+	return key + variantKeySep + hex.EncodeToString(h.Sum(nil))
+}
+
+// resolveVariant follows a variant index read under key, with err, to the variant matching req. Other entries are
+// returned as they are. The returned key is the one the returned entry was read from.
+func (r Cache) resolveVariant(ctx context.Context, key string, req *http.Request, entry *cacheEntry, err error) (string, *cacheEntry, error) {
+	if err != nil || entry == nil || !entry.isIndex() {
+		return key, entry, err
+	}
+	vkey := variantKey(key, entry.Vary, req)
+	entry, err = r.read(ctx, vkey)
+	return vkey, entry, err
+}
+
+// storageKey returns the key e is stored under by writeEntry.
+func (r Cache) storageKey(key string, req *http.Request, e *cacheEntry) string {
+	vary, _ := parseVary(e.header("Vary"))
+	if len(vary) == 0 || req == nil {
+		return key
+	}
+	return variantKey(key, vary, req)
+}
+
+// writeEntry writes e under key or, when the response varies on request headers, under the key of the variant
+// matching req, indexed under key.
+func (r Cache) writeEntry(ctx context.Context, key string, req *http.Request, e *cacheEntry) error {
+	vkey := r.storageKey(key, req, e)
+	if vkey == key {
+		if err := r.write(ctx, key, e); err != nil {
+			return err
+		}
+		r.indexKeys(ctx, req, key)
+		return nil
+	}
+	vary, _ := parseVary(e.header("Vary"))
+	if err := r.write(ctx, vkey, e); err != nil {
+		return err
+	}
+	if err := r.writeIndex(ctx, key, vary, vkey, e.URL); err != nil {
+		return err
+	}
+	r.indexKeys(ctx, req, key, vkey)
+	return nil
+}
+
+// writeIndex records the variant stored under vkey in the index stored under key, replacing whatever was stored under
+// key if it isn't an index of the same headers.
+func (r Cache) writeIndex(ctx context.Context, key string, vary []string, vkey string, url string) error {
+	index := &cacheEntry{Ts: r.now(), URL: url, Vary: vary}
+	current, err := r.read(ctx, key)
+	if err != nil && !errors.Is(err, ErrCacheExpired) && !errors.Is(err, ErrCacheExpiryIgnored) {
+		current = nil
+	}
+	if current != nil && current.isIndex() && strings.Join(current.Vary, ",") == strings.Join(vary, ",") {
+		for _, v := range current.Variants {
+			if v == vkey {
+				// already indexed
+				return nil
+			}
+		}
+		index.Variants = current.Variants
+	}
+	index.Variants = append(index.Variants, vkey)
+	if len(index.Variants) > maxVariants {
+		index.Variants = index.Variants[len(index.Variants)-maxVariants:]
+	}
+	return r.write(ctx, key, index)
+}
+
+// invalidateVariants removes the entry stored under key and, if it is a variant index, the variants it knows of.
+// Returns the number of removed responses: the variants of an index, or 1 for a plain entry.
+func (r Cache) invalidateVariants(ctx context.Context, key string) (int, error) {
+	var deleted int
+	entry, _ := r.read(ctx, key)
+	if entry != nil && entry.isIndex() {
+		for _, vkey := range entry.Variants {
+			if err := r.invalidate(ctx, vkey); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	} else if entry != nil {
+		deleted++
+	}
+	if err := r.invalidate(ctx, key); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// VCRMode selects whether a VCR records or replays.
+type VCRMode int
+
+const (
+	// VCRRecord sends every request to the origin and stores every response in the cassette, regardless of its
+	// cacheability.
+	VCRRecord VCRMode = iota
+	// VCRReplay answers every request from the cassette, never going to the origin. Requests that were not recorded
+	// fail with ErrNotRecorded.
+	VCRReplay
+)
+
+// VCR turns the cache into a record-and-replay fixture for tests. Requests are matched on their method and cache
+// key; request bodies are not considered.
+type VCR struct {
+	Mode     VCRMode
+	Cassette string // name separating the recordings of different tests in the same provider
+}
+
+func (v *VCR) key(req *http.Request, key string) string {
+	return "vcr:" + v.Cassette + ":" + req.Method + " " + key
+}
+
+// doVCR answers req in record or replay mode, bypassing every other cache feature.
+func (r Cache) doVCR(req *http.Request, info *callInfo) (*http.Response, error) {
+	ctx := req.Context()
+	key := r.VCR.key(req, r.key(req))
+	info.key = key
+
+	if r.VCR.Mode == VCRReplay {
+		entry, err := r.read(ctx, key)
+		if err != nil && !errors.Is(err, ErrCacheExpired) && !errors.Is(err, ErrCacheExpiryIgnored) {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("%w: %s %s in cassette %q", ErrNotRecorded, req.Method, r.logURL(req), r.VCR.Cassette)
+		}
+		info.stat = CacheStatusHit
+		return info.serve(req, entry), nil
+	}
+
+	info.stat = CacheStatusMiss
+	start := time.Now()
+	resp, err := r.originDo(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := r.store(ctx, key, nil, resp, start, nil)
+	if entry == nil {
+		return nil, err
+	}
+	return info.serve(req, entry), err
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// WarmOptions configures Warm.
+type WarmOptions struct {
+	Concurrency int              // maximum number of concurrent requests, defaults to 8
+	Interval    time.Duration    // minimum interval between the start of two requests, or 0 for no rate limit
+	Include     []*regexp.Regexp // if set, only URLs matching at least one of them are warmed
+	Exclude     []*regexp.Regexp // URLs matching any of them are skipped
+}
+
+func (o WarmOptions) allows(rawURL string) bool {
+	for _, re := range o.Exclude {
+		if re.MatchString(rawURL) {
+			return false
+		}
+	}
+	if len(o.Include) == 0 {
+		return true
+	}
+	for _, re := range o.Include {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// WarmResult summarizes a warming.
+type WarmResult struct {
+	Requested int `json:"requested"` // URLs requested through the cache
+	Skipped   int `json:"skipped"`   // URLs filtered out by the include and exclude patterns
+	Failed    int `json:"failed"`    // requests that failed or got an error status code
+}
+
+// Warm primes the cache by requesting every URL through it, e.g. right after a deploy. URLs already cached and fresh
+// are not fetched again. Failing requests are counted, and logged, but don't stop the warming. Returns an error if a
+// URL is invalid.
+func (r Cache) Warm(ctx context.Context, urls []string, opts WarmOptions) (WarmResult, error) {
+	var result WarmResult
+	reqs := make([]*http.Request, 0, len(urls))
+	for _, u := range urls {
+		if !opts.allows(u) {
+			result.Skipped++
+			continue
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return result, fmt.Errorf("http.NewRequest(): %w", err)
+		}
+		reqs = append(reqs, req)
+	}
+
+	for i, res := range r.DoBatch(ctx, reqs, BatchOptions{Concurrency: opts.Concurrency, Interval: opts.Interval}) {
+		result.Requested++
+		if res.Response != nil {
+			_, _ = io.Copy(io.Discard, res.Response.Body)
+			_ = res.Response.Body.Close()
+		}
+		if res.Err != nil && !errors.Is(res.Err, ErrStaleServed) {
+			result.Failed++
+			r.logError(ctx, "error warming entry", "url", r.logURL(reqs[i]), "error", res.Err)
+			continue
+		}
+		if res.Response.StatusCode >= http.StatusBadRequest {
+			result.Failed++
+		}
+	}
+	return result, ctx.Err()
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AsyncWrites configures write-behind storage: entries are written to the provider by background workers, so slow
+// backends don't add latency to responses. Pending writes are flushed on Close.
+type AsyncWrites struct {
+	Workers   int // number of background writers, defaults to 4
+	QueueSize int // maximum number of pending writes, defaults to 256. Writes are dropped when full
+}
+
+const (
+	defaultWriteWorkers   = 4
+	defaultWriteQueueSize = 256
+	defaultWriteTimeout   = 30 * time.Second // timeout of each background write, its call being gone
+)
+
+type writeJob struct {
+	ctx    context.Context // detached context of the call, see detach
+	cache  Cache
+	key    string
+	value  []byte
+	expiry time.Duration
+	done   func() // called once the job is written or dropped
+}
+
+type asyncWriter struct {
+	once  sync.Once
+	queue chan writeJob
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	depth   atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+func newAsyncWriter() *asyncWriter {
+	return &asyncWriter{}
+}
+
+func (w *asyncWriter) start(cfg AsyncWrites) {
+	w.once.Do(func() {
+		workers := cfg.Workers
+		if workers <= 0 {
+			workers = defaultWriteWorkers
+		}
+		size := cfg.QueueSize
+		if size <= 0 {
+			size = defaultWriteQueueSize
+		}
+		w.queue = make(chan writeJob, size)
+
+		for i := 0; i < workers; i++ {
+			w.wg.Add(1)
+			go w.work()
+		}
+	})
+}
+
+func (w *asyncWriter) work() {
+	defer w.wg.Done()
+
+	for job := range w.queue {
+		w.depth.Add(-1)
+		ctx, cancel := context.WithTimeout(job.ctx, defaultWriteTimeout)
+		err := job.cache.providerSet(ctx, job.key, job.value, job.expiry)
+		cancel()
+		if err != nil {
+			if job.cache.retryWrite(job.key, job.value, job.expiry, job.done) {
+				job.cache.logError(job.ctx, "error writing entry, retrying in the background", "key", job.key, "provider", job.cache.providerName(), "error", err)
+				continue
+			}
+			w.failed.Add(1)
+			job.cache.releaseUsage(job.key)
+			job.cache.logError(job.ctx, "error writing entry", "key", job.key, "provider", job.cache.providerName(), "error", err)
+		}
+		job.done()
+	}
+}
+
+// enqueue schedules a write. Returns false if the writer is closed and the write should be done synchronously.
+func (w *asyncWriter) enqueue(job writeJob) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return false
+	}
+	w.start(*job.cache.AsyncWrites)
+
+	w.depth.Add(1)
+	select {
+	case w.queue <- job:
+	default:
+		w.depth.Add(-1)
+		w.dropped.Add(1)
+		job.cache.releaseUsage(job.key)
+		job.cache.logError(job.ctx, "write queue is full, dropping write", "key", job.key, "provider", job.cache.providerName())
+		job.done()
+	}
+	return true
+}
+
+// close stops accepting writes and waits for pending writes to be flushed.
+func (w *asyncWriter) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	if w.queue != nil {
+		close(w.queue)
+	}
+	w.wg.Wait()
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WriteRetry configures retries of failed provider writes. Retries happen in the background, so a failed write never
+// delays a response: it is not reported as failed to the caller unless retries are not possible, e.g. after Close.
+type WriteRetry struct {
+	Attempts  int           // total number of attempts, including the first one
+	BaseDelay time.Duration // delay before the first retry, doubled on every subsequent retry
+	MaxDelay  time.Duration // upper bound of the delay between retries, or 0 for no bound
+}
+
+type writeRetrier struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[string]*pendingRetry // pending retry of each key
+
+	inFlight  atomic.Int64
+	retries   atomic.Int64
+	abandoned atomic.Int64
+}
+
+// pendingRetry is the state of the retries of a write, held during an attempt so that a newer write of the key waits
+// for it instead of being overwritten by it.
+type pendingRetry struct {
+	mu       sync.Mutex
+	canceled bool
+}
+
+func newWriteRetrier() *writeRetrier {
+	return &writeRetrier{stop: make(chan struct{}), pending: make(map[string]*pendingRetry)}
+}
+
+// retryWrite schedules background retries of a failed write of value under key, taking ownership of done, which is
+// called once the retries are over. Retries are canceled by a newer write or a removal of the key, see cancelRetry.
+// Returns false, leaving done to the caller, if retries are disabled or the cache is closed.
+func (r Cache) retryWrite(key string, value []byte, expiry time.Duration, done func()) bool {
+	if r.WriteRetry == nil || r.WriteRetry.Attempts <= 1 || r.retrier == nil {
+		return false
+	}
+	w := r.retrier
+	w.cancel(key)
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return false
+	}
+	p := &pendingRetry{}
+	w.pending[key] = p
+	w.wg.Add(1)
+	w.inFlight.Add(1)
+	w.mu.Unlock()
+
+	cfg := *r.WriteRetry
+	backoff := Retry{BaseDelay: cfg.BaseDelay, MaxDelay: cfg.MaxDelay}
+	go func() {
+		defer w.wg.Done()
+		defer w.inFlight.Add(-1)
+		defer w.forget(key, p)
+		defer done()
+
+		ctx := context.Background()
+		var err error
+		for retry := 1; retry < cfg.Attempts; retry++ {
+			timer := time.NewTimer(backoff.delay(retry))
+			select {
+			case <-w.stop:
+				timer.Stop()
+				w.abandoned.Add(1)
+				r.releaseUsage(key)
+				r.logError(ctx, "cache closed, giving up writing entry", "key", key, "provider", r.providerName())
+				return
+			case <-timer.C:
+			}
+
+			p.mu.Lock()
+			if p.canceled {
+				p.mu.Unlock()
+				r.logDebug(ctx, "newer write scheduled, dropping retry", "key", key, "provider", r.providerName())
+				return
+			}
+			w.retries.Add(1)
+			err = r.providerSet(ctx, key, value, expiry)
+			p.mu.Unlock()
+			if err == nil {
+				return
+			}
+		}
+		w.abandoned.Add(1)
+		r.releaseUsage(key)
+		r.logError(ctx, "giving up writing entry", "key", key, "attempts", cfg.Attempts, "provider", r.providerName(), "error", err)
+	}()
+	return true
+}
+
+// cancelRetry cancels the pending retry of a write under key, before a newer write or a removal of the key. An attempt
+// in progress is waited for, so that it can't overwrite the newer value.
+func (r Cache) cancelRetry(key string) {
+	if r.retrier != nil {
+		r.retrier.cancel(key)
+	}
+}
+
+func (w *writeRetrier) cancel(key string) {
+	w.mu.Lock()
+	p, ok := w.pending[key]
+	delete(w.pending, key)
+	w.mu.Unlock()
+	if ok {
+		p.mu.Lock()
+		p.canceled = true
+		p.mu.Unlock()
+	}
+}
+
+// forget removes p from the pending retries once it is over, unless it was replaced already.
+func (w *writeRetrier) forget(key string, p *pendingRetry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[key] == p {
+		delete(w.pending, key)
+	}
+}
+
+// drain waits for the pending retries to be over, or for ctx to be done.
+func (w *writeRetrier) drain(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for w.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// close abandons the pending retries.
+func (w *writeRetrier) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	w.wg.Wait()
+}